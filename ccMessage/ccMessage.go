@@ -132,11 +132,11 @@ type ccMessage struct {
 }
 
 type ccMessageJSON struct {
-	Name string `json:"name"` // Measurement name
-	// Meta   map[string]string      `json:"meta,omitempty"` // map of meta data tags
-	Tags   map[string]string `json:"tags"`      // map of of tags
-	Fields map[string]any    `json:"fields"`    // map of of fields
-	Tm     time.Time         `json:"timestamp"` // timestamp
+	Name   string            `json:"name"`           // Measurement name
+	Meta   map[string]string `json:"meta,omitempty"` // map of meta data tags, only used for decoding
+	Tags   map[string]string `json:"tags"`           // map of of tags
+	Fields map[string]any    `json:"fields"`         // map of of fields
+	Tm     time.Time         `json:"timestamp"`      // timestamp
 }
 
 // CCMessage is the interface for accessing and manipulating ClusterCockpit messages.
@@ -468,6 +468,7 @@ func EmptyMessage() CCMessage {
 }
 
 // FromJSON creates a CCMessage from a JSON representation.
+// Meta information is read from the optional "meta" object.
 func FromJSON(input json.RawMessage) (CCMessage, error) {
 	var j ccMessageJSON
 	err := json.Unmarshal(input, &j)
@@ -475,7 +476,7 @@ func FromJSON(input json.RawMessage) (CCMessage, error) {
 		return nil, fmt.Errorf("failed to parse JSON to CCMessage: %w", err)
 	}
 
-	return NewMessage(j.Name, j.Tags, make(map[string]string), j.Fields, j.Tm)
+	return NewMessage(j.Name, j.Tags, j.Meta, j.Fields, j.Tm)
 }
 
 func (m *ccMessage) MarshalJSON() ([]byte, error) {
@@ -491,6 +492,7 @@ func (m *ccMessage) UnmarshalJSON(data []byte) error {
 	m.name = j.Name
 	m.tm = j.Tm
 	m.meta = make(map[string]string)
+	maps.Copy(m.meta, j.Meta)
 	m.tags = make(map[string]string)
 	maps.Copy(m.tags, j.Tags)
 	m.fields = make(map[string]any)
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"strings"
 	"testing"
 	"time"
 )
@@ -41,6 +42,31 @@ func TestJSONDecode(t *testing.T) {
 	}
 }
 
+func TestJSONDecodeMeta(t *testing.T) {
+	input := `{"name":"test1","tags":{"type":"node"},"meta":{"unit":"B"},"fields":{"value":1.23},"timestamp":"2024-06-22T13:51:59.495479906+02:00"}`
+	m, err := FromJSON(json.RawMessage(input))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if unit, ok := m.GetMeta("unit"); !ok || unit != "B" {
+		t.Errorf("Expected meta unit=B, got %v", m.Meta())
+	}
+
+	var x ccMessage
+	if err := json.Unmarshal([]byte(input), &x); err != nil {
+		t.Fatal(err.Error())
+	}
+	if unit, ok := x.GetMeta("unit"); !ok || unit != "B" {
+		t.Errorf("Expected meta unit=B, got %v", x.Meta())
+	}
+
+	// Meta information is not part of the JSON output
+	j, _ := m.ToJSON(nil)
+	if strings.Contains(string(j), "meta") {
+		t.Errorf("Unexpected meta in JSON output %s", string(j))
+	}
+}
+
 func TestILPDecode(t *testing.T) {
 	input := fmt.Sprintf(`test1,type=node value=1.23 %d
 test2,type=socket,type-id=0 value=1.23 %d`, time.Now().UnixNano(), time.Now().UnixNano())
@@ -0,0 +1,478 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package detector provides online phase change and anomaly detection for
+// the metrics of jobs.
+//
+// A Detector keeps the recent values of metrics per job and task (e.g. MPI
+// rank or process) and tests them with configurable detection methods:
+//
+//   - chisquare: Chi-square test of the increase of cumulative values over
+//     all tasks of a job, evaluated periodically by Analyse
+//   - ewma: Z-score of each value against an exponentially weighted moving
+//     average and variance
+//   - cusum: Two-sided cumulative sum control chart
+//   - changepoint: Welch's t-test of the means of two adjacent windows
+//
+// Detected changes are returned as events whose value is the JSON encoded
+// Result with the statistics of the test. The state of a job is dropped when
+// a stop_job event of the job is added, when it was not updated within the
+// job timeout or when the maximum number of jobs is reached.
+package detector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	"github.com/ClusterCockpit/cc-lib/v2/util"
+)
+
+// DetectorConfig configures a detection method for the metrics matching a
+// selector
+type DetectorConfig struct {
+	// Detection method: chisquare, ewma, cusum or changepoint
+	Type string `json:"type"`
+	// Metric names to test: a name, a list of names or "*"
+	Metrics util.SelectorElement `json:"metrics"`
+	// Name of the events of detected changes (default: phase_change)
+	Event string `json:"event,omitempty"`
+	// Additional tags of the events
+	Tags map[string]string `json:"tags,omitempty"`
+	// Number of values kept by chisquare and compared by changepoint, or the
+	// number of values to learn the reference by ewma and cusum
+	// (default: 4 for chisquare, 10 otherwise)
+	Window int `json:"window,omitempty"`
+	// Limit of the test statistic. For chisquare, the default is the critical
+	// value at 95% confidence for the number of tasks. For ewma, cusum and
+	// changepoint it is given in standard deviations (default: 3, 5, 5).
+	Threshold float64 `json:"threshold,omitempty"`
+	// Weight of new values of ewma (default: 0.3)
+	Alpha float64 `json:"alpha,omitempty"`
+	// Deviation in standard deviations tolerated by cusum (default: 0.5)
+	Drift float64 `json:"drift,omitempty"`
+	// Test the differences of successive values instead of the values, e.g.
+	// for counters. Not used by chisquare, which expects cumulative values.
+	Derivative bool `json:"derivative,omitempty"`
+}
+
+// Config is the configuration of a Detector
+type Config struct {
+	Detectors []DetectorConfig `json:"detectors"`
+	// Tags identifying the job of a metric. The first present tag is used
+	// (default: jobid, application).
+	JobTags []string `json:"job_tags,omitempty"`
+	// Tags or fields identifying the task of a metric. The first present one
+	// is used (default: pid, rank).
+	TaskTags []string `json:"task_tags,omitempty"`
+	// Tag of the events holding the job (default: jobid)
+	EventJobTag string `json:"event_job_tag,omitempty"`
+	// Maximum number of jobs. The least recently updated job is dropped for
+	// a new one (default: 1000).
+	MaxJobs int `json:"max_jobs,omitempty"`
+	// Maximum number of tasks per job and metric. Values of further tasks are
+	// ignored (default: 4096).
+	MaxTasks int `json:"max_tasks,omitempty"`
+	// Jobs without new values for this duration are dropped by Analyse
+	// (default: 1h, 0 to keep them until they stop)
+	JobTimeout string `json:"job_timeout,omitempty"`
+}
+
+// Result holds the statistics of a detected change. It is the value of the
+// events returned by a Detector.
+type Result struct {
+	Detector string `json:"detector"`
+	Metric   string `json:"metric"`
+	Job      string `json:"job,omitempty"`
+	Task     string `json:"task,omitempty"`
+	// Test statistic: the chi-square sum, the z-score, the cumulative sum or
+	// the t-statistic. It is negative for decreases, except for chisquare.
+	Statistic float64 `json:"statistic"`
+	Threshold float64 `json:"threshold"`
+	// Value which caused the change and reference mean and standard
+	// deviation of the online methods
+	Value  float64 `json:"value"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	// Number of values the test is based on
+	Samples int `json:"samples"`
+	// Number of tasks compared by chisquare
+	Tasks int `json:"tasks,omitempty"`
+	// Direction of the change of the online methods: up or down
+	Direction string `json:"direction,omitempty"`
+}
+
+// task is the state of a detection method for a task of a job
+type task struct {
+	series series
+	last   float64
+	seen   bool
+}
+
+// metricKey identifies the state of a detection method for a metric
+type metricKey struct {
+	detector int
+	metric   string
+}
+
+// job is the state of all detection methods for a job
+type job struct {
+	updated time.Time
+	metrics map[metricKey]map[string]*task
+}
+
+// Detector tests the metrics of jobs for phase changes and anomalies. It is
+// safe for concurrent use.
+type Detector struct {
+	config     Config
+	jobTimeout time.Duration
+	lock       sync.Mutex
+	jobs       map[string]*job
+}
+
+// NewDetector creates a Detector from its JSON configuration
+func NewDetector(config json.RawMessage) (*Detector, error) {
+	d := &Detector{
+		config: Config{
+			JobTags:     []string{"jobid", "application"},
+			TaskTags:    []string{"pid", "rank"},
+			EventJobTag: "jobid",
+			MaxJobs:     1000,
+			MaxTasks:    4096,
+			JobTimeout:  "1h",
+		},
+		jobs: make(map[string]*job),
+	}
+	dec := json.NewDecoder(bytes.NewReader(config))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&d.config); err != nil {
+		return nil, fmt.Errorf("failed to decode detector config: %w", err)
+	}
+	if len(d.config.Detectors) == 0 {
+		return nil, errors.New("no detectors configured")
+	}
+	if d.config.MaxJobs <= 0 || d.config.MaxTasks <= 0 {
+		return nil, errors.New("max_jobs and max_tasks must be positive")
+	}
+	if len(d.config.JobTimeout) > 0 {
+		t, err := time.ParseDuration(d.config.JobTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse job_timeout %s: %w", d.config.JobTimeout, err)
+		}
+		d.jobTimeout = t
+	}
+	for i := range d.config.Detectors {
+		if err := d.config.Detectors[i].init(); err != nil {
+			return nil, fmt.Errorf("detector %d: %w", i, err)
+		}
+	}
+	return d, nil
+}
+
+// init validates the configuration of a detection method and sets defaults
+func (c *DetectorConfig) init() error {
+	if !c.Metrics.Any && len(c.Metrics.String) == 0 && len(c.Metrics.Group) == 0 {
+		return errors.New("no metrics selected")
+	}
+	if len(c.Event) == 0 {
+		c.Event = "phase_change"
+	}
+	window, threshold := 10, 0.0
+	switch c.Type {
+	case ChiSquare:
+		window = 4
+	case EWMA:
+		threshold = 3
+		if c.Alpha == 0 {
+			c.Alpha = 0.3
+		}
+		if c.Alpha < 0 || c.Alpha > 1 {
+			return fmt.Errorf("alpha %f not in (0, 1]", c.Alpha)
+		}
+	case CUSUM:
+		threshold = 5
+		if c.Drift == 0 {
+			c.Drift = 0.5
+		}
+		if c.Drift < 0 {
+			return fmt.Errorf("negative drift %f", c.Drift)
+		}
+	case ChangePoint:
+		threshold = 5
+	default:
+		return fmt.Errorf("unknown detector type '%s'", c.Type)
+	}
+	if c.Window == 0 {
+		c.Window = window
+	}
+	if c.Type == ChiSquare && c.Window < 3 {
+		return fmt.Errorf("window of %d too small, chisquare requires 3 values", c.Window)
+	}
+	if c.Window < 2 {
+		return fmt.Errorf("window of %d too small", c.Window)
+	}
+	if c.Threshold == 0 {
+		c.Threshold = threshold
+	}
+	if c.Threshold < 0 {
+		return fmt.Errorf("negative threshold %f", c.Threshold)
+	}
+	return nil
+}
+
+// matches reports whether a metric is tested by the detection method
+func (c *DetectorConfig) matches(name string) bool {
+	return c.Metrics.Any || c.Metrics.String == name || slices.Contains(c.Metrics.Group, name)
+}
+
+// identifier returns the value of the first present tag or field of keys
+// and the key
+func identifier(m lp.CCMessage, keys []string, fields bool) (key, value string) {
+	for _, key := range keys {
+		if v, ok := m.GetTag(key); ok {
+			return key, v
+		}
+		if !fields {
+			continue
+		}
+		if v, ok := m.GetField(key); ok {
+			if x := toFloat64(v); !math.IsNaN(x) {
+				return key, strconv.FormatFloat(x, 'f', -1, 64)
+			}
+		}
+	}
+	return "", ""
+}
+
+// toFloat64 converts a field value to float64. It returns NaN for other
+// types.
+func toFloat64(input any) float64 {
+	switch in := input.(type) {
+	case int:
+		return float64(in)
+	case int32:
+		return float64(in)
+	case int64:
+		return float64(in)
+	case uint:
+		return float64(in)
+	case uint32:
+		return float64(in)
+	case uint64:
+		return float64(in)
+	case float32:
+		return float64(in)
+	case float64:
+		return in
+	case string:
+		if x, err := strconv.ParseFloat(in, 64); err == nil {
+			return x
+		}
+	}
+	return math.NaN()
+}
+
+// stoppedJob returns the job of a stop_job event. Like for metrics, it is the
+// value of the first present job tag of the event. Without job tags, it is
+// the job ID of the event payload if jobid is a job tag.
+func (d *Detector) stoppedJob(m lp.CCMessage) (string, bool) {
+	if _, job := identifier(m, d.config.JobTags, false); len(job) > 0 {
+		return job, true
+	}
+	if !slices.Contains(d.config.JobTags, "jobid") {
+		return "", false
+	}
+	j, err := m.GetJob()
+	if err != nil {
+		return "", false
+	}
+	return strconv.FormatInt(j.JobID, 10), true
+}
+
+// Add adds a message to the detector. Metrics matching a detection method are
+// tested by the online methods. The returned events describe the detected
+// changes. A stop_job event drops the state of its job.
+func (d *Detector) Add(m lp.CCMessage) []lp.CCMessage {
+	if name, ok := m.IsJobEvent(); ok {
+		if name == "stop_job" {
+			if job, ok := d.stoppedJob(m); ok {
+				d.Evict(job)
+			}
+		}
+		return nil
+	}
+	if !m.IsMetric() {
+		return nil
+	}
+	detectors := make([]int, 0, len(d.config.Detectors))
+	for i := range d.config.Detectors {
+		if d.config.Detectors[i].matches(m.Name()) {
+			detectors = append(detectors, i)
+		}
+	}
+	if len(detectors) == 0 {
+		return nil
+	}
+	v, _ := m.GetMetricValue()
+	value := toFloat64(v)
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil
+	}
+	_, jobid := identifier(m, d.config.JobTags, false)
+	taskKey, taskid := identifier(m, d.config.TaskTags, true)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	j := d.job(jobid)
+	var events []lp.CCMessage
+	for _, i := range detectors {
+		c := &d.config.Detectors[i]
+		key := metricKey{detector: i, metric: m.Name()}
+		tasks, ok := j.metrics[key]
+		if !ok {
+			tasks = make(map[string]*task)
+			j.metrics[key] = tasks
+		}
+		t, ok := tasks[taskid]
+		if !ok {
+			if len(tasks) >= d.config.MaxTasks {
+				continue
+			}
+			t = &task{series: newSeries(c)}
+			tasks[taskid] = t
+		}
+		x := value
+		if c.Derivative && c.Type != ChiSquare {
+			x -= t.last
+			t.last = value
+			if !t.seen {
+				t.seen = true
+				continue
+			}
+		}
+		result, changed := t.series.add(x)
+		if !changed {
+			continue
+		}
+		result.Detector = c.Type
+		result.Metric = m.Name()
+		result.Job = jobid
+		result.Task = taskid
+		tags := make(map[string]string)
+		for _, key := range []string{"hostname", "type", "type-id"} {
+			if v, ok := m.GetTag(key); ok {
+				tags[key] = v
+			}
+		}
+		if len(taskKey) > 0 {
+			tags[taskKey] = taskid
+		}
+		if e, err := d.event(c, result, tags, m.Time()); err == nil {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// job returns the state of a job. A new job replaces the least recently
+// updated job if the maximum number of jobs is reached. The lock has to be
+// held.
+func (d *Detector) job(ident string) *job {
+	now := time.Now()
+	if j, ok := d.jobs[ident]; ok {
+		j.updated = now
+		return j
+	}
+	if len(d.jobs) >= d.config.MaxJobs {
+		oldest := ""
+		for other, j := range d.jobs {
+			if len(oldest) == 0 || j.updated.Before(d.jobs[oldest].updated) {
+				oldest = other
+			}
+		}
+		cclog.ComponentDebug("Detector", "Drop job", oldest, "for new job", ident)
+		delete(d.jobs, oldest)
+	}
+	j := &job{updated: now, metrics: make(map[metricKey]map[string]*task)}
+	d.jobs[ident] = j
+	return j
+}
+
+// event returns the event of a detected change
+func (d *Detector) event(c *DetectorConfig, result Result, tags map[string]string, t time.Time) (lp.CCMessage, error) {
+	maps.Copy(tags, c.Tags)
+	if len(result.Job) > 0 {
+		tags[d.config.EventJobTag] = result.Job
+	}
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return lp.NewEvent(c.Event, tags, nil, string(payload), t)
+}
+
+// Analyse runs the chi-square tests of all jobs and returns the events of the
+// detected changes. Jobs without new values within the job timeout are
+// dropped.
+func (d *Detector) Analyse(t time.Time) []lp.CCMessage {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var events []lp.CCMessage
+	for ident, j := range d.jobs {
+		if d.jobTimeout > 0 && t.Sub(j.updated) > d.jobTimeout {
+			cclog.ComponentDebug("Detector", "Drop idle job", ident)
+			delete(d.jobs, ident)
+			continue
+		}
+		for key, tasks := range j.metrics {
+			c := &d.config.Detectors[key.detector]
+			if c.Type != ChiSquare {
+				continue
+			}
+			series := make([]*chiSquareSeries, 0, len(tasks))
+			for _, s := range tasks {
+				series = append(series, s.series.(*chiSquareSeries))
+			}
+			result, changed := chiSquareTest(c, series)
+			cclog.ComponentDebug("Detector", fmt.Sprintf("Job %s metric %s: chi-square %f limit %f", ident, key.metric, result.Statistic, result.Threshold))
+			if !changed {
+				continue
+			}
+			result.Detector = c.Type
+			result.Metric = key.metric
+			result.Job = ident
+			if e, err := d.event(c, result, make(map[string]string), t); err == nil {
+				events = append(events, e)
+			}
+		}
+	}
+	return events
+}
+
+// Evict drops the state of a job
+func (d *Detector) Evict(job string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, ok := d.jobs[job]; ok {
+		cclog.ComponentDebug("Detector", "Drop job", job)
+		delete(d.jobs, job)
+	}
+}
+
+// Jobs returns the number of jobs with state
+func (d *Detector) Jobs() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return len(d.jobs)
+}
@@ -0,0 +1,266 @@
+package detector
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	"github.com/ClusterCockpit/cc-lib/v2/schema"
+)
+
+func newTestMetric(t *testing.T, name, jobid, rank string, value float64, tm time.Time) lp.CCMessage {
+	t.Helper()
+	tags := map[string]string{"hostname": "node01", "type": "node"}
+	if len(jobid) > 0 {
+		tags["jobid"] = jobid
+	}
+	if len(rank) > 0 {
+		tags["rank"] = rank
+	}
+	m, err := lp.NewMetric(name, tags, nil, value, tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func decodeResult(t *testing.T, e lp.CCMessage) Result {
+	t.Helper()
+	value, ok := e.GetEventValue()
+	if !ok {
+		t.Fatalf("expected event, got %s", e)
+	}
+	var r Result
+	if err := json.Unmarshal([]byte(value), &r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestDetectorConfig(t *testing.T) {
+	for _, config := range []string{
+		``,
+		`{"detectors": []}`,
+		`{"detectors": [{"type": "unknown", "metrics": "*"}]}`,
+		`{"detectors": [{"type": "ewma"}]}`,
+		`{"detectors": [{"type": "ewma", "metrics": "*", "alpha": 1.5}]}`,
+		`{"detectors": [{"type": "cusum", "metrics": "*", "drift": -1}]}`,
+		`{"detectors": [{"type": "chisquare", "metrics": "*", "window": 2}]}`,
+		`{"detectors": [{"type": "changepoint", "metrics": "*", "threshold": -1}]}`,
+		`{"detectors": [{"type": "ewma", "metrics": "*", "unknown": 1}]}`,
+		`{"detectors": [{"type": "ewma", "metrics": "*"}], "job_timeout": "1x"}`,
+		`{"detectors": [{"type": "ewma", "metrics": "*"}], "max_jobs": -1}`,
+	} {
+		if _, err := NewDetector(json.RawMessage(config)); err == nil {
+			t.Errorf("expected error for config %s", config)
+		}
+	}
+
+	d, err := NewDetector(json.RawMessage(`{"detectors": [{"type": "cusum", "metrics": ["a", "b"]}, {"type": "chisquare", "metrics": "*"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := d.config.Detectors[0]
+	if c.Window != 10 || c.Threshold != 5 || c.Drift != 0.5 || c.Event != "phase_change" {
+		t.Errorf("unexpected defaults %+v", c)
+	}
+	if !c.matches("b") || c.matches("c") || !d.config.Detectors[1].matches("c") {
+		t.Error("unexpected metric selection")
+	}
+	if d.config.Detectors[1].Window != 4 || d.config.Detectors[1].Threshold != 0 {
+		t.Errorf("unexpected chisquare defaults %+v", d.config.Detectors[1])
+	}
+}
+
+func TestDetectorOnline(t *testing.T) {
+	for _, method := range []string{EWMA, CUSUM, ChangePoint} {
+		t.Run(method, func(t *testing.T) {
+			config := fmt.Sprintf(`{"detectors": [{"type": "%s", "metrics": "power", "event": "anomaly", "tags": {"stype": "application"}}]}`, method)
+			d, err := NewDetector(json.RawMessage(config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			var events []lp.CCMessage
+			for i := range 60 {
+				// Alternating values around 100 with a step to 150 after 30 values
+				value := 100 + float64(i%3)
+				if i >= 30 {
+					value += 50
+				}
+				tm := start.Add(time.Duration(i) * time.Second)
+				events = append(events, d.Add(newTestMetric(t, "power", "123", "0", value, tm))...)
+				// Not selected by the detector
+				if e := d.Add(newTestMetric(t, "energy", "123", "0", value*float64(i), tm)); len(e) > 0 {
+					t.Errorf("unexpected events for unselected metric: %v", e)
+				}
+			}
+			if len(events) != 1 {
+				t.Fatalf("expected one event, got %d: %v", len(events), events)
+			}
+			e := events[0]
+			if e.Name() != "anomaly" {
+				t.Errorf("unexpected event name %s", e.Name())
+			}
+			for key, value := range map[string]string{"hostname": "node01", "type": "node", "jobid": "123", "rank": "0", "stype": "application"} {
+				if v, _ := e.GetTag(key); v != value {
+					t.Errorf("expected tag %s=%s, got %s", key, value, v)
+				}
+			}
+			r := decodeResult(t, e)
+			if r.Detector != method || r.Metric != "power" || r.Job != "123" || r.Task != "0" {
+				t.Errorf("unexpected result %+v", r)
+			}
+			if r.Statistic <= r.Threshold || r.Direction != "up" || r.Value < 150 || math.Abs(r.Mean-101) > 1 {
+				t.Errorf("unexpected statistics %+v", r)
+			}
+			if e.Time().Before(start.Add(30 * time.Second)) {
+				t.Errorf("change detected before the step at %s", e.Time())
+			}
+		})
+	}
+}
+
+func TestDetectorDerivative(t *testing.T) {
+	d, err := NewDetector(json.RawMessage(`{"detectors": [{"type": "ewma", "metrics": "flops", "derivative": true}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Counter with an increase of 10 per value, which drops to 1 after 20 values
+	counter := 0.0
+	var events []lp.CCMessage
+	for i := range 40 {
+		if i < 20 {
+			counter += 10 + float64(i%2)
+		} else {
+			counter += 1
+		}
+		events = append(events, d.Add(newTestMetric(t, "flops", "123", "", counter, time.Now()))...)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected one event, got %d", len(events))
+	}
+	if r := decodeResult(t, events[0]); r.Direction != "down" || r.Value != 1 {
+		t.Errorf("unexpected result %+v", r)
+	}
+}
+
+func TestDetectorChiSquare(t *testing.T) {
+	d, err := NewDetector(json.RawMessage(`{
+		"detectors": [{"type": "chisquare", "metrics": "num-blocking-calls", "window": 10, "event": "region", "tags": {"type": "node", "stype": "application"}}],
+		"event_job_tag": "stype-id"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	add := func(step int, increase float64) {
+		for rank := range 4 {
+			value := float64(step)*10 + increase
+			m, err := lp.NewMetric("num-blocking-calls", map[string]string{"application": "myapp"}, nil, value, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.AddField("rank", rank)
+			if e := d.Add(m); len(e) > 0 {
+				t.Errorf("unexpected events of chisquare in Add: %v", e)
+			}
+		}
+	}
+	for step := range 5 {
+		add(step, 0)
+	}
+	if e := d.Analyse(time.Now()); len(e) > 0 {
+		t.Errorf("unexpected events for constant increase: %v", e)
+	}
+	add(5, 100)
+	events := d.Analyse(time.Now())
+	if len(events) != 1 {
+		t.Fatalf("expected one event, got %d", len(events))
+	}
+	e := events[0]
+	if v, _ := e.GetTag("stype-id"); e.Name() != "region" || v != "myapp" || !e.HasTag("stype") {
+		t.Errorf("unexpected event %s", e)
+	}
+	r := decodeResult(t, e)
+	if r.Detector != ChiSquare || r.Tasks != 4 || r.Threshold != ChiSquareLimit(4) || r.Statistic <= r.Threshold || r.Samples != 6 {
+		t.Errorf("unexpected result %+v", r)
+	}
+	// The values were reset after the change
+	if e := d.Analyse(time.Now()); len(e) > 0 {
+		t.Errorf("unexpected events after reset: %v", e)
+	}
+}
+
+func TestDetectorEviction(t *testing.T) {
+	d, err := NewDetector(json.RawMessage(`{"detectors": [{"type": "ewma", "metrics": "*"}], "max_jobs": 2, "job_timeout": "10m"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, jobid := range []string{"1", "2", "3"} {
+		d.Add(newTestMetric(t, "power", jobid, "", 1, time.Now()))
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := d.jobs["1"]; ok || d.Jobs() != 2 {
+		t.Errorf("expected least recently updated job to be dropped, have %d jobs", d.Jobs())
+	}
+
+	stop, err := lp.NewJobStopEvent(&schema.Job{JobID: 2, Cluster: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := d.Add(stop); len(e) > 0 {
+		t.Errorf("unexpected events for stop_job event: %v", e)
+	}
+	if _, ok := d.jobs["2"]; ok || d.Jobs() != 1 {
+		t.Error("expected stopped job to be dropped")
+	}
+
+	// Jobs identified by other job tags are dropped by the tags of the event
+	m, err := lp.NewMetric("power", map[string]string{"hostname": "node01", "application": "myapp"}, nil, 1.0, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Add(m)
+	stop.AddTag("application", "myapp")
+	d.Add(stop)
+	if _, ok := d.jobs["myapp"]; ok || d.Jobs() != 1 {
+		t.Error("expected stopped application to be dropped")
+	}
+
+	d.Analyse(time.Now())
+	if d.Jobs() != 1 {
+		t.Error("expected active job to be kept")
+	}
+	d.Analyse(time.Now().Add(time.Hour))
+	if d.Jobs() != 0 {
+		t.Error("expected idle job to be dropped")
+	}
+}
+
+func TestDetectorConcurrent(t *testing.T) {
+	d, err := NewDetector(json.RawMessage(`{"detectors": [{"type": "chisquare", "metrics": "*"}, {"type": "cusum", "metrics": "*"}], "max_jobs": 4}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Go(func() {
+			for i := range 200 {
+				jobid := fmt.Sprint((w + i) % 6)
+				d.Add(newTestMetric(t, "power", jobid, fmt.Sprint(w), float64(i), time.Now()))
+				if i%50 == 0 {
+					d.Analyse(time.Now())
+					d.Evict(jobid)
+				}
+			}
+		})
+	}
+	wg.Wait()
+	if d.Jobs() > 4 {
+		t.Errorf("expected at most 4 jobs, have %d", d.Jobs())
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package detector
+
+import (
+	"math"
+)
+
+// Detection methods
+const (
+	ChiSquare   = "chisquare"
+	EWMA        = "ewma"
+	CUSUM       = "cusum"
+	ChangePoint = "changepoint"
+)
+
+// Values below this threshold are treated as zero to avoid divisions by zero
+const minDenominator float64 = 1.0e-12
+
+// series is the state of a detection method for the values of one task.
+// Online methods test each value in add. The chi-square test compares all
+// tasks of a job and is evaluated periodically by chiSquareTest instead.
+type series interface {
+	// add adds a value and reports whether it is a change. The result holds
+	// the statistics of the test.
+	add(x float64) (Result, bool)
+}
+
+// newSeries returns the state of a detection method for a new task
+func newSeries(c *DetectorConfig) series {
+	switch c.Type {
+	case EWMA:
+		return &ewmaSeries{config: c}
+	case CUSUM:
+		return &cusumSeries{config: c}
+	case ChangePoint:
+		return &changePointSeries{config: c, values: make([]float64, 0, 2*c.Window)}
+	}
+	return &chiSquareSeries{config: c, values: make([]float64, 0, c.Window)}
+}
+
+// stddev returns the standard deviation for a variance. It is bounded below
+// relative to the mean, so that changes of constant values result in large
+// but finite statistics.
+func stddev(variance, mean float64) float64 {
+	return max(math.Sqrt(max(variance, 0)), minDenominator*max(math.Abs(mean), 1))
+}
+
+// chiSquareSeries keeps the last values of a task. The values are expected
+// to be cumulative, like the counters of the EECPT instrumentation library.
+type chiSquareSeries struct {
+	config *DetectorConfig
+	values []float64
+}
+
+func (s *chiSquareSeries) add(x float64) (Result, bool) {
+	if len(s.values) == s.config.Window {
+		s.values = append(s.values[:0], s.values[1:]...)
+	}
+	s.values = append(s.values, x)
+	return Result{}, false
+}
+
+// ChiSquareRates returns the average increase of the cumulative values over
+// all but the last value (prev) and the last increase (last). At least three
+// values are required.
+func ChiSquareRates(values []float64) (prev, last float64, ok bool) {
+	n := len(values)
+	if n < 3 {
+		return 0, 0, false
+	}
+	prev = (values[n-2] - values[0]) / float64(n-2)
+	last = values[n-1] - values[n-2]
+	return prev, last, true
+}
+
+// reset drops all values but the last one
+func (s *chiSquareSeries) reset() {
+	if n := len(s.values); n > 0 {
+		s.values = append(s.values[:0], s.values[n-1])
+	}
+}
+
+// ChiSquareLimit returns the critical chi-square value at 95% confidence
+// level (p=0.05) for the number of tasks
+func ChiSquareLimit(tasks int) float64 {
+	switch {
+	case tasks < 3:
+		return 3.8145
+	case tasks < 5:
+		return 7.8147
+	case tasks < 9:
+		return 1.4067e1
+	case tasks < 17:
+		return 2.4996e1
+	case tasks < 33:
+		return 4.4985e1
+	case tasks < 65:
+		return 8.2529e1
+	case tasks < 73:
+		return 9.1670e1
+	case tasks < 129:
+		return 1.5430e2
+	case tasks < 257:
+		return 2.9325e2
+	case tasks < 513:
+		return 5.6470e2
+	}
+	return 1.0985e3
+}
+
+// chiSquareTest compares the last increase of each task with its average
+// increase. The statistic is the sum of (last - prev)^2 / prev over all
+// tasks. If it exceeds the threshold, the job changed its phase and the
+// values of the tasks are reset.
+func chiSquareTest(c *DetectorConfig, tasks []*chiSquareSeries) (Result, bool) {
+	result := Result{Tasks: len(tasks), Threshold: c.Threshold}
+	if result.Threshold <= 0 {
+		result.Threshold = ChiSquareLimit(len(tasks))
+	}
+	for _, s := range tasks {
+		prev, last, ok := ChiSquareRates(s.values)
+		if !ok {
+			continue
+		}
+		result.Samples = max(result.Samples, len(s.values))
+		if prev > minDenominator {
+			result.Statistic += math.Pow(last-prev, 2) / prev
+		}
+	}
+	if result.Statistic <= result.Threshold {
+		return result, false
+	}
+	for _, s := range tasks {
+		s.reset()
+	}
+	return result, true
+}
+
+// ewmaSeries keeps an exponentially weighted moving average and variance.
+// After the warm-up of window values, a value is a change if its z-score
+// exceeds the threshold. The average is then restarted with the new value.
+type ewmaSeries struct {
+	config   *DetectorConfig
+	samples  int
+	mean     float64
+	variance float64
+}
+
+func (s *ewmaSeries) add(x float64) (Result, bool) {
+	if s.samples >= s.config.Window {
+		sd := stddev(s.variance, s.mean)
+		z := (x - s.mean) / sd
+		if math.Abs(z) > s.config.Threshold {
+			result := Result{
+				Value:     x,
+				Statistic: z,
+				Threshold: s.config.Threshold,
+				Mean:      s.mean,
+				StdDev:    sd,
+				Samples:   s.samples,
+				Direction: direction(z),
+			}
+			*s = ewmaSeries{config: s.config, samples: 1, mean: x}
+			return result, true
+		}
+	}
+	if s.samples == 0 {
+		s.mean = x
+	} else {
+		diff := x - s.mean
+		incr := s.config.Alpha * diff
+		s.mean += incr
+		s.variance = (1 - s.config.Alpha) * (s.variance + diff*incr)
+	}
+	s.samples++
+	return Result{}, false
+}
+
+// cusumSeries is a two-sided cumulative sum control chart. The reference mean
+// and standard deviation are learned from the first window values. Afterwards,
+// deviations of more than drift standard deviations are accumulated. If a sum
+// exceeds threshold standard deviations, the value is a change and the
+// reference is learned again.
+type cusumSeries struct {
+	config  *DetectorConfig
+	samples int
+	mean    float64
+	m2      float64
+	high    float64
+	low     float64
+}
+
+func (s *cusumSeries) add(x float64) (Result, bool) {
+	if s.samples < s.config.Window {
+		// Welford's online algorithm for the reference mean and variance
+		s.samples++
+		diff := x - s.mean
+		s.mean += diff / float64(s.samples)
+		s.m2 += diff * (x - s.mean)
+		return Result{}, false
+	}
+	sd := stddev(s.m2/float64(s.samples-1), s.mean)
+	s.high = max(0, s.high+(x-s.mean)/sd-s.config.Drift)
+	s.low = max(0, s.low+(s.mean-x)/sd-s.config.Drift)
+	if s.high <= s.config.Threshold && s.low <= s.config.Threshold {
+		return Result{}, false
+	}
+	result := Result{
+		Value:     x,
+		Statistic: s.high,
+		Threshold: s.config.Threshold,
+		Mean:      s.mean,
+		StdDev:    sd,
+		Samples:   s.samples,
+		Direction: "up",
+	}
+	if s.low > s.high {
+		result.Statistic = -s.low
+		result.Direction = "down"
+	}
+	*s = cusumSeries{config: s.config, samples: 1, mean: x}
+	return result, true
+}
+
+// changePointSeries compares the means of two adjacent windows of values
+// with Welch's t-test. If the t-statistic exceeds the threshold, the value
+// is a change and only the last value is kept to compare the next windows.
+type changePointSeries struct {
+	config *DetectorConfig
+	values []float64
+}
+
+func (s *changePointSeries) add(x float64) (Result, bool) {
+	w := s.config.Window
+	if len(s.values) == 2*w {
+		s.values = append(s.values[:0], s.values[1:]...)
+	}
+	s.values = append(s.values, x)
+	if len(s.values) < 2*w {
+		return Result{}, false
+	}
+	before, beforeVar := meanVariance(s.values[:w])
+	after, afterVar := meanVariance(s.values[w:])
+	sd := stddev((beforeVar+afterVar)/float64(w), before)
+	t := (after - before) / sd
+	if math.Abs(t) <= s.config.Threshold {
+		return Result{}, false
+	}
+	result := Result{
+		Value:     x,
+		Statistic: t,
+		Threshold: s.config.Threshold,
+		Mean:      before,
+		StdDev:    math.Sqrt(beforeVar),
+		Samples:   len(s.values),
+		Direction: direction(t),
+	}
+	s.values = append(s.values[:0], x)
+	return result, true
+}
+
+// meanVariance returns the mean and the sample variance of values
+func meanVariance(values []float64) (mean, variance float64) {
+	for _, x := range values {
+		mean += x
+	}
+	mean /= float64(len(values))
+	if len(values) < 2 {
+		return mean, 0
+	}
+	for _, x := range values {
+		variance += (x - mean) * (x - mean)
+	}
+	return mean, variance / float64(len(values)-1)
+}
+
+// direction returns the direction of a change by the sign of its statistic
+func direction(statistic float64) string {
+	if statistic < 0 {
+		return "down"
+	}
+	return "up"
+}
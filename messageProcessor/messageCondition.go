@@ -0,0 +1,52 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package messageprocessor
+
+import (
+	"fmt"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// MessageCondition is a pre-compiled boolean condition which can be evaluated
+// for single messages. It uses the same evaluation environment as the
+// conditions of the message processor stages (name, tags, meta, fields, ...).
+type MessageCondition struct {
+	condition string
+	program   *vm.Program
+}
+
+// NewMessageCondition compiles the condition once so that the evaluation per
+// message is cheap.
+func NewMessageCondition(condition string) (*MessageCondition, error) {
+	program, err := expr.Compile(sanitizeExprString(condition), expr.Env(baseenv), expr.AsBool())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create condition evaluable of '%s': %w", condition, err)
+	}
+	return &MessageCondition{
+		condition: condition,
+		program:   program,
+	}, nil
+}
+
+// String returns the original condition
+func (c *MessageCondition) String() string {
+	return c.condition
+}
+
+// Match evaluates the condition for message m
+func (c *MessageCondition) Match(m lp.CCMessage) (bool, error) {
+	params := getParamMap(m)
+	defer putParamMap(params)
+
+	value, err := expr.Run(c.program, params)
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate '%s': %w", c.condition, err)
+	}
+	return value.(bool), nil
+}
@@ -243,6 +243,16 @@ func getParamMap(point lp.CCMessage) map[string]any {
 	return params
 }
 
+// putParamMap returns a parameter map created by getParamMap to the pool
+func putParamMap(params map[string]any) {
+	params["field"] = nil
+	params["tag"] = nil
+	paramMapPool.Put(params["fields"])
+	paramMapPool.Put(params["tags"])
+	paramMapPool.Put(params["meta"])
+	paramMapPool.Put(params)
+}
+
 var baseenv = map[string]any{
 	"name":        "",
 	"messagetype": "unknown",
@@ -772,23 +782,17 @@ func (mp *messageProcessor) ProcessMessage(m lp.CCMessage) (lp.CCMessage, error)
 	var err error
 	out := lp.FromMessage(m)
 
+	mp.mutex.RLock()
 	if len(mp.stages) == 0 {
+		// SetStages takes the write lock
+		mp.mutex.RUnlock()
 		mp.SetStages(mp.DefaultStages())
+		mp.mutex.RLock()
 	}
-
-	mp.mutex.RLock()
 	defer mp.mutex.RUnlock()
 
 	params := getParamMap(out)
-
-	defer func() {
-		params["field"] = nil
-		params["tag"] = nil
-		paramMapPool.Put(params["fields"])
-		paramMapPool.Put(params["tags"])
-		paramMapPool.Put(params["meta"])
-		paramMapPool.Put(params)
-	}()
+	defer putParamMap(params)
 
 	for _, s := range mp.stages {
 		switch s {
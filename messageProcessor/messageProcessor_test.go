@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -449,3 +450,30 @@ func BenchmarkProcessing(b *testing.B) {
 	b.StopTimer()
 	b.ReportMetric(float64(b.Elapsed())/float64(len(mlist)*b.N), "ns/message")
 }
+
+func TestProcessMessageConcurrent(t *testing.T) {
+	mp, err := NewMessageProcessor()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	mp.AddDropMessagesByName("mylog")
+	mlists, err := generate_message_lists(8, 100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// The default stages are set by the first call of ProcessMessage
+	var wg sync.WaitGroup
+	for _, mlist := range mlists {
+		wg.Go(func() {
+			for _, m := range mlist {
+				out, err := mp.ProcessMessage(m)
+				if err != nil {
+					t.Errorf("failed to process message: %s", m)
+				} else if out != nil && out.Name() == "mylog" {
+					t.Errorf("message was not dropped: %s", out)
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
@@ -17,6 +17,12 @@ var AvailableReceivers = map[string]func(name string, config json.RawMessage) (R
 	"nats":       NewNatsReceiver,
 	"eecpt":      NewEECPTReceiver,
 	"prometheus": NewPrometheusReceiver,
+	"file":       NewFileReceiver,
+	"graphite":   NewGraphiteReceiver,
+	"statsd":     NewStatsdReceiver,
+	"socket":     NewSocketReceiver,
+	"snmp":       NewSNMPReceiver,
+	"slurm":      NewSlurmReceiver,
 	"ipmi":       NewIPMIReceiver,
 	"redfish":    NewRedfishReceiver,
 }
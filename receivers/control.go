@@ -0,0 +1,132 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+)
+
+// Name of the control message to get or set the power cap of a node in watts.
+// The value "off" removes the power cap.
+const CONTROL_POWERCAP = "powercap"
+
+// Number of queued control messages per control worker
+const CONTROL_QUEUE_SIZE = 100
+
+// ControlReceiver is implemented by receivers which act on control messages,
+// e.g. to set the power cap of a node. The result of a control message is sent
+// as event with the name of the control message to the sink.
+type ControlReceiver interface {
+	Receiver
+	// Control queues a control message. It reports whether the receiver handles
+	// the message, i.e. supports the control and monitors the host.
+	Control(msg lp.CCMessage) bool
+}
+
+// controlQueue applies control messages in parallel for different hosts and in
+// order for the same host
+type controlQueue struct {
+	inputs []chan lp.CCMessage
+	wg     sync.WaitGroup
+}
+
+// newControlQueue creates a queue for the given number of workers. The workers
+// are started by start.
+func newControlQueue(workers int) *controlQueue {
+	q := &controlQueue{
+		inputs: make([]chan lp.CCMessage, max(workers, 1)),
+	}
+	for i := range q.inputs {
+		q.inputs[i] = make(chan lp.CCMessage, CONTROL_QUEUE_SIZE)
+	}
+	return q
+}
+
+// start starts the workers which call apply for each queued control message
+func (q *controlQueue) start(apply func(msg lp.CCMessage)) {
+	for _, input := range q.inputs {
+		q.wg.Go(func() {
+			for msg := range input {
+				apply(msg)
+			}
+		})
+	}
+}
+
+// add queues a control message. Messages of the same host are processed by the
+// same worker.
+func (q *controlQueue) add(msg lp.CCMessage) {
+	hostname, _ := msg.GetTag("hostname")
+	h := fnv.New32a()
+	h.Write([]byte(hostname))
+	q.inputs[h.Sum32()%uint32(len(q.inputs))] <- msg
+}
+
+// close waits until all queued control messages are processed. Without
+// started workers, the queued messages are dropped.
+func (q *controlQueue) close() {
+	for _, input := range q.inputs {
+		close(input)
+	}
+	q.wg.Wait()
+}
+
+// parsePowerCap parses the value of a powercap control message. It returns the
+// power cap in watts or 0 for "off".
+func parsePowerCap(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "off" {
+		return 0, nil
+	}
+	watts, err := strconv.Atoi(value)
+	if err != nil || watts <= 0 {
+		return 0, fmt.Errorf("invalid power cap '%s', expected watts or 'off'", value)
+	}
+	return watts, nil
+}
+
+// formatPowerCap formats a power cap in watts, 0 for "off"
+func formatPowerCap(watts int) string {
+	if watts == 0 {
+		return "off"
+	}
+	return strconv.Itoa(watts)
+}
+
+// sendControlResult sends the result of a control message as event to the sink.
+// On success, the event value is the current value of the control, otherwise
+// the error.
+func (r *receiver) sendControlResult(msg lp.CCMessage, value string, err error) {
+	tags := maps.Clone(msg.Tags())
+	tags["status"] = "ok"
+	if err != nil {
+		tags["status"] = "failed"
+		value = err.Error()
+	}
+	meta := map[string]string{
+		"source": r.name,
+		"group":  "Control",
+	}
+	y, err := lp.NewEvent(msg.Name(), tags, meta, value, time.Now())
+	if err != nil {
+		return
+	}
+	if r.mp != nil {
+		y, err = r.mp.ProcessMessage(y)
+		if err != nil || y == nil {
+			return
+		}
+	}
+	r.sink <- y
+}
@@ -0,0 +1,143 @@
+package receivers
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+)
+
+// receiveControlResult waits for the result of a control message
+func receiveControlResult(t *testing.T, sink chan lp.CCMessage) lp.CCMessage {
+	t.Helper()
+	for {
+		select {
+		case m := <-sink:
+			if m.IsEvent() && m.Name() == CONTROL_POWERCAP {
+				return m
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for control result")
+		}
+	}
+}
+
+// testPowerCap sends a powercap control message to the receiver and checks the result
+func testPowerCap(t *testing.T, r ControlReceiver, sink chan lp.CCMessage, method, value, status, result string) lp.CCMessage {
+	t.Helper()
+	tags := map[string]string{"hostname": "node01", "type": "node"}
+	var msg lp.CCMessage
+	if method == "GET" {
+		msg, _ = lp.NewGetControl(CONTROL_POWERCAP, tags, nil, time.Now())
+	} else {
+		msg, _ = lp.NewPutControl(CONTROL_POWERCAP, tags, nil, value, time.Now())
+	}
+	if !r.Control(msg) {
+		t.Fatalf("%s %s was not accepted", method, value)
+	}
+	e := receiveControlResult(t, sink)
+	if v, _ := e.GetTag("status"); v != status {
+		t.Errorf("%s %s: expected status %s, got %s", method, value, status, v)
+	}
+	if v, _ := e.GetEventValue(); status == "ok" && v != result {
+		t.Errorf("%s %s: expected result '%s', got '%s'", method, value, result, v)
+	}
+	if v, _ := e.GetTag("hostname"); v != "node01" {
+		t.Errorf("invalid hostname '%s'", v)
+	}
+	return e
+}
+
+// testControlReceiver records the control messages of its host
+type testControlReceiver struct {
+	receiver
+	hostname string
+	lock     sync.Mutex
+	values   []string
+}
+
+func (r *testControlReceiver) Start() {}
+func (r *testControlReceiver) Close() {}
+
+func (r *testControlReceiver) Control(msg lp.CCMessage) bool {
+	if hostname, _ := msg.GetTag("hostname"); hostname != r.hostname {
+		return false
+	}
+	value, _ := msg.GetControlValue()
+	r.lock.Lock()
+	r.values = append(r.values, value)
+	r.lock.Unlock()
+	return true
+}
+
+func TestReceiveManagerControl(t *testing.T) {
+	r1 := &testControlReceiver{receiver: receiver{name: "r1"}, hostname: "node01"}
+	r2 := &testControlReceiver{receiver: receiver{name: "r2"}, hostname: "node02"}
+	rm := &receiveManager{inputs: []Receiver{r1, r2}, done: make(chan bool)}
+	control := make(chan lp.CCMessage)
+	rm.AddControlInput(control)
+	rm.Start()
+
+	for _, host := range []string{"node01", "node02", "node01", "node03"} {
+		msg, _ := lp.NewPutControl(CONTROL_POWERCAP, map[string]string{"hostname": host}, nil, host, time.Now())
+		control <- msg
+	}
+	// Metrics are not passed to the receivers
+	msg, _ := lp.NewMetric(CONTROL_POWERCAP, map[string]string{"hostname": "node01"}, nil, 300, time.Now())
+	control <- msg
+	rm.Close()
+
+	if !slices.Equal(r1.values, []string{"node01", "node01"}) || !slices.Equal(r2.values, []string{"node02"}) {
+		t.Errorf("invalid control messages %v %v", r1.values, r2.values)
+	}
+}
+
+func TestControlQueue(t *testing.T) {
+	var (
+		lock   sync.Mutex
+		values = make(map[string][]string)
+	)
+	q := newControlQueue(4)
+	q.start(func(msg lp.CCMessage) {
+		hostname, _ := msg.GetTag("hostname")
+		value, _ := msg.GetControlValue()
+		lock.Lock()
+		values[hostname] = append(values[hostname], value)
+		lock.Unlock()
+	})
+	for i := range 100 {
+		for _, host := range []string{"node01", "node02", "node03"} {
+			msg, _ := lp.NewPutControl(CONTROL_POWERCAP, map[string]string{"hostname": host}, nil, string(rune('a'+i%26)), time.Now())
+			q.add(msg)
+		}
+	}
+	q.close()
+
+	// Messages of the same host are applied in order
+	for host, v := range values {
+		if len(v) != 100 {
+			t.Errorf("%s: expected 100 messages, got %d", host, len(v))
+		}
+		for i := range v {
+			if v[i] != string(rune('a'+i%26)) {
+				t.Errorf("%s: message %d out of order", host, i)
+				break
+			}
+		}
+	}
+}
+
+func TestParsePowerCap(t *testing.T) {
+	for value, want := range map[string]int{"300": 300, " 250 ": 250, "off": 0} {
+		if got, err := parsePowerCap(value); err != nil || got != want {
+			t.Errorf("%s: expected %d, got %d (%v)", value, want, got, err)
+		}
+	}
+	for _, value := range []string{"", "0", "-100", "300W", "on"} {
+		if _, err := parsePowerCap(value); err == nil {
+			t.Errorf("invalid power cap '%s' was accepted", value)
+		}
+	}
+}
@@ -12,14 +12,15 @@ import (
 	"fmt"
 	"math"
 	"net/http"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
 	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	"github.com/ClusterCockpit/cc-lib/v2/detector"
 	mp "github.com/ClusterCockpit/cc-lib/v2/messageProcessor"
+	"github.com/ClusterCockpit/cc-lib/v2/util"
 	influx "github.com/ClusterCockpit/cc-line-protocol/v2/lineprotocol"
 )
 
@@ -28,7 +29,7 @@ const (
 	chiSquareDistThreshold float64 = 1.0e-12
 )
 
-// overwritten by configuration. 4 is the minimum
+// overwritten by configuration, used by the deprecated EECPTReceiverTask
 var eecpt_analysis_buffer_size = 4
 
 type EECPTReceiverConfig struct {
@@ -50,12 +51,32 @@ type EECPTReceiverConfig struct {
 	Password     string `json:"password"`
 	useBasicAuth bool
 
+	// Serve HTTPS, optionally with client certificate authentication
+	TLS *util.TLSConfig `json:"tls,omitempty"`
+
 	AnalysisBufferLength int    `json:"analysis_buffer_size"`
 	AnalysisInterval     string `json:"analysis_interval"`
 	AnalysisMetric       string `json:"analysis_metric"`
 	analysisInterval     time.Duration
+
+	// Configuration of the phase and anomaly detection. If set, it replaces
+	// the chi-square test of analysis_metric.
+	Analysis json.RawMessage `json:"analysis,omitempty"`
+}
+
+type EECPTReceiver struct {
+	receiver
+	// meta   map[string]string
+	config         EECPTReceiverConfig
+	server         *http.Server
+	wg             sync.WaitGroup
+	detector       *detector.Detector
+	analysisTicker *time.Ticker
+	analysisDone   chan bool
 }
 
+// Deprecated: EECPTReceiverTask is no longer used by the EECPTReceiver. Use
+// the detector package instead.
 type EECPTReceiverTask struct {
 	ident      string
 	tags       map[string]string
@@ -64,23 +85,15 @@ type EECPTReceiverTask struct {
 	subtasks   map[int64]*EECPTReceiverTask
 }
 
+// Deprecated: EECPTReceiverJob is no longer used by the EECPTReceiver. Use
+// the detector package instead.
 type EECPTReceiverJob struct {
 	ident string
 	tags  map[string]string
 	tasks map[int64]*EECPTReceiverTask
 }
 
-type EECPTReceiver struct {
-	receiver
-	// meta   map[string]string
-	config         EECPTReceiverConfig
-	server         *http.Server
-	wg             sync.WaitGroup
-	jobs           map[string]*EECPTReceiverJob
-	analysisTicker *time.Ticker
-	analysisDone   chan bool
-}
-
+// Deprecated: Use detector.NewDetector instead.
 func NewJob(ident string) *EECPTReceiverJob {
 	j := new(EECPTReceiverJob)
 	j.ident = ident
@@ -90,26 +103,6 @@ func NewJob(ident string) *EECPTReceiverJob {
 	return j
 }
 
-func (job *EECPTReceiverJob) newTask(id int64) {
-	if _, ok := job.tasks[id]; !ok {
-		job.tasks[id] = new(EECPTReceiverTask)
-		job.tasks[id].ident = fmt.Sprintf("%d", id)
-		cclog.ComponentDebug("EECPTReceiver", "New task: ", id)
-		job.tasks[id].buffer = make([]float64, 0)
-		job.tasks[id].tags = make(map[string]string)
-		job.tasks[id].subtasks = make(map[int64]*EECPTReceiverTask)
-	}
-}
-
-// func (task *EECPTReceiverTask) newSubTask(id int64) {
-// 	if _, ok := task.subtasks[id]; !ok {
-// 		task.subtasks[id] = new(EECPTReceiverTask)
-// 		task.subtasks[id].ident = fmt.Sprintf("%d", id)
-// 		task.subtasks[id].buffer = make([]float64, 0)
-// 		task.subtasks[id].tags = make(map[string]string)
-// 	}
-// }
-
 // Analyse performs chi-square statistical test to detect phase transitions in application behavior.
 // It computes the chi-square statistic by comparing the expected rate of change (prev)
 // with the observed change (last) across all tasks in the job.
@@ -133,33 +126,8 @@ func (job *EECPTReceiverJob) Reset() {
 
 // ChiSquareLimit returns the critical chi-square value at 95% confidence level (p=0.05)
 // for the given number of degrees of freedom (number of tasks).
-// These are pre-calculated chi-square distribution values used to determine
-// if a phase transition is statistically significant.
 func (job *EECPTReceiverJob) ChiSquareLimit() float64 {
-	length := len(job.tasks)
-	switch {
-	case length < 3:
-		return 3.8145
-	case length < 5:
-		return 7.8147
-	case length < 9:
-		return 1.4067e1
-	case length < 17:
-		return 2.4996e1
-	case length < 33:
-		return 4.4985e1
-	case length < 65:
-		return 8.2529e1
-	case length < 73:
-		return 9.1670e1
-	case length < 129:
-		return 1.5430e2
-	case length < 257:
-		return 2.9325e2
-	case length < 513:
-		return 5.6470e2
-	}
-	return 1.0985e3
+	return detector.ChiSquareLimit(len(job.tasks))
 }
 
 // Analyse computes the expected rate of change (prev) and last observed change (last)
@@ -169,12 +137,10 @@ func (job *EECPTReceiverJob) ChiSquareLimit() float64 {
 func (task *EECPTReceiverTask) Analyse() (float64, float64, error) {
 	task.bufferLock.RLock()
 	defer task.bufferLock.RUnlock()
-	buflen := len(task.buffer)
-	if buflen < 3 {
-		return 0, 0, fmt.Errorf("Analysis of task %s requires at least 3 entries in buffer but have only %d", task.ident, buflen)
+	prev, last, ok := detector.ChiSquareRates(task.buffer)
+	if !ok {
+		return 0, 0, fmt.Errorf("Analysis of task %s requires at least 3 entries in buffer but have only %d", task.ident, len(task.buffer))
 	}
-	prev := float64(task.buffer[buflen-2]-task.buffer[0]) / float64(buflen-2)
-	last := float64(task.buffer[buflen-1] - task.buffer[buflen-2])
 	return prev, last, nil
 }
 
@@ -202,127 +168,49 @@ func (task *EECPTReceiverTask) Add(value float64) {
 
 func (task *EECPTReceiverTask) Reset() {
 	task.bufferLock.Lock()
-	// store the last value in the buffer
-	last := task.buffer[len(task.buffer)-1]
-	// reset buffer to zero entries
-	task.buffer = task.buffer[:0]
-	// add the last value back to the buffer
-	task.buffer = append(task.buffer, last)
+	// keep only the last value in the buffer
+	if n := len(task.buffer); n > 0 {
+		task.buffer = append(task.buffer[:0], task.buffer[n-1])
+	}
 	task.bufferLock.Unlock()
 }
 
 func (r *EECPTReceiver) Start() {
 	cclog.ComponentDebug(r.name, "START")
 	r.wg.Go(func() {
-		err := r.server.ListenAndServe()
+		var err error
+		if r.server.TLSConfig != nil {
+			err = r.server.ListenAndServeTLS("", "")
+		} else {
+			err = r.server.ListenAndServe()
+		}
 		if err != nil && err.Error() != "http: Server closed" {
 			cclog.ComponentError(r.name, err.Error())
 		}
 	})
 	r.analysisTicker = time.NewTicker(r.config.analysisInterval)
-	r.wg.Add(1)
-	go func(myr **EECPTReceiver) {
+	r.wg.Go(func() {
 		for {
 			select {
-			case <-(*myr).analysisDone:
-				(*myr).analysisTicker.Stop()
-				(*myr).wg.Done()
+			case <-r.analysisDone:
+				r.analysisTicker.Stop()
 				return
-			case <-(*myr).analysisTicker.C:
-				for _, job := range (*myr).jobs {
-					result := job.Analyse()
-					if result > job.ChiSquareLimit() {
-						cclog.ComponentDebug(r.name, fmt.Sprintf("Job %s changed phases (analysis %f chiSquareLimit %f)", job.ident, result, job.ChiSquareLimit()))
-						y, err := lp.NewEvent("region", map[string]string{"type": "node", "stype": "application"}, nil, "region changed", time.Now())
-						if err == nil {
-							y.AddTag("stype-id", job.ident)
-							m, err := (*myr).mp.ProcessMessage(y)
-							if err == nil && m != nil {
-								(*myr).sink <- m
-							}
-						}
-						job.Reset()
-					} else {
-						cclog.ComponentDebug(r.name, fmt.Sprintf("Job %s no change (analysis %f chiSquareLimit %f)", job.ident, result, job.ChiSquareLimit()))
-					}
-				}
+			case t := <-r.analysisTicker.C:
+				r.sendEvents(r.detector.Analyse(t))
 			}
 		}
-	}(&r)
-}
-
-func fieldToFloat64(input any) float64 {
-	switch in := input.(type) {
-	case int:
-		return float64(in)
-	case int32:
-		return float64(in)
-	case int64:
-		return float64(in)
-	case uint:
-		return float64(in)
-	case uint32:
-		return float64(in)
-	case uint64:
-		return float64(in)
-	case float32:
-		return float64(in)
-	case float64:
-		return in
-	case string:
-		x, err := strconv.ParseFloat(in, 64)
-		if err == nil {
-			return x
-		}
-	}
-	return math.NaN()
+	})
 }
 
-func (r *EECPTReceiver) toAnalysis(msg lp.CCMessage) {
-	jobid := ""
-	if j, ok := msg.GetTag("jobid"); ok {
-		jobid = j
-	}
-	if len(jobid) == 0 && msg.HasTag("application") {
-		jobid, _ = msg.GetTag("application")
-	}
-	rank := int64(-1)
-	if t, ok := msg.GetTag("rank"); ok {
-		x, err := strconv.ParseInt(t, 10, 64)
-		if err == nil {
-			rank = x
-		}
-	} else {
-		if t, ok := msg.GetField("rank"); ok {
-			rank = int64(fieldToFloat64(t))
-		}
-	}
-	if t, ok := msg.GetTag("pid"); ok {
-		x, err := strconv.ParseInt(t, 10, 64)
-		if err == nil {
-			rank = x
-		}
-	} else {
-		if t, ok := msg.GetField("pid"); ok {
-			rank = int64(t.(int))
+// sendEvents sends the events of detected phase changes
+func (r *EECPTReceiver) sendEvents(events []lp.CCMessage) {
+	for _, e := range events {
+		cclog.ComponentDebug(r.name, "Phase change:", e.String())
+		m, err := r.mp.ProcessMessage(e)
+		if err == nil && m != nil {
+			r.sink <- m
 		}
 	}
-	value := float64(0)
-	if v, ok := msg.GetField("value"); ok {
-		value = fieldToFloat64(v)
-	}
-	if _, ok := r.jobs[jobid]; !ok {
-		newjob := NewJob(jobid)
-		r.jobs[jobid] = newjob
-	}
-	job := r.jobs[jobid]
-
-	if _, ok := job.tasks[rank]; !ok {
-		job.newTask(rank)
-	}
-	task := job.tasks[rank]
-
-	task.Add(value)
 }
 
 func (r *EECPTReceiver) ServerHttp(w http.ResponseWriter, req *http.Request) {
@@ -359,9 +247,7 @@ func (r *EECPTReceiver) ServerHttp(w http.ResponseWriter, req *http.Request) {
 		m, err := r.mp.ProcessMessage(y)
 		if err == nil && m != nil {
 			r.sink <- m
-			if m.Name() == r.config.AnalysisMetric {
-				r.toAnalysis(m)
-			}
+			r.sendEvents(r.detector.Add(m))
 		}
 	}
 
@@ -434,6 +320,33 @@ func NewEECPTReceiver(name string, config json.RawMessage) (Receiver, error) {
 		return nil, fmt.Errorf("buffer length of %d not allowed", r.config.AnalysisBufferLength)
 	}
 	eecpt_analysis_buffer_size = r.config.AnalysisBufferLength
+	analysis := r.config.Analysis
+	if len(analysis) == 0 {
+		// Chi-square test of analysis_metric with events for the application.
+		// The test needs at least three values, smaller buffers were accepted before.
+		if r.config.AnalysisBufferLength < 3 {
+			cclog.ComponentWarn(r.name, fmt.Sprintf("Raising analysis_buffer_size %d to the minimum of 3", r.config.AnalysisBufferLength))
+		}
+		legacy, err := json.Marshal(detector.Config{
+			Detectors: []detector.DetectorConfig{{
+				Type:    detector.ChiSquare,
+				Metrics: util.SelectorElement{String: r.config.AnalysisMetric},
+				Event:   "region",
+				Tags:    map[string]string{"type": "node", "stype": "application"},
+				Window:  max(r.config.AnalysisBufferLength, 3),
+			}},
+			EventJobTag: "stype-id",
+		})
+		if err != nil {
+			return nil, err
+		}
+		analysis = legacy
+	}
+	d, err := detector.NewDetector(analysis)
+	if err != nil {
+		return nil, fmt.Errorf("%s: analysis configuration: %w", r.name, err)
+	}
+	r.detector = d
 
 	msgp, err := mp.NewMessageProcessor()
 	if err != nil {
@@ -463,9 +376,15 @@ func NewEECPTReceiver(name string, config json.RawMessage) (Receiver, error) {
 		Handler:     nil,
 		IdleTimeout: r.config.idleTimeout,
 	}
+	if r.config.TLS != nil {
+		tlsConfig, err := r.config.TLS.ServerConfig()
+		if err != nil {
+			return nil, fmt.Errorf("%s: TLS configuration: %w", r.name, err)
+		}
+		r.server.TLSConfig = tlsConfig
+	}
 	r.server.SetKeepAlivesEnabled(r.config.KeepAlivesEnabled)
 
-	r.jobs = make(map[string]*EECPTReceiverJob)
 	r.analysisDone = make(chan bool)
 
 	return r, nil
@@ -2,12 +2,15 @@ package receivers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
 	"testing"
 	"time"
 
 	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	"github.com/ClusterCockpit/cc-lib/v2/detector"
+	"github.com/ClusterCockpit/cc-lib/v2/schema"
 )
 
 var eecpt_config string = `
@@ -47,6 +50,112 @@ func TestEecptReceiver(t *testing.T) {
 	r.Close()
 }
 
+func TestEecptReceiverBufferSize(t *testing.T) {
+	// Buffers smaller than the minimum of the chi-square test are raised
+	for size, valid := range map[int]bool{-1: false, 0: false, 1: true, 2: true, 4: true} {
+		config := fmt.Sprintf(`{"type": "eecpt", "address": "localhost", "port": "8090", "path": "/buffer%d", "analysis_buffer_size": %d}`, size, size)
+		_, err := NewEECPTReceiver("testreceiver", json.RawMessage(config))
+		if (err == nil) != valid {
+			t.Errorf("analysis_buffer_size %d: unexpected error %v", size, err)
+		}
+	}
+}
+
+func TestEecptReceiverJob(t *testing.T) {
+	size := eecpt_analysis_buffer_size
+	defer func() { eecpt_analysis_buffer_size = size }()
+	eecpt_analysis_buffer_size = 4
+
+	// The deprecated job wraps the chi-square test of the detector package
+	job := NewJob("1234")
+	for id := range int64(2) {
+		job.tasks[id] = &EECPTReceiverTask{ident: fmt.Sprint(id)}
+		for _, v := range []float64{-5, 0, 1, 2, 10} {
+			job.tasks[id].Add(v)
+		}
+	}
+	if got := job.Analyse(); got != 98 {
+		t.Errorf("invalid statistic %f, expected 98", got)
+	}
+	if got := job.ChiSquareLimit(); got != detector.ChiSquareLimit(2) {
+		t.Errorf("invalid limit %f", got)
+	}
+	job.Reset()
+	if _, _, err := job.tasks[0].Analyse(); err == nil {
+		t.Error("analysis of a reset task succeeded")
+	}
+	if got := job.Analyse(); got != 0 {
+		t.Errorf("invalid statistic %f after reset, expected 0", got)
+	}
+}
+
+func TestEecptReceiverAnalysis(t *testing.T) {
+	config := `{
+		"type": "eecpt",
+		"address": "localhost",
+		"port": "8089",
+		"path": "/analysis",
+		"analysis": {
+			"detectors": [{"type": "changepoint", "metrics": "power", "window": 5, "event": "power_change"}]
+		}
+	}`
+	sink := make(chan lp.CCMessage, 100)
+	r, err := NewEECPTReceiver("testanalysis", json.RawMessage(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetSink(sink)
+	r.Start()
+	defer r.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	var body strings.Builder
+	for i := range 20 {
+		value := 100 + i%2
+		if i >= 10 {
+			value += 50
+		}
+		fmt.Fprintf(&body, "power,jobid=42,hostname=node01 rank=0,value=%d %d\n", value, time.Now().UnixNano())
+	}
+	resp, err := http.Post("http://localhost:8089/analysis", "text/plain", strings.NewReader(body.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	var events []lp.CCMessage
+	for len(sink) > 0 {
+		if m := <-sink; m.IsEvent() {
+			events = append(events, m)
+		}
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected one event, got %d", len(events))
+	}
+	if v, _ := events[0].GetTag("jobid"); events[0].Name() != "power_change" || v != "42" {
+		t.Errorf("unexpected event %s", events[0])
+	}
+	var result detector.Result
+	value, _ := events[0].GetEventValue()
+	if err := json.Unmarshal([]byte(value), &result); err != nil || result.Detector != detector.ChangePoint || result.Task != "0" {
+		t.Errorf("unexpected event value %s", value)
+	}
+
+	stop, err := lp.NewJobStopEvent(&schema.Job{JobID: 42, Cluster: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop.AddTag("cluster", "test")
+	resp, err = http.Post("http://localhost:8089/analysis", "text/plain", strings.NewReader(stop.ToLineProtocol(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if n := r.(*EECPTReceiver).detector.Jobs(); n != 0 {
+		t.Errorf("expected job to be dropped after stop_job event, have %d jobs", n)
+	}
+}
+
 var test_metrics string = `
 num-blocking-calls,application=myapplication,hostname=testfront1 rank=0,value=66 1759760564838624000
 num-blocking-calls,application=myapplication,hostname=testfront1 rank=0,value=67 1759760564838673920
@@ -0,0 +1,27 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import "sync"
+
+// forEachParallel calls f for all items in parallel, at most fanout at a time,
+// and returns when all calls finished. At least one call runs at a time.
+func forEachParallel[T any](items []T, fanout int, f func(item T)) {
+	queue := make(chan T)
+	var wg sync.WaitGroup
+	for range max(1, min(fanout, len(items))) {
+		wg.Go(func() {
+			for item := range queue {
+				f(item)
+			}
+		})
+	}
+	for _, item := range items {
+		queue <- item
+	}
+	close(queue)
+	wg.Wait()
+}
@@ -0,0 +1,41 @@
+package receivers
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestForEachParallel(t *testing.T) {
+	items := make([]int, 20)
+	for i := range items {
+		items[i] = i
+	}
+	for _, fanout := range []int{-1, 0, 1, 4, 100} {
+		var lock sync.Mutex
+		seen := make(map[int]bool)
+		var running, maxRunning atomic.Int32
+		forEachParallel(items, fanout, func(item int) {
+			n := running.Add(1)
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+			lock.Lock()
+			seen[item] = true
+			lock.Unlock()
+		})
+		if len(seen) != len(items) {
+			t.Errorf("fanout %d: called for %d of %d items", fanout, len(seen), len(items))
+		}
+		if limit := max(1, min(fanout, len(items))); int(maxRunning.Load()) > limit {
+			t.Errorf("fanout %d: %d calls at a time", fanout, maxRunning.Load())
+		}
+	}
+	forEachParallel(nil, 4, func(int) { t.Error("called for empty list") })
+}
@@ -0,0 +1,280 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	mp "github.com/ClusterCockpit/cc-lib/v2/messageProcessor"
+	influx "github.com/ClusterCockpit/cc-line-protocol/v2/lineprotocol"
+)
+
+const (
+	FILE_RECEIVER_FORMAT_LINEPROTOCOL = "lineprotocol"
+	FILE_RECEIVER_FORMAT_JSON         = "json"
+)
+
+// FileReceiverConfig configures the file receiver for replaying recorded messages.
+type FileReceiverConfig struct {
+	defaultReceiverConfig
+	Files     []string `json:"files"`                // List of files or glob patterns to replay in the given order (required)
+	Format    string   `json:"format,omitempty"`     // Input format: lineprotocol or json (default: derived from file extension)
+	Precision string   `json:"precision,omitempty"`  // Timestamp precision of line protocol input (default: ns)
+	Speed     float64  `json:"speed,omitempty"`      // Replay speed: 0 as fast as possible (default), 1 original pacing, N for N times faster
+	ShiftTime bool     `json:"shift_time,omitempty"` // Shift timestamps so that the replay starts now
+	Loop      bool     `json:"loop,omitempty"`       // Restart the replay after the last file
+}
+
+type FileReceiver struct {
+	receiver
+	config    FileReceiverConfig
+	precision influx.Precision
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// replayState keeps track of the pacing of one replay pass
+type replayState struct {
+	start     time.Time // wall clock time of the first message
+	first     time.Time // timestamp of the first message
+	hasFirst  bool
+	timeShift time.Duration
+}
+
+// fileFormat returns the configured input format or derives it from the file name
+func (r *FileReceiver) fileFormat(path string) string {
+	if len(r.config.Format) > 0 {
+		return r.config.Format
+	}
+	switch filepath.Ext(strings.TrimSuffix(path, ".gz")) {
+	case ".json", ".jsonl", ".ndjson":
+		return FILE_RECEIVER_FORMAT_JSON
+	}
+	return FILE_RECEIVER_FORMAT_LINEPROTOCOL
+}
+
+// wait delays the message with timestamp t according to the replay speed.
+// It returns false if the receiver was closed while waiting.
+func (r *FileReceiver) wait(state *replayState, t time.Time) bool {
+	if !state.hasFirst {
+		state.start = time.Now()
+		state.first = t
+		state.hasFirst = true
+		if r.config.ShiftTime {
+			state.timeShift = state.start.Sub(t)
+		}
+	}
+	if r.config.Speed <= 0 {
+		select {
+		case <-r.done:
+			return false
+		default:
+			return true
+		}
+	}
+	offset := time.Duration(float64(t.Sub(state.first)) / r.config.Speed)
+	delay := time.Until(state.start.Add(offset))
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-r.done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// send processes msg and forwards it to the sink.
+// It returns false if the receiver was closed.
+func (r *FileReceiver) send(state *replayState, msg lp.CCMessage) bool {
+	if !r.wait(state, msg.Time()) {
+		return false
+	}
+	if state.timeShift != 0 {
+		msg.SetTime(msg.Time().Add(state.timeShift))
+	}
+	m, err := r.mp.ProcessMessage(msg)
+	if err != nil || m == nil {
+		return true
+	}
+	select {
+	case <-r.done:
+		return false
+	case r.sink <- m:
+		return true
+	}
+}
+
+// replayFile replays all messages of a single file.
+// It returns false if the receiver was closed.
+func (r *FileReceiver) replayFile(path string, state *replayState) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return true, err
+	}
+	defer f.Close()
+
+	// Detect gzip compressed files by their magic number
+	var in io.Reader
+	br := bufio.NewReader(f)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return true, fmt.Errorf("failed to read gzip file %s: %w", path, err)
+		}
+		defer gz.Close()
+		in = gz
+	} else {
+		in = br
+	}
+
+	switch r.fileFormat(path) {
+	case FILE_RECEIVER_FORMAT_JSON:
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if len(line) == 0 {
+				continue
+			}
+			msg, err := lp.FromJSON(json.RawMessage(line))
+			if err != nil {
+				cclog.ComponentError(r.name, "Failed to decode message in", path, ":", err.Error())
+				continue
+			}
+			if !r.send(state, msg) {
+				return false, nil
+			}
+		}
+		return true, scanner.Err()
+	default:
+		d := influx.NewDecoder(in)
+		for d.Next() {
+			msg, err := DecodeInfluxMessageWithPrecision(d, r.precision)
+			if err != nil {
+				cclog.ComponentError(r.name, "Failed to decode message in", path, ":", err.Error())
+				continue
+			}
+			if !r.send(state, msg) {
+				return false, nil
+			}
+		}
+		return true, d.Err()
+	}
+}
+
+// files expands the configured glob patterns
+func (r *FileReceiver) files() []string {
+	out := make([]string, 0, len(r.config.Files))
+	for _, pattern := range r.config.Files {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			cclog.ComponentError(r.name, "Invalid file pattern", pattern, ":", err.Error())
+			continue
+		}
+		if len(matches) == 0 {
+			cclog.ComponentError(r.name, "No files found for", pattern)
+		}
+		out = append(out, matches...)
+	}
+	return out
+}
+
+// Start replays the configured files in a background goroutine
+func (r *FileReceiver) Start() {
+	cclog.ComponentDebug(r.name, "START")
+	r.wg.Go(func() {
+		for {
+			state := new(replayState)
+			for _, path := range r.files() {
+				cclog.ComponentDebug(r.name, "Replaying", path)
+				ok, err := r.replayFile(path, state)
+				if err != nil {
+					cclog.ComponentError(r.name, "Failed to replay", path, ":", err.Error())
+				}
+				if !ok {
+					return
+				}
+			}
+			// Stop looping if no message could be replayed
+			if !r.config.Loop || !state.hasFirst {
+				cclog.ComponentDebug(r.name, "Replay finished")
+				return
+			}
+		}
+	})
+}
+
+// Close stops the replay
+func (r *FileReceiver) Close() {
+	cclog.ComponentDebug(r.name, "CLOSE")
+	r.closeOnce.Do(func() { close(r.done) })
+	r.wg.Wait()
+	cclog.ComponentDebug(r.name, "DONE")
+}
+
+// NewFileReceiver creates a new Receiver which replays recorded messages from files
+func NewFileReceiver(name string, config json.RawMessage) (Receiver, error) {
+	r := new(FileReceiver)
+	r.name = fmt.Sprintf("FileReceiver(%s)", name)
+
+	if len(config) > 0 {
+		err := json.Unmarshal(config, &r.config)
+		if err != nil {
+			cclog.ComponentError(r.name, "Error reading config:", err.Error())
+			return nil, err
+		}
+	}
+	if len(r.config.Files) == 0 {
+		return nil, errors.New("not all configuration variables set required by FileReceiver (files)")
+	}
+	switch r.config.Format {
+	case "", FILE_RECEIVER_FORMAT_LINEPROTOCOL, FILE_RECEIVER_FORMAT_JSON:
+	default:
+		return nil, fmt.Errorf("unknown format '%s' for FileReceiver", r.config.Format)
+	}
+	if r.config.Speed < 0 {
+		return nil, errors.New("speed of FileReceiver must not be negative")
+	}
+	precision, err := ParseInfluxPrecision(r.config.Precision)
+	if err != nil {
+		return nil, err
+	}
+	r.precision = precision
+
+	p, err := mp.NewMessageProcessor()
+	if err != nil {
+		return nil, fmt.Errorf("initialization of message processor failed: %w", err)
+	}
+	r.mp = p
+	if len(r.config.MessageProcessor) > 0 {
+		err = r.mp.FromConfigJSON(r.config.MessageProcessor)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing JSON for message processor: %w", err)
+		}
+	}
+	r.mp.AddAddMetaByCondition("true", "source", r.name)
+
+	r.done = make(chan struct{})
+
+	return r, nil
+}
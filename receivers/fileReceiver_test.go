@@ -0,0 +1,82 @@
+package receivers
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+)
+
+func TestFileReceiver(t *testing.T) {
+	numMessages := 10
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	msgs := make([]lp.CCMessage, 0, numMessages)
+	for i := range numMessages {
+		m, _ := lp.NewMetric("testmetric", map[string]string{"type": "node"}, nil, float64(i), start.Add(time.Duration(i)*10*time.Millisecond))
+		msgs = append(msgs, m)
+	}
+
+	// First half as gzip compressed line protocol, second half as JSON lines
+	lpFile := filepath.Join(dir, "a.lp.gz")
+	f, _ := os.Create(lpFile)
+	gz := gzip.NewWriter(f)
+	for _, m := range msgs[:numMessages/2] {
+		gz.Write([]byte(m.ToLineProtocol(nil)))
+	}
+	gz.Close()
+	f.Close()
+
+	jsonFile := filepath.Join(dir, "b.jsonl")
+	f, _ = os.Create(jsonFile)
+	for _, m := range msgs[numMessages/2:] {
+		j, _ := m.ToJSON(nil)
+		f.Write(append(j, '\n'))
+	}
+	f.Close()
+
+	for _, speed := range []float64{0, 1} {
+		config := json.RawMessage(fmt.Sprintf(`{
+			"type": "file",
+			"files": ["%s/*.lp.gz", "%s"],
+			"speed": %v,
+			"shift_time": true
+		}`, dir, jsonFile, speed))
+		r, err := NewFileReceiver("testreceiver", config)
+		if err != nil {
+			t.Fatalf("failed to create file receiver: %v", err)
+		}
+		sink := make(chan lp.CCMessage, numMessages)
+		r.SetSink(sink)
+		replayStart := time.Now()
+		r.Start()
+
+		for i := range numMessages {
+			select {
+			case m := <-sink:
+				if v, _ := m.GetMetricValue(); v != float64(i) {
+					t.Errorf("message %d has value %v", i, v)
+				}
+				if time.Since(m.Time()) > time.Minute {
+					t.Errorf("timestamp of message %d was not shifted: %v", i, m.Time())
+				}
+				if src, _ := m.GetMeta("source"); src != r.Name() {
+					t.Errorf("message %d has source '%s'", i, src)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("received only %d of %d messages", i, numMessages)
+			}
+		}
+		elapsed := time.Since(replayStart)
+		if speed == 1 && elapsed < 80*time.Millisecond {
+			t.Errorf("replay with original pacing took only %v", elapsed)
+		}
+		r.Close()
+	}
+}
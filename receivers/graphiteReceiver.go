@@ -0,0 +1,246 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	mp "github.com/ClusterCockpit/cc-lib/v2/messageProcessor"
+)
+
+const GRAPHITE_RECEIVER_DEFAULT_TEMPLATE = "{cluster}.{hostname}.{name}.{type}{type-id}"
+
+// GraphiteReceiverConfig configures the receiver for the Graphite plaintext protocol.
+type GraphiteReceiverConfig struct {
+	defaultReceiverConfig
+	Addr     string `json:"address,omitempty"`  // Address to listen on (default: all interfaces)
+	Port     string `json:"port,omitempty"`     // Port to listen on (default: 2003)
+	Protocol string `json:"protocol,omitempty"` // Transport protocol: tcp (default) or udp
+	Template string `json:"template,omitempty"` // Reverse template to split the metric path into name and tags
+	Prefix   string `json:"prefix,omitempty"`   // Prefix removed from all metric paths
+}
+
+type GraphiteReceiver struct {
+	receiver
+	config   GraphiteReceiverConfig
+	template *regexp.Regexp
+	keys     []string // placeholder names of the template groups
+
+	socketListener
+}
+
+var graphiteReceiverPlaceholder = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// compileGraphiteTemplate converts a path template into an anchored regular expression.
+// {name} may span several path components, placeholders ending in -id match digits
+// and all other placeholders match a part of a single path component.
+func compileGraphiteTemplate(template string) (*regexp.Regexp, []string, error) {
+	var (
+		expr strings.Builder
+		keys []string
+		last int
+	)
+	expr.WriteString("^")
+	for _, loc := range graphiteReceiverPlaceholder.FindAllStringSubmatchIndex(template, -1) {
+		expr.WriteString(regexp.QuoteMeta(template[last:loc[0]]))
+		key := template[loc[2]:loc[3]]
+		switch {
+		case key == "name":
+			expr.WriteString("(.+?)")
+		case strings.HasSuffix(key, "-id"):
+			expr.WriteString("([0-9]*)")
+		default:
+			expr.WriteString("([^.]+?)")
+		}
+		keys = append(keys, key)
+		last = loc[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(template[last:]))
+	expr.WriteString("$")
+
+	hasName := false
+	for _, k := range keys {
+		hasName = hasName || k == "name"
+	}
+	if !hasName {
+		return nil, nil, errors.New("template must contain {name}")
+	}
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to compile template '%s': %w", template, err)
+	}
+	return re, keys, nil
+}
+
+// parseLine converts a single plaintext line 'path[;tag=value...] value [timestamp]'
+// into a CCMessage
+func (r *GraphiteReceiver) parseLine(line string) (lp.CCMessage, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, fmt.Errorf("invalid line '%s'", line)
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value in line '%s': %w", line, err)
+	}
+
+	t := time.Now()
+	if len(fields) == 3 && fields[2] != "-1" {
+		ts, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp in line '%s': %w", line, err)
+		}
+		sec, frac := math.Modf(ts)
+		t = time.Unix(int64(sec), int64(frac*1e9))
+	}
+
+	// Graphite 1.1 tags: path;tag1=value1;tag2=value2
+	path, tagList, _ := strings.Cut(fields[0], ";")
+	tags := make(map[string]string)
+	if len(tagList) > 0 {
+		for kv := range strings.SplitSeq(tagList, ";") {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok || len(k) == 0 {
+				return nil, fmt.Errorf("invalid tag '%s' in line '%s'", kv, line)
+			}
+			tags[k] = v
+		}
+	}
+
+	if len(r.config.Prefix) > 0 {
+		path = strings.TrimPrefix(strings.TrimPrefix(path, r.config.Prefix), ".")
+	}
+
+	name := path
+	if match := r.template.FindStringSubmatch(path); match != nil {
+		for i, key := range r.keys {
+			if key == "name" {
+				name = match[i+1]
+			} else if len(match[i+1]) > 0 {
+				tags[key] = match[i+1]
+			}
+		}
+	}
+
+	return lp.NewMetric(name, tags, nil, value, t)
+}
+
+// process parses a chunk of lines and forwards the messages to the sink
+func (r *GraphiteReceiver) process(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		msg, err := r.parseLine(line)
+		if err != nil {
+			cclog.ComponentError(r.name, err.Error())
+			continue
+		}
+		m, err := r.mp.ProcessMessage(msg)
+		if err != nil || m == nil {
+			continue
+		}
+		select {
+		case <-r.done:
+			return
+		case r.sink <- m:
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		select {
+		case <-r.done:
+		default:
+			cclog.ComponentError(r.name, "Read failed:", err.Error())
+		}
+	}
+}
+
+// Start listens for plaintext lines in a background goroutine
+func (r *GraphiteReceiver) Start() {
+	cclog.ComponentDebug(r.name, "START")
+	r.serve(r.name, func(conn net.Conn) { r.process(conn) }, func(data []byte) {
+		r.process(bytes.NewReader(data))
+	})
+}
+
+// Close stops listening and closes all client connections
+func (r *GraphiteReceiver) Close() {
+	cclog.ComponentDebug(r.name, "CLOSE")
+	r.shutdown()
+	cclog.ComponentDebug(r.name, "DONE")
+}
+
+// NewGraphiteReceiver creates a new Receiver for the Graphite plaintext protocol
+func NewGraphiteReceiver(name string, config json.RawMessage) (Receiver, error) {
+	r := new(GraphiteReceiver)
+	r.name = fmt.Sprintf("GraphiteReceiver(%s)", name)
+	r.config.Port = "2003"
+	r.config.Protocol = "tcp"
+	r.config.Template = GRAPHITE_RECEIVER_DEFAULT_TEMPLATE
+
+	if len(config) > 0 {
+		err := json.Unmarshal(config, &r.config)
+		if err != nil {
+			cclog.ComponentError(r.name, "Error reading config:", err.Error())
+			return nil, err
+		}
+	}
+	if len(r.config.Port) == 0 {
+		return nil, errors.New("not all configuration variables set required by GraphiteReceiver (port)")
+	}
+	template, keys, err := compileGraphiteTemplate(r.config.Template)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", r.name, err)
+	}
+	r.template = template
+	r.keys = keys
+
+	p, err := mp.NewMessageProcessor()
+	if err != nil {
+		return nil, fmt.Errorf("initialization of message processor failed: %w", err)
+	}
+	r.mp = p
+	if len(r.config.MessageProcessor) > 0 {
+		err = r.mp.FromConfigJSON(r.config.MessageProcessor)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing JSON for message processor: %w", err)
+		}
+	}
+	r.mp.AddAddMetaByCondition("true", "source", r.name)
+
+	addr := net.JoinHostPort(r.config.Addr, r.config.Port)
+	switch r.config.Protocol {
+	case "tcp":
+		r.listener, err = net.Listen("tcp", addr)
+	case "udp":
+		r.packet, err = net.ListenPacket("udp", addr)
+	default:
+		return nil, fmt.Errorf("unknown protocol '%s' for GraphiteReceiver", r.config.Protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to listen on %s: %w", r.name, addr, err)
+	}
+	r.conns = make(map[net.Conn]struct{})
+	r.done = make(chan struct{})
+
+	return r, nil
+}
@@ -0,0 +1,109 @@
+package receivers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+)
+
+func TestGraphiteReceiverParseLine(t *testing.T) {
+	r, err := NewGraphiteReceiver("testreceiver", json.RawMessage(`{"type": "graphite", "address": "127.0.0.1", "port": "0", "prefix": "cc"}`))
+	if err != nil {
+		t.Fatalf("failed to create graphite receiver: %v", err)
+	}
+	defer r.Close()
+	g := r.(*GraphiteReceiver)
+
+	tests := []struct {
+		line  string
+		name  string
+		tags  map[string]string
+		value float64
+		time  int64
+	}{
+		{"cc.fritz.f0101.mem_bw.socket1 12.5 1700000000", "mem_bw", map[string]string{"cluster": "fritz", "hostname": "f0101", "type": "socket", "type-id": "1"}, 12.5, 1700000000},
+		{"cc.fritz.f0101.cpu.load.node 3 1700000000.5", "cpu.load", map[string]string{"cluster": "fritz", "hostname": "f0101", "type": "node"}, 3, 1700000000},
+		{"cc.fritz.f0101.flops.node;unit=GF 1 1700000000", "flops", map[string]string{"cluster": "fritz", "hostname": "f0101", "type": "node", "unit": "GF"}, 1, 1700000000},
+		{"short.path 1 1700000000", "short.path", map[string]string{}, 1, 1700000000},
+	}
+	for _, tt := range tests {
+		m, err := g.parseLine(tt.line)
+		if err != nil {
+			t.Errorf("failed to parse '%s': %v", tt.line, err)
+			continue
+		}
+		if m.Name() != tt.name {
+			t.Errorf("invalid name for '%s': '%s' vs '%s'", tt.line, m.Name(), tt.name)
+		}
+		for k, v := range tt.tags {
+			if x, ok := m.GetTag(k); !ok || x != v {
+				t.Errorf("invalid tag %s for '%s': '%s' vs '%s'", k, tt.line, x, v)
+			}
+		}
+		if len(m.Tags()) != len(tt.tags) {
+			t.Errorf("invalid tags for '%s': %v", tt.line, m.Tags())
+		}
+		if v, _ := m.GetMetricValue(); v != tt.value {
+			t.Errorf("invalid value for '%s': %v vs %v", tt.line, v, tt.value)
+		}
+		if m.Time().Unix() != tt.time {
+			t.Errorf("invalid time for '%s': %d vs %d", tt.line, m.Time().Unix(), tt.time)
+		}
+	}
+
+	for _, line := range []string{"path", "path abc 1700000000", "path 1 abc", "path;tag 1"} {
+		if _, err := g.parseLine(line); err == nil {
+			t.Errorf("invalid line '%s' was accepted", line)
+		}
+	}
+}
+
+func TestGraphiteReceiver(t *testing.T) {
+	for _, protocol := range []string{"tcp", "udp"} {
+		config := json.RawMessage(fmt.Sprintf(`{"type": "graphite", "address": "127.0.0.1", "port": "0", "protocol": "%s"}`, protocol))
+		r, err := NewGraphiteReceiver("testreceiver", config)
+		if err != nil {
+			t.Fatalf("failed to create graphite receiver: %v", err)
+		}
+		g := r.(*GraphiteReceiver)
+		var addr string
+		if g.listener != nil {
+			addr = g.listener.Addr().String()
+		} else {
+			addr = g.packet.LocalAddr().String()
+		}
+
+		sink := make(chan lp.CCMessage, 10)
+		r.SetSink(sink)
+		r.Start()
+
+		conn, err := net.Dial(protocol, addr)
+		if err != nil {
+			t.Fatalf("failed to connect to %s: %v", addr, err)
+		}
+		fmt.Fprintf(conn, "testcluster.node01.testmetric.node 42 1700000000\ntestcluster.node01.testmetric.node 43 -1\n")
+		conn.Close()
+
+		for i := range 2 {
+			select {
+			case m := <-sink:
+				if m.Name() != "testmetric" {
+					t.Errorf("%s: invalid name '%s'", protocol, m.Name())
+				}
+				if v, _ := m.GetMetricValue(); v != float64(42+i) {
+					t.Errorf("%s: invalid value %v", protocol, v)
+				}
+				if source, _ := m.GetMeta("source"); source != r.Name() {
+					t.Errorf("%s: invalid source meta '%s'", protocol, source)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s: timeout waiting for message %d", protocol, i)
+			}
+		}
+		r.Close()
+	}
+}
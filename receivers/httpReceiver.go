@@ -8,22 +8,38 @@
 package receivers
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
 	mp "github.com/ClusterCockpit/cc-lib/v2/messageProcessor"
+	"github.com/ClusterCockpit/cc-lib/v2/util"
 	influx "github.com/ClusterCockpit/cc-line-protocol/v2/lineprotocol"
+	"github.com/klauspost/compress/zstd"
 )
 
 const HTTP_RECEIVER_PORT = "8080"
 
+// Default maximum size of a request body in InfluxDB compatibility mode
+const HTTP_RECEIVER_MAX_BODY_SIZE = 32 * 1024 * 1024
+
+// Version reported by the ping endpoint in InfluxDB compatibility mode
+const HTTP_RECEIVER_INFLUX_VERSION = "cc-lib"
+
 // HttpReceiverConfig configures the HTTP receiver for accepting metrics via POST requests.
 type HttpReceiverConfig struct {
 	defaultReceiverConfig
@@ -39,6 +55,30 @@ type HttpReceiverConfig struct {
 	Username     string `json:"username"` // Basic auth username (optional)
 	Password     string `json:"password"` // Basic auth password (optional)
 	useBasicAuth bool
+
+	Token string `json:"token,omitempty"` // Token for 'Authorization: Token <token>' authentication (optional)
+
+	JWT *JWTConfig `json:"jwt,omitempty"` // Verification of 'Authorization: Bearer <JWT>' authentication (optional)
+
+	InfluxCompat bool   `json:"influx_compat,omitempty"` // Serve the InfluxDB v1 and v2 write and ping API
+	DatabaseTag  string `json:"database_tag,omitempty"`  // Tag set to the database (v1) or bucket (v2) of InfluxDB write requests (optional)
+	MaxBodySize  int64  `json:"max_body_size,omitempty"` // Maximum size of a request body (default: 32 MiB)
+
+	TLS *util.TLSConfig `json:"tls,omitempty"` // Serve HTTPS, optionally with client certificate authentication
+}
+
+// HttpReceiverItemError reports a rejected message of a JSON request
+type HttpReceiverItemError struct {
+	Index int    `json:"index"` // Position of the message in the array or NDJSON stream (starting at 0)
+	Error string `json:"error"`
+}
+
+// HttpReceiverResult is the response to JSON requests
+type HttpReceiverResult struct {
+	Accepted int                     `json:"accepted"`         // Number of accepted messages
+	Rejected int                     `json:"rejected"`         // Number of rejected messages
+	Errors   []HttpReceiverItemError `json:"errors,omitempty"` // Errors of the rejected messages
+	Error    string                  `json:"error,omitempty"`  // Error of the whole request
 }
 
 type HttpReceiver struct {
@@ -47,18 +87,271 @@ type HttpReceiver struct {
 	config HttpReceiverConfig
 	server *http.Server
 	wg     sync.WaitGroup
+
+	jwt      *jwtValidator
+	rejected atomic.Uint64 // number of messages rejected by the authorization
+}
+
+// httpRequest holds the state of a single write request
+type httpRequest struct {
+	tags      map[string]string // tags added to all messages without these tags
+	principal *jwtPrincipal     // user of JWT authenticated requests
+	rejected  int               // number of messages rejected by the authorization
+	rejectErr error             // first authorization error
 }
 
 func (r *HttpReceiver) Start() {
 	cclog.ComponentDebug(r.name, "START")
 	r.wg.Go(func() {
-		err := r.server.ListenAndServe()
+		var err error
+		if r.server.TLSConfig != nil {
+			err = r.server.ListenAndServeTLS("", "")
+		} else {
+			err = r.server.ListenAndServe()
+		}
 		if err != nil && err.Error() != "http: Server closed" {
 			cclog.ComponentError(r.name, err.Error())
 		}
 	})
 }
 
+// authorized checks the basic, token or JWT authentication of the request.
+// For JWT authenticated requests, it returns the principal of the token.
+func (r *HttpReceiver) authorized(req *http.Request) (*jwtPrincipal, bool) {
+	if !r.config.useBasicAuth && len(r.config.Token) == 0 && r.jwt == nil {
+		return nil, true
+	}
+	if r.config.useBasicAuth {
+		username, password, ok := req.BasicAuth()
+		if ok && username == r.config.Username && password == r.config.Password {
+			return nil, true
+		}
+	}
+	if len(r.config.Token) > 0 {
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Token ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(r.config.Token)) == 1 {
+			return nil, true
+		}
+	}
+	if r.jwt != nil {
+		if token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok {
+			principal, err := r.jwt.Validate(token)
+			if err == nil {
+				return principal, true
+			}
+			cclog.ComponentError(r.name, "JWT validation failed:", err.Error())
+		}
+	}
+	return nil, false
+}
+
+// limitedBody is a decompressed request body. Reading more than limit bytes
+// fails with a http.MaxBytesError.
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n - int(b.read-b.limit), &http.MaxBytesError{Limit: b.limit}
+	}
+	return n, err
+}
+
+// body returns the request body, decompressed if required. The decompressed
+// body is limited to the maximum body size.
+func (r *HttpReceiver) body(req *http.Request) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	switch req.Header.Get("Content-Encoding") {
+	case "", "identity":
+		return req.Body, nil
+	case "gzip":
+		zr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = zr
+	case "zstd":
+		zr, err := zstd.NewReader(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = zr.IOReadCloser()
+	default:
+		return nil, fmt.Errorf("unsupported content encoding '%s'", req.Header.Get("Content-Encoding"))
+	}
+	return &limitedBody{ReadCloser: body, limit: r.config.MaxBodySize}, nil
+}
+
+// decode decodes all line protocol messages of body and forwards them to the sink.
+// It returns the error of the first line that could not be decoded and the read error,
+// if any. With stopOnError, decoding stops at the first invalid line.
+func (r *HttpReceiver) decode(body io.Reader, precision influx.Precision, hr *httpRequest, stopOnError bool) (lineErr error, readErr error) {
+	d := influx.NewDecoder(body)
+	for d.Next() {
+		y, err := DecodeInfluxMessageWithPrecision(d, precision)
+		if err != nil {
+			if lineErr == nil {
+				lineErr = err
+			}
+			if stopOnError {
+				return lineErr, d.Err()
+			}
+			continue
+		}
+		r.forward(y, hr)
+	}
+	return lineErr, d.Err()
+}
+
+// jsonFormat returns the JSON format selected by the content type of the request:
+// "json" for a single message or an array of messages, "ndjson" for newline
+// delimited messages or "" for line protocol
+func jsonFormat(req *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	switch mediaType {
+	case "application/json":
+		return "json"
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+		return "ndjson"
+	}
+	return ""
+}
+
+// forward adds the tags missing in y and sends it through the message processor
+// to the sink. It returns an error if the user of the request may not submit y.
+func (r *HttpReceiver) forward(y lp.CCMessage, hr *httpRequest) error {
+	for k, v := range hr.tags {
+		if !y.HasTag(k) {
+			y.AddTag(k, v)
+		}
+	}
+	if err := hr.principal.Authorize(y); err != nil {
+		hr.rejected++
+		if hr.rejectErr == nil {
+			hr.rejectErr = err
+		}
+		r.rejected.Add(1)
+		return err
+	}
+	m, err := r.mp.ProcessMessage(y)
+	if err == nil && m != nil {
+		r.sink <- m
+	}
+	return nil
+}
+
+// logRejected logs the messages of a request rejected by the authorization
+func (r *HttpReceiver) logRejected(hr *httpRequest) {
+	if hr.rejected > 0 {
+		cclog.ComponentError(r.name, fmt.Sprintf("Rejected %d messages (%d in total): %s", hr.rejected, r.rejected.Load(), hr.rejectErr.Error()))
+	}
+}
+
+// decodeJSON decodes a single JSON message or an array of JSON messages and
+// forwards all valid messages to the sink
+func (r *HttpReceiver) decodeJSON(body io.Reader, hr *httpRequest, result *HttpReceiverResult) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	data = bytes.TrimSpace(data)
+
+	var items []json.RawMessage
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &items); err != nil {
+			return fmt.Errorf("failed to parse JSON array: %w", err)
+		}
+	} else {
+		items = []json.RawMessage{data}
+	}
+
+	for i, item := range items {
+		y, err := lp.FromJSON(item)
+		if err == nil {
+			err = r.forward(y, hr)
+		}
+		if err != nil {
+			result.Rejected++
+			result.Errors = append(result.Errors, HttpReceiverItemError{Index: i, Error: err.Error()})
+			continue
+		}
+		result.Accepted++
+	}
+	return nil
+}
+
+// decodeNDJSON decodes newline delimited JSON messages and forwards all valid
+// messages to the sink
+func (r *HttpReceiver) decodeNDJSON(body io.Reader, hr *httpRequest, result *HttpReceiverResult) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	i := 0
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		y, err := lp.FromJSON(json.RawMessage(line))
+		if err == nil {
+			err = r.forward(y, hr)
+		}
+		if err != nil {
+			result.Rejected++
+			result.Errors = append(result.Errors, HttpReceiverItemError{Index: i, Error: err.Error()})
+		} else {
+			result.Accepted++
+		}
+		i++
+	}
+	return scanner.Err()
+}
+
+// serveJSON handles requests with JSON and NDJSON bodies. The response reports
+// the rejected messages: 200 if all messages were accepted, 207 if some of them
+// were rejected and 400 if all of them were rejected or the body was invalid.
+func (r *HttpReceiver) serveJSON(w http.ResponseWriter, format string, body io.Reader, hr *httpRequest) {
+	var (
+		result HttpReceiverResult
+		err    error
+	)
+	if format == "ndjson" {
+		err = r.decodeNDJSON(body, hr, &result)
+	} else {
+		err = r.decodeJSON(body, hr, &result)
+	}
+	r.logRejected(hr)
+
+	status := http.StatusOK
+	if err != nil {
+		result.Error = err.Error()
+		status = http.StatusBadRequest
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		cclog.ComponentError(r.name, "ServerHttp: Failed to decode JSON:", err.Error())
+	} else if result.Rejected > 0 {
+		if result.Accepted > 0 {
+			status = http.StatusMultiStatus
+		} else {
+			status = http.StatusBadRequest
+		}
+		cclog.ComponentError(r.name, fmt.Sprintf("ServerHttp: Rejected %d of %d messages", result.Rejected, result.Rejected+result.Accepted))
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
 func (r *HttpReceiver) ServerHttp(w http.ResponseWriter, req *http.Request) {
 	// Check request method, only post method is handled
 	if req.Method != http.MethodPost {
@@ -66,45 +359,180 @@ func (r *HttpReceiver) ServerHttp(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Check basic authentication
-	if r.config.useBasicAuth {
-		username, password, ok := req.BasicAuth()
-		if !ok || username != r.config.Username || password != r.config.Password {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+	// Check basic, token or JWT authentication
+	principal, ok := r.authorized(req)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
+	hr := &httpRequest{principal: principal}
 	if r.sink == nil {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	d := influx.NewDecoder(req.Body)
-	for d.Next() {
-		y, err := DecodeInfluxMessage(d)
-		if err != nil {
-			msg := "ServerHttp: Failed to decode message: " + err.Error()
-			cclog.ComponentError(r.name, msg)
-			http.Error(w, msg, http.StatusInternalServerError)
-			return
+	precision, err := ParseInfluxPrecision(req.URL.Query().Get("precision"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Body = http.MaxBytesReader(w, req.Body, r.config.MaxBodySize)
+	body, err := r.body(req)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 		}
+		return
+	}
+	defer body.Close()
 
-		m, err := r.mp.ProcessMessage(y)
-		if err == nil && m != nil {
-			r.sink <- m
-		}
+	if format := jsonFormat(req); len(format) > 0 {
+		r.serveJSON(w, format, body, hr)
+		return
+	}
+
+	lineErr, readErr := r.decode(body, precision, hr, true)
+	r.logRejected(hr)
+	// A body truncated by the size limit also results in an invalid last line
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(readErr, &maxBytesErr) {
+		msg := "ServerHttp: Failed to decode: " + readErr.Error()
+		cclog.ComponentError(r.name, msg)
+		http.Error(w, msg, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if lineErr != nil {
+		msg := "ServerHttp: Failed to decode message: " + lineErr.Error()
+		cclog.ComponentError(r.name, msg)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
 	}
 
-	if err := d.Err(); err != nil {
-		msg := "ServerHttp: Failed to decode: " + err.Error()
+	if readErr != nil {
+		msg := "ServerHttp: Failed to decode: " + readErr.Error()
 		cclog.ComponentError(r.name, msg)
 		http.Error(w, msg, http.StatusInternalServerError)
 		return
 	}
 
+	if hr.rejected > 0 {
+		http.Error(w, fmt.Sprintf("Forbidden: rejected %d messages: %s", hr.rejected, hr.rejectErr.Error()), http.StatusForbidden)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 }
 
+// influxError writes an error response in the format of the InfluxDB v1 or v2 API
+func influxError(w http.ResponseWriter, req *http.Request, status int, code string, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="InfluxDB"`)
+	}
+	w.WriteHeader(status)
+	if strings.HasPrefix(req.URL.Path, "/api/v2/") {
+		json.NewEncoder(w).Encode(map[string]string{"code": code, "message": msg})
+	} else {
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	}
+}
+
+// InfluxWrite handles write requests of the InfluxDB v1 (/write) and v2 (/api/v2/write) API
+func (r *HttpReceiver) InfluxWrite(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		influxError(w, req, http.StatusMethodNotAllowed, "method not allowed", "method not allowed")
+		return
+	}
+	principal, ok := r.authorized(req)
+	if !ok {
+		influxError(w, req, http.StatusUnauthorized, "unauthorized", "authorization failed")
+		return
+	}
+	hr := &httpRequest{principal: principal}
+
+	query := req.URL.Query()
+	precision, err := ParseInfluxPrecision(query.Get("precision"))
+	if err != nil {
+		influxError(w, req, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+
+	if len(r.config.DatabaseTag) > 0 {
+		database := query.Get("db")
+		if strings.HasPrefix(req.URL.Path, "/api/v2/") {
+			database = query.Get("bucket")
+		}
+		if len(database) > 0 {
+			hr.tags = map[string]string{r.config.DatabaseTag: database}
+		}
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, r.config.MaxBodySize)
+	body, err := r.body(req)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			influxError(w, req, http.StatusRequestEntityTooLarge, "request too large", err.Error())
+		} else {
+			influxError(w, req, http.StatusBadRequest, "invalid", err.Error())
+		}
+		return
+	}
+	defer body.Close()
+
+	if r.sink == nil {
+		io.Copy(io.Discard, body)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if format := jsonFormat(req); len(format) > 0 {
+		r.serveJSON(w, format, body, hr)
+		return
+	}
+
+	// Valid lines are written even if other lines are invalid (partial write)
+	lineErr, readErr := r.decode(body, precision, hr, false)
+	r.logRejected(hr)
+	if readErr != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(readErr, &maxBytesErr) {
+			influxError(w, req, http.StatusRequestEntityTooLarge, "request too large", readErr.Error())
+		} else {
+			cclog.ComponentError(r.name, "InfluxWrite: Failed to read request:", readErr.Error())
+			influxError(w, req, http.StatusBadRequest, "invalid", readErr.Error())
+		}
+		return
+	}
+	if lineErr != nil {
+		cclog.ComponentError(r.name, "InfluxWrite: Failed to decode message:", lineErr.Error())
+		influxError(w, req, http.StatusBadRequest, "invalid", "partial write: "+lineErr.Error())
+		return
+	}
+	if hr.rejected > 0 {
+		influxError(w, req, http.StatusForbidden, "forbidden", fmt.Sprintf("partial write: rejected %d messages: %s", hr.rejected, hr.rejectErr.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// InfluxPing handles the ping endpoint used by InfluxDB clients to check the server
+func (r *HttpReceiver) InfluxPing(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("X-Influxdb-Build", "OSS")
+	w.Header().Set("X-Influxdb-Version", HTTP_RECEIVER_INFLUX_VERSION)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (r *HttpReceiver) Close() {
 	cclog.ComponentDebug(r.name, "CLOSE")
 	r.server.Shutdown(context.Background())
@@ -119,6 +547,7 @@ func NewHttpReceiver(name string, config json.RawMessage) (Receiver, error) {
 	r.config.Port = HTTP_RECEIVER_PORT
 	r.config.KeepAlivesEnabled = true
 	r.config.IdleTimeout = "120s"
+	r.config.MaxBodySize = HTTP_RECEIVER_MAX_BODY_SIZE
 
 	if len(config) > 0 {
 		err := json.Unmarshal(config, &r.config)
@@ -148,6 +577,16 @@ func NewHttpReceiver(name string, config json.RawMessage) (Receiver, error) {
 	if r.config.useBasicAuth && len(r.config.Password) == 0 {
 		return nil, errors.New("basic authentication requires password")
 	}
+	if r.config.JWT != nil {
+		v, err := newJWTValidator(*r.config.JWT)
+		if err != nil {
+			return nil, fmt.Errorf("%s: JWT configuration: %w", r.name, err)
+		}
+		r.jwt = v
+	}
+	if r.config.MaxBodySize <= 0 {
+		return nil, errors.New("max_body_size of HttpReceiver must be positive")
+	}
 
 	msgp, err := mp.NewMessageProcessor()
 	if err != nil {
@@ -170,13 +609,30 @@ func NewHttpReceiver(name string, config json.RawMessage) (Receiver, error) {
 	uri := addr + p
 	cclog.ComponentDebug(r.name, "INIT", "listen on:", uri)
 
-	http.HandleFunc(p, r.ServerHttp)
+	mux := http.NewServeMux()
+	if r.config.InfluxCompat {
+		mux.HandleFunc("/write", r.InfluxWrite)
+		mux.HandleFunc("/api/v2/write", r.InfluxWrite)
+		mux.HandleFunc("/ping", r.InfluxPing)
+		if p != "/write" && p != "/api/v2/write" && p != "/ping" {
+			mux.HandleFunc(p, r.InfluxWrite)
+		}
+	} else {
+		mux.HandleFunc(p, r.ServerHttp)
+	}
 
 	r.server = &http.Server{
 		Addr:        addr,
-		Handler:     nil,
+		Handler:     mux,
 		IdleTimeout: r.config.idleTimeout,
 	}
+	if r.config.TLS != nil {
+		tlsConfig, err := r.config.TLS.ServerConfig()
+		if err != nil {
+			return nil, fmt.Errorf("%s: TLS configuration: %w", r.name, err)
+		}
+		r.server.TLSConfig = tlsConfig
+	}
 	r.server.SetKeepAlivesEnabled(r.config.KeepAlivesEnabled)
 
 	return r, nil
@@ -1,8 +1,11 @@
 package receivers
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
@@ -68,3 +71,166 @@ func TestHttpReceiver(t *testing.T) {
 	t.Log("Closing http receiver")
 	r.Close()
 }
+
+func TestHttpReceiverInfluxCompat(t *testing.T) {
+	config := json.RawMessage(`{
+		"type": "http",
+		"address": "localhost",
+		"port": "8083",
+		"influx_compat": true,
+		"token": "secret",
+		"database_tag": "cluster",
+		"max_body_size": 1024
+	}`)
+	r, err := NewHttpReceiver("testreceiver", config)
+	if err != nil {
+		t.Fatalf("failed to create http receiver: %v", err)
+	}
+	h := r.(*HttpReceiver)
+	sink := make(chan lp.CCMessage, 100)
+	r.SetSink(sink)
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte("testmetric,type=node value=2 1700000000000\n"))
+	gz.Close()
+
+	// Small compressed body exceeding max_body_size when decompressed
+	var bomb bytes.Buffer
+	gz = gzip.NewWriter(&bomb)
+	gz.Write(bytes.Repeat([]byte("testmetric,type=node value=1\n"), 1000))
+	gz.Close()
+
+	tests := []struct {
+		url      string
+		token    string
+		encoding string
+		body     []byte
+		status   int
+		messages int
+	}{
+		{"/write?db=fritz&precision=s", "secret", "", []byte("testmetric,type=node value=1 1700000000\n"), http.StatusNoContent, 1},
+		{"/api/v2/write?org=nhr&bucket=fritz&precision=ms", "secret", "gzip", compressed.Bytes(), http.StatusNoContent, 1},
+		{"/write?db=fritz", "wrong", "", []byte("testmetric,type=node value=1\n"), http.StatusUnauthorized, 0},
+		{"/write?db=fritz&precision=x", "secret", "", []byte("testmetric,type=node value=1\n"), http.StatusBadRequest, 0},
+		{"/write?db=fritz&precision=s", "secret", "", []byte("testmetric,type=node value=1 1700000000\ninvalid line\n"), http.StatusBadRequest, 1},
+		{"/write?db=fritz", "secret", "", bytes.Repeat([]byte("testmetric,type=node value=1\n"), 100), http.StatusRequestEntityTooLarge, -1},
+		{"/write?db=fritz", "secret", "gzip", bomb.Bytes(), http.StatusRequestEntityTooLarge, -1},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, tt.url, bytes.NewReader(tt.body))
+		req.Header.Set("Authorization", "Token "+tt.token)
+		if len(tt.encoding) > 0 {
+			req.Header.Set("Content-Encoding", tt.encoding)
+		}
+		w := httptest.NewRecorder()
+		h.InfluxWrite(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.url, tt.status, w.Code, w.Body.String())
+		}
+		if tt.messages < 0 {
+			// drain messages written before the size limit was reached
+			for len(sink) > 0 {
+				<-sink
+			}
+			continue
+		}
+		if len(sink) != tt.messages {
+			t.Errorf("%s: expected %d messages, got %d", tt.url, tt.messages, len(sink))
+		}
+		for range len(sink) {
+			m := <-sink
+			if cluster, _ := m.GetTag("cluster"); cluster != "fritz" {
+				t.Errorf("%s: database not mapped to tag: %s", tt.url, m)
+			}
+			if m.Time().Unix() != 1700000000 {
+				t.Errorf("%s: invalid timestamp: %s", tt.url, m)
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.InfluxPing(w, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	if w.Code != http.StatusNoContent || len(w.Header().Get("X-Influxdb-Version")) == 0 {
+		t.Errorf("invalid ping response: %d %v", w.Code, w.Header())
+	}
+}
+
+func TestHttpReceiverJSON(t *testing.T) {
+	config := json.RawMessage(`{"type": "http", "address": "localhost", "port": "8084", "path": "/write"}`)
+	r, err := NewHttpReceiver("testreceiver", config)
+	if err != nil {
+		t.Fatalf("failed to create http receiver: %v", err)
+	}
+	h := r.(*HttpReceiver)
+	sink := make(chan lp.CCMessage, 10)
+	r.SetSink(sink)
+
+	valid := `{"name":"testmetric","tags":{"type":"node"},"meta":{"unit":"B"},"fields":{"value":1.5},"timestamp":"2024-06-22T13:51:59Z"}`
+	invalid := `{"name":"","tags":{"type":"node"},"fields":{"value":1.5},"timestamp":"2024-06-22T13:51:59Z"}`
+
+	tests := []struct {
+		contentType string
+		body        string
+		status      int
+		accepted    int
+		rejected    []int
+	}{
+		{"application/json", valid, http.StatusOK, 1, nil},
+		{"application/json; charset=utf-8", "[" + valid + "," + valid + "]", http.StatusOK, 2, nil},
+		{"application/json", "[" + valid + "," + invalid + "," + valid + "]", http.StatusMultiStatus, 2, []int{1}},
+		{"application/json", "[" + invalid + "]", http.StatusBadRequest, 0, []int{0}},
+		{"application/json", "[" + valid, http.StatusBadRequest, 0, nil},
+		{"application/x-ndjson", valid + "\n\n" + invalid + "\n" + valid + "\n", http.StatusMultiStatus, 2, []int{1}},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/write", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		w := httptest.NewRecorder()
+		h.ServerHttp(w, req)
+		if w.Code != tt.status {
+			t.Errorf("test %d: expected status %d, got %d: %s", i, tt.status, w.Code, w.Body.String())
+		}
+		var result HttpReceiverResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Errorf("test %d: invalid response '%s': %v", i, w.Body.String(), err)
+			continue
+		}
+		if result.Accepted != tt.accepted || result.Rejected != len(tt.rejected) {
+			t.Errorf("test %d: invalid result %+v", i, result)
+		}
+		for j, idx := range tt.rejected {
+			if j >= len(result.Errors) || result.Errors[j].Index != idx {
+				t.Errorf("test %d: expected rejected item %d, got %+v", i, idx, result.Errors)
+			}
+		}
+		if len(sink) != tt.accepted {
+			t.Errorf("test %d: expected %d messages, got %d", i, tt.accepted, len(sink))
+		}
+		for range len(sink) {
+			m := <-sink
+			if unit, _ := m.GetMeta("unit"); unit != "B" {
+				t.Errorf("test %d: meta missing in %s", i, m)
+			}
+		}
+	}
+
+	// Bodies larger than max_body_size are rejected for all formats
+	h.config.MaxBodySize = 100
+	for contentType, body := range map[string]string{
+		"application/json":     "[" + valid + "," + valid + "]",
+		"application/x-ndjson": valid + "\n" + valid + "\n",
+		"text/plain":           strings.Repeat("testmetric,type=node value=1 1700000000000000000\n", 10),
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/write", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		h.ServerHttp(w, req)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: expected status %d, got %d: %s", contentType, http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+		}
+		for len(sink) > 0 {
+			<-sink
+		}
+	}
+}
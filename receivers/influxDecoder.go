@@ -11,15 +11,38 @@
 package receivers
 
 import (
+	"fmt"
 	"time"
 
 	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
 	influx "github.com/ClusterCockpit/cc-line-protocol/v2/lineprotocol"
 )
 
+// ParseInfluxPrecision parses the timestamp precision names used by InfluxDB
+// (n, ns, u, us, ms, s). An empty string selects nanoseconds.
+func ParseInfluxPrecision(precision string) (influx.Precision, error) {
+	switch precision {
+	case "", "n", "ns":
+		return influx.Nanosecond, nil
+	case "u", "us", "µs":
+		return influx.Microsecond, nil
+	case "ms":
+		return influx.Millisecond, nil
+	case "s":
+		return influx.Second, nil
+	}
+	return influx.Nanosecond, fmt.Errorf("unsupported timestamp precision '%s'", precision)
+}
+
 // DecodeInfluxMessage decodes a single InfluxDB line protocol message from the decoder
 // Returns the decoded CCMessage or an error if decoding fails
 func DecodeInfluxMessage(d *influx.Decoder) (lp.CCMessage, error) {
+	return DecodeInfluxMessageWithPrecision(d, influx.Nanosecond)
+}
+
+// DecodeInfluxMessageWithPrecision decodes a single InfluxDB line protocol message
+// from the decoder with timestamps in the given precision
+func DecodeInfluxMessageWithPrecision(d *influx.Decoder, precision influx.Precision) (lp.CCMessage, error) {
 	measurement, err := d.Measurement()
 	if err != nil {
 		return nil, err
@@ -49,7 +72,7 @@ func DecodeInfluxMessage(d *influx.Decoder) (lp.CCMessage, error) {
 		fields[string(key)] = value.Interface()
 	}
 
-	t, err := d.Time(influx.Nanosecond, time.Time{})
+	t, err := d.Time(precision, time.Time{})
 	if err != nil {
 		return nil, err
 	}
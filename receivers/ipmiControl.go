@@ -0,0 +1,157 @@
+//go:build linux
+
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+)
+
+var _ ControlReceiver = (*IPMIReceiver)(nil)
+
+// Power limit in the output of ipmi-dcmi --get-power-limit, e.g.
+// "Power Limit Requested : 300 watts"
+var ipmiDCMIPowerLimitRegex = regexp.MustCompile(`(?i)^power limit requested\s*:\s*(\d+)\s*watts`)
+
+// Activation state in the output of ipmi-dcmi --get-power-limit, e.g.
+// "Active Power Limit : No"
+var ipmiDCMIPowerLimitActiveRegex = regexp.MustCompile(`(?i)^(?:power limit active|active power limit)\s*:\s*no\b`)
+
+// Control queues powercap control messages of the monitored hosts
+func (r *IPMIReceiver) Control(msg lp.CCMessage) bool {
+	if msg.Name() != CONTROL_POWERCAP {
+		return false
+	}
+	hostname, _ := msg.GetTag("hostname")
+	if _, ok := r.hosts[hostname]; !ok {
+		return false
+	}
+	r.control.add(msg)
+	return true
+}
+
+// applyControl applies a control message and sends the result
+func (r *IPMIReceiver) applyControl(msg lp.CCMessage) {
+	hostname, _ := msg.GetTag("hostname")
+	value, err := r.powerCap(r.hosts[hostname], msg)
+	r.sendControlResult(msg, value, err)
+}
+
+// dcmi runs ipmi-dcmi for the IPMI device of a host and returns its output
+func (r *IPMIReceiver) dcmi(host *ipmiHost, args ...string) (string, error) {
+	clientConfig := host.clientConfig
+	password := clientConfig.Password.Value()
+	cmdOptions := []string{
+		"--driver-type", clientConfig.DriverType,
+		"--hostname", host.ipmiHost,
+		"--username", clientConfig.Username,
+		"--password", password,
+	}
+	cmdOptions = append(cmdOptions, args...)
+
+	command := exec.Command("ipmi-dcmi", cmdOptions...)
+	var stdout, stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		return "", fmt.Errorf("command \"%s\" failed: %w: %s",
+			strings.ReplaceAll(command.String(), password, "<PW>"), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// freeIPMIPowerLimit returns the DCMI power limit of a host in watts, 0 if it
+// is not active, using ipmi-dcmi
+func (r *IPMIReceiver) freeIPMIPowerLimit(host *ipmiHost) (int, error) {
+	out, err := r.dcmi(host, "--get-power-limit")
+	if err != nil {
+		return 0, err
+	}
+	watts := -1
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if ipmiDCMIPowerLimitActiveRegex.MatchString(line) {
+			return 0, nil
+		}
+		if m := ipmiDCMIPowerLimitRegex.FindStringSubmatch(line); m != nil {
+			watts, _ = strconv.Atoi(m[1])
+		}
+	}
+	if watts < 0 {
+		return 0, fmt.Errorf("failed to parse power limit of ipmi-dcmi output '%s'", strings.TrimSpace(out))
+	}
+	return watts, nil
+}
+
+// setFreeIPMIPowerLimit sets and activates the DCMI power limit of a host in
+// watts or deactivates it for 0 using ipmi-dcmi
+func (r *IPMIReceiver) setFreeIPMIPowerLimit(host *ipmiHost, watts int) error {
+	if watts == 0 {
+		_, err := r.dcmi(host, "--activate-deactivate-power-limit=deactivate")
+		return err
+	}
+	if _, err := r.dcmi(host, "--set-power-limit", "--power-limit-requested="+strconv.Itoa(watts)); err != nil {
+		return err
+	}
+	_, err := r.dcmi(host, "--activate-deactivate-power-limit=activate")
+	return err
+}
+
+// powerCap gets or sets the power cap of a host through the DCMI power
+// management of the protocol of the host. It returns the current or new power
+// cap.
+func (r *IPMIReceiver) powerCap(host *ipmiHost, msg lp.CCMessage) (string, error) {
+	method, _ := msg.GetControlMethod()
+	var watts int
+	if method == "PUT" {
+		value, _ := msg.GetControlValue()
+		var err error
+		if watts, err = parsePowerCap(value); err != nil {
+			return "", err
+		}
+	}
+
+	var err error
+	switch host.clientConfig.Protocol {
+	case "ipmi":
+		host.lock.Lock()
+		defer host.lock.Unlock()
+		err = r.withSession(host, func(s *ipmiSession) error {
+			if method == "GET" {
+				var err error
+				watts, _, err = s.dcmiPowerLimit()
+				return err
+			}
+			return s.setDCMIPowerLimit(watts)
+		})
+	case "ipmitool":
+		if method == "GET" {
+			watts, err = r.ipmitoolPowerLimit(host)
+		} else {
+			err = r.setIpmitoolPowerLimit(host, watts)
+		}
+	default:
+		if method == "GET" {
+			watts, err = r.freeIPMIPowerLimit(host)
+		} else {
+			err = r.setFreeIPMIPowerLimit(host, watts)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return formatPowerCap(watts), nil
+}
@@ -0,0 +1,424 @@
+//go:build linux
+
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"os"
+	"time"
+)
+
+// IPMI v2.0 RMCP+ client of the IPMI LAN interface
+// See: IPMI v2.0 specification, section 13
+
+// Default UDP port of the IPMI LAN interface
+const IPMI_LAN_PORT = 623
+
+// Interval to retransmit requests without response
+const IPMI_RETRY_INTERVAL = time.Second
+
+// Number of retransmissions of requests without response
+const IPMI_RETRIES = 2
+
+// RMCP+ payload types
+const (
+	ipmiPayloadIPMI                = 0x00
+	ipmiPayloadOpenSessionRequest  = 0x10
+	ipmiPayloadOpenSessionResponse = 0x11
+	ipmiPayloadRAKP1               = 0x12
+	ipmiPayloadRAKP2               = 0x13
+	ipmiPayloadRAKP3               = 0x14
+	ipmiPayloadRAKP4               = 0x15
+)
+
+// Network functions
+const (
+	ipmiNetFnSensorEvent    = 0x04
+	ipmiNetFnApp            = 0x06
+	ipmiNetFnStorage        = 0x0a
+	ipmiNetFnGroupExtension = 0x2c
+)
+
+// Privilege levels of sessions
+var ipmiPrivilegeLevels = map[string]byte{
+	"user":          0x02,
+	"operator":      0x03,
+	"administrator": 0x04,
+}
+
+// ipmiCipherSuite are the algorithms of a RMCP+ cipher suite
+type ipmiCipherSuite struct {
+	authAlg            byte             // RAKP authentication algorithm
+	integrityAlg       byte             // integrity algorithm
+	confidentialityAlg byte             // confidentiality algorithm
+	hash               func() hash.Hash // hash of the HMACs
+	icvLen             int              // length of the integrity check values
+}
+
+// Supported cipher suites
+var ipmiCipherSuites = map[int]ipmiCipherSuite{
+	// RAKP-HMAC-SHA1, HMAC-SHA1-96, AES-CBC-128
+	3: {authAlg: 0x01, integrityAlg: 0x01, confidentialityAlg: 0x01, hash: sha1.New, icvLen: 12},
+	// RAKP-HMAC-SHA256, HMAC-SHA256-128, AES-CBC-128
+	17: {authAlg: 0x03, integrityAlg: 0x04, confidentialityAlg: 0x01, hash: sha256.New, icvLen: 16},
+}
+
+// ipmiCompletionError is an IPMI response with a completion code other than success
+type ipmiCompletionError byte
+
+func (e ipmiCompletionError) Error() string {
+	return fmt.Sprintf("IPMI completion code 0x%02x", byte(e))
+}
+
+// ipmiSession is a RMCP+ session with a BMC
+type ipmiSession struct {
+	conn      net.Conn
+	suite     ipmiCipherSuite
+	deadline  time.Time // deadline of the current requests
+	consoleID uint32    // session ID of the remote console
+	bmcID     uint32    // session ID of the BMC
+	seq       uint32    // session sequence number
+	rqSeq     byte      // request sequence number
+	k1        []byte    // integrity key
+	k2        []byte    // confidentiality key
+	active    bool      // session is established
+}
+
+// ipmiHMAC returns the HMAC of the concatenated data
+func ipmiHMAC(h func() hash.Hash, key []byte, data ...[]byte) []byte {
+	mac := hmac.New(h, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+// ipmiSessionKeys derives the integrity key K1 and the confidentiality key K2
+// from the session integrity key. The constants are 20 bytes for all
+// authentication algorithms (IPMI v2.0, section 13.32).
+func ipmiSessionKeys(h func() hash.Hash, sik []byte) (k1, k2 []byte) {
+	k1 = ipmiHMAC(h, sik, bytes.Repeat([]byte{0x01}, 20))
+	k2 = ipmiHMAC(h, sik, bytes.Repeat([]byte{0x02}, 20))
+	return k1, k2
+}
+
+// ipmiChecksum returns the two's complement checksum of an IPMI message
+func ipmiChecksum(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return -sum
+}
+
+// newIPMISession opens a RMCP+ session with the BMC at address. The session
+// is established until deadline.
+func newIPMISession(
+	address, username, password string,
+	cipherSuite int,
+	privilege byte,
+	deadline time.Time,
+) (*ipmiSession, error) {
+	suite, ok := ipmiCipherSuites[cipherSuite]
+	if !ok {
+		return nil, fmt.Errorf("unsupported cipher suite %d", cipherSuite)
+	}
+	conn, err := net.DialTimeout("udp", address, time.Until(deadline))
+	if err != nil {
+		return nil, err
+	}
+	s := &ipmiSession{
+		conn:     conn,
+		suite:    suite,
+		deadline: deadline,
+	}
+	if err := s.open(username, password, privilege); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open session with %s: %w", address, err)
+	}
+	return s, nil
+}
+
+// open establishes the session with the RAKP handshake
+func (s *ipmiSession) open(username, password string, privilege byte) error {
+	if len(username) > 16 {
+		return errors.New("user name longer than 16 characters")
+	}
+	var random [36]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return err
+	}
+	s.consoleID = binary.LittleEndian.Uint32(random[:4]) | 1
+	consoleID := binary.LittleEndian.AppendUint32(nil, s.consoleID)
+
+	// Open Session Request
+	req := []byte{0x00, privilege, 0x00, 0x00}
+	req = append(req, consoleID...)
+	req = append(req,
+		0x00, 0x00, 0x00, 0x08, s.suite.authAlg, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x00, 0x08, s.suite.integrityAlg, 0x00, 0x00, 0x00,
+		0x02, 0x00, 0x00, 0x08, s.suite.confidentialityAlg, 0x00, 0x00, 0x00,
+	)
+	resp, err := s.exchange(ipmiPayloadOpenSessionRequest, req, ipmiPayloadOpenSessionResponse, nil)
+	if err != nil {
+		return err
+	}
+	if len(resp) >= 2 && resp[1] != 0 {
+		return fmt.Errorf("open session request failed with status 0x%02x", resp[1])
+	}
+	if len(resp) < 36 || !bytes.Equal(resp[4:8], consoleID) {
+		return errors.New("invalid open session response")
+	}
+	if resp[16] != s.suite.authAlg || resp[24] != s.suite.integrityAlg || resp[32] != s.suite.confidentialityAlg {
+		return errors.New("cipher suite not accepted")
+	}
+	bmcID := resp[8:12]
+	s.bmcID = binary.LittleEndian.Uint32(bmcID)
+
+	// RAKP Message 1
+	rm := random[4:20]
+	role := privilege | 0x10 // name-only lookup
+	user := append([]byte{role, 0x00, 0x00, byte(len(username))}, username...)
+	req = append([]byte{0x00, 0x00, 0x00, 0x00}, bmcID...)
+	req = append(req, rm...)
+	req = append(req, user...)
+	resp, err = s.exchange(ipmiPayloadRAKP1, req, ipmiPayloadRAKP2, nil)
+	if err != nil {
+		return err
+	}
+	if len(resp) >= 2 && resp[1] != 0 {
+		return fmt.Errorf("RAKP message 1 failed with status 0x%02x", resp[1])
+	}
+	hashLen := s.suite.hash().Size()
+	if len(resp) < 40+hashLen || !bytes.Equal(resp[4:8], consoleID) {
+		return errors.New("invalid RAKP message 2")
+	}
+	rc := resp[8:24]
+	guid := resp[24:40]
+	kuid := []byte(password)
+	// The role is followed by two reserved bytes in RAKP message 1, but not in the HMACs
+	userHMAC := append([]byte{role, byte(len(username))}, username...)
+	if !hmac.Equal(resp[40:40+hashLen], ipmiHMAC(s.suite.hash, kuid, consoleID, bmcID, rm, rc, guid, userHMAC)) {
+		return errors.New("RAKP message 2 authentication failed, invalid password")
+	}
+
+	// Session keys
+	sik := ipmiHMAC(s.suite.hash, kuid, rm, rc, userHMAC)
+	s.k1, s.k2 = ipmiSessionKeys(s.suite.hash, sik)
+
+	// RAKP Message 3
+	req = append([]byte{0x00, 0x00, 0x00, 0x00}, bmcID...)
+	req = append(req, ipmiHMAC(s.suite.hash, kuid, rc, consoleID, userHMAC)...)
+	resp, err = s.exchange(ipmiPayloadRAKP3, req, ipmiPayloadRAKP4, nil)
+	if err != nil {
+		return err
+	}
+	if len(resp) >= 2 && resp[1] != 0 {
+		return fmt.Errorf("RAKP message 3 failed with status 0x%02x", resp[1])
+	}
+	if len(resp) < 8+s.suite.icvLen || !bytes.Equal(resp[4:8], consoleID) ||
+		!hmac.Equal(resp[8:8+s.suite.icvLen], ipmiHMAC(s.suite.hash, sik, rm, bmcID, guid)[:s.suite.icvLen]) {
+		return errors.New("RAKP message 4 integrity check failed")
+	}
+	s.active = true
+
+	// Sessions start with user privilege
+	if privilege > ipmiPrivilegeLevels["user"] {
+		if _, err := s.request(ipmiNetFnApp, 0, 0x3b, []byte{privilege}); err != nil {
+			return fmt.Errorf("failed to set session privilege level: %w", err)
+		}
+	}
+	return nil
+}
+
+// close closes the session
+func (s *ipmiSession) close() {
+	if s.active {
+		s.deadline = time.Now().Add(IPMI_RETRY_INTERVAL)
+		s.request(ipmiNetFnApp, 0, 0x3c, binary.LittleEndian.AppendUint32(nil, s.bmcID))
+		s.active = false
+	}
+	s.conn.Close()
+}
+
+// request sends an IPMI request and returns the response data. On completion
+// codes other than success, the data is returned with an ipmiCompletionError.
+func (s *ipmiSession) request(netFn, lun, cmd byte, data []byte) ([]byte, error) {
+	s.rqSeq = (s.rqSeq + 1) & 0x3f
+	rqSeq := s.rqSeq
+	msg := []byte{0x20, netFn<<2 | lun&0x03}
+	msg = append(msg, ipmiChecksum(msg))
+	msg = append(msg, 0x81, rqSeq<<2, cmd)
+	msg = append(msg, data...)
+	msg = append(msg, ipmiChecksum(msg[3:]))
+
+	resp, err := s.exchange(ipmiPayloadIPMI, msg, ipmiPayloadIPMI, func(resp []byte) bool {
+		return len(resp) >= 8 && resp[1]>>2 == netFn|1 && resp[4]>>2 == rqSeq && resp[5] == cmd
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ipmiChecksum(resp[:2]) != resp[2] || ipmiChecksum(resp[3:len(resp)-1]) != resp[len(resp)-1] {
+		return nil, errors.New("invalid checksum of IPMI response")
+	}
+	if cc := resp[6]; cc != 0 {
+		return resp[7 : len(resp)-1], ipmiCompletionError(cc)
+	}
+	return resp[7 : len(resp)-1], nil
+}
+
+// exchange sends a payload and returns the first response payload of type
+// rtype accepted by match. Requests are retransmitted until the deadline, at
+// most IPMI_RETRIES times.
+func (s *ipmiSession) exchange(ptype byte, payload []byte, rtype byte, match func([]byte) bool) ([]byte, error) {
+	buf := make([]byte, 1024)
+	for i := 0; i <= IPMI_RETRIES && time.Now().Before(s.deadline); i++ {
+		packet, err := s.packet(ptype, payload)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.conn.Write(packet); err != nil {
+			return nil, err
+		}
+		retry := time.Now().Add(IPMI_RETRY_INTERVAL)
+		if s.deadline.Before(retry) {
+			retry = s.deadline
+		}
+		s.conn.SetReadDeadline(retry)
+		for {
+			n, err := s.conn.Read(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			// Skip invalid packets and responses of previous requests
+			t, resp, err := s.parse(buf[:n])
+			if err != nil || t != rtype || (match != nil && !match(resp)) {
+				continue
+			}
+			return resp, nil
+		}
+	}
+	return nil, fmt.Errorf("timeout waiting for response of %s", s.conn.RemoteAddr())
+}
+
+// packet creates a RMCP+ packet. Packets of established sessions are encrypted
+// and authenticated.
+func (s *ipmiSession) packet(ptype byte, payload []byte) ([]byte, error) {
+	// RMCP header and authentication type RMCP+
+	p := []byte{0x06, 0x00, 0xff, 0x07, 0x06}
+	if !s.active {
+		p = append(p, ptype, 0, 0, 0, 0, 0, 0, 0, 0)
+		p = binary.LittleEndian.AppendUint16(p, uint16(len(payload)))
+		return append(p, payload...), nil
+	}
+
+	s.seq++
+	data, err := s.encrypt(payload)
+	if err != nil {
+		return nil, err
+	}
+	p = append(p, ptype|0xc0)
+	p = binary.LittleEndian.AppendUint32(p, s.bmcID)
+	p = binary.LittleEndian.AppendUint32(p, s.seq)
+	p = binary.LittleEndian.AppendUint16(p, uint16(len(data)))
+	p = append(p, data...)
+	// Pad the session header, payload and trailer to a multiple of 4 bytes
+	pad := (4 - (len(p)-4+2)%4) % 4
+	p = append(p, bytes.Repeat([]byte{0xff}, pad)...)
+	p = append(p, byte(pad), 0x07)
+	return append(p, ipmiHMAC(s.suite.hash, s.k1, p[4:])[:s.suite.icvLen]...), nil
+}
+
+// parse parses a RMCP+ packet and returns its payload type and payload
+func (s *ipmiSession) parse(p []byte) (byte, []byte, error) {
+	if len(p) < 16 || p[0] != 0x06 || p[3] != 0x07 || p[4] != 0x06 {
+		return 0, nil, errors.New("not a RMCP+ packet")
+	}
+	ptype := p[5] & 0x3f
+	length := int(binary.LittleEndian.Uint16(p[14:16]))
+	if len(p) < 16+length {
+		return 0, nil, errors.New("truncated RMCP+ packet")
+	}
+	payload := p[16 : 16+length]
+	if p[5]&0x40 == 0 {
+		// Only packets to establish the session are not authenticated
+		if s.active {
+			return 0, nil, errors.New("unauthenticated packet")
+		}
+		return ptype, payload, nil
+	}
+
+	if !s.active || binary.LittleEndian.Uint32(p[6:10]) != s.consoleID || len(p) < 16+length+2+s.suite.icvLen {
+		return 0, nil, errors.New("invalid session")
+	}
+	icv := len(p) - s.suite.icvLen
+	if !hmac.Equal(p[icv:], ipmiHMAC(s.suite.hash, s.k1, p[4:icv])[:s.suite.icvLen]) {
+		return 0, nil, errors.New("integrity check failed")
+	}
+	if p[5]&0x80 != 0 {
+		var err error
+		if payload, err = s.decrypt(payload); err != nil {
+			return 0, nil, err
+		}
+	}
+	return ptype, payload, nil
+}
+
+// encrypt encrypts a payload with AES-CBC-128
+func (s *ipmiSession) encrypt(payload []byte) ([]byte, error) {
+	block, err := aes.NewCipher(s.k2[:16])
+	if err != nil {
+		return nil, err
+	}
+	// Pad with 1, 2, 3, ... followed by the pad length
+	data := append([]byte{}, payload...)
+	pad := (aes.BlockSize - (len(data)+1)%aes.BlockSize) % aes.BlockSize
+	for i := range pad {
+		data = append(data, byte(i+1))
+	}
+	data = append(data, byte(pad))
+
+	out := make([]byte, aes.BlockSize+len(data))
+	if _, err := rand.Read(out[:aes.BlockSize]); err != nil {
+		return nil, err
+	}
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], data)
+	return out, nil
+}
+
+// decrypt decrypts an AES-CBC-128 encrypted payload
+func (s *ipmiSession) decrypt(data []byte) ([]byte, error) {
+	if len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid length of encrypted payload")
+	}
+	block, err := aes.NewCipher(s.k2[:16])
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, data[:aes.BlockSize]).CryptBlocks(out, data[aes.BlockSize:])
+	pad := int(out[len(out)-1])
+	if pad >= len(out) {
+		return nil, errors.New("invalid padding of encrypted payload")
+	}
+	return out[:len(out)-1-pad], nil
+}
@@ -0,0 +1,441 @@
+//go:build linux
+
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Number of bytes of a sensor data record read per request
+const IPMI_SDR_CHUNK_SIZE = 16
+
+// Completion codes
+const (
+	ipmiReservationCancelled = 0xc5
+	ipmiNoActivePowerLimit   = 0x80
+)
+
+// Sensor type names of ipmi-sensors
+// See: IPMI v2.0 specification, table 42-3
+var ipmiSensorTypes = map[byte]string{
+	0x01: "Temperature",
+	0x02: "Voltage",
+	0x03: "Current",
+	0x04: "Fan",
+	0x05: "Physical Security",
+	0x07: "Processor",
+	0x08: "Power Supply",
+	0x09: "Power Unit",
+	0x0a: "Cooling Device",
+	0x0b: "Other Units Based Sensor",
+	0x0c: "Memory",
+	0x0f: "System Firmware Progress",
+	0x10: "Event Logging Disabled",
+	0x12: "System Event",
+	0x13: "Critical Interrupt",
+	0x23: "Watchdog 2",
+}
+
+// Non-abbreviated unit names of ipmi-sensors
+// See: IPMI v2.0 specification, table 43-15
+var ipmiUnits = map[byte]string{
+	0x00: "unspecified",
+	0x01: "degrees C",
+	0x02: "degrees F",
+	0x03: "degrees K",
+	0x04: "Volts",
+	0x05: "Amps",
+	0x06: "Watts",
+	0x11: "CFM",
+	0x12: "RPM",
+}
+
+// ipmiSensor is a threshold based sensor of the sensor data repository
+type ipmiSensor struct {
+	number        byte   // sensor number
+	lun           byte   // LUN of the sensor owner
+	name          string // sensor ID string
+	sensorType    string // sensor type name of ipmi-sensors
+	unit          string // unit name of ipmi-sensors
+	analogFormat  byte   // analog data format of the readings
+	linearization byte   // linearization function
+	m, b          int    // conversion factors
+	bExp, rExp    int    // conversion exponents
+}
+
+// signed converts a two's complement number of the given number of bits
+func signed(v, bits int) int {
+	if v&(1<<(bits-1)) != 0 {
+		return v - 1<<bits
+	}
+	return v
+}
+
+// parseIPMISensorRecord parses a full sensor record. It returns nil for other
+// records and for sensors which are not read, i.e. discrete sensors, sensors
+// without analog readings, sensors of other controllers than the BMC and
+// sensors with non-linear conversions.
+// See: IPMI v2.0 specification, table 43-1
+func parseIPMISensorRecord(record []byte) *ipmiSensor {
+	if len(record) < 48 || record[3] != 0x01 {
+		return nil
+	}
+	if record[5] != 0x20 || record[13] != 0x01 {
+		return nil
+	}
+	s := &ipmiSensor{
+		number:        record[7],
+		lun:           record[6] & 0x03,
+		sensorType:    ipmiSensorTypes[record[12]],
+		unit:          ipmiUnits[record[21]],
+		analogFormat:  record[20] >> 6,
+		linearization: record[23] & 0x7f,
+		m:             signed(int(record[24])|int(record[25]&0xc0)<<2, 10),
+		b:             signed(int(record[26])|int(record[27]&0xc0)<<2, 10),
+		rExp:          signed(int(record[29]>>4), 4),
+		bExp:          signed(int(record[29]&0x0f), 4),
+	}
+	if s.analogFormat == 3 || s.linearization > 0x0b {
+		return nil
+	}
+	if s.sensorType == "" {
+		s.sensorType = "OEM Reserved"
+	}
+	if record[20]&0x01 != 0 {
+		s.unit = "%"
+	} else if s.unit == "" {
+		s.unit = "unspecified"
+	}
+
+	s.name = ipmiSensorRecordName(record)
+	if s.name == "" {
+		s.name = "sensor_" + strconv.Itoa(int(s.number))
+	}
+	return s
+}
+
+// ipmiSensorRecordName returns the ID string of a full or compact sensor
+// record. Only 8-bit ASCII ID strings are supported.
+// See: IPMI v2.0 specification, tables 43-1 and 43-2
+func ipmiSensorRecordName(record []byte) string {
+	offset := 47
+	if len(record) > 3 && record[3] == 0x02 {
+		offset = 31
+	}
+	if len(record) <= offset || record[offset]>>6 != 3 {
+		return ""
+	}
+	length := int(record[offset] & 0x1f)
+	if len(record) < offset+1+length {
+		return ""
+	}
+	return strings.TrimRight(string(record[offset+1:offset+1+length]), "\x00 ")
+}
+
+// value converts a raw sensor reading
+// See: IPMI v2.0 specification, section 36.3
+func (s *ipmiSensor) value(raw byte) float64 {
+	var x int
+	switch s.analogFormat {
+	case 0:
+		x = int(raw)
+	case 1:
+		// One's complement
+		x = int(int8(raw))
+		if x < 0 {
+			x++
+		}
+	case 2:
+		x = int(int8(raw))
+	}
+	y := (float64(s.m*x) + float64(s.b)*math.Pow10(s.bExp)) * math.Pow10(s.rExp)
+	switch s.linearization {
+	case 0x01:
+		y = math.Log(y)
+	case 0x02:
+		y = math.Log10(y)
+	case 0x03:
+		y = math.Log2(y)
+	case 0x04:
+		y = math.Exp(y)
+	case 0x05:
+		y = math.Pow(10, y)
+	case 0x06:
+		y = math.Exp2(y)
+	case 0x07:
+		y = 1 / y
+	case 0x08:
+		y = y * y
+	case 0x09:
+		y = y * y * y
+	case 0x0a:
+		y = math.Sqrt(y)
+	case 0x0b:
+		y = math.Cbrt(y)
+	}
+	return y
+}
+
+// reserveSDR reserves the sensor data repository
+func (s *ipmiSession) reserveSDR() (uint16, error) {
+	resp, err := s.request(ipmiNetFnStorage, 0, 0x22, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reserve SDR repository: %w", err)
+	}
+	if len(resp) < 2 {
+		return 0, errors.New("invalid reserve SDR repository response")
+	}
+	return binary.LittleEndian.Uint16(resp), nil
+}
+
+// getSDR reads a part of a sensor data record and returns it with the ID of
+// the next record. Cancelled reservations are renewed.
+func (s *ipmiSession) getSDR(reservation *uint16, recordID uint16, offset, n int) ([]byte, uint16, error) {
+	for {
+		req := binary.LittleEndian.AppendUint16(nil, *reservation)
+		req = binary.LittleEndian.AppendUint16(req, recordID)
+		req = append(req, byte(offset), byte(n))
+		resp, err := s.request(ipmiNetFnStorage, 0, 0x23, req)
+		if errors.Is(err, ipmiCompletionError(ipmiReservationCancelled)) {
+			if *reservation, err = s.reserveSDR(); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to get SDR 0x%04x: %w", recordID, err)
+		}
+		if len(resp) < 2+n {
+			return nil, 0, fmt.Errorf("invalid get SDR 0x%04x response", recordID)
+		}
+		return resp[2 : 2+n], binary.LittleEndian.Uint16(resp), nil
+	}
+}
+
+// readSDR reads the threshold based sensors of the sensor data repository and
+// the names of all full and compact sensor records of the BMC by sensor number
+func (s *ipmiSession) readSDR() ([]*ipmiSensor, map[byte]string, error) {
+	reservation, err := s.reserveSDR()
+	if err != nil {
+		return nil, nil, err
+	}
+	var sensors []*ipmiSensor
+	names := make(map[byte]string)
+	recordID := uint16(0)
+	for range 0xffff {
+		record, next, err := s.getSDR(&reservation, recordID, 0, 5)
+		if err != nil {
+			return nil, nil, err
+		}
+		length := 5 + int(record[4])
+		for len(record) < length {
+			data, _, err := s.getSDR(&reservation, recordID, len(record), min(IPMI_SDR_CHUNK_SIZE, length-len(record)))
+			if err != nil {
+				return nil, nil, err
+			}
+			record = append(record, data...)
+		}
+		if sensor := parseIPMISensorRecord(record); sensor != nil {
+			sensors = append(sensors, sensor)
+		}
+		if (record[3] == 0x01 || record[3] == 0x02) && len(record) > 7 && record[5] == 0x20 {
+			if name := ipmiSensorRecordName(record); name != "" {
+				names[record[7]] = name
+			}
+		}
+		if next == 0xffff || next == recordID {
+			break
+		}
+		recordID = next
+	}
+	return sensors, names, nil
+}
+
+// readSensor returns the current value of a sensor. It reports false if the
+// reading is not available, e.g. the sensor is not present.
+func (s *ipmiSession) readSensor(sensor *ipmiSensor) (float64, bool, error) {
+	resp, err := s.request(ipmiNetFnSensorEvent, sensor.lun, 0x2d, []byte{sensor.number})
+	var completionError ipmiCompletionError
+	if errors.As(err, &completionError) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get reading of sensor %s: %w", sensor.name, err)
+	}
+	// Skip sensors with disabled scanning or unavailable readings
+	if len(resp) < 2 || resp[1]&0x40 == 0 || resp[1]&0x20 != 0 {
+		return 0, false, nil
+	}
+	return sensor.value(resp[0]), true, nil
+}
+
+// dcmiRequest sends a request of the DCMI group extension
+// See: DCMI v1.5 specification, section 6
+func (s *ipmiSession) dcmiRequest(cmd byte, data ...byte) ([]byte, error) {
+	resp, err := s.request(ipmiNetFnGroupExtension, 0, cmd, append([]byte{0xdc}, data...))
+	if len(resp) > 0 {
+		resp = resp[1:]
+	}
+	return resp, err
+}
+
+// dcmiPowerReading returns the current power consumption of the system in
+// watts. It reports false if the power measurement is not active.
+func (s *ipmiSession) dcmiPowerReading() (float64, bool, error) {
+	resp, err := s.dcmiRequest(0x02, 0x01, 0x00, 0x00)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get DCMI power reading: %w", err)
+	}
+	if len(resp) < 17 || resp[16]&0x40 == 0 {
+		return 0, false, nil
+	}
+	return float64(binary.LittleEndian.Uint16(resp)), true, nil
+}
+
+// dcmiPowerLimit returns the DCMI power limit in watts, 0 if it is not active,
+// and the raw limit settings. Without settings of the BMC, the settings have
+// no exception action, a correction time of 1 s and a sampling period of 1 s.
+func (s *ipmiSession) dcmiPowerLimit() (int, []byte, error) {
+	resp, err := s.dcmiRequest(0x03, 0x00, 0x00)
+	noLimit := errors.Is(err, ipmiCompletionError(ipmiNoActivePowerLimit))
+	if err != nil && !noLimit {
+		return 0, nil, fmt.Errorf("failed to get DCMI power limit: %w", err)
+	}
+	if len(resp) < 13 {
+		if !noLimit {
+			return 0, nil, errors.New("invalid DCMI power limit response")
+		}
+		resp = []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0xe8, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00}
+	}
+	if noLimit {
+		return 0, resp, nil
+	}
+	return int(binary.LittleEndian.Uint16(resp[3:5])), resp, nil
+}
+
+// setDCMIPowerLimit sets and activates the DCMI power limit in watts or
+// deactivates it for 0. The exception action, correction time and sampling
+// period of the current settings are kept.
+func (s *ipmiSession) setDCMIPowerLimit(watts int) error {
+	if watts > 0 {
+		_, current, err := s.dcmiPowerLimit()
+		if err != nil {
+			return err
+		}
+		req := []byte{0x00, 0x00, 0x00, current[2]}
+		req = binary.LittleEndian.AppendUint16(req, uint16(watts))
+		req = append(req, current[5:9]...)
+		req = append(req, 0x00, 0x00)
+		req = append(req, current[11:13]...)
+		if _, err := s.dcmiRequest(0x04, req...); err != nil {
+			return fmt.Errorf("failed to set DCMI power limit: %w", err)
+		}
+	}
+	activate := byte(0x00)
+	if watts > 0 {
+		activate = 0x01
+	}
+	if _, err := s.dcmiRequest(0x05, activate, 0x00, 0x00); err != nil {
+		return fmt.Errorf("failed to activate DCMI power limit: %w", err)
+	}
+	return nil
+}
+
+// withSession calls f with the RMCP+ session of a host, which is opened on
+// first use and reused afterwards. Requests of f end at the host timeout.
+// Sessions with failed requests other than completion errors are closed. When
+// a reused session failed, e.g. because it timed out on the BMC, f is retried
+// with a new session. The caller must hold the lock of the host.
+func (r *IPMIReceiver) withSession(host *ipmiHost, f func(s *ipmiSession) error) error {
+	clientConfig := host.clientConfig
+	deadline := time.Now().Add(clientConfig.Timeout)
+	for retry := host.session != nil; ; retry = false {
+		if host.session == nil {
+			address := host.ipmiHost
+			if _, _, err := net.SplitHostPort(address); err != nil {
+				address = net.JoinHostPort(address, strconv.Itoa(IPMI_LAN_PORT))
+			}
+			s, err := newIPMISession(
+				address,
+				clientConfig.Username,
+				clientConfig.Password.Value(),
+				clientConfig.CipherSuite,
+				ipmiPrivilegeLevels[clientConfig.PrivilegeLevel],
+				deadline)
+			if err != nil {
+				return err
+			}
+			host.session = s
+			host.sensors = nil
+			host.sensorNames = nil
+		}
+		host.session.deadline = deadline
+		err := f(host.session)
+		var completionError ipmiCompletionError
+		if err == nil || errors.As(err, &completionError) {
+			return err
+		}
+		host.session.close()
+		host.session = nil
+		if !retry || !time.Now().Before(deadline) {
+			return err
+		}
+	}
+}
+
+// readNative reads the sensors, the DCMI power reading and the new SEL entries
+// of a host with the native IPMI client. The sensor data repository is read
+// once per session.
+func (r *IPMIReceiver) readNative(host *ipmiHost) error {
+	host.lock.Lock()
+	defer host.lock.Unlock()
+	return r.withSession(host, func(s *ipmiSession) error {
+		if host.sensorNames == nil {
+			sensors, names, err := s.readSDR()
+			if err != nil {
+				return err
+			}
+			host.sensors = sensors
+			host.sensorNames = names
+		}
+		for _, sensor := range host.sensors {
+			value, ok, err := s.readSensor(sensor)
+			if err != nil {
+				return err
+			}
+			if ok {
+				r.sendSensor(host.clientConfig, host.hostname, sensor.sensorType, sensor.name, sensor.unit, value)
+			}
+		}
+
+		// Node power of the DCMI power management, if supported
+		var completionError ipmiCompletionError
+		if !host.noDCMI && !host.clientConfig.isExcluded["power"] {
+			value, ok, err := s.dcmiPowerReading()
+			if errors.As(err, &completionError) {
+				host.noDCMI = true
+			} else if err != nil {
+				return err
+			} else if ok {
+				r.sendSensor(host.clientConfig, host.hostname, "Power Unit", "dcmi", "Watts", value)
+			}
+		}
+
+		if r.config.SEL {
+			return r.readNativeSEL(s, host)
+		}
+		return nil
+	})
+}
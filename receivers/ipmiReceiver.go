@@ -20,11 +20,13 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
 	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
 	"github.com/ClusterCockpit/cc-lib/v2/hostlist"
+	"github.com/ClusterCockpit/cc-lib/v2/util"
 )
 
 type IPMIReceiverClientConfig struct {
@@ -36,9 +38,13 @@ type IPMIReceiverClientConfig struct {
 	IPMIHosts        string            // List of remote IPMI devices to communicate with
 	IPMI2HostMapping map[string]string // Mapping between IPMI device name and host name
 	Username         string            // User name to authenticate with
-	Password         string            // Password to use for authentication
-	CLIOptions       []string          // Additional command line options for ipmi-sensors
+	Password         *util.Secret      // Password to use for authentication
+	CLIOptions       []string          // Additional command line options for ipmi-sensors or ipmitool
+	Timeout          time.Duration     // Maximum time to read an IPMI device with ipmitool or the native client
+	CipherSuite      int               // RMCP+ cipher suite of ipmitool or the native client
+	PrivilegeLevel   string            // Session privilege level of ipmitool or the native client
 	isExcluded       map[string]bool   // is metric excluded
+	hosts            []*ipmiHost       // IPMI devices
 }
 
 type IPMIReceiver struct {
@@ -46,6 +52,12 @@ type IPMIReceiver struct {
 	config struct {
 		Interval time.Duration
 
+		// Read the System Event Log
+		SEL bool
+
+		// File to persist the last seen SEL record IDs
+		SELStateFile string
+
 		// Client config for each IPMI hosts
 		ClientConfigs []IPMIReceiverClientConfig
 	}
@@ -55,212 +67,281 @@ type IPMIReceiver struct {
 
 	done chan bool      // channel to finish / stop IPMI receiver
 	wg   sync.WaitGroup // wait group for IPMI receiver
+
+	hosts      map[string]*ipmiHost // IPMI devices by host name
+	control    *controlQueue        // queue of control messages
+	selChanged atomic.Bool          // SEL state needs to be saved
+}
+
+// Prefix enumeration of sensor names like 01-...
+var ipmiNumPrefixRegex = regexp.MustCompile("^[[:digit:]][[:digit:]]-(.*)$")
+
+// ipmiHost is the IPMI device of a host
+type ipmiHost struct {
+	clientConfig *IPMIReceiverClientConfig
+	ipmiHost     string
+	hostname     string
+
+	lock    sync.Mutex    // serializes the use of the session
+	session *ipmiSession  // RMCP+ session of the native IPMI client
+	sensors []*ipmiSensor // sensors of the sensor data repository
+	noDCMI  bool          // DCMI power reading is not supported
+
+	sensorNames map[byte]string // sensor names by sensor number
+	sel         *ipmiSELState   // SEL state, nil before first contact
+}
+
+// ipmiMetric normalizes a sensor with the sensor type, name and unit names of
+// ipmi-sensors to metric name, sensor name and unit. It reports false for
+// sensors which are not collected.
+func ipmiMetric(sensorType, name, unit string) (string, string, string, bool) {
+	metric := strings.ToLower(sensorType)
+	name = strings.ToLower(
+		strings.ReplaceAll(
+			strings.TrimSpace(name), " ", "_"))
+	// remove prefix enumeration like 01-...
+	if v := ipmiNumPrefixRegex.FindStringSubmatch(name); v != nil {
+		name = v[1]
+	}
+	if unit == "Watts" {
+
+		// Power
+		metric = "power"
+		name = strings.TrimSuffix(name, "_power")
+		name = strings.TrimSuffix(name, "_pwr")
+		name = strings.TrimPrefix(name, "pwr_")
+	} else if metric == "voltage" &&
+		unit == "Volts" {
+
+		// Voltage
+		name = strings.TrimPrefix(name, "volt_")
+	} else if metric == "current" &&
+		unit == "Amps" {
+
+		// Current
+		unit = "Ampere"
+	} else if metric == "temperature" &&
+		unit == "degrees C" {
+
+		// Temperature
+		name = strings.TrimSuffix(name, "_temp")
+		unit = "degC"
+	} else if metric == "temperature" &&
+		unit == "degrees F" {
+
+		// Temperature
+		name = strings.TrimSuffix(name, "_temp")
+		unit = "degF"
+	} else if metric == "fan" && unit == "RPM" {
+
+		// Fan speed
+		metric = "fan_speed"
+		name = strings.TrimSuffix(name, "_tach")
+		name = strings.TrimPrefix(name, "spd_")
+	} else if (metric == "cooling device" ||
+		metric == "other units based sensor") &&
+		name == "system_air_flow" &&
+		unit == "CFM" {
+
+		// Air flow
+		metric = "air_flow"
+		name = strings.TrimSuffix(name, "_air_flow")
+		unit = "CubicFeetPerMinute"
+	} else if (metric == "processor" ||
+		metric == "other units based sensor") &&
+		(name == "cpu_utilization" ||
+			name == "io_utilization" ||
+			name == "mem_utilization" ||
+			name == "sys_utilization") &&
+		(unit == "unspecified" ||
+			unit == "%") {
+
+		// Utilization
+		metric = "utilization"
+		name = strings.TrimSuffix(name, "_utilization")
+		unit = "percent"
+	} else {
+		return "", "", "", false
+	}
+	return metric, name, unit, true
+}
+
+// sendSensor sends the normalized metric of a sensor reading of a host to the sink
+func (r *IPMIReceiver) sendSensor(
+	clientConfig *IPMIReceiverClientConfig,
+	host, sensorType, name, unit string,
+	value float64,
+) {
+	metric, name, unit, ok := ipmiMetric(sensorType, name, unit)
+	if !ok {
+		if false {
+			// Debug output for unprocessed metrics
+			fmt.Printf(
+				"host: '%s', type: '%s', name: '%s', unit: '%s'\n",
+				host, sensorType, name, unit)
+		}
+		return
+	}
+
+	// Skip excluded metrics
+	if clientConfig.isExcluded[metric] {
+		return
+	}
+
+	y, err := lp.NewMessage(
+		metric,
+		map[string]string{
+			"hostname": host,
+			"type":     "node",
+			"name":     name,
+		},
+		map[string]string{
+			"source": r.name,
+			"group":  "IPMI",
+			"unit":   unit,
+		},
+		map[string]any{
+			"value": value,
+		},
+		time.Now())
+	if err == nil {
+		r.sink <- y
+	}
+}
+
+// readHosts calls read for the IPMI devices of a client config in parallel,
+// for at most fanout devices at a time
+func (r *IPMIReceiver) readHosts(clientConfig *IPMIReceiverClientConfig, read func(host *ipmiHost) error) {
+	forEachParallel(clientConfig.hosts, clientConfig.Fanout, func(host *ipmiHost) {
+		if err := read(host); err != nil {
+			cclog.ComponentError(r.name, fmt.Sprintf("Failed to read sensors of %s: %v", host.ipmiHost, err))
+		}
+	})
 }
 
-// doReadMetric reads sensor data from all configured IPMI hosts using the ipmi-sensors command.
-// It executes the command with appropriate options, parses the CSV output, and sends
-// normalized metrics (power, temperature, fan_speed, voltage, current) to the sink.
+// doReadMetric reads sensor data from all configured IPMI hosts using the
+// ipmi-sensors command, the ipmitool command or the native IPMI client and
+// sends normalized metrics (power, temperature, fan_speed, voltage, current)
+// to the sink. If enabled, new SEL entries are sent as events.
 func (r *IPMIReceiver) doReadMetric() {
 	for i := range r.config.ClientConfigs {
 		clientConfig := &r.config.ClientConfigs[i]
-		var cmd_options []string
-		if clientConfig.Protocol == "ipmi-sensors" {
-			cmd_options = append(cmd_options,
-				"--always-prefix",
-				"--sdr-cache-recreate",
-				// Attempt to interpret OEM data, such as event data, sensor readings, or general extra info
-				"--interpret-oem-data",
-				// Ignore not-available (i.e. N/A) sensors in output
-				"--ignore-not-available-sensors",
-				// Ignore unrecognized sensor events
-				"--ignore-unrecognized-events",
-				// Output fields in comma separated format
-				"--comma-separated-output",
-				// Do not output column headers
-				"--no-header-output",
-				// Output non-abbreviated units (e.g. 'Amps' instead of 'A').
-				// May aid in disambiguation of units (e.g. 'C' for Celsius or Coulombs).
-				"--non-abbreviated-units",
-				"--fanout", fmt.Sprint(clientConfig.Fanout),
-				"--driver-type", clientConfig.DriverType,
-				"--hostname", clientConfig.IPMIHosts,
-				"--username", clientConfig.Username,
-				"--password", clientConfig.Password,
-			)
-			cmd_options := append(cmd_options, clientConfig.CLIOptions...)
-
-			command := exec.Command("ipmi-sensors", cmd_options...)
-			stdout, _ := command.StdoutPipe()
-			errBuf := new(bytes.Buffer)
-			command.Stderr = errBuf
-
-			// start command
-			if err := command.Start(); err != nil {
-				cclog.ComponentError(
-					r.name,
-					fmt.Sprintf("doReadMetric(): Failed to start command \"%s\": %v", command.String(), err),
-				)
-				continue
+		switch clientConfig.Protocol {
+		case "ipmi":
+			r.readHosts(clientConfig, r.readNative)
+		case "ipmitool":
+			r.readHosts(clientConfig, r.readIpmitool)
+		case "ipmi-sensors":
+			r.readIPMISensors(clientConfig)
+			if r.config.SEL {
+				r.readIPMISel(clientConfig)
 			}
+		}
+	}
+	r.saveSELState()
+}
 
-			// Read command output
-			const (
-				idxID = iota
-				idxName
-				idxType
-				idxReading
-				idxUnits
-				idxEvent
-			)
-			numPrefixRegex := regexp.MustCompile("^[[:digit:]][[:digit:]]-(.*)$")
-			scanner := bufio.NewScanner(stdout)
-			for scanner.Scan() {
-				// Read host
-				v1 := strings.Split(scanner.Text(), ": ")
-				if len(v1) != 2 {
-					continue
-				}
-				host, ok := clientConfig.IPMI2HostMapping[v1[0]]
-				if !ok {
-					continue
-				}
-
-				// Read sensors
-				v2 := strings.Split(v1[1], ",")
-				if len(v2) != 6 {
-					continue
-				}
-				// Skip sensors with non available sensor readings
-				if v2[idxReading] == "N/A" {
-					continue
-				}
+// readIPMISensors reads sensor data of the IPMI hosts of a client config using
+// the ipmi-sensors command. It executes the command with appropriate options
+// and parses the CSV output.
+func (r *IPMIReceiver) readIPMISensors(clientConfig *IPMIReceiverClientConfig) {
+	password := clientConfig.Password.Value()
+	cmd_options := []string{
+		"--always-prefix",
+		"--sdr-cache-recreate",
+		// Attempt to interpret OEM data, such as event data, sensor readings, or general extra info
+		"--interpret-oem-data",
+		// Ignore not-available (i.e. N/A) sensors in output
+		"--ignore-not-available-sensors",
+		// Ignore unrecognized sensor events
+		"--ignore-unrecognized-events",
+		// Output fields in comma separated format
+		"--comma-separated-output",
+		// Do not output column headers
+		"--no-header-output",
+		// Output non-abbreviated units (e.g. 'Amps' instead of 'A').
+		// May aid in disambiguation of units (e.g. 'C' for Celsius or Coulombs).
+		"--non-abbreviated-units",
+		"--fanout", fmt.Sprint(clientConfig.Fanout),
+		"--driver-type", clientConfig.DriverType,
+		"--hostname", clientConfig.IPMIHosts,
+		"--username", clientConfig.Username,
+		"--password", password,
+	}
+	cmd_options = append(cmd_options, clientConfig.CLIOptions...)
+
+	command := exec.Command("ipmi-sensors", cmd_options...)
+	stdout, _ := command.StdoutPipe()
+	errBuf := new(bytes.Buffer)
+	command.Stderr = errBuf
+
+	// start command
+	if err := command.Start(); err != nil {
+		cclog.ComponentError(
+			r.name,
+			fmt.Sprintf("doReadMetric(): Failed to start command \"%s\": %v", command.String(), err),
+		)
+		return
+	}
 
-				metric := strings.ToLower(v2[idxType])
-				name := strings.ToLower(
-					strings.ReplaceAll(
-						strings.TrimSpace(
-							v2[idxName]), " ", "_"))
-				// remove prefix enumeration like 01-...
-				if v := numPrefixRegex.FindStringSubmatch(name); v != nil {
-					name = v[1]
-				}
-				unit := v2[idxUnits]
-				if unit == "Watts" {
-
-					// Power
-					metric = "power"
-					name = strings.TrimSuffix(name, "_power")
-					name = strings.TrimSuffix(name, "_pwr")
-					name = strings.TrimPrefix(name, "pwr_")
-				} else if metric == "voltage" &&
-					unit == "Volts" {
-
-					// Voltage
-					name = strings.TrimPrefix(name, "volt_")
-				} else if metric == "current" &&
-					unit == "Amps" {
-
-					// Current
-					unit = "Ampere"
-				} else if metric == "temperature" &&
-					unit == "degrees C" {
-
-					// Temperature
-					name = strings.TrimSuffix(name, "_temp")
-					unit = "degC"
-				} else if metric == "temperature" &&
-					unit == "degrees F" {
-
-					// Temperature
-					name = strings.TrimSuffix(name, "_temp")
-					unit = "degF"
-				} else if metric == "fan" && unit == "RPM" {
-
-					// Fan speed
-					metric = "fan_speed"
-					name = strings.TrimSuffix(name, "_tach")
-					name = strings.TrimPrefix(name, "spd_")
-				} else if (metric == "cooling device" ||
-					metric == "other units based sensor") &&
-					name == "system_air_flow" &&
-					unit == "CFM" {
-
-					// Air flow
-					metric = "air_flow"
-					name = strings.TrimSuffix(name, "_air_flow")
-					unit = "CubicFeetPerMinute"
-				} else if (metric == "processor" ||
-					metric == "other units based sensor") &&
-					(name == "cpu_utilization" ||
-						name == "io_utilization" ||
-						name == "mem_utilization" ||
-						name == "sys_utilization") &&
-					(unit == "unspecified" ||
-						unit == "%") {
-
-					// Utilization
-					metric = "utilization"
-					name = strings.TrimSuffix(name, "_utilization")
-					unit = "percent"
-				} else {
-					if false {
-						// Debug output for unprocessed metrics
-						fmt.Printf(
-							"host: '%s', metric: '%s', name: '%s', unit: '%s'\n",
-							host, metric, name, unit)
-					}
-					continue
-				}
+	// Read command output
+	const (
+		idxID = iota
+		idxName
+		idxType
+		idxReading
+		idxUnits
+		idxEvent
+	)
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		// Read host
+		v1 := strings.Split(scanner.Text(), ": ")
+		if len(v1) != 2 {
+			continue
+		}
+		host, ok := clientConfig.IPMI2HostMapping[v1[0]]
+		if !ok {
+			continue
+		}
 
-				// Skip excluded metrics
-				if clientConfig.isExcluded[metric] {
-					continue
-				}
+		// Read sensors
+		v2 := strings.Split(v1[1], ",")
+		if len(v2) != 6 {
+			continue
+		}
+		// Skip sensors with non available sensor readings
+		if v2[idxReading] == "N/A" {
+			continue
+		}
 
-				// Parse sensor value
-				value, err := strconv.ParseFloat(v2[idxReading], 64)
-				if err != nil {
-					continue
-				}
+		// Parse sensor value
+		value, err := strconv.ParseFloat(v2[idxReading], 64)
+		if err != nil {
+			continue
+		}
 
-				y, err := lp.NewMessage(
-					metric,
-					map[string]string{
-						"hostname": host,
-						"type":     "node",
-						"name":     name,
-					},
-					map[string]string{
-						"source": r.name,
-						"group":  "IPMI",
-						"unit":   unit,
-					},
-					map[string]any{
-						"value": value,
-					},
-					time.Now())
-				if err == nil {
-					r.sink <- y
-				}
-			}
+		r.sendSensor(clientConfig, host, v2[idxType], v2[idxName], v2[idxUnits], value)
+	}
 
-			// Wait for command end
-			if err := command.Wait(); err != nil {
-				errMsg, _ := io.ReadAll(errBuf)
-				cclog.ComponentError(
-					r.name,
-					fmt.Sprintf("doReadMetric(): Failed to wait for the end of command \"%s\": %v\n",
-						strings.ReplaceAll(command.String(), clientConfig.Password, "<PW>"), err),
-					fmt.Sprintf("doReadMetric(): command stderr: \"%s\"\n", string(errMsg)),
-				)
-			}
-		}
+	// Wait for command end
+	if err := command.Wait(); err != nil {
+		errMsg, _ := io.ReadAll(errBuf)
+		cclog.ComponentError(
+			r.name,
+			fmt.Sprintf("doReadMetric(): Failed to wait for the end of command \"%s\": %v\n",
+				strings.ReplaceAll(command.String(), password, "<PW>"), err),
+			fmt.Sprintf("doReadMetric(): command stderr: \"%s\"\n", string(errMsg)),
+		)
 	}
 }
 
 func (r *IPMIReceiver) Start() {
 	cclog.ComponentDebug(r.name, "START")
 
+	// Apply the queued control messages
+	r.control.start(r.applyControl)
+
 	// Start IPMI receiver
 	r.wg.Go(func() {
 		// Create ticker
@@ -293,10 +374,28 @@ func (r *IPMIReceiver) Start() {
 func (r *IPMIReceiver) Close() {
 	cclog.ComponentDebug(r.name, "CLOSE")
 
+	// Finish the queued control messages
+	r.control.close()
+
 	// Send the signal and wait
 	close(r.done)
 	r.wg.Wait()
 
+	// Close the sessions of the native IPMI client
+	for _, host := range r.hosts {
+		host.lock.Lock()
+		if host.session != nil {
+			host.session.close()
+			host.session = nil
+		}
+		host.lock.Unlock()
+	}
+
+	// Stop watching the passwords
+	for i := range r.config.ClientConfigs {
+		r.config.ClientConfigs[i].Password.Close()
+	}
+
 	cclog.ComponentDebug(r.name, "DONE")
 }
 
@@ -318,11 +417,29 @@ func NewIPMIReceiver(name string, config json.RawMessage) (Receiver, error) {
 		// Out of band IPMI driver (default: LAN_2_0)
 		DriverType string `json:"driver_type,omitempty"`
 
+		// Maximum time to read an IPMI device with ipmitool or the native client (default: 10 s)
+		Timeout string `json:"timeout,omitempty"`
+
+		// RMCP+ cipher suite of ipmitool or the native client (default: 3)
+		CipherSuite int `json:"cipher_suite,omitempty"`
+
+		// Session privilege level of ipmitool or the native client (default: administrator)
+		PrivilegeLevel string `json:"privilege_level,omitempty"`
+
 		// Default client username, password and endpoint
 		Username *string `json:"username"` // User name to authenticate with
-		Password *string `json:"password"` // Password to use for authentication
+		Password *string `json:"password"` // Password to use for authentication, may be a secret reference (env:, file: or exec:)
 		Endpoint *string `json:"endpoint"` // URL of the IPMI device
 
+		// Re-read file: secret references when the files change
+		ReloadSecrets bool `json:"reload_secrets,omitempty"`
+
+		// Send new entries of the System Event Log as events
+		SEL bool `json:"sel,omitempty"`
+
+		// File to persist the last seen SEL record IDs of the hosts
+		SELStateFile string `json:"sel_state_file,omitempty"`
+
 		// Globally excluded metrics
 		ExcludeMetrics []string `json:"exclude_metrics,omitempty"`
 
@@ -334,10 +451,14 @@ func NewIPMIReceiver(name string, config json.RawMessage) (Receiver, error) {
 			Password   *string `json:"password"`              // Password to use for authentication
 			Endpoint   *string `json:"endpoint"`              // URL of the IPMI service
 
+			Timeout        string `json:"timeout,omitempty"`         // Maximum time to read an IPMI device (default: 10 s)
+			CipherSuite    int    `json:"cipher_suite,omitempty"`    // RMCP+ cipher suite (default: 3)
+			PrivilegeLevel string `json:"privilege_level,omitempty"` // Session privilege level (default: administrator)
+
 			// Per client excluded metrics
 			ExcludeMetrics []string `json:"exclude_metrics,omitempty"`
 
-			// Additional command line options for ipmi-sensors
+			// Additional command line options for ipmi-sensors or ipmitool
 			CLIOptions []string `json:"cli_options,omitempty"`
 		} `json:"client_config"`
 	}{
@@ -345,6 +466,9 @@ func NewIPMIReceiver(name string, config json.RawMessage) (Receiver, error) {
 		// Allow overwriting these defaults by reading config JSON
 		Fanout:         64,
 		DriverType:     "LAN_2_0",
+		Timeout:        "10s",
+		CipherSuite:    3,
+		PrivilegeLevel: "administrator",
 		IntervalString: "30s",
 	}
 
@@ -400,6 +524,11 @@ func NewIPMIReceiver(name string, config json.RawMessage) (Receiver, error) {
 		if clientConfigJSON.Fanout != 0 {
 			fanout = clientConfigJSON.Fanout
 		}
+		if fanout < 1 {
+			err := fmt.Errorf("client config number %v has invalid fanout %d", i, fanout)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
 
 		driverType := configJSON.DriverType
 		if clientConfigJSON.DriverType != "" {
@@ -421,6 +550,51 @@ func NewIPMIReceiver(name string, config json.RawMessage) (Receiver, error) {
 			cclog.ComponentError(r.name, err)
 			return nil, err
 		}
+		switch protocol {
+		case "ipmi-sensors", "ipmitool":
+		case "ipmi":
+			if driverType != "LAN_2_0" {
+				err := fmt.Errorf("client config number %v: protocol ipmi requires driver type LAN_2_0", i)
+				cclog.ComponentError(r.name, err)
+				return nil, err
+			}
+		default:
+			err := fmt.Errorf("client config number %v has unknown protocol %s", i, protocol)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
+
+		timeoutString := configJSON.Timeout
+		if clientConfigJSON.Timeout != "" {
+			timeoutString = clientConfigJSON.Timeout
+		}
+		timeout, err := time.ParseDuration(timeoutString)
+		if err != nil || timeout <= 0 {
+			err := fmt.Errorf("client config number %v has invalid timeout %s", i, timeoutString)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
+
+		cipherSuite := configJSON.CipherSuite
+		if clientConfigJSON.CipherSuite != 0 {
+			cipherSuite = clientConfigJSON.CipherSuite
+		}
+		if _, ok := ipmiCipherSuites[cipherSuite]; !ok && protocol == "ipmi" {
+			err := fmt.Errorf("client config number %v has unsupported cipher suite %d", i, cipherSuite)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
+
+		privilegeLevel := configJSON.PrivilegeLevel
+		if clientConfigJSON.PrivilegeLevel != "" {
+			privilegeLevel = clientConfigJSON.PrivilegeLevel
+		}
+		privilegeLevel = strings.ToLower(privilegeLevel)
+		if _, ok := ipmiPrivilegeLevels[privilegeLevel]; !ok {
+			err := fmt.Errorf("client config number %v has invalid privilege level %s", i, privilegeLevel)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
 
 		var username string
 		if clientConfigJSON.Username != nil {
@@ -433,16 +607,22 @@ func NewIPMIReceiver(name string, config json.RawMessage) (Receiver, error) {
 			return nil, err
 		}
 
-		var password string
+		var passwordRef string
 		if clientConfigJSON.Password != nil {
-			password = *clientConfigJSON.Password
+			passwordRef = *clientConfigJSON.Password
 		} else if configJSON.Password != nil {
-			password = *configJSON.Password
+			passwordRef = *configJSON.Password
 		} else {
 			err := fmt.Errorf("client config number %v requires password", i)
 			cclog.ComponentError(r.name, err)
 			return nil, err
 		}
+		password, err := util.NewSecret(passwordRef, configJSON.ReloadSecrets)
+		if err != nil {
+			err := fmt.Errorf("client config number %v: password: %w", i, err)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
 
 		// Create mapping between IPMI host name and node host name
 		// This also guaranties that all IPMI host names are unique
@@ -467,7 +647,21 @@ func NewIPMIReceiver(name string, config json.RawMessage) (Receiver, error) {
 		}
 
 		// Additional command line options
+		if protocol == "ipmi" && len(clientConfigJSON.CLIOptions) > 0 {
+			err := fmt.Errorf("client config number %v: cli_options are not supported by protocol ipmi", i)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
 		for _, v := range clientConfigJSON.CLIOptions {
+			if protocol == "ipmitool" {
+				switch v {
+				case "-I", "-H", "-p", "-U", "-P", "-E", "-f", "-L", "-C":
+					err := fmt.Errorf("client config number %v: Do not use option %s in cli_options, it is set by the json config", i, v)
+					cclog.ComponentError(r.name, err)
+					return nil, err
+				}
+				continue
+			}
 			switch {
 			case v == "-u" || strings.HasPrefix(v, "--username"):
 				err := fmt.Errorf("client config number %v: do not set username in cli_options. Use json config username instead", i)
@@ -526,6 +720,9 @@ func NewIPMIReceiver(name string, config json.RawMessage) (Receiver, error) {
 				Username:         username,
 				Password:         password,
 				CLIOptions:       cliOptions,
+				Timeout:          timeout,
+				CipherSuite:      cipherSuite,
+				PrivilegeLevel:   privilegeLevel,
 				isExcluded:       isExcluded,
 			})
 	}
@@ -536,6 +733,30 @@ func NewIPMIReceiver(name string, config json.RawMessage) (Receiver, error) {
 		return nil, err
 	}
 
+	// Map host names to IPMI devices
+	r.hosts = make(map[string]*ipmiHost)
+	maxFanout := 1
+	for i := range r.config.ClientConfigs {
+		clientConfig := &r.config.ClientConfigs[i]
+		for ipmi, hostname := range clientConfig.IPMI2HostMapping {
+			host := &ipmiHost{clientConfig: clientConfig, ipmiHost: ipmi, hostname: hostname}
+			clientConfig.hosts = append(clientConfig.hosts, host)
+			r.hosts[hostname] = host
+		}
+		maxFanout = max(maxFanout, clientConfig.Fanout)
+	}
+	r.control = newControlQueue(min(maxFanout, len(r.hosts)))
+
+	// Last seen SEL records of a previous run
+	r.config.SEL = configJSON.SEL
+	r.config.SELStateFile = configJSON.SELStateFile
+	if r.config.SEL && r.config.SELStateFile != "" {
+		if err := r.loadSELState(); err != nil {
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
+	}
+
 	cclog.ComponentInfo(r.name, "monitoring", totalNumHosts, "IPMI hosts")
 	return r, nil
 }
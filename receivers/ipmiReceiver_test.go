@@ -0,0 +1,698 @@
+//go:build linux
+
+package receivers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+)
+
+// fakeIPMIDCMI installs an ipmi-dcmi script which logs its arguments and
+// prints the content of the file get for --get-power-limit
+func fakeIPMIDCMI(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	script := `#!/bin/sh
+echo "$@" >> ` + dir + `/args
+case "$*" in
+*--get-power-limit*) cat ` + dir + `/get ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "ipmi-dcmi"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+func TestIPMIReceiverPowerCap(t *testing.T) {
+	dir := fakeIPMIDCMI(t)
+	recv, err := NewIPMIReceiver("test", json.RawMessage(`{
+		"type": "ipmi", "username": "admin", "password": "secret",
+		"endpoint": "ipmi-sensors://%h-bmc", "client_config": [{"host_list": "node[01-02]"}]}`))
+	if err != nil {
+		t.Fatalf("failed to create IPMI receiver: %v", err)
+	}
+	sink := make(chan lp.CCMessage, 10)
+	recv.SetSink(sink)
+	recv.Start()
+	defer recv.Close()
+	r := recv.(ControlReceiver)
+
+	args := func() []string {
+		data, _ := os.ReadFile(filepath.Join(dir, "args"))
+		os.Remove(filepath.Join(dir, "args"))
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+
+	testPowerCap(t, r, sink, "PUT", "300", "ok", "300")
+	calls := args()
+	if len(calls) != 2 ||
+		!strings.Contains(calls[0], "--hostname node01-bmc") ||
+		!strings.HasSuffix(calls[0], "--set-power-limit --power-limit-requested=300") ||
+		!strings.HasSuffix(calls[1], "--activate-deactivate-power-limit=activate") {
+		t.Errorf("invalid ipmi-dcmi calls %q", calls)
+	}
+
+	os.WriteFile(filepath.Join(dir, "get"), []byte("Exception Actions : No Action\nPower Limit Requested : 300 watts\nCorrection time limit : 1000 ms\n"), 0o644)
+	testPowerCap(t, r, sink, "GET", "", "ok", "300")
+
+	testPowerCap(t, r, sink, "PUT", "off", "ok", "off")
+	if calls := args(); len(calls) != 2 || !strings.HasSuffix(calls[1], "--activate-deactivate-power-limit=deactivate") {
+		t.Errorf("invalid ipmi-dcmi calls %q", calls)
+	}
+
+	os.WriteFile(filepath.Join(dir, "get"), []byte("Power Limit Requested : 300 watts\nActive Power Limit : No\n"), 0o644)
+	testPowerCap(t, r, sink, "GET", "", "ok", "off")
+
+	// Failing commands are reported without password
+	os.WriteFile(filepath.Join(dir, "ipmi-dcmi"), []byte("#!/bin/sh\necho 'connection timeout' >&2\nexit 1\n"), 0o755)
+	e := testPowerCap(t, r, sink, "PUT", "200", "failed", "")
+	if v, _ := e.GetEventValue(); !strings.Contains(v, "connection timeout") || strings.Contains(v, "secret") {
+		t.Errorf("invalid error '%s'", v)
+	}
+}
+
+// ipmiSimulator is a BMC with RMCP+ sessions, a sensor data repository, sensor
+// readings and DCMI power management
+type ipmiSimulator struct {
+	conn     *net.UDPConn
+	username string
+	password string
+	suite    ipmiCipherSuite
+	records  [][]byte      // sensor data records, indexed by record ID
+	readings map[byte]byte // raw readings by sensor number
+	sel      [][]byte      // SEL entries
+	erased   uint32        // time of the last erase of the SEL
+
+	lock        sync.Mutex
+	session     *ipmiSession // session with swapped session IDs
+	rm, rc      []byte       // random numbers of the RAKP handshake
+	guid        []byte       // GUID of the BMC
+	user        []byte       // role, user name length and user name
+	sessions    int          // number of established sessions
+	sdrReads    int          // number of read sensor data records
+	cancel      bool         // cancel the next SDR reservation
+	powerLimit  uint16
+	limitActive bool
+}
+
+// ipmiFullSensorRecord creates a full sensor record of a threshold based sensor
+func ipmiFullSensorRecord(id uint16, number, sensorType, unit byte, m, b, rExp int, name string) []byte {
+	r := make([]byte, 48, 48+len(name))
+	binary.LittleEndian.PutUint16(r, id)
+	r[2], r[3], r[4] = 0x51, 0x01, byte(43+len(name))
+	r[5], r[7] = 0x20, number
+	r[12], r[13] = sensorType, 0x01
+	r[21] = unit
+	r[24], r[25] = byte(m), byte(m>>2)&0xc0
+	r[26], r[27] = byte(b), byte(b>>2)&0xc0
+	r[29] = byte(rExp) << 4
+	r[47] = 0xc0 | byte(len(name))
+	return append(r, name...)
+}
+
+// ipmiCompactSensorRecord creates a compact sensor record of a sensor-specific sensor
+func ipmiCompactSensorRecord(id uint16, number, sensorType byte, name string) []byte {
+	r := make([]byte, 32, 32+len(name))
+	binary.LittleEndian.PutUint16(r, id)
+	r[2], r[3], r[4] = 0x51, 0x02, byte(27+len(name))
+	r[5], r[7] = 0x20, number
+	r[12], r[13] = sensorType, 0x6f
+	r[31] = 0xc0 | byte(len(name))
+	return append(r, name...)
+}
+
+// ipmiSELRecord creates a system event record
+func ipmiSELRecord(id uint16, timestamp uint32, sensorType, number, eventType, offset byte) []byte {
+	r := make([]byte, 16)
+	binary.LittleEndian.PutUint16(r, id)
+	r[2] = 0x02
+	binary.LittleEndian.PutUint32(r[3:], timestamp)
+	r[7], r[9] = 0x20, 0x04
+	r[10], r[11], r[12], r[13] = sensorType, number, eventType, offset
+	return r
+}
+
+func newIPMISimulator(t *testing.T, cipherSuite int) *ipmiSimulator {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	discrete := ipmiFullSensorRecord(5, 6, 0x07, 0x00, 0, 0, 0, "CPU1 Status")
+	discrete[13] = 0x6f
+	b := &ipmiSimulator{
+		conn:     conn,
+		username: "admin",
+		password: "secret",
+		suite:    ipmiCipherSuites[cipherSuite],
+		records: [][]byte{
+			ipmiFullSensorRecord(0, 1, 0x01, 0x01, 1, 0, 0, "CPU1 Temp"),
+			ipmiFullSensorRecord(1, 2, 0x02, 0x04, 6, 0, -2, "12V"),
+			ipmiFullSensorRecord(2, 3, 0x04, 0x12, 70, 0, 0, "FAN1"),
+			ipmiFullSensorRecord(3, 4, 0x08, 0x06, 2, 0, 0, "PS1 Input Power"),
+			ipmiFullSensorRecord(4, 5, 0x01, 0x01, 1, -40, 0, "Inlet Temp"),
+			discrete,
+			ipmiCompactSensorRecord(6, 7, 0x0c, "DIMM_A1"),
+		},
+		readings: map[byte]byte{1: 45, 2: 200, 3: 50, 4: 80, 6: 0},
+		guid:     bytes.Repeat([]byte{0x42}, 16),
+	}
+	go b.serve()
+	return b
+}
+
+func (b *ipmiSimulator) serve() {
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := b.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		b.lock.Lock()
+		resp := b.handle(buf[:n])
+		b.lock.Unlock()
+		if resp != nil {
+			b.conn.WriteToUDP(resp, addr)
+		}
+	}
+}
+
+// handle returns the response packet of a request packet
+func (b *ipmiSimulator) handle(p []byte) []byte {
+	if len(p) < 16 {
+		return nil
+	}
+	payload := p[16:]
+	hash := b.suite.hash
+	switch p[5] & 0x3f {
+	case ipmiPayloadOpenSessionRequest:
+		b.session = &ipmiSession{
+			suite:     b.suite,
+			consoleID: rand.Uint32() | 1,
+			bmcID:     binary.LittleEndian.Uint32(payload[4:8]),
+		}
+		resp := []byte{payload[0], 0x00, 0x04, 0x00}
+		resp = append(resp, payload[4:8]...)
+		resp = binary.LittleEndian.AppendUint32(resp, b.session.consoleID)
+		resp = append(resp, payload[8:32]...)
+		packet, _ := b.session.packet(ipmiPayloadOpenSessionResponse, resp)
+		return packet
+	case ipmiPayloadRAKP1:
+		s := b.session
+		b.rm = append([]byte{}, payload[8:24]...)
+		b.rc = bytes.Repeat([]byte{0x17}, 16)
+		b.user = append([]byte{payload[24], payload[27]}, payload[28:28+payload[27]]...)
+		resp := []byte{payload[0], 0x00, 0x00, 0x00}
+		if string(b.user[2:]) != b.username {
+			resp[1] = 0x0d
+		}
+		resp = binary.LittleEndian.AppendUint32(resp, s.bmcID)
+		resp = append(resp, b.rc...)
+		resp = append(resp, b.guid...)
+		resp = append(resp, ipmiHMAC(hash, []byte(b.password),
+			binary.LittleEndian.AppendUint32(nil, s.bmcID),
+			binary.LittleEndian.AppendUint32(nil, s.consoleID),
+			b.rm, b.rc, b.guid, b.user)...)
+		packet, _ := s.packet(ipmiPayloadRAKP2, resp)
+		return packet
+	case ipmiPayloadRAKP3:
+		s := b.session
+		resp := []byte{payload[0], 0x00, 0x00, 0x00}
+		resp = binary.LittleEndian.AppendUint32(resp, s.bmcID)
+		if !hmac.Equal(payload[8:], ipmiHMAC(hash, []byte(b.password),
+			b.rc, binary.LittleEndian.AppendUint32(nil, s.bmcID), b.user)) {
+			resp[1] = 0x0f
+			packet, _ := s.packet(ipmiPayloadRAKP4, resp)
+			return packet
+		}
+		sik := ipmiHMAC(hash, []byte(b.password), b.rm, b.rc, b.user)
+		// Const1 and Const2 of the specification have 20 bytes for all algorithms
+		s.k1 = ipmiHMAC(hash, sik, bytes.Repeat([]byte{0x01}, 20))
+		s.k2 = ipmiHMAC(hash, sik, bytes.Repeat([]byte{0x02}, 20))
+		resp = append(resp, ipmiHMAC(hash, sik, b.rm,
+			binary.LittleEndian.AppendUint32(nil, s.consoleID), b.guid)[:b.suite.icvLen]...)
+		packet, _ := s.packet(ipmiPayloadRAKP4, resp)
+		s.active = true
+		b.sessions++
+		return packet
+	case ipmiPayloadIPMI:
+		// Packets of unknown sessions are discarded
+		s := b.session
+		if s == nil || !s.active {
+			return nil
+		}
+		_, msg, err := s.parse(p)
+		if err != nil || len(msg) < 7 {
+			return nil
+		}
+		netFn, cmd := msg[1]>>2, msg[5]
+		cc, data := b.command(netFn, cmd, msg[6:len(msg)-1])
+		resp := []byte{0x81, (netFn|1)<<2 | msg[1]&0x03}
+		resp = append(resp, ipmiChecksum(resp))
+		resp = append(resp, 0x20, msg[4], cmd, cc)
+		resp = append(resp, data...)
+		resp = append(resp, ipmiChecksum(resp[3:]))
+		packet, _ := s.packet(ipmiPayloadIPMI, resp)
+		if netFn == ipmiNetFnApp && cmd == 0x3c {
+			b.session = nil
+		}
+		return packet
+	}
+	return nil
+}
+
+// command returns the completion code and response data of an IPMI request
+func (b *ipmiSimulator) command(netFn, cmd byte, data []byte) (byte, []byte) {
+	dcmiLimit := func() []byte {
+		resp := []byte{0xdc, 0x00, 0x00, 0x01}
+		resp = binary.LittleEndian.AppendUint16(resp, b.powerLimit)
+		resp = binary.LittleEndian.AppendUint32(resp, 2000)
+		return append(resp, 0x00, 0x00, 0x05, 0x00)
+	}
+	switch {
+	case netFn == ipmiNetFnApp && cmd == 0x3b:
+		return 0x00, data[:1]
+	case netFn == ipmiNetFnApp && cmd == 0x3c:
+		return 0x00, nil
+	case netFn == ipmiNetFnStorage && cmd == 0x22:
+		return 0x00, []byte{0x01, 0x00}
+	case netFn == ipmiNetFnStorage && cmd == 0x23:
+		id, offset, n := int(binary.LittleEndian.Uint16(data[2:4])), int(data[4]), int(data[5])
+		if id >= len(b.records) || offset+n > len(b.records[id]) {
+			return 0xca, nil
+		}
+		if offset > 0 && b.cancel {
+			b.cancel = false
+			return ipmiReservationCancelled, nil
+		}
+		if offset == 0 {
+			b.sdrReads++
+		}
+		next := uint16(id + 1)
+		if id == len(b.records)-1 {
+			next = 0xffff
+		}
+		resp := binary.LittleEndian.AppendUint16(nil, next)
+		return 0x00, append(resp, b.records[id][offset:offset+n]...)
+	case netFn == ipmiNetFnStorage && cmd == 0x40:
+		resp := []byte{0x51}
+		resp = binary.LittleEndian.AppendUint16(resp, uint16(len(b.sel)))
+		resp = append(resp, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00)
+		resp = binary.LittleEndian.AppendUint32(resp, b.erased)
+		return 0x00, append(resp, 0x02)
+	case netFn == ipmiNetFnStorage && cmd == 0x43:
+		id := binary.LittleEndian.Uint16(data[2:4])
+		for i, record := range b.sel {
+			if id == 0xffff && i < len(b.sel)-1 || id != 0xffff && id != 0 && binary.LittleEndian.Uint16(record) != id {
+				continue
+			}
+			next := uint16(0xffff)
+			if i < len(b.sel)-1 {
+				next = binary.LittleEndian.Uint16(b.sel[i+1])
+			}
+			return 0x00, append(binary.LittleEndian.AppendUint16(nil, next), record...)
+		}
+		return 0xcb, nil
+	case netFn == ipmiNetFnSensorEvent && cmd == 0x2d:
+		raw, ok := b.readings[data[0]]
+		if !ok {
+			return 0xcb, nil
+		}
+		return 0x00, []byte{raw, 0x40, 0x00, 0x00}
+	case netFn == ipmiNetFnGroupExtension && cmd == 0x02:
+		resp := []byte{0xdc}
+		for range 4 {
+			resp = binary.LittleEndian.AppendUint16(resp, 250)
+		}
+		return 0x00, append(resp, 0, 0, 0, 0, 0xe8, 0x03, 0x00, 0x00, 0x40)
+	case netFn == ipmiNetFnGroupExtension && cmd == 0x03:
+		if !b.limitActive {
+			return ipmiNoActivePowerLimit, dcmiLimit()
+		}
+		return 0x00, dcmiLimit()
+	case netFn == ipmiNetFnGroupExtension && cmd == 0x04:
+		if data[4] != 0x01 || binary.LittleEndian.Uint32(data[7:11]) != 2000 {
+			return 0xcc, nil
+		}
+		b.powerLimit = binary.LittleEndian.Uint16(data[5:7])
+		return 0x00, []byte{0xdc}
+	case netFn == ipmiNetFnGroupExtension && cmd == 0x05:
+		b.limitActive = data[1] == 0x01
+		return 0x00, []byte{0xdc}
+	}
+	return 0xc1, nil
+}
+
+// receiveIPMI returns the values of the received metrics by metric and
+// sensor name and the received events
+func receiveIPMI(t *testing.T, sink chan lp.CCMessage) (map[string]float64, []lp.CCMessage) {
+	t.Helper()
+	values := make(map[string]float64)
+	var events []lp.CCMessage
+	for {
+		select {
+		case m := <-sink:
+			if host, _ := m.GetTag("hostname"); host != "node01" {
+				t.Errorf("invalid hostname %s", host)
+			}
+			if m.IsEvent() {
+				events = append(events, m)
+				continue
+			}
+			name, _ := m.GetTag("name")
+			v, _ := m.GetField("value")
+			values[m.Name()+"/"+name], _ = v.(float64)
+		default:
+			return values, events
+		}
+	}
+}
+
+func TestIPMISessionKeys(t *testing.T) {
+	// Known answers with Const1 and Const2 of 20 bytes for all algorithms
+	tests := []struct {
+		cipherSuite int
+		sik, k1, k2 string
+		icv         string // integrity check value of icvData
+	}{
+		{3, "e477992a67f69aa9770bac9ed19ba88a7e8dfcba",
+			"ebbcd16601da82b5bc9ccde65d43477508a72e8e",
+			"d025c91665b92a8e7b9677d0bf831ccf7511d99b",
+			"7c13d8ac0c2702ec7880b259"},
+		{17, "0d3e065da757aef97301cc6020a4975cf351b4d472cb587dc9b569d9cfa4f684",
+			"72013dd4bb756ddb72dc309b8a97161f19c125c5524b68b2994f0fe027f47565",
+			"6fb5338af06df773f554ca6015a40b6d719a48d6dd419f5bb86bba756abf3dc6",
+			"0ccbc5c99eb636a05af4e7e497bb27e2"},
+	}
+	icvData, _ := hex.DecodeString("0686000000000000000000000000")
+	for _, tt := range tests {
+		suite := ipmiCipherSuites[tt.cipherSuite]
+		sik, _ := hex.DecodeString(tt.sik)
+		k1, k2 := ipmiSessionKeys(suite.hash, sik)
+		if hex.EncodeToString(k1) != tt.k1 || hex.EncodeToString(k2) != tt.k2 {
+			t.Errorf("cipher suite %d: invalid keys %x %x", tt.cipherSuite, k1, k2)
+		}
+		if icv := ipmiHMAC(suite.hash, k1, icvData)[:suite.icvLen]; hex.EncodeToString(icv) != tt.icv {
+			t.Errorf("cipher suite %d: invalid integrity check value %x", tt.cipherSuite, icv)
+		}
+	}
+}
+
+func TestIPMISession(t *testing.T) {
+	for _, cipherSuite := range []int{3, 17} {
+		bmc := newIPMISimulator(t, cipherSuite)
+		address := bmc.conn.LocalAddr().String()
+		deadline := time.Now().Add(5 * time.Second)
+
+		if _, err := newIPMISession(address, "admin", "wrong", cipherSuite, 4, deadline); err == nil ||
+			!strings.Contains(err.Error(), "invalid password") {
+			t.Errorf("cipher suite %d: expected invalid password, got %v", cipherSuite, err)
+		}
+		if _, err := newIPMISession(address, "nobody", "secret", cipherSuite, 4, deadline); err == nil {
+			t.Errorf("cipher suite %d: expected unknown user to fail", cipherSuite)
+		}
+
+		s, err := newIPMISession(address, "admin", "secret", cipherSuite, 4, deadline)
+		if err != nil {
+			t.Fatalf("cipher suite %d: %v", cipherSuite, err)
+		}
+		bmc.lock.Lock()
+		bmc.cancel = true
+		bmc.lock.Unlock()
+		sensors, _, err := s.readSDR()
+		if err != nil {
+			t.Fatalf("cipher suite %d: %v", cipherSuite, err)
+		}
+		if len(sensors) != 5 {
+			t.Errorf("cipher suite %d: expected 5 sensors, got %d", cipherSuite, len(sensors))
+		}
+		if v, ok, err := s.readSensor(sensors[1]); err != nil || !ok || v != 12 {
+			t.Errorf("cipher suite %d: invalid reading of %s: %v %v %v", cipherSuite, sensors[1].name, v, ok, err)
+		}
+		s.close()
+	}
+}
+
+func TestIPMIReceiverNative(t *testing.T) {
+	bmc := newIPMISimulator(t, 3)
+	recv, err := NewIPMIReceiver("test", json.RawMessage(`{
+		"type": "ipmi", "username": "admin", "password": "secret", "timeout": "5s",
+		"endpoint": "ipmi://`+bmc.conn.LocalAddr().String()+`", "client_config": [{"host_list": "node01"}]}`))
+	if err != nil {
+		t.Fatalf("failed to create IPMI receiver: %v", err)
+	}
+	sink := make(chan lp.CCMessage, 100)
+	recv.SetSink(sink)
+	defer recv.Close()
+	r := recv.(*IPMIReceiver)
+
+	expected := map[string]float64{
+		"temperature/cpu1": 45,
+		"voltage/12v":      12,
+		"fan_speed/fan1":   3500,
+		"power/ps1_input":  160,
+		"power/dcmi":       250,
+	}
+	for i := range 3 {
+		// The BMC forgets the session, which is reopened
+		if i == 2 {
+			bmc.lock.Lock()
+			bmc.session = nil
+			bmc.lock.Unlock()
+		}
+		r.doReadMetric()
+		if values, _ := receiveIPMI(t, sink); !maps.Equal(values, expected) {
+			t.Errorf("read %d: expected %v, got %v", i, expected, values)
+		}
+	}
+	bmc.lock.Lock()
+	if bmc.sessions != 2 || bmc.sdrReads != 2*len(bmc.records) {
+		t.Errorf("expected 2 sessions and 2 SDR reads, got %d sessions and %d record reads", bmc.sessions, bmc.sdrReads)
+	}
+	bmc.lock.Unlock()
+
+	// Only the control workers of Start, the metrics are read above
+	r.control.start(r.applyControl)
+	testPowerCap(t, r, sink, "GET", "", "ok", "off")
+	testPowerCap(t, r, sink, "PUT", "300", "ok", "300")
+	bmc.lock.Lock()
+	if bmc.powerLimit != 300 || !bmc.limitActive {
+		t.Errorf("power limit not set")
+	}
+	bmc.lock.Unlock()
+	testPowerCap(t, r, sink, "GET", "", "ok", "300")
+	testPowerCap(t, r, sink, "PUT", "off", "ok", "off")
+	bmc.lock.Lock()
+	if bmc.limitActive {
+		t.Errorf("power limit not deactivated")
+	}
+	bmc.lock.Unlock()
+	testPowerCap(t, r, sink, "GET", "", "ok", "off")
+}
+
+// Output of ipmitool sdr list full
+const ipmitoolSDRFixture = `CPU1 Temp        | 45 degrees C      | ok
+CPU2 Temp        | no reading        | ns
+12V              | 12.19 Volts       | ok
+FAN1             | 3600 RPM          | ok
+FAN2             | disabled          | ns
+PS1 Input Power  | 160 Watts         | ok
+PS1 Curr Out %   | 14 percent        | ok
+CPU_Utilization  | 42 percent        | ok
+Current 1        | 0.60 Amps         | ok
+System Air Flow  | 34 CFM            | ok
+`
+
+func TestParseIpmitoolSDR(t *testing.T) {
+	readings := parseIpmitoolSDR(strings.NewReader(ipmitoolSDRFixture))
+	if len(readings) != 8 {
+		t.Fatalf("expected 8 readings, got %d", len(readings))
+	}
+	if r := readings[1]; r.name != "12V" || r.sensorType != "Voltage" || r.unit != "Volts" || r.value != 12.19 {
+		t.Errorf("invalid reading %+v", r)
+	}
+	if r := readings[4]; r.name != "PS1 Curr Out %" || r.unit != "%" || r.value != 14 {
+		t.Errorf("invalid reading %+v", r)
+	}
+}
+
+func TestIPMIReceiverIpmitool(t *testing.T) {
+	dir := t.TempDir()
+	script := `#!/bin/sh
+echo "$@ $IPMI_PASSWORD" >> ` + dir + `/args
+cat <<'EOT'
+` + ipmitoolSDRFixture + `EOT
+`
+	if err := os.WriteFile(filepath.Join(dir, "ipmitool"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	recv, err := NewIPMIReceiver("test", json.RawMessage(`{
+		"type": "ipmi", "username": "admin", "password": "secret", "exclude_metrics": ["current"],
+		"endpoint": "ipmitool://%h-bmc", "client_config": [{"host_list": "node01"}]}`))
+	if err != nil {
+		t.Fatalf("failed to create IPMI receiver: %v", err)
+	}
+	sink := make(chan lp.CCMessage, 100)
+	recv.SetSink(sink)
+	defer recv.Close()
+	recv.(*IPMIReceiver).doReadMetric()
+
+	expected := map[string]float64{
+		"temperature/cpu1": 45,
+		"voltage/12v":      12.19,
+		"fan_speed/fan1":   3600,
+		"power/ps1_input":  160,
+		"utilization/cpu":  42,
+		"air_flow/system":  34,
+	}
+	if values, _ := receiveIPMI(t, sink); !maps.Equal(values, expected) {
+		t.Errorf("expected %v, got %v", expected, values)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if string(args) != "-I lanplus -C 3 -H node01-bmc -U admin -E -L ADMINISTRATOR sdr list full secret\n" {
+		t.Errorf("invalid ipmitool call '%s'", args)
+	}
+
+	// A fanout below 1 would start no workers
+	for _, config := range []string{
+		`{"type": "ipmi", "username": "admin", "password": "secret", "fanout": 0, "endpoint": "ipmitool://%h-bmc", "client_config": [{"host_list": "node01"}]}`,
+		`{"type": "ipmi", "username": "admin", "password": "secret", "endpoint": "ipmitool://%h-bmc", "client_config": [{"host_list": "node01", "fanout": -1}]}`,
+	} {
+		if _, err := NewIPMIReceiver("test", json.RawMessage(config)); err == nil {
+			t.Errorf("expected error for config %s", config)
+		}
+	}
+}
+
+func TestIPMIReceiverSEL(t *testing.T) {
+	bmc := newIPMISimulator(t, 3)
+	addSEL := func(records ...[]byte) {
+		bmc.lock.Lock()
+		bmc.sel = append(bmc.sel, records...)
+		bmc.lock.Unlock()
+	}
+	addSEL(ipmiSELRecord(1, 1760000000, 0x0c, 7, 0x6f, 0x00))
+
+	stateFile := filepath.Join(t.TempDir(), "sel.json")
+	sink := make(chan lp.CCMessage, 100)
+	newReceiver := func() *IPMIReceiver {
+		recv, err := NewIPMIReceiver("test", json.RawMessage(`{
+			"type": "ipmi", "username": "admin", "password": "secret", "sel": true, "sel_state_file": "`+stateFile+`",
+			"endpoint": "ipmi://`+bmc.conn.LocalAddr().String()+`", "client_config": [{"host_list": "node01"}]}`))
+		if err != nil {
+			t.Fatalf("failed to create IPMI receiver: %v", err)
+		}
+		recv.SetSink(sink)
+		return recv.(*IPMIReceiver)
+	}
+	readEvents := func(r *IPMIReceiver) []lp.CCMessage {
+		r.doReadMetric()
+		_, events := receiveIPMI(t, sink)
+		return events
+	}
+
+	// Existing entries are skipped on first contact
+	r := newReceiver()
+	if events := readEvents(r); len(events) != 0 {
+		t.Errorf("expected no events on first contact, got %v", events)
+	}
+	if data, _ := os.ReadFile(stateFile); !strings.Contains(string(data), `"record_id": 1`) {
+		t.Errorf("invalid SEL state file '%s'", data)
+	}
+
+	addSEL(
+		ipmiSELRecord(2, 1760000000, 0x0c, 7, 0x6f, 0x01),
+		ipmiSELRecord(3, 1760000060, 0x01, 1, 0x81, 0x09),
+	)
+	events := readEvents(r)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %v", events)
+	}
+	for i, e := range []map[string]string{
+		{"value": "Uncorrectable ECC", "severity": "Critical", "sensor_type": "Memory", "name": "DIMM_A1"},
+		{"value": "Upper Critical going high deasserted", "severity": "OK", "sensor_type": "Temperature", "name": "CPU1 Temp"},
+	} {
+		m := events[i]
+		if v, _ := m.GetEventValue(); m.Name() != IPMI_SEL_EVENT_NAME || v != e["value"] {
+			t.Errorf("event %d: expected '%s', got %s '%s'", i, e["value"], m.Name(), v)
+		}
+		for _, tag := range []string{"severity", "sensor_type", "name"} {
+			if v, _ := m.GetTag(tag); v != e[tag] {
+				t.Errorf("event %d: expected %s '%s', got '%s'", i, tag, e[tag], v)
+			}
+		}
+	}
+	if !events[0].Time().Equal(time.Unix(1760000000, 0)) {
+		t.Errorf("invalid event time %v", events[0].Time())
+	}
+	r.Close()
+
+	// Entries are not sent again after a restart
+	r = newReceiver()
+	defer r.Close()
+	addSEL(ipmiSELRecord(4, 1760000120, 0x0c, 7, 0x6f, 0x00))
+	if events := readEvents(r); len(events) != 1 {
+		t.Errorf("expected 1 event after restart, got %v", events)
+	} else if id, _ := events[0].GetMeta("record_id"); id != "4" {
+		t.Errorf("expected record 4, got %s", id)
+	}
+
+	// All entries of an erased SEL are new
+	bmc.lock.Lock()
+	bmc.sel = [][]byte{ipmiSELRecord(1, 1760000180, 0x08, 8, 0x6f, 0x01)}
+	bmc.erased = 1760000150
+	bmc.lock.Unlock()
+	if events := readEvents(r); len(events) != 1 {
+		t.Errorf("expected 1 event after erase, got %v", events)
+	} else if v, _ := events[0].GetEventValue(); v != "Failure detected" {
+		t.Errorf("invalid event '%s'", v)
+	}
+}
+
+func TestParseIPMISEL(t *testing.T) {
+	entries := parseIpmitoolSEL(strings.NewReader(`   1 | 10/18/2026 | 11:58:00 | Event Logging Disabled #0x07 | Log area reset/cleared | Asserted
+   1a | 10/18/2026 | 12:00:00 CEST | Memory CPU1_DIMM_A1 | Correctable ECC | Asserted
+   1b | 10/18/2026 | 12:01:00 | Temperature CPU1 Temp | Upper Critical going high | Deasserted | Reading 80 < Threshold 90 degrees C
+SEL has no entries
+`))
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	if e := entries[1]; e.id != 0x1a || e.sensorType != "Memory" || e.name != "CPU1_DIMM_A1" ||
+		e.severity != "Warning" || e.deasserted || e.time.Hour() != 12 {
+		t.Errorf("invalid entry %+v", e)
+	}
+	if e := entries[2]; e.sensorType != "Temperature" || e.severity != "Critical" || !e.deasserted {
+		t.Errorf("invalid entry %+v", e)
+	}
+
+	hosts := parseIPMISel(strings.NewReader(`node01-bmc: 26,Oct-18-2026,12:00:00,CPU1_DIMM_A1,Memory,Warning,Correctable ECC
+node01-bmc: 27,Oct-18-2026,12:01:00,PS1 Status,Power Supply,Critical,Power Supply Failure detected, AC lost
+node02-bmc: connection timeout
+`))
+	if len(hosts) != 1 || len(hosts["node01-bmc"]) != 2 {
+		t.Fatalf("invalid entries %v", hosts)
+	}
+	if e := hosts["node01-bmc"][1]; e.id != 27 || e.severity != "Critical" || e.description != "Power Supply Failure detected, AC lost" {
+		t.Errorf("invalid entry %+v", e)
+	}
+}
@@ -0,0 +1,552 @@
+//go:build linux
+
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+)
+
+// Name of the events of System Event Log entries
+const IPMI_SEL_EVENT_NAME = "ipmi_sel"
+
+// ipmiEvent is the description and severity of an event offset
+type ipmiEvent struct {
+	description string
+	severity    string
+}
+
+// Threshold events (event/reading type 0x01) by offset
+// See: IPMI v2.0 specification, table 42-2
+var ipmiThresholdEvents = []ipmiEvent{
+	{"Lower Non-critical going low", "Warning"},
+	{"Lower Non-critical going high", "Warning"},
+	{"Lower Critical going low", "Critical"},
+	{"Lower Critical going high", "Critical"},
+	{"Lower Non-recoverable going low", "Critical"},
+	{"Lower Non-recoverable going high", "Critical"},
+	{"Upper Non-critical going low", "Warning"},
+	{"Upper Non-critical going high", "Warning"},
+	{"Upper Critical going low", "Critical"},
+	{"Upper Critical going high", "Critical"},
+	{"Upper Non-recoverable going low", "Critical"},
+	{"Upper Non-recoverable going high", "Critical"},
+}
+
+// Sensor-specific events (event/reading type 0x6f) by sensor type and offset
+// See: IPMI v2.0 specification, table 42-3
+var ipmiSensorSpecificEvents = map[byte][]ipmiEvent{
+	// Physical Security
+	0x05: {
+		{"General Chassis intrusion", "Critical"},
+		{"Drive Bay intrusion", "Critical"},
+		{"I/O Card area intrusion", "Critical"},
+		{"Processor area intrusion", "Critical"},
+		{"System unplugged from LAN", "Warning"},
+		{"Unauthorized dock", "Warning"},
+		{"FAN area intrusion", "Critical"},
+	},
+	// Processor
+	0x07: {
+		{"IERR", "Critical"},
+		{"Thermal Trip", "Critical"},
+		{"FRB1/BIST failure", "Critical"},
+		{"FRB2/Hang in POST failure", "Critical"},
+		{"FRB3/Processor Startup/Initialization failure", "Critical"},
+		{"Configuration Error", "Critical"},
+		{"SM BIOS Uncorrectable CPU-complex Error", "Critical"},
+		{"Presence detected", "OK"},
+		{"Disabled", "Warning"},
+		{"Terminator presence detected", "OK"},
+		{"Throttled", "Warning"},
+		{"Uncorrectable machine check exception", "Critical"},
+		{"Correctable machine check error", "Warning"},
+	},
+	// Power Supply
+	0x08: {
+		{"Presence detected", "OK"},
+		{"Failure detected", "Critical"},
+		{"Predictive failure", "Warning"},
+		{"Power Supply AC lost", "Critical"},
+		{"AC lost or out-of-range", "Critical"},
+		{"AC out-of-range, but present", "Warning"},
+		{"Config Error", "Critical"},
+	},
+	// Power Unit
+	0x09: {
+		{"Power off/down", "OK"},
+		{"Power cycle", "OK"},
+		{"240VA power down", "Warning"},
+		{"Interlock power down", "Warning"},
+		{"AC lost", "Critical"},
+		{"Soft-power control failure", "Critical"},
+		{"Failure detected", "Critical"},
+		{"Predictive failure", "Warning"},
+	},
+	// Memory
+	0x0c: {
+		{"Correctable ECC", "Warning"},
+		{"Uncorrectable ECC", "Critical"},
+		{"Parity", "Critical"},
+		{"Memory Scrub Error", "Critical"},
+		{"Memory Device Disabled", "Warning"},
+		{"Correctable ECC logging limit reached", "Warning"},
+		{"Presence Detected", "OK"},
+		{"Configuration Error", "Critical"},
+		{"Spare", "Warning"},
+		{"Throttled", "Warning"},
+		{"Critical Overtemperature", "Critical"},
+	},
+	// Event Logging Disabled
+	0x10: {
+		{"Correctable memory error logging disabled", "Warning"},
+		{"Event logging disabled", "Warning"},
+		{"Log area reset/cleared", "OK"},
+		{"All event logging disabled", "Warning"},
+		{"Log full", "Warning"},
+		{"Log almost full", "Warning"},
+	},
+	// Critical Interrupt
+	0x13: {
+		{"Front Panel NMI/Diagnostic Interrupt", "Critical"},
+		{"Bus Timeout", "Critical"},
+		{"I/O Channel check NMI", "Critical"},
+		{"Software NMI", "Critical"},
+		{"PCI PERR", "Critical"},
+		{"PCI SERR", "Critical"},
+		{"EISA failsafe timeout", "Critical"},
+		{"Bus Correctable error", "Warning"},
+		{"Bus Uncorrectable error", "Critical"},
+		{"Fatal NMI", "Critical"},
+		{"Bus Fatal Error", "Critical"},
+		{"Bus Degraded", "Warning"},
+	},
+}
+
+// Severities of the event states of ipmi-sel
+var ipmiSELStates = map[string]string{
+	"Nominal":  "OK",
+	"Warning":  "Warning",
+	"Critical": "Critical",
+}
+
+// ipmiSELState is the persisted SEL state of a host
+type ipmiSELState struct {
+	RecordID  uint16 `json:"record_id"`            // last seen record ID
+	EraseTime uint32 `json:"erase_time,omitempty"` // time of the last erase of the SEL
+}
+
+// ipmiSELEntry is an entry of the System Event Log
+type ipmiSELEntry struct {
+	id          uint16
+	time        time.Time
+	sensorType  string
+	name        string
+	description string
+	severity    string
+	deasserted  bool
+}
+
+// ipmiEventSeverity returns the severity of an event by sensor type name and
+// description. Unknown events have severity OK.
+func ipmiEventSeverity(sensorType, description string) string {
+	events := ipmiThresholdEvents
+	for code, name := range ipmiSensorTypes {
+		if name == sensorType && ipmiSensorSpecificEvents[code] != nil {
+			events = slices.Concat(ipmiSensorSpecificEvents[code], events)
+		}
+	}
+	for _, e := range events {
+		if strings.EqualFold(e.description, description) {
+			return e.severity
+		}
+	}
+	return "OK"
+}
+
+// parseIPMISELRecord parses a system event record. It returns false for OEM
+// records.
+// See: IPMI v2.0 specification, section 32.1
+func parseIPMISELRecord(record []byte, names map[byte]string) (ipmiSELEntry, bool) {
+	if len(record) < 16 || record[2] != 0x02 {
+		return ipmiSELEntry{}, false
+	}
+	sensorType, number := record[10], record[11]
+	eventType, offset := record[12]&0x7f, int(record[13]&0x0f)
+	e := ipmiSELEntry{
+		id:         binary.LittleEndian.Uint16(record),
+		time:       time.Now(),
+		sensorType: ipmiSensorTypes[sensorType],
+		name:       names[number],
+		deasserted: record[12]&0x80 != 0,
+	}
+	// Time stamps up to 0x20000000 are relative to the BMC initialization
+	if t := binary.LittleEndian.Uint32(record[3:7]); t > 0x20000000 {
+		e.time = time.Unix(int64(t), 0)
+	}
+	if e.sensorType == "" {
+		e.sensorType = fmt.Sprintf("Sensor type 0x%02x", sensorType)
+	}
+	if e.name == "" {
+		e.name = fmt.Sprintf("#0x%02x", number)
+	}
+
+	var events []ipmiEvent
+	switch eventType {
+	case 0x01:
+		events = ipmiThresholdEvents
+	case 0x6f:
+		events = ipmiSensorSpecificEvents[sensorType]
+	}
+	if offset < len(events) {
+		e.description, e.severity = events[offset].description, events[offset].severity
+	} else {
+		e.description = fmt.Sprintf("Event type 0x%02x offset 0x%02x", eventType, offset)
+		e.severity = "OK"
+	}
+	return e, true
+}
+
+// parseIpmitoolSEL parses the output of ipmitool sel elist, e.g.
+// "  1a | 10/18/2026 | 12:00:00 | Memory CPU1_DIMM_A1 | Correctable ECC | Asserted"
+func parseIpmitoolSEL(r io.Reader) []ipmiSELEntry {
+	// Longest sensor type names first to match e.g. "Power Supply" before "Power"
+	sensorTypes := make([]string, 0, len(ipmiSensorTypes))
+	for _, name := range ipmiSensorTypes {
+		sensorTypes = append(sensorTypes, name)
+	}
+	slices.SortFunc(sensorTypes, func(a, b string) int { return len(b) - len(a) })
+
+	var entries []ipmiSELEntry
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "|")
+		if len(fields) < 6 {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		id, err := strconv.ParseUint(fields[0], 16, 16)
+		if err != nil {
+			continue
+		}
+		e := ipmiSELEntry{
+			id:          uint16(id),
+			time:        time.Now(),
+			name:        fields[3],
+			description: fields[4],
+			deasserted:  strings.EqualFold(fields[5], "Deasserted"),
+		}
+		// The time may be followed by the time zone
+		clock, _, _ := strings.Cut(fields[2], " ")
+		if t, err := time.ParseInLocation("01/02/2006 15:04:05", fields[1]+" "+clock, time.Local); err == nil {
+			e.time = t
+		}
+		for _, sensorType := range sensorTypes {
+			if name, ok := strings.CutPrefix(fields[3], sensorType); ok {
+				e.sensorType = sensorType
+				e.name = strings.TrimSpace(name)
+				break
+			}
+		}
+		e.severity = ipmiEventSeverity(e.sensorType, e.description)
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// parseIPMISel parses the comma separated output of ipmi-sel with event
+// states and returns the entries by IPMI device, e.g.
+// "node01-bmc: 26,Oct-18-2026,12:00:00,CPU1_DIMM_A1,Memory,Warning,Correctable ECC"
+func parseIPMISel(r io.Reader) map[string][]ipmiSELEntry {
+	const (
+		idxID = iota
+		idxDate
+		idxTime
+		idxName
+		idxType
+		idxState
+		idxEvent
+	)
+	entries := make(map[string][]ipmiSELEntry)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		ipmiHost, line, ok := strings.Cut(scanner.Text(), ": ")
+		if !ok {
+			continue
+		}
+		// The event may contain commas
+		v := strings.SplitN(line, ",", 7)
+		if len(v) != 7 {
+			continue
+		}
+		id, err := strconv.ParseUint(v[idxID], 10, 16)
+		if err != nil {
+			continue
+		}
+		e := ipmiSELEntry{
+			id:          uint16(id),
+			time:        time.Now(),
+			sensorType:  v[idxType],
+			name:        v[idxName],
+			description: v[idxEvent],
+			severity:    ipmiSELStates[v[idxState]],
+		}
+		if t, err := time.ParseInLocation("Jan-02-2006 15:04:05", v[idxDate]+" "+v[idxTime], time.Local); err == nil {
+			e.time = t
+		}
+		if e.severity == "" {
+			e.severity = "OK"
+		}
+		entries[ipmiHost] = append(entries[ipmiHost], e)
+	}
+	return entries
+}
+
+// sendSELEntry sends an entry of the System Event Log of a host as event to
+// the sink. Deasserted events have severity OK.
+func (r *IPMIReceiver) sendSELEntry(host string, e ipmiSELEntry) {
+	severity := e.severity
+	message := e.description
+	if e.deasserted {
+		severity = "OK"
+		message += " deasserted"
+	}
+	tags := map[string]string{
+		"hostname":    host,
+		"type":        "node",
+		"severity":    severity,
+		"sensor_type": e.sensorType,
+		"name":        e.name,
+	}
+	meta := map[string]string{
+		"source":    r.name,
+		"group":     "Events",
+		"record_id": strconv.Itoa(int(e.id)),
+	}
+	deleteEmptyTags(tags)
+	y, err := lp.NewEvent(IPMI_SEL_EVENT_NAME, tags, meta, message, e.time)
+	if err == nil {
+		r.sink <- y
+	}
+}
+
+// updateSEL sends the new entries of the System Event Log of a host and
+// updates the last seen record ID. The entries are either all entries of the
+// SEL or the entries following the last seen record. Entries without
+// description only update the last seen record ID. On first contact with a
+// host, the existing entries are skipped. If the SEL was erased, all entries
+// are new.
+func (r *IPMIReceiver) updateSEL(host *ipmiHost, entries []ipmiSELEntry, eraseTime uint32) {
+	maxID := uint16(0)
+	for _, e := range entries {
+		maxID = max(maxID, e.id)
+	}
+	state := host.sel
+	if state != nil {
+		erased := eraseTime != state.EraseTime || maxID < state.RecordID
+		for _, e := range entries {
+			if e.description != "" && (erased || e.id > state.RecordID) {
+				r.sendSELEntry(host.hostname, e)
+			}
+		}
+	}
+	if state == nil || state.RecordID != maxID || state.EraseTime != eraseTime {
+		host.sel = &ipmiSELState{RecordID: maxID, EraseTime: eraseTime}
+		r.selChanged.Store(true)
+	}
+}
+
+// getSELEntry returns a SEL entry and the ID of the next entry. The record ID
+// 0xffff returns the last entry.
+func (s *ipmiSession) getSELEntry(recordID uint16) ([]byte, uint16, error) {
+	req := []byte{0x00, 0x00}
+	req = binary.LittleEndian.AppendUint16(req, recordID)
+	req = append(req, 0x00, 0xff)
+	resp, err := s.request(ipmiNetFnStorage, 0, 0x43, req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(resp) < 18 {
+		return nil, 0, errors.New("invalid get SEL entry response")
+	}
+	return resp[2:18], binary.LittleEndian.Uint16(resp), nil
+}
+
+// readNativeSEL reads the entries of the System Event Log following the last
+// seen record of a host with the native IPMI client
+func (r *IPMIReceiver) readNativeSEL(s *ipmiSession, host *ipmiHost) error {
+	info, err := s.request(ipmiNetFnStorage, 0, 0x40, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get SEL info: %w", err)
+	}
+	if len(info) < 13 {
+		return errors.New("invalid get SEL info response")
+	}
+	numEntries := binary.LittleEndian.Uint16(info[1:3])
+	eraseTime := binary.LittleEndian.Uint32(info[9:13])
+	if numEntries == 0 {
+		r.updateSEL(host, nil, eraseTime)
+		return nil
+	}
+
+	// On first contact only the last record ID is required
+	var completionError ipmiCompletionError
+	if host.sel == nil {
+		record, _, err := s.getSELEntry(0xffff)
+		if err != nil {
+			return fmt.Errorf("failed to get last SEL entry: %w", err)
+		}
+		r.updateSEL(host, []ipmiSELEntry{{id: binary.LittleEndian.Uint16(record)}}, eraseTime)
+		return nil
+	}
+
+	// Continue after the last seen record, if it still exists
+	recordID := uint16(0)
+	if host.sel.RecordID != 0 && host.sel.EraseTime == eraseTime {
+		_, next, err := s.getSELEntry(host.sel.RecordID)
+		switch {
+		case errors.As(err, &completionError):
+			host.sel.RecordID = 0
+		case err != nil:
+			return fmt.Errorf("failed to get SEL entry 0x%04x: %w", host.sel.RecordID, err)
+		case next == 0xffff:
+			return nil
+		default:
+			recordID = next
+		}
+	}
+
+	var entries []ipmiSELEntry
+	for range numEntries {
+		record, next, err := s.getSELEntry(recordID)
+		if err != nil {
+			return fmt.Errorf("failed to get SEL entry 0x%04x: %w", recordID, err)
+		}
+		if e, ok := parseIPMISELRecord(record, host.sensorNames); ok {
+			entries = append(entries, e)
+		} else {
+			// OEM records only update the last seen record ID
+			entries = append(entries, ipmiSELEntry{id: binary.LittleEndian.Uint16(record)})
+		}
+		if next == 0xffff {
+			break
+		}
+		recordID = next
+	}
+	r.updateSEL(host, entries, eraseTime)
+	return nil
+}
+
+// readIpmitoolSEL reads the entries of the System Event Log of a host using
+// ipmitool
+func (r *IPMIReceiver) readIpmitoolSEL(host *ipmiHost) error {
+	out, err := r.ipmitool(host, "sel", "elist")
+	if err != nil {
+		return err
+	}
+	r.updateSEL(host, parseIpmitoolSEL(strings.NewReader(out)), 0)
+	return nil
+}
+
+// readIPMISel reads the entries of the System Event Log of the IPMI hosts of
+// a client config using the ipmi-sel command
+func (r *IPMIReceiver) readIPMISel(clientConfig *IPMIReceiverClientConfig) {
+	password := clientConfig.Password.Value()
+	command := exec.Command("ipmi-sel",
+		"--always-prefix",
+		"--comma-separated-output",
+		"--no-header-output",
+		"--output-event-state",
+		"--interpret-oem-data",
+		"--fanout", fmt.Sprint(clientConfig.Fanout),
+		"--driver-type", clientConfig.DriverType,
+		"--hostname", clientConfig.IPMIHosts,
+		"--username", clientConfig.Username,
+		"--password", password,
+	)
+	var stdout, stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		cclog.ComponentError(
+			r.name,
+			fmt.Sprintf("readIPMISel(): Failed to run command \"%s\": %v: %s",
+				strings.ReplaceAll(command.String(), password, "<PW>"), err, strings.TrimSpace(stderr.String())),
+		)
+		return
+	}
+	// Hosts without entries are skipped, as they may have failed
+	for ipmiHost, entries := range parseIPMISel(&stdout) {
+		if hostname, ok := clientConfig.IPMI2HostMapping[ipmiHost]; ok {
+			r.updateSEL(r.hosts[hostname], entries, 0)
+		}
+	}
+}
+
+// loadSELState reads the last seen SEL record IDs of the hosts from the state
+// file, if it exists
+func (r *IPMIReceiver) loadSELState() error {
+	data, err := os.ReadFile(r.config.SELStateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state map[string]ipmiSELState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse SEL state file %s: %w", r.config.SELStateFile, err)
+	}
+	for hostname, s := range state {
+		if host, ok := r.hosts[hostname]; ok {
+			host.sel = &s
+		}
+	}
+	return nil
+}
+
+// saveSELState writes the last seen SEL record IDs of the hosts to the state
+// file, if they changed
+func (r *IPMIReceiver) saveSELState() {
+	if r.config.SELStateFile == "" || !r.selChanged.Swap(false) {
+		return
+	}
+	state := make(map[string]ipmiSELState)
+	for hostname, host := range r.hosts {
+		if host.sel != nil {
+			state[hostname] = *host.sel
+		}
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err == nil {
+		tmp := r.config.SELStateFile + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o644); err == nil {
+			err = os.Rename(tmp, r.config.SELStateFile)
+		}
+	}
+	if err != nil {
+		r.selChanged.Store(true)
+		cclog.ComponentError(r.name, fmt.Sprintf("saveSELState(): Failed to write SEL state file %s: %v", r.config.SELStateFile, err))
+	}
+}
@@ -0,0 +1,179 @@
+//go:build linux
+
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Sensor type names of ipmi-sensors by unit of ipmitool, which does not print
+// sensor types in the SDR list
+var ipmitoolSensorTypes = map[string]string{
+	"degrees C": "Temperature",
+	"degrees F": "Temperature",
+	"Volts":     "Voltage",
+	"Amps":      "Current",
+	"Watts":     "Power Supply",
+	"RPM":       "Fan",
+	"CFM":       "Other Units Based Sensor",
+	"percent":   "Other Units Based Sensor",
+}
+
+// Power limit in the output of ipmitool dcmi power get_limit, e.g.
+// "Power Limit: 300 Watts"
+var ipmitoolPowerLimitRegex = regexp.MustCompile(`(?i)^power limit\s*:\s*(\d+)\s*watts`)
+
+// Activation state in the output of ipmitool dcmi power get_limit, e.g.
+// "Current Limit State: No Active Power Limit"
+var ipmitoolPowerLimitActiveRegex = regexp.MustCompile(`(?i)^current limit state\s*:\s*no active`)
+
+// ipmiReading is a sensor reading with sensor type and unit names of ipmi-sensors
+type ipmiReading struct {
+	name       string
+	sensorType string
+	unit       string
+	value      float64
+}
+
+// parseIpmitoolSDR parses the output of ipmitool sdr list, e.g.
+// "CPU1 Temp        | 45 degrees C      | ok"
+// Sensors without readings and with unsupported units are skipped.
+func parseIpmitoolSDR(r io.Reader) []ipmiReading {
+	var readings []ipmiReading
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "|")
+		if len(fields) != 3 {
+			continue
+		}
+		value, unit, ok := strings.Cut(strings.TrimSpace(fields[1]), " ")
+		if !ok {
+			continue
+		}
+		unit = strings.TrimSpace(unit)
+		sensorType, ok := ipmitoolSensorTypes[unit]
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		if unit == "percent" {
+			unit = "%"
+		}
+		readings = append(readings, ipmiReading{
+			name:       strings.TrimSpace(fields[0]),
+			sensorType: sensorType,
+			unit:       unit,
+			value:      v,
+		})
+	}
+	return readings
+}
+
+// ipmitool runs ipmitool for the IPMI device of a host and returns its output.
+// The password is passed in the environment.
+func (r *IPMIReceiver) ipmitool(host *ipmiHost, args ...string) (string, error) {
+	clientConfig := host.clientConfig
+	ctx, cancel := context.WithTimeout(context.Background(), clientConfig.Timeout)
+	defer cancel()
+
+	cmdOptions := []string{"-I", "lanplus"}
+	if clientConfig.DriverType == "LAN" {
+		cmdOptions = []string{"-I", "lan"}
+	} else {
+		cmdOptions = append(cmdOptions, "-C", strconv.Itoa(clientConfig.CipherSuite))
+	}
+	if h, port, err := net.SplitHostPort(host.ipmiHost); err == nil {
+		cmdOptions = append(cmdOptions, "-H", h, "-p", port)
+	} else {
+		cmdOptions = append(cmdOptions, "-H", host.ipmiHost)
+	}
+	cmdOptions = append(cmdOptions,
+		"-U", clientConfig.Username,
+		"-E",
+		"-L", strings.ToUpper(clientConfig.PrivilegeLevel),
+	)
+	cmdOptions = append(cmdOptions, clientConfig.CLIOptions...)
+	cmdOptions = append(cmdOptions, args...)
+
+	command := exec.CommandContext(ctx, "ipmitool", cmdOptions...)
+	command.Env = append(os.Environ(), "IPMI_PASSWORD="+clientConfig.Password.Value())
+	var stdout, stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		return "", fmt.Errorf("command \"%s\" failed: %w: %s",
+			command.String(), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// readIpmitool reads the sensors and the new SEL entries of a host using ipmitool
+func (r *IPMIReceiver) readIpmitool(host *ipmiHost) error {
+	out, err := r.ipmitool(host, "sdr", "list", "full")
+	if err != nil {
+		return err
+	}
+	for _, reading := range parseIpmitoolSDR(strings.NewReader(out)) {
+		r.sendSensor(host.clientConfig, host.hostname, reading.sensorType, reading.name, reading.unit, reading.value)
+	}
+	if r.config.SEL {
+		return r.readIpmitoolSEL(host)
+	}
+	return nil
+}
+
+// ipmitoolPowerLimit returns the DCMI power limit of a host in watts, 0 if it
+// is not active, using ipmitool
+func (r *IPMIReceiver) ipmitoolPowerLimit(host *ipmiHost) (int, error) {
+	out, err := r.ipmitool(host, "dcmi", "power", "get_limit")
+	if err != nil {
+		return 0, err
+	}
+	watts := -1
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if ipmitoolPowerLimitActiveRegex.MatchString(line) {
+			return 0, nil
+		}
+		if m := ipmitoolPowerLimitRegex.FindStringSubmatch(line); m != nil {
+			watts, _ = strconv.Atoi(m[1])
+		}
+	}
+	if watts < 0 {
+		return 0, fmt.Errorf("failed to parse power limit of ipmitool output '%s'", strings.TrimSpace(out))
+	}
+	return watts, nil
+}
+
+// setIpmitoolPowerLimit sets and activates the DCMI power limit of a host in
+// watts or deactivates it for 0 using ipmitool
+func (r *IPMIReceiver) setIpmitoolPowerLimit(host *ipmiHost, watts int) error {
+	if watts == 0 {
+		_, err := r.ipmitool(host, "dcmi", "power", "deactivate")
+		return err
+	}
+	if _, err := r.ipmitool(host, "dcmi", "power", "set_limit", "limit", strconv.Itoa(watts)); err != nil {
+		return err
+	}
+	_, err := r.ipmitool(host, "dcmi", "power", "activate")
+	return err
+}
@@ -0,0 +1,183 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	"github.com/ClusterCockpit/cc-lib/v2/schema"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTConfig configures the verification of Ed25519 signed JSON Web Tokens
+// as issued by cc-backend.
+type JWTConfig struct {
+	PublicKey     string   `json:"public_key,omitempty"`      // Base64 encoded Ed25519 public key
+	PublicKeyFile string   `json:"public_key_file,omitempty"` // File with the base64 or PEM encoded Ed25519 public key
+	Issuer        string   `json:"issuer,omitempty"`          // Required issuer (iss claim) of the tokens (optional)
+	RequireExpiry bool     `json:"require_expiry,omitempty"`  // Reject tokens without expiration time (exp claim)
+	Roles         []string `json:"roles,omitempty"`           // The token must contain at least one of these roles (optional)
+	ClusterClaim  string   `json:"cluster_claim,omitempty"`   // Claim with the clusters the token is scoped to (default: clusters)
+	ClusterTag    string   `json:"cluster_tag,omitempty"`     // Message tag checked against the clusters of the token (default: cluster)
+}
+
+// jwtValidator verifies tokens and maps their claims to a user
+type jwtValidator struct {
+	config JWTConfig
+	key    ed25519.PublicKey
+	parser *jwt.Parser
+}
+
+// jwtPrincipal is the user of a verified token and the clusters it is scoped to
+type jwtPrincipal struct {
+	user       *schema.User
+	clusters   []string // nil if the token is not restricted to clusters
+	clusterTag string
+}
+
+// parseEd25519PublicKey reads a base64 encoded raw or a PEM encoded PKIX Ed25519 public key
+func parseEd25519PublicKey(data string) (ed25519.PublicKey, error) {
+	data = strings.TrimSpace(data)
+	if block, _ := pem.Decode([]byte(data)); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse PEM public key: %w", err)
+		}
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key of type %T is no Ed25519 key", key)
+		}
+		return edKey, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 public key: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid size %d of Ed25519 public key", len(raw))
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// newJWTValidator creates a token validator for the given configuration
+func newJWTValidator(config JWTConfig) (*jwtValidator, error) {
+	v := &jwtValidator{config: config}
+	if len(v.config.ClusterClaim) == 0 {
+		v.config.ClusterClaim = "clusters"
+	}
+	if len(v.config.ClusterTag) == 0 {
+		v.config.ClusterTag = "cluster"
+	}
+	for _, role := range v.config.Roles {
+		if !schema.IsValidRole(role) {
+			return nil, fmt.Errorf("invalid role '%s'", role)
+		}
+	}
+
+	keyData := v.config.PublicKey
+	if len(v.config.PublicKeyFile) > 0 {
+		if len(keyData) > 0 {
+			return nil, errors.New("public_key and public_key_file are mutually exclusive")
+		}
+		data, err := os.ReadFile(v.config.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public key: %w", err)
+		}
+		keyData = string(data)
+	}
+	if len(keyData) == 0 {
+		return nil, errors.New("public_key or public_key_file is required")
+	}
+	key, err := parseEd25519PublicKey(keyData)
+	if err != nil {
+		return nil, err
+	}
+	v.key = key
+
+	options := []jwt.ParserOption{jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()})}
+	if len(v.config.Issuer) > 0 {
+		options = append(options, jwt.WithIssuer(v.config.Issuer))
+	}
+	if v.config.RequireExpiry {
+		options = append(options, jwt.WithExpirationRequired())
+	}
+	v.parser = jwt.NewParser(options...)
+	return v, nil
+}
+
+// claimStrings returns a claim, which is either a string or a list of strings
+func claimStrings(claims jwt.MapClaims, key string) []string {
+	switch v := claims[key].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, x := range v {
+			if s, ok := x.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// Validate verifies the signed token and returns the principal described by its claims
+func (v *jwtValidator) Validate(rawToken string) (*jwtPrincipal, error) {
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(rawToken, claims, func(t *jwt.Token) (any, error) {
+		return v.key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sub, _ := claims.GetSubject()
+	user := &schema.User{
+		Username:   sub,
+		Roles:      claimStrings(claims, "roles"),
+		Projects:   claimStrings(claims, "projects"),
+		AuthType:   schema.AuthToken,
+		AuthSource: schema.AuthViaToken,
+	}
+	if len(v.config.Roles) > 0 && !slices.ContainsFunc(v.config.Roles, func(role string) bool {
+		hasRole, _ := user.HasValidRole(role)
+		return hasRole
+	}) {
+		return nil, fmt.Errorf("user '%s' has none of the roles %v", sub, v.config.Roles)
+	}
+
+	p := &jwtPrincipal{user: user, clusterTag: v.config.ClusterTag}
+	// Administrators may submit messages for all clusters
+	if _, ok := claims[v.config.ClusterClaim]; ok && !user.HasRole(schema.RoleAdmin) {
+		p.clusters = claimStrings(claims, v.config.ClusterClaim)
+	}
+	return p, nil
+}
+
+// Authorize checks whether the principal may submit the message m
+func (p *jwtPrincipal) Authorize(m lp.CCMessage) error {
+	if p == nil || p.clusters == nil {
+		return nil
+	}
+	cluster, ok := m.GetTag(p.clusterTag)
+	if !ok {
+		return fmt.Errorf("user '%s' is restricted to clusters %v but message has no tag '%s'", p.user.Username, p.clusters, p.clusterTag)
+	}
+	if !slices.Contains(p.clusters, cluster) {
+		return fmt.Errorf("user '%s' is not authorized for cluster '%s'", p.user.Username, cluster)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package receivers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestToken(t *testing.T, key ed25519.PrivateKey, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return token
+}
+
+func TestJWTValidator(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+	v, err := newJWTValidator(JWTConfig{
+		PublicKey:     base64.StdEncoding.EncodeToString(pub),
+		Issuer:        "cc-backend",
+		RequireExpiry: true,
+		Roles:         []string{"api", "admin"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create validator: %v", err)
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	valid := jwt.MapClaims{"sub": "collector", "iss": "cc-backend", "exp": exp, "roles": []string{"api"}, "projects": []string{"p1"}, "clusters": []string{"fritz"}}
+	p, err := v.Validate(newTestToken(t, priv, valid))
+	if err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	if p.user.Username != "collector" || !p.user.HasProject("p1") || len(p.clusters) != 1 {
+		t.Errorf("invalid principal %+v %+v", p, p.user)
+	}
+
+	fritz, _ := lp.NewMetric("m", map[string]string{"cluster": "fritz"}, nil, 1.0, time.Now())
+	alex, _ := lp.NewMetric("m", map[string]string{"cluster": "alex"}, nil, 1.0, time.Now())
+	none, _ := lp.NewMetric("m", nil, nil, 1.0, time.Now())
+	if err := p.Authorize(fritz); err != nil {
+		t.Errorf("message for cluster fritz rejected: %v", err)
+	}
+	if p.Authorize(alex) == nil || p.Authorize(none) == nil {
+		t.Error("message for other cluster accepted")
+	}
+
+	// Administrators are not restricted to clusters
+	admin := jwt.MapClaims{"sub": "admin", "iss": "cc-backend", "exp": exp, "roles": []string{"admin"}, "clusters": "fritz"}
+	if p, err := v.Validate(newTestToken(t, priv, admin)); err != nil || p.Authorize(alex) != nil {
+		t.Errorf("admin token not accepted for all clusters: %v", err)
+	}
+
+	invalid := map[string]string{}
+	invalid["wrong key"] = newTestToken(t, otherPriv, valid)
+	invalid["wrong issuer"] = newTestToken(t, priv, jwt.MapClaims{"sub": "c", "iss": "other", "exp": exp, "roles": []string{"api"}})
+	invalid["expired"] = newTestToken(t, priv, jwt.MapClaims{"sub": "c", "iss": "cc-backend", "exp": time.Now().Add(-time.Hour).Unix(), "roles": []string{"api"}})
+	invalid["no expiry"] = newTestToken(t, priv, jwt.MapClaims{"sub": "c", "iss": "cc-backend", "roles": []string{"api"}})
+	invalid["no role"] = newTestToken(t, priv, jwt.MapClaims{"sub": "c", "iss": "cc-backend", "exp": exp, "roles": []string{"user"}})
+	hs, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, valid).SignedString(pub)
+	invalid["wrong algorithm"] = hs
+	for name, token := range invalid {
+		if _, err := v.Validate(token); err == nil {
+			t.Errorf("token with %s accepted", name)
+		}
+	}
+}
+
+func TestHttpReceiverJWT(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	config := json.RawMessage(fmt.Sprintf(`{
+		"type": "http",
+		"address": "localhost",
+		"port": "8085",
+		"jwt": {"public_key": "%s"}
+	}`, base64.StdEncoding.EncodeToString(pub)))
+	r, err := NewHttpReceiver("testreceiver", config)
+	if err != nil {
+		t.Fatalf("failed to create http receiver: %v", err)
+	}
+	h := r.(*HttpReceiver)
+	sink := make(chan lp.CCMessage, 10)
+	r.SetSink(sink)
+
+	token := newTestToken(t, priv, jwt.MapClaims{"sub": "collector", "roles": []string{"api"}, "clusters": []string{"fritz"}})
+	body := "m,cluster=fritz,type=node value=1 1700000000000000000\nm,cluster=alex,type=node value=2 1700000000000000000\n"
+
+	tests := []struct {
+		auth   string
+		status int
+		n      int
+	}{
+		{"", http.StatusUnauthorized, 0},
+		{"Bearer invalid", http.StatusUnauthorized, 0},
+		{"Bearer " + token, http.StatusForbidden, 1},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/write", strings.NewReader(body))
+		if len(tt.auth) > 0 {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServerHttp(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.auth, tt.status, w.Code)
+		}
+		if len(sink) != tt.n {
+			t.Errorf("%s: expected %d messages, got %d", tt.auth, tt.n, len(sink))
+		}
+		for range len(sink) {
+			if m := <-sink; m.Tags()["cluster"] != "fritz" {
+				t.Errorf("message for wrong cluster forwarded: %s", m)
+			}
+		}
+	}
+	if h.rejected.Load() != 1 {
+		t.Errorf("expected 1 rejected message, got %d", h.rejected.Load())
+	}
+}
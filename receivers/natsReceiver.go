@@ -8,15 +8,25 @@
 package receivers
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strconv"
+	"time"
 
 	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
 	mp "github.com/ClusterCockpit/cc-lib/v2/messageProcessor"
 	influx "github.com/ClusterCockpit/cc-line-protocol/v2/lineprotocol"
+	"github.com/klauspost/compress/zstd"
 	nats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
 // NatsReceiverConfig configures the NATS receiver for subscribing to metric messages.
@@ -24,51 +34,293 @@ type NatsReceiverConfig struct {
 	defaultReceiverConfig
 	Addr     string `json:"address"`             // NATS server address (default: localhost)
 	Port     string `json:"port"`                // NATS server port (default: 4222)
-	Subject  string `json:"subject"`             // NATS subject to subscribe to (required)
+	Subject  string `json:"subject"`             // NATS subject to subscribe to
 	User     string `json:"user,omitempty"`      // Username for authentication
 	Password string `json:"password,omitempty"`  // Password for authentication
 	NkeyFile string `json:"nkey_file,omitempty"` // Path to NKey credentials file
+
+	// Further subjects to subscribe to. At least one of subject and subjects is required.
+	Subjects []string `json:"subjects,omitempty"`
+	// Queue group shared by all receivers which process the messages together.
+	// Each message is delivered to only one receiver of the group.
+	QueueGroup string `json:"queue_group,omitempty"`
+	// Consume the subjects from a JetStream stream instead of core NATS
+	JetStream *NatsReceiverJetStreamConfig `json:"jetstream,omitempty"`
+	// Maximum size of a decompressed message (default: 32 MiB)
+	MaxPayloadSize int64 `json:"max_payload_size,omitempty"`
+}
+
+// Default maximum size of a decompressed message
+const NATS_RECEIVER_MAX_PAYLOAD_SIZE = 32 * 1024 * 1024
+
+// NatsReceiverJetStreamConfig configures the durable JetStream consumer of the NATS receiver
+type NatsReceiverJetStreamConfig struct {
+	Stream  string `json:"stream"`  // Name of the stream (required)
+	Durable string `json:"durable"` // Name of the durable consumer, shared by all receivers processing the messages together (required)
+	// Consumer type: pull (default) or push. Push consumers deliver to deliver_subject
+	// (default: cc.deliver.<durable>) and balance the messages over the queue_group.
+	Consumer       string `json:"consumer,omitempty"`
+	DeliverSubject string `json:"deliver_subject,omitempty"`
+	// Time the server waits for the acknowledgement before redelivering a message (default: 30s)
+	AckWait string `json:"ack_wait,omitempty"`
+	ackWait time.Duration
+	// Maximum number of deliveries of a message (default: 5)
+	MaxDeliver int `json:"max_deliver,omitempty"`
+	// Delay of the redelivery of messages which failed to be processed (default: 1s)
+	RetryDelay string `json:"retry_delay,omitempty"`
+	retryDelay time.Duration
+	// Subject for messages which failed to be processed in all deliveries (default: the messages are dropped)
+	DeadLetterSubject string `json:"dead_letter_subject,omitempty"`
 }
 
+// Headers added to messages published to the dead-letter subject
+const (
+	NATS_RECEIVER_DEAD_LETTER_SUBJECT_HEADER   = "Cc-Dead-Letter-Subject"
+	NATS_RECEIVER_DEAD_LETTER_ERROR_HEADER     = "Cc-Dead-Letter-Error"
+	NATS_RECEIVER_DEAD_LETTER_DELIVERED_HEADER = "Cc-Dead-Letter-Delivered"
+)
+
 type NatsReceiver struct {
 	receiver
-	nc  *nats.Conn
-	sub *nats.Subscription
+	nc   *nats.Conn
+	subs []*nats.Subscription
 	// meta   map[string]string
-	config NatsReceiverConfig
+	config   NatsReceiverConfig
+	subjects []string      // all subjects of subject and subjects
+	zstd     *zstd.Decoder // decoder for zstd compressed messages
+
+	// JetStream consumer
+	consume  func() (jetstream.ConsumeContext, error)
+	consumer jetstream.ConsumeContext
 }
 
-// Start subscribes to the configured NATS subject
-// Messages wil be handled by r._NatsReceive
+// Start subscribes to the configured NATS subjects or starts consuming from the JetStream consumer
+// Messages wil be handled by r._NatsReceive or r._JetStreamReceive
 func (r *NatsReceiver) Start() {
 	cclog.ComponentDebug(r.name, "START")
-	sub, err := r.nc.Subscribe(r.config.Subject, r._NatsReceive)
-	if err != nil {
-		msg := fmt.Sprintf("Failed to subscribe to subject '%s': %s", r.config.Subject, err.Error())
-		cclog.ComponentError(r.name, msg)
+	if r.consume != nil {
+		// Messages are only consumed, if they can be handed to a sink
+		if r.sink == nil {
+			cclog.ComponentError(r.name, "No sink set, not consuming from durable consumer "+r.config.JetStream.Durable)
+			return
+		}
+		cc, err := r.consume()
+		if err != nil {
+			cclog.ComponentError(r.name, fmt.Sprintf("Failed to consume from durable consumer '%s': %s", r.config.JetStream.Durable, err.Error()))
+		}
+		r.consumer = cc
+		return
+	}
+	for _, subject := range r.subjects {
+		var (
+			sub *nats.Subscription
+			err error
+		)
+		if len(r.config.QueueGroup) > 0 {
+			sub, err = r.nc.QueueSubscribe(subject, r.config.QueueGroup, r._NatsReceive)
+		} else {
+			sub, err = r.nc.Subscribe(subject, r._NatsReceive)
+		}
+		if err != nil {
+			msg := fmt.Sprintf("Failed to subscribe to subject '%s': %s", subject, err.Error())
+			cclog.ComponentError(r.name, msg)
+			continue
+		}
+		r.subs = append(r.subs, sub)
 	}
-	r.sub = sub
 }
 
-// _NatsReceive receives subscribed messages from the NATS server
-func (r *NatsReceiver) _NatsReceive(m *nats.Msg) {
-	if r.sink == nil {
-		return
+// decode decompresses and decodes the message. It returns the processed
+// messages decoded until the first error.
+func (r *NatsReceiver) decode(header nats.Header, data []byte) ([]lp.CCMessage, error) {
+	data, err := r.payload(header, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress message: %w", err)
 	}
 
-	d := influx.NewDecoderWithBytes(m.Data)
+	var out []lp.CCMessage
+	d := influx.NewDecoderWithBytes(data)
 	for d.Next() {
 		y, err := DecodeInfluxMessage(d)
 		if err != nil {
-			cclog.ComponentError(r.name, "_NatsReceive: Failed to decode message:", err)
-			return
+			return out, fmt.Errorf("failed to decode message: %w", err)
 		}
 
 		msg, err := r.mp.ProcessMessage(y)
 		if err == nil && msg != nil {
+			out = append(out, msg)
+		}
+	}
+	return out, nil
+}
+
+// _NatsReceive receives subscribed messages from the NATS server
+func (r *NatsReceiver) _NatsReceive(m *nats.Msg) {
+	if r.sink == nil {
+		return
+	}
+
+	msgs, err := r.decode(m.Header, m.Data)
+	for _, msg := range msgs {
+		r.sink <- msg
+	}
+	if err != nil {
+		cclog.ComponentError(r.name, "_NatsReceive:", err)
+	}
+}
+
+// _JetStreamReceive receives messages from the JetStream consumer. Messages are
+// acknowledged after they are handed to the sink. Messages which fail to be
+// decoded are redelivered up to max_deliver times and then sent to the dead-letter subject.
+func (r *NatsReceiver) _JetStreamReceive(m jetstream.Msg) {
+	msgs, err := r.decode(m.Headers(), m.Data())
+	if err == nil {
+		for _, msg := range msgs {
 			r.sink <- msg
 		}
+		if err := m.Ack(); err != nil {
+			cclog.ComponentError(r.name, "_JetStreamReceive: Failed to acknowledge message:", err)
+		}
+		return
+	}
+
+	c := r.config.JetStream
+	delivered := uint64(1)
+	if md, err := m.Metadata(); err == nil {
+		delivered = md.NumDelivered
+	}
+	if delivered < uint64(c.MaxDeliver) {
+		cclog.ComponentError(r.name, fmt.Sprintf("_JetStreamReceive: delivery %d of %d: %s", delivered, c.MaxDeliver, err))
+		if err := m.NakWithDelay(c.retryDelay); err != nil {
+			cclog.ComponentError(r.name, "_JetStreamReceive: Failed to request redelivery:", err)
+		}
+		return
+	}
+
+	if len(c.DeadLetterSubject) == 0 {
+		cclog.ComponentError(r.name, fmt.Sprintf("_JetStreamReceive: dropping message of subject %s after %d deliveries: %s", m.Subject(), delivered, err))
+		m.Term()
+		return
+	}
+	dl := nats.NewMsg(c.DeadLetterSubject)
+	dl.Data = m.Data()
+	for k, v := range m.Headers() {
+		dl.Header[k] = slices.Clone(v)
 	}
+	dl.Header.Set(NATS_RECEIVER_DEAD_LETTER_SUBJECT_HEADER, m.Subject())
+	dl.Header.Set(NATS_RECEIVER_DEAD_LETTER_ERROR_HEADER, err.Error())
+	dl.Header.Set(NATS_RECEIVER_DEAD_LETTER_DELIVERED_HEADER, strconv.FormatUint(delivered, 10))
+	if perr := r.nc.PublishMsg(dl); perr != nil {
+		// The message stays in the stream, but is not delivered again
+		cclog.ComponentError(r.name, fmt.Sprintf("_JetStreamReceive: failed to publish message to dead-letter subject %s: %s", c.DeadLetterSubject, perr))
+		return
+	}
+	cclog.ComponentError(r.name, fmt.Sprintf("_JetStreamReceive: sent message of subject %s to dead-letter subject %s after %d deliveries: %s",
+		m.Subject(), c.DeadLetterSubject, delivered, err))
+	m.Term()
+}
+
+// payload returns the data of a message, decompressed according to its
+// Content-Encoding header. Payloads larger than max_payload_size are rejected.
+func (r *NatsReceiver) payload(header nats.Header, data []byte) ([]byte, error) {
+	maxSize := r.config.MaxPayloadSize
+	encoding := header.Get("Content-Encoding")
+	switch encoding {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		if data, err = io.ReadAll(io.LimitReader(gz, maxSize+1)); err != nil {
+			return nil, err
+		}
+	case "zstd":
+		// The decoder is limited to max_payload_size
+		var err error
+		if data, err = r.zstd.DecodeAll(data, nil); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content encoding '%s'", encoding)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("payload exceeds maximum size of %d bytes", maxSize)
+	}
+	return data, nil
+}
+
+// setupJetStream creates or updates the durable consumer
+func (r *NatsReceiver) setupJetStream() error {
+	c := r.config.JetStream
+	if len(c.Stream) == 0 || len(c.Durable) == 0 {
+		return errors.New("jetstream requires stream and durable")
+	}
+	if c.MaxDeliver == 0 {
+		c.MaxDeliver = 5
+	}
+	if c.MaxDeliver < 1 {
+		return errors.New("jetstream max_deliver must be positive")
+	}
+	c.ackWait = 30 * time.Second
+	c.retryDelay = time.Second
+	for _, d := range []struct {
+		option string
+		value  string
+		target *time.Duration
+	}{
+		{"ack_wait", c.AckWait, &c.ackWait},
+		{"retry_delay", c.RetryDelay, &c.retryDelay},
+	} {
+		if len(d.value) > 0 {
+			t, err := time.ParseDuration(d.value)
+			if err != nil {
+				return fmt.Errorf("failed to parse jetstream %s '%s': %w", d.option, d.value, err)
+			}
+			*d.target = t
+		}
+	}
+
+	js, err := jetstream.New(r.nc)
+	if err != nil {
+		return err
+	}
+	cfg := jetstream.ConsumerConfig{
+		Durable:        c.Durable,
+		FilterSubjects: r.subjects,
+		AckPolicy:      jetstream.AckExplicitPolicy,
+		AckWait:        c.ackWait,
+		MaxDeliver:     c.MaxDeliver,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	switch c.Consumer {
+	case "", "pull":
+		consumer, err := js.CreateOrUpdateConsumer(ctx, c.Stream, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create consumer %s of stream %s: %w", c.Durable, c.Stream, err)
+		}
+		r.consume = func() (jetstream.ConsumeContext, error) {
+			return consumer.Consume(r._JetStreamReceive)
+		}
+	case "push":
+		cfg.DeliverSubject = c.DeliverSubject
+		if len(cfg.DeliverSubject) == 0 {
+			cfg.DeliverSubject = "cc.deliver." + c.Durable
+		}
+		cfg.DeliverGroup = r.config.QueueGroup
+		consumer, err := js.CreateOrUpdatePushConsumer(ctx, c.Stream, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create push consumer %s of stream %s: %w", c.Durable, c.Stream, err)
+		}
+		r.consume = func() (jetstream.ConsumeContext, error) {
+			return consumer.Consume(r._JetStreamReceive)
+		}
+	default:
+		return fmt.Errorf("unknown jetstream consumer type '%s'", c.Consumer)
+	}
+	return nil
 }
 
 // Close closes the connection to the NATS server
@@ -77,13 +329,19 @@ func (r *NatsReceiver) Close() {
 		return
 	}
 
+	defer r.zstd.Close()
 	defer r.nc.Close()
-	defer r.sub.Unsubscribe()
 
+	if r.consumer != nil {
+		// Messages not yet acknowledged are redelivered after ack_wait
+		cclog.ComponentDebug(r.name, "STOP")
+		r.consumer.Stop()
+	}
 	cclog.ComponentDebug(r.name, "DRAIN")
-	err := r.sub.Drain()
-	if err != nil {
-		cclog.ComponentError(r.name, "Failed to drain subscription to subject", r.config.Subject, ":", err)
+	for _, sub := range r.subs {
+		if err := sub.Drain(); err != nil {
+			cclog.ComponentError(r.name, fmt.Sprintf("Failed to drain subscription to subject %s: %s", sub.Subject, err))
+		}
 	}
 	cclog.ComponentDebug(r.name, "CLOSE")
 }
@@ -97,6 +355,7 @@ func NewNatsReceiver(name string, config json.RawMessage) (Receiver, error) {
 	// Read configuration file, allow overwriting default config
 	r.config.Addr = "localhost"
 	r.config.Port = "4222"
+	r.config.MaxPayloadSize = NATS_RECEIVER_MAX_PAYLOAD_SIZE
 	if len(config) > 0 {
 		err := json.Unmarshal(config, &r.config)
 		if err != nil {
@@ -104,11 +363,22 @@ func NewNatsReceiver(name string, config json.RawMessage) (Receiver, error) {
 			return nil, err
 		}
 	}
+	if len(r.config.Subject) > 0 {
+		r.subjects = append(r.subjects, r.config.Subject)
+	}
+	for _, subject := range r.config.Subjects {
+		if len(subject) > 0 && !slices.Contains(r.subjects, subject) {
+			r.subjects = append(r.subjects, subject)
+		}
+	}
 	if len(r.config.Addr) == 0 ||
 		len(r.config.Port) == 0 ||
-		len(r.config.Subject) == 0 {
+		len(r.subjects) == 0 {
 		return nil, errors.New("not all configuration variables set required by NatsReceiver")
 	}
+	if r.config.MaxPayloadSize <= 0 {
+		return nil, errors.New("max_payload_size of NatsReceiver must be positive")
+	}
 	p, err := mp.NewMessageProcessor()
 	if err != nil {
 		return nil, fmt.Errorf("initialization of message processor failed: %w", err)
@@ -139,23 +409,45 @@ func NewNatsReceiver(name string, config json.RawMessage) (Receiver, error) {
 		}
 	}
 
+	// Decoder for compressed messages sent by the NATS sink, created before
+	// connecting so that a failure does not leak the connection
+	r.zstd, err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(r.config.MaxPayloadSize)))
+	if err != nil {
+		return nil, err
+	}
+
 	// Connect to NATS server
 	url := fmt.Sprintf("nats://%s:%s", r.config.Addr, r.config.Port)
-	cclog.ComponentDebug(r.name, "NewNatsReceiver ", url, " Subject ", r.config.Subject)
+	cclog.ComponentDebug(r.name, "NewNatsReceiver ", url, " Subjects ", r.subjects)
 	if nc, err := nats.Connect(url, uinfo, nats.MaxReconnects(-1), nats.RetryOnFailedConnect(true)); err == nil {
 		r.nc = nc
 	} else {
 		r.nc = nil
+		r.zstd.Close()
 		return nil, err
 	}
 
-	sub, err := r.nc.Subscribe(r.config.Subject, func(m *nats.Msg) {})
-	if err != nil {
-		err = fmt.Errorf("Failed to test subscribe to subject '%s': %w", r.config.Subject, err)
-		cclog.ComponentError(r.name, err)
-		return nil, err
+	if r.config.JetStream != nil {
+		if err := r.setupJetStream(); err != nil {
+			r.nc.Close()
+			r.zstd.Close()
+			err = fmt.Errorf("%s: %w", r.name, err)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
+	} else {
+		for _, subject := range r.subjects {
+			sub, err := r.nc.Subscribe(subject, func(m *nats.Msg) {})
+			if err != nil {
+				err = fmt.Errorf("Failed to test subscribe to subject '%s': %w", subject, err)
+				cclog.ComponentError(r.name, err)
+				r.nc.Close()
+				r.zstd.Close()
+				return nil, err
+			}
+			sub.Unsubscribe()
+		}
 	}
-	sub.Unsubscribe()
 
 	return r, nil
 }
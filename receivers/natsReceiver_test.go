@@ -1,16 +1,22 @@
 package receivers
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"sync"
 	"testing"
 	"time"
 
 	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	"github.com/klauspost/compress/zstd"
 	server "github.com/nats-io/nats-server/v2/server"
 	nats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
 var natsReceiverTestConfig json.RawMessage = json.RawMessage(`{
@@ -116,3 +122,217 @@ func TestNatsReceiver(t *testing.T) {
 	serverDone <- true
 	wg.Wait()
 }
+
+func TestNatsReceiverPayload(t *testing.T) {
+	data := []byte("testmetric,type=node value=1 1700000000000000000\n")
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(data)
+	w.Close()
+	enc, _ := zstd.NewWriter(nil)
+	zs := enc.EncodeAll(data, nil)
+	enc.Close()
+
+	r := &NatsReceiver{}
+	r.config.MaxPayloadSize = 1024
+	r.zstd, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(1024))
+	defer r.zstd.Close()
+	for _, tt := range []struct {
+		encoding string
+		data     []byte
+	}{
+		{"", data},
+		{"gzip", gz.Bytes()},
+		{"zstd", zs},
+	} {
+		m := nats.NewMsg("test")
+		m.Data = tt.data
+		if len(tt.encoding) > 0 {
+			m.Header.Set("Content-Encoding", tt.encoding)
+		}
+		out, err := r.payload(m.Header, m.Data)
+		if err != nil || !bytes.Equal(out, data) {
+			t.Errorf("%s: invalid payload '%s': %v", tt.encoding, out, err)
+		}
+	}
+
+	m := nats.NewMsg("test")
+	m.Header.Set("Content-Encoding", "br")
+	if _, err := r.payload(m.Header, m.Data); err == nil {
+		t.Error("unsupported content encoding was accepted")
+	}
+
+	// Small compressed payloads exceeding max_payload_size when decompressed
+	large := bytes.Repeat(data, 100)
+	gz.Reset()
+	w = gzip.NewWriter(&gz)
+	w.Write(large)
+	w.Close()
+	enc, _ = zstd.NewWriter(nil)
+	zs = enc.EncodeAll(large, nil)
+	enc.Close()
+	for encoding, payload := range map[string][]byte{"": large, "gzip": gz.Bytes(), "zstd": zs} {
+		m := nats.NewMsg("test")
+		m.Data = payload
+		if len(encoding) > 0 {
+			m.Header.Set("Content-Encoding", encoding)
+		}
+		if _, err := r.payload(m.Header, m.Data); err == nil {
+			t.Errorf("%s: payload exceeding max_payload_size was accepted", encoding)
+		}
+	}
+}
+
+// startNatsTestServer starts a NATS server with JetStream on a random port
+func startNatsTestServer(t *testing.T) *server.Server {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("nats server cannot be created: %v", err)
+	}
+	server.Run(ns)
+	if !ns.ReadyForConnections(4 * time.Second) {
+		t.Fatal("nats server not ready for connection")
+	}
+	t.Cleanup(ns.Shutdown)
+	return ns
+}
+
+// natsTestConfig returns the receiver configuration for the server with the additional options
+func natsTestConfig(ns *server.Server, options string) json.RawMessage {
+	addr := ns.Addr().(*net.TCPAddr)
+	return json.RawMessage(fmt.Sprintf(`{"type": "nats", "address": "127.0.0.1", "port": "%d", %s}`, addr.Port, options))
+}
+
+func TestNatsReceiverQueueGroup(t *testing.T) {
+	ns := startNatsTestServer(t)
+	sink := make(chan lp.CCMessage, 100)
+	for i := range 2 {
+		r, err := NewNatsReceiver(fmt.Sprintf("testreceiver%d", i),
+			natsTestConfig(ns, `"subject": "a", "subjects": ["b"], "queue_group": "workers"`))
+		if err != nil {
+			t.Fatalf("failed to create nats receiver: %v", err)
+		}
+		r.SetSink(sink)
+		r.Start()
+		defer r.Close()
+	}
+
+	c, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("failed to connect to nats server: %v", err)
+	}
+	defer c.Close()
+	for _, m := range gen_messages(10) {
+		for _, subject := range []string{"a", "b"} {
+			c.Publish(subject, []byte(m.ToLineProtocol(nil)))
+		}
+	}
+	c.Flush()
+
+	// Each message is received by only one receiver of the group
+	for i := range 20 {
+		select {
+		case <-sink:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received only %d of 20 messages", i)
+		}
+	}
+	select {
+	case m := <-sink:
+		t.Errorf("message received twice: %s", m.ToLineProtocol(nil))
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestNatsReceiverJetStream(t *testing.T) {
+	for _, consumer := range []string{"pull", "push"} {
+		t.Run(consumer, func(t *testing.T) {
+			ns := startNatsTestServer(t)
+			c, err := nats.Connect(ns.ClientURL())
+			if err != nil {
+				t.Fatalf("failed to connect to nats server: %v", err)
+			}
+			defer c.Close()
+			js, _ := jetstream.New(c)
+			ctx := context.Background()
+			if _, err := js.CreateStream(ctx, jetstream.StreamConfig{Name: "METRICS", Subjects: []string{"metrics.>"}}); err != nil {
+				t.Fatalf("failed to create stream: %v", err)
+			}
+			deadLetters := make(chan *nats.Msg, 10)
+			if _, err := c.ChanSubscribe("dead", deadLetters); err != nil {
+				t.Fatalf("failed to subscribe: %v", err)
+			}
+
+			// Messages published before the receiver starts are not lost
+			msgs := gen_messages(5)
+			for _, m := range msgs {
+				if _, err := js.Publish(ctx, "metrics.node", []byte(m.ToLineProtocol(nil))); err != nil {
+					t.Fatalf("failed to publish: %v", err)
+				}
+			}
+			if _, err := js.Publish(ctx, "metrics.bad", []byte("not line protocol")); err != nil {
+				t.Fatalf("failed to publish: %v", err)
+			}
+
+			sink := make(chan lp.CCMessage, 10)
+			r, err := NewNatsReceiver("testreceiver", natsTestConfig(ns, fmt.Sprintf(`"subjects": ["metrics.node", "metrics.bad"], "queue_group": "workers",
+				"jetstream": {"stream": "METRICS", "durable": "receiver", "consumer": "%s", "max_deliver": 3, "retry_delay": "10ms", "dead_letter_subject": "dead"}`, consumer)))
+			if err != nil {
+				t.Fatalf("failed to create nats receiver: %v", err)
+			}
+			r.SetSink(sink)
+			r.Start()
+			defer r.Close()
+
+			for i, m := range msgs {
+				select {
+				case recv := <-sink:
+					if recv.Name() != m.Name() {
+						t.Errorf("message %d: expected %s, got %s", i, m.Name(), recv.Name())
+					}
+				case <-time.After(2 * time.Second):
+					t.Fatalf("timeout waiting for message %d", i)
+				}
+			}
+
+			select {
+			case m := <-deadLetters:
+				if string(m.Data) != "not line protocol" ||
+					m.Header.Get(NATS_RECEIVER_DEAD_LETTER_SUBJECT_HEADER) != "metrics.bad" ||
+					m.Header.Get(NATS_RECEIVER_DEAD_LETTER_DELIVERED_HEADER) != "3" {
+					t.Errorf("invalid dead letter '%s' with headers %v", m.Data, m.Header)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("timeout waiting for dead letter")
+			}
+
+			// All messages are acknowledged or terminated
+			var consumerInfo func(context.Context) (*jetstream.ConsumerInfo, error)
+			if consumer == "push" {
+				pc, err := js.PushConsumer(ctx, "METRICS", "receiver")
+				if err != nil {
+					t.Fatalf("failed to get consumer: %v", err)
+				}
+				consumerInfo = pc.Info
+			} else {
+				pc, err := js.Consumer(ctx, "METRICS", "receiver")
+				if err != nil {
+					t.Fatalf("failed to get consumer: %v", err)
+				}
+				consumerInfo = pc.Info
+			}
+			deadline := time.Now().Add(2 * time.Second)
+			for {
+				ci, err := consumerInfo(ctx)
+				if err == nil && ci.NumAckPending == 0 && ci.AckFloor.Stream == uint64(len(msgs)+1) {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("messages not acknowledged: %+v %v", ci, err)
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+		})
+	}
+}
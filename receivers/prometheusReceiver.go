@@ -21,6 +21,7 @@ import (
 
 	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
 	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	"github.com/ClusterCockpit/cc-lib/v2/util"
 )
 
 type PrometheusReceiverConfig struct {
@@ -30,6 +31,8 @@ type PrometheusReceiverConfig struct {
 	Path     string `json:"path"`
 	Interval string `json:"interval"`
 	SSL      bool   `json:"ssl"`
+
+	TLS *util.TLSConfig `json:"tls,omitempty"` // CA bundle and client certificate for HTTPS (implies ssl)
 }
 
 type PrometheusReceiver struct {
@@ -41,6 +44,7 @@ type PrometheusReceiver struct {
 	wg       sync.WaitGroup
 	ticker   *time.Ticker
 	uri      string
+	client   *http.Client
 }
 
 func (r *PrometheusReceiver) Start() {
@@ -55,7 +59,7 @@ func (r *PrometheusReceiver) Start() {
 				r.wg.Done()
 				return
 			case t := <-r.ticker.C:
-				resp, err := http.Get(r.uri)
+				resp, err := r.client.Get(r.uri)
 				if err != nil {
 					log.Fatal(err)
 				}
@@ -120,8 +124,16 @@ func NewPrometheusReceiver(name string, config json.RawMessage) (Receiver, error
 		}
 	}
 	r.meta = map[string]string{"source": r.name}
+	r.client = &http.Client{}
+	if r.config.TLS != nil {
+		tlsConfig, err := r.config.TLS.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("%s: TLS configuration: %w", r.name, err)
+		}
+		r.client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
 	proto := "http"
-	if r.config.SSL {
+	if r.config.SSL || r.config.TLS != nil {
 		proto = "https"
 	}
 	r.uri = fmt.Sprintf("%s://%s:%s/%s", proto, r.config.Addr, r.config.Port, r.config.Path)
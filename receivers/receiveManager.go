@@ -20,12 +20,17 @@ type receiveManager struct {
 	inputs []Receiver
 	output chan lp.CCMessage
 	config []json.RawMessage
+
+	control chan lp.CCMessage // control messages for the receivers
+	done    chan bool
+	wg      sync.WaitGroup
 }
 
 type ReceiveManager interface {
 	Init(wg *sync.WaitGroup, receiverConfig json.RawMessage) error
 	AddInput(name string, rawConfig json.RawMessage) error
 	AddOutput(output chan lp.CCMessage)
+	AddControlInput(input chan lp.CCMessage)
 	Start()
 	Close()
 }
@@ -35,6 +40,7 @@ func (rm *receiveManager) Init(wg *sync.WaitGroup, receiverConfig json.RawMessag
 	rm.inputs = make([]Receiver, 0)
 	rm.output = nil
 	rm.config = make([]json.RawMessage, 0)
+	rm.done = make(chan bool)
 
 	// Parse config
 	var rawConfigs map[string]json.RawMessage
@@ -63,6 +69,20 @@ func (rm *receiveManager) Start() {
 		cclog.ComponentDebug("ReceiveManager", "START", r.Name())
 		r.Start()
 	}
+
+	// Forward control messages to the receivers which handle them
+	if rm.control != nil {
+		rm.wg.Go(func() {
+			for {
+				select {
+				case msg := <-rm.control:
+					rm.dispatchControl(msg)
+				case <-rm.done:
+					return
+				}
+			}
+		})
+	}
 	cclog.ComponentDebug("ReceiveManager", "STARTED")
 }
 
@@ -99,9 +119,37 @@ func (rm *receiveManager) AddOutput(output chan lp.CCMessage) {
 	}
 }
 
+// AddControlInput sets the channel of control messages, e.g. to set the power
+// cap of a node. Control messages are passed to the receivers implementing
+// ControlReceiver, which send the results as events to the output channel.
+func (rm *receiveManager) AddControlInput(input chan lp.CCMessage) {
+	rm.control = input
+}
+
+// dispatchControl passes a control message to all receivers handling it
+func (rm *receiveManager) dispatchControl(msg lp.CCMessage) {
+	if !msg.IsControl() {
+		cclog.ComponentError("ReceiveManager", "SKIP not a control message: "+msg.String())
+		return
+	}
+	handled := false
+	for _, r := range rm.inputs {
+		if c, ok := r.(ControlReceiver); ok && c.Control(msg) {
+			handled = true
+		}
+	}
+	if !handled {
+		cclog.ComponentDebug("ReceiveManager", "SKIP no receiver for control message: "+msg.String())
+	}
+}
+
 func (rm *receiveManager) Close() {
 	cclog.ComponentDebug("ReceiveManager", "CLOSE")
 
+	// Stop forwarding control messages
+	close(rm.done)
+	rm.wg.Wait()
+
 	// Close all receivers
 	for _, r := range rm.inputs {
 		cclog.ComponentDebug("ReceiveManager", "CLOSE", r.Name())
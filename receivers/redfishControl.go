@@ -0,0 +1,137 @@
+//go:build linux
+
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	"github.com/stmcginnis/gofish"
+	"github.com/stmcginnis/gofish/schemas"
+)
+
+var _ ControlReceiver = (*RedfishReceiver)(nil)
+
+// Control queues powercap control messages of the monitored hosts
+func (r *RedfishReceiver) Control(msg lp.CCMessage) bool {
+	if msg.Name() != CONTROL_POWERCAP {
+		return false
+	}
+	hostname, _ := msg.GetTag("hostname")
+	if _, ok := r.clients[hostname]; !ok {
+		return false
+	}
+	r.control.add(msg)
+	return true
+}
+
+// applyControl applies a control message and sends the result
+func (r *RedfishReceiver) applyControl(msg lp.CCMessage) {
+	hostname, _ := msg.GetTag("hostname")
+	value, err := r.powerCap(r.clients[hostname], msg)
+	r.sendControlResult(msg, value, err)
+}
+
+// patch sends a PATCH request to a redfish resource
+func patch(c *gofish.APIClient, uri string, payload any) error {
+	resp, err := c.Patch(uri, payload)
+	if err != nil {
+		return fmt.Errorf("PATCH %s failed: %w", uri, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// powerCap gets or sets the power cap of a redfish service. The Control
+// resources of type Power are preferred over the deprecated PowerLimit of the
+// Power resource. It returns the current or new power cap.
+// See: https://redfish.dmtf.org/schemas/v1/Control.json
+// Redfish URI: /redfish/v1/Chassis/{ChassisId}/Controls/{ControlId}
+func (r *RedfishReceiver) powerCap(clientConfig *RedfishReceiverClientConfig, msg lp.CCMessage) (string, error) {
+	method, _ := msg.GetControlMethod()
+	var watts int
+	if method == "PUT" {
+		value, _ := msg.GetControlValue()
+		var err error
+		if watts, err = parsePowerCap(value); err != nil {
+			return "", err
+		}
+	}
+
+	c, err := r.connect(context.Background(), clientConfig, clientConfig.gofish.HTTPClient)
+	if err != nil {
+		return "", err
+	}
+	defer c.Logout()
+	chassisList, err := c.Service.Chassis()
+	if err != nil {
+		return "", fmt.Errorf("c.Service.Chassis() failed: %w", err)
+	}
+
+	// Power controls
+	for _, chassis := range chassisList {
+		controls, err := chassis.Controls()
+		if err != nil {
+			return "", fmt.Errorf("chassis.Controls() failed: %w", err)
+		}
+		for _, control := range controls {
+			if control.ControlType != schemas.PowerControlType {
+				continue
+			}
+			if method == "GET" {
+				if control.ControlMode == schemas.DisabledControlMode || control.SetPoint == nil {
+					return formatPowerCap(0), nil
+				}
+				return formatPowerCap(int(math.Round(*control.SetPoint))), nil
+			}
+			payload := map[string]any{"ControlMode": schemas.DisabledControlMode}
+			if watts > 0 {
+				payload = map[string]any{"ControlMode": schemas.AutomaticControlMode, "SetPoint": watts}
+			}
+			if err := patch(c, control.ODataID, payload); err != nil {
+				return "", err
+			}
+			return formatPowerCap(watts), nil
+		}
+	}
+
+	// Power limit of the first power control
+	// See: https://redfish.dmtf.org/schemas/v1/Power.json
+	for _, chassis := range chassisList {
+		power, err := chassis.Power()
+		if err != nil {
+			return "", fmt.Errorf("chassis.Power() failed: %w", err)
+		}
+		if power == nil || len(power.PowerControl) == 0 {
+			continue
+		}
+		if method == "GET" {
+			limit := power.PowerControl[0].PowerLimit.LimitInWatts
+			if limit == nil {
+				return formatPowerCap(0), nil
+			}
+			return formatPowerCap(int(math.Round(*limit))), nil
+		}
+		var limit any
+		if watts > 0 {
+			limit = watts
+		}
+		payload := map[string]any{
+			"PowerControl": []any{map[string]any{"PowerLimit": map[string]any{"LimitInWatts": limit}}},
+		}
+		if err := patch(c, power.ODataID, payload); err != nil {
+			return "", err
+		}
+		return formatPowerCap(watts), nil
+	}
+
+	return "", errors.New("redfish service has no power control")
+}
@@ -0,0 +1,528 @@
+//go:build linux
+
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	"github.com/stmcginnis/gofish"
+	"github.com/stmcginnis/gofish/schemas"
+)
+
+// Name of the events created from Redfish events and log entries
+const REDFISH_EVENT_NAME = "redfish_event"
+
+// Maximum size of a single server-sent event
+const REDFISH_SSE_MAX_EVENT_SIZE = 1024 * 1024
+
+// Maximum size of events posted to the push listener
+const REDFISH_PUSH_MAX_BODY_SIZE = 4 * 1024 * 1024
+
+// redfishEventRecord is an event record of a Redfish Event or a LogEntry
+// See: https://redfish.dmtf.org/schemas/v1/Event.json
+type redfishEventRecord struct {
+	EventType         string
+	EventId           string
+	EventTimestamp    string
+	Severity          string
+	MessageSeverity   string
+	Message           string
+	MessageId         string
+	MessageArgs       []string
+	OriginOfCondition struct {
+		ODataID string `json:"@odata.id"`
+	}
+}
+
+// redfishEventPayload is a Redfish Event with event records or a LogEntry
+// See: https://redfish.dmtf.org/schemas/v1/LogEntry.json
+type redfishEventPayload struct {
+	ODataType string `json:"@odata.type"`
+	Context   string
+	Events    []redfishEventRecord
+
+	// LogEntry
+	redfishEventRecord
+	Created string
+	Links   struct {
+		OriginOfCondition struct {
+			ODataID string `json:"@odata.id"`
+		}
+	}
+}
+
+// redfishEventRecords parses a Redfish Event or LogEntry. It returns the
+// event records and the context of the event.
+func redfishEventRecords(data []byte) ([]redfishEventRecord, string, error) {
+	var p redfishEventPayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, "", err
+	}
+	if strings.HasPrefix(p.ODataType, "#LogEntry.") {
+		rec := p.redfishEventRecord
+		if len(rec.EventTimestamp) == 0 {
+			rec.EventTimestamp = p.Created
+		}
+		if len(rec.OriginOfCondition.ODataID) == 0 {
+			rec.OriginOfCondition.ODataID = p.Links.OriginOfCondition.ODataID
+		}
+		return []redfishEventRecord{rec}, p.Context, nil
+	}
+	return p.Events, p.Context, nil
+}
+
+// sendEvent sends an event record as event message through the sink channel
+func (r *RedfishReceiver) sendEvent(clientConfig *RedfishReceiverClientConfig, rec *redfishEventRecord) {
+	severity := rec.MessageSeverity
+	if len(severity) == 0 {
+		severity = rec.Severity
+	}
+	if len(severity) == 0 {
+		severity = "OK"
+	}
+	tags := map[string]string{
+		"hostname": clientConfig.Hostname,
+		"type":     "node",
+		"severity": severity,
+		"origin":   rec.OriginOfCondition.ODataID,
+	}
+	meta := map[string]string{
+		"source":     r.name,
+		"group":      "Events",
+		"message_id": rec.MessageId,
+		"event_type": rec.EventType,
+		"event_id":   rec.EventId,
+	}
+	deleteEmptyTags(tags)
+	deleteEmptyTags(meta)
+
+	timestamp, err := time.Parse(time.RFC3339, rec.EventTimestamp)
+	if err != nil {
+		timestamp = time.Now()
+	}
+	message := rec.Message
+	if len(message) == 0 {
+		message = rec.MessageId
+	}
+
+	y, err := lp.NewEvent(REDFISH_EVENT_NAME, tags, meta, message, timestamp)
+	if err == nil {
+		mc, err := clientConfig.mp.ProcessMessage(y)
+		if err == nil && mc != nil {
+			m, err := r.mp.ProcessMessage(mc)
+			if err == nil && m != nil {
+				r.sink <- m
+			}
+		}
+	}
+}
+
+// connect connects to the redfish service of the client with the given HTTP client
+func (r *RedfishReceiver) connect(ctx context.Context, clientConfig *RedfishReceiverClientConfig, httpClient *http.Client) (*gofish.APIClient, error) {
+	gofishConfig := clientConfig.gofish
+	gofishConfig.Password = clientConfig.password.Value()
+	gofishConfig.HTTPClient = httpClient
+	c, err := gofish.ConnectContext(ctx, gofishConfig)
+	if err != nil {
+		return nil, fmt.Errorf("gofish.Connect(%s) failed: %w", gofishConfig.Endpoint, err)
+	}
+	return c, nil
+}
+
+// connectEvents connects to the redfish service of the client with the given
+// HTTP client and returns its EventService
+func (r *RedfishReceiver) connectEvents(ctx context.Context, clientConfig *RedfishReceiverClientConfig, httpClient *http.Client) (*gofish.APIClient, *schemas.EventService, error) {
+	c, err := r.connect(ctx, clientConfig, httpClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	es, err := c.Service.EventService()
+	if err == nil && es == nil {
+		err = errors.New("redfish service has no EventService")
+	}
+	if err != nil {
+		c.Logout()
+		return nil, nil, err
+	}
+	return c, es, nil
+}
+
+// streamEvents reads the server-sent event stream of a client. The stream is
+// re-opened when it is closed, e.g. by a reset of the BMC.
+func (r *RedfishReceiver) streamEvents(ctx context.Context, clientConfig *RedfishReceiverClientConfig) {
+	delay := time.Second
+	for {
+		connected, err := r.readEventStream(ctx, clientConfig)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			// The stream was closed, e.g. by a reset of the BMC
+			delay = time.Second
+			cclog.ComponentInfo(r.name, fmt.Sprintf("Event stream of %s closed: %v, reconnecting in %v", clientConfig.Hostname, err, delay))
+		} else {
+			cclog.ComponentError(r.name, fmt.Sprintf("Event stream of %s: %v, reconnecting in %v", clientConfig.Hostname, err, delay))
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay = min(2*delay, r.config.EventCheckInterval)
+	}
+}
+
+// readEventStream connects to the server-sent event stream of a client and
+// sends its events until the stream is closed. It reports whether the stream
+// was opened.
+func (r *RedfishReceiver) readEventStream(ctx context.Context, clientConfig *RedfishReceiverClientConfig) (bool, error) {
+	c, es, err := r.connectEvents(ctx, clientConfig, r.eventHTTPClient)
+	if err != nil {
+		return false, err
+	}
+	defer c.Logout()
+	if len(es.ServerSentEventURI) == 0 {
+		return false, errors.New("EventService does not support server-sent events")
+	}
+
+	headers := map[string]string{"Accept": "text/event-stream"}
+	if len(clientConfig.lastEventID) > 0 {
+		headers["Last-Event-ID"] = clientConfig.lastEventID
+	}
+	resp, err := c.GetWithHeaders(es.ServerSentEventURI, headers)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	cclog.ComponentDebug(r.name, "Connected to event stream of "+clientConfig.Hostname)
+
+	// MetricReports of the stream replace the polling of metrics while it is open
+	if t := clientConfig.telemetry; t != nil && r.config.TelemetryMode == "subscribe" {
+		ts, err := telemetryService(c)
+		if err != nil {
+			cclog.ComponentError(r.name, fmt.Sprintf("Failed to get TelemetryService of %s: %v", clientConfig.Hostname, err))
+		}
+		t.active.Store(ts != nil)
+		defer t.active.Store(false)
+	}
+
+	// Parse the server-sent events
+	// See: https://html.spec.whatwg.org/multipage/server-sent-events.html
+	var data strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), REDFISH_SSE_MAX_EVENT_SIZE)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 {
+			// Dispatch the event
+			if data.Len() > 0 {
+				if _, err := r.dispatchEvent(c, clientConfig, []byte(data.String()), false); err != nil {
+					cclog.ComponentError(r.name, fmt.Sprintf("Failed to parse event of %s: %v", clientConfig.Hostname, err))
+				}
+				data.Reset()
+			}
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "data":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+		case "id":
+			clientConfig.lastEventID = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	return true, io.EOF
+}
+
+// eventDestination returns the URL events of the client are posted to
+func (r *RedfishReceiver) eventDestination(clientConfig *RedfishReceiverClientConfig) string {
+	return strings.TrimSuffix(r.config.EventDestination, "/") + "/" + clientConfig.Hostname
+}
+
+// checkSubscription creates the push subscription of a client, if it does not
+// exist. Subscriptions are lost e.g. by a reset of the BMC.
+func (r *RedfishReceiver) checkSubscription(ctx context.Context, clientConfig *RedfishReceiverClientConfig) error {
+	c, es, err := r.connectEvents(ctx, clientConfig, clientConfig.gofish.HTTPClient)
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+
+	if len(clientConfig.subscriptions) > 0 {
+		removed := false
+		for _, uri := range clientConfig.subscriptions {
+			_, err := es.GetEventSubscription(uri)
+			if err == nil {
+				continue
+			}
+			var rfErr *schemas.Error
+			if !errors.As(err, &rfErr) || rfErr.HTTPReturnedStatusCode != http.StatusNotFound {
+				return fmt.Errorf("failed to get subscription %s: %w", uri, err)
+			}
+			removed = true
+		}
+		if !removed {
+			return nil
+		}
+		cclog.ComponentInfo(r.name, fmt.Sprintf("Subscription of %s was removed, re-creating it", clientConfig.Hostname))
+	}
+
+	// Poll metrics until MetricReports are subscribed again
+	if clientConfig.telemetry != nil {
+		clientConfig.telemetry.active.Store(false)
+	}
+
+	// Remove stale subscriptions of previous runs
+	if err := r.deleteSubscription(es, clientConfig); err != nil {
+		cclog.ComponentError(r.name, fmt.Sprintf("Failed to delete stale subscriptions of %s: %v", clientConfig.Hostname, err))
+	}
+
+	if clientConfig.doEvents {
+		uri, err := es.CreateEventSubscriptionInstance(
+			r.eventDestination(clientConfig),
+			r.config.EventRegistryPrefixes,
+			nil,
+			nil,
+			schemas.RedfishEventDestinationProtocol,
+			r.eventContext,
+			"",
+			nil,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create subscription: %w", err)
+		}
+		clientConfig.subscriptions = append(clientConfig.subscriptions, uri)
+		cclog.ComponentInfo(r.name, fmt.Sprintf("Created subscription %s of %s", uri, clientConfig.Hostname))
+	}
+
+	if clientConfig.telemetry != nil && r.config.TelemetryMode == "subscribe" {
+		uri, err := r.subscribeMetricReports(c, es, clientConfig)
+		if err != nil {
+			return err
+		}
+		if len(uri) == 0 {
+			cclog.ComponentInfo(r.name, fmt.Sprintf("%s has no TelemetryService, polling metrics", clientConfig.Hostname))
+			return nil
+		}
+		clientConfig.subscriptions = append(clientConfig.subscriptions, uri)
+		clientConfig.telemetry.active.Store(true)
+		cclog.ComponentInfo(r.name, fmt.Sprintf("Created MetricReport subscription %s of %s", uri, clientConfig.Hostname))
+	}
+	return nil
+}
+
+// forEachEventClient calls f for all clients with events, with up to fanout calls in parallel
+func (r *RedfishReceiver) forEachEventClient(f func(clientConfig *RedfishReceiverClientConfig)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, r.config.fanout)
+	for _, clientConfig := range r.eventClients {
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			f(clientConfig)
+		})
+	}
+	wg.Wait()
+}
+
+// checkSubscriptions checks the push subscriptions of all clients
+func (r *RedfishReceiver) checkSubscriptions(ctx context.Context) {
+	r.forEachEventClient(func(clientConfig *RedfishReceiverClientConfig) {
+		if err := r.checkSubscription(ctx, clientConfig); err != nil {
+			cclog.ComponentError(r.name, fmt.Sprintf("Subscription of %s: %v", clientConfig.Hostname, err))
+		}
+	})
+}
+
+// deleteSubscription removes all subscriptions with the event destination of the client
+func (r *RedfishReceiver) deleteSubscription(es *schemas.EventService, clientConfig *RedfishReceiverClientConfig) error {
+	subscriptions, err := es.Subscriptions()
+	if err != nil {
+		return err
+	}
+	destination := r.eventDestination(clientConfig)
+	for _, s := range subscriptions {
+		if s.Destination == destination {
+			if err := es.DeleteEventSubscription(s.ODataID); err != nil {
+				return err
+			}
+		}
+	}
+	clientConfig.subscriptions = nil
+	return nil
+}
+
+// deleteSubscriptions removes the push subscriptions of all clients
+func (r *RedfishReceiver) deleteSubscriptions() {
+	r.forEachEventClient(func(clientConfig *RedfishReceiverClientConfig) {
+		c, es, err := r.connectEvents(context.Background(), clientConfig, clientConfig.gofish.HTTPClient)
+		if err == nil {
+			err = r.deleteSubscription(es, clientConfig)
+			c.Logout()
+		}
+		if err != nil {
+			cclog.ComponentError(r.name, fmt.Sprintf("Failed to delete subscription of %s: %v", clientConfig.Hostname, err))
+		}
+	})
+}
+
+// handleEvent receives the events posted by the redfish services
+func (r *RedfishReceiver) handleEvent(w http.ResponseWriter, req *http.Request) {
+	clientConfig, ok := r.eventClients[req.PathValue("host")]
+	if !ok {
+		http.Error(w, "unknown host", http.StatusNotFound)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, req.Body, REDFISH_PUSH_MAX_BODY_SIZE))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ok, err = r.dispatchEvent(nil, clientConfig, data, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		http.Error(w, "invalid context", http.StatusForbidden)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// dispatchEvent sends the event records or the metric values of a MetricReport
+// received from a client. Metric properties are mapped with the redfish client
+// c, which is connected on demand if nil. With checkContext, only payloads with
+// the context of our own subscriptions are accepted; dispatchEvent reports
+// whether the payload was accepted.
+func (r *RedfishReceiver) dispatchEvent(c schemas.Client, clientConfig *RedfishReceiverClientConfig, data []byte, checkContext bool) (bool, error) {
+	if isMetricReport(data) {
+		report := new(schemas.MetricReport)
+		if err := json.Unmarshal(data, report); err != nil {
+			return false, err
+		}
+		if checkContext && report.Context != r.eventContext {
+			return false, nil
+		}
+		t := clientConfig.telemetry
+		if t == nil || r.config.TelemetryMode != "subscribe" || !r.useMetricReport(report) {
+			return true, nil
+		}
+		if c == nil && t.unresolved(report) {
+			client, err := r.connect(context.Background(), clientConfig, clientConfig.gofish.HTTPClient)
+			if err != nil {
+				return true, fmt.Errorf("failed to map metric properties: %w", err)
+			}
+			defer client.Logout()
+			c = client
+		}
+		r.sendMetricReport(c, clientConfig, report)
+		return true, nil
+	}
+
+	records, context, err := redfishEventRecords(data)
+	if err != nil {
+		return false, err
+	}
+	// Only accept events of our own subscriptions
+	if checkContext && context != r.eventContext {
+		return false, nil
+	}
+	if clientConfig.doEvents {
+		for i := range records {
+			r.sendEvent(clientConfig, &records[i])
+		}
+	}
+	return true, nil
+}
+
+// startEvents starts the event streams or the push listener and the subscription checks
+func (r *RedfishReceiver) startEvents() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.eventCancel = cancel
+
+	switch r.config.EventMode {
+	case "sse":
+		for _, clientConfig := range r.eventClients {
+			r.wg.Go(func() {
+				r.streamEvents(ctx, clientConfig)
+			})
+		}
+	case "push":
+		listener, err := net.Listen("tcp", r.config.EventListenAddress)
+		if err != nil {
+			cclog.ComponentError(r.name, fmt.Sprintf("Failed to listen for events: %v", err))
+			return
+		}
+		r.wg.Go(func() {
+			var err error
+			if r.eventServer.TLSConfig != nil {
+				err = r.eventServer.ServeTLS(listener, "", "")
+			} else {
+				err = r.eventServer.Serve(listener)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				cclog.ComponentError(r.name, fmt.Sprintf("Event listener failed: %v", err))
+			}
+		})
+		r.wg.Go(func() {
+			ticker := time.NewTicker(r.config.EventCheckInterval)
+			defer ticker.Stop()
+			for {
+				r.checkSubscriptions(ctx)
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+	}
+}
+
+// closeEvents stops the event streams and the push listener
+func (r *RedfishReceiver) closeEvents() {
+	if r.eventCancel != nil {
+		r.eventCancel()
+	}
+	if r.eventServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), r.config.HttpTimeout)
+		defer cancel()
+		r.eventServer.Shutdown(ctx)
+	}
+}
+
+// newEventContext creates the random context of the push subscriptions
+func newEventContext(name string) (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return name + " " + hex.EncodeToString(token), nil
+}
@@ -11,12 +11,15 @@ package receivers
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"maps"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
@@ -26,6 +29,7 @@ import (
 	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
 	"github.com/ClusterCockpit/cc-lib/v2/hostlist"
 	mp "github.com/ClusterCockpit/cc-lib/v2/messageProcessor"
+	"github.com/ClusterCockpit/cc-lib/v2/util"
 
 	// See: https://pkg.go.dev/github.com/stmcginnis/gofish
 	"github.com/stmcginnis/gofish"
@@ -43,13 +47,22 @@ type RedfishReceiverClientConfig struct {
 	doProcessorMetrics bool
 	doSensors          bool
 	doThermalMetrics   bool
+	doEvents           bool
+
+	// Event stream and push subscription state
+	lastEventID   string   // ID of the last server-sent event, to resume the stream
+	subscriptions []string // URIs of the push subscriptions
+
+	// TelemetryService state, nil if MetricReports are not used
+	telemetry *redfishTelemetry
 
 	skipProcessorMetricsURL map[string]bool
 
 	// readSensorURLs stores for each chassis ID a list of sensor URLs to read
 	readSensorURLs map[string][]string
 
-	gofish gofish.ClientConfig
+	gofish   gofish.ClientConfig
+	password *util.Secret // password of the gofish client, resolved on connect
 
 	mp mp.MessageProcessor
 }
@@ -64,12 +77,31 @@ type RedfishReceiver struct {
 		Interval    time.Duration
 		HttpTimeout time.Duration
 
+		DisableMetrics        bool
+		EventMode             string
+		EventListenAddress    string
+		EventDestination      string
+		EventCheckInterval    time.Duration
+		EventRegistryPrefixes []string
+		TelemetryMode         string
+		TelemetryReports      []string
+
 		// Client config for each redfish service
 		ClientConfigs []RedfishReceiverClientConfig
 	}
 
 	done chan bool      // channel to finish / stop redfish receiver
 	wg   sync.WaitGroup // wait group for redfish receiver
+
+	clients map[string]*RedfishReceiverClientConfig // client configs by host name
+	control *controlQueue                           // queue of control messages
+
+	// Event subscription
+	eventClients    map[string]*RedfishReceiverClientConfig // clients with events by host name
+	eventHTTPClient *http.Client                            // HTTP client without timeout for event streams
+	eventContext    string                                  // context of push subscriptions
+	eventServer     *http.Server                            // listener for pushed events
+	eventCancel     context.CancelFunc
 }
 
 // deleteEmptyTags removes tags or meta data tags with empty value
@@ -105,6 +137,96 @@ func (r *RedfishReceiver) sendMetric(mp mp.MessageProcessor, name string, tags m
 	}
 }
 
+// chassisTags returns the tags of metrics of a chassis
+func chassisTags(clientConfig *RedfishReceiverClientConfig, chassis *schemas.Chassis) map[string]string {
+	return map[string]string{
+		"hostname": clientConfig.Hostname,
+		"type":     "node",
+		// ChassisType shall indicate the physical form factor for the type of chassis
+		"chassis_typ": string(chassis.ChassisType),
+		// Chassis name
+		"chassis_name": chassis.Name,
+	}
+}
+
+// sensorTags returns the tags of a sensor, prefix is the kind of the sensor
+// (temperature, fan or power)
+func sensorTags(clientConfig *RedfishReceiverClientConfig, chassis *schemas.Chassis, sensor *schemas.Sensor, prefix string) map[string]string {
+	tags := chassisTags(clientConfig, chassis)
+	// ID uniquely identifies the resource
+	tags["sensor_id"] = sensor.ID
+	// The area or device to which this sensor measurement applies
+	tags[prefix+"_physical_context"] = string(sensor.PhysicalContext)
+	// Name
+	tags[prefix+"_name"] = sensor.Name
+	return tags
+}
+
+// temperatureTags returns the tags of a temperature of the thermal information
+func temperatureTags(clientConfig *RedfishReceiverClientConfig, chassis *schemas.Chassis, temperature *schemas.Temperature) map[string]string {
+	tags := chassisTags(clientConfig, chassis)
+	// ID uniquely identifies the resource
+	tags["temperature_id"] = temperature.ID
+	// MemberID shall uniquely identify the member within the collection. For
+	// services supporting Redfish v1.6 or higher, this value shall be the
+	// zero-based array index.
+	tags["temperature_member_id"] = temperature.MemberID
+	// PhysicalContext shall be a description of the affected device or region
+	// within the chassis to which this temperature measurement applies
+	tags["temperature_physical_context"] = string(temperature.PhysicalContext)
+	// Name
+	tags["temperature_name"] = temperature.Name
+	return tags
+}
+
+// fanTags returns the tags of a fan of the thermal information
+func fanTags(clientConfig *RedfishReceiverClientConfig, chassis *schemas.Chassis, fan *schemas.ThermalFan) map[string]string {
+	tags := chassisTags(clientConfig, chassis)
+	// ID uniquely identifies the resource
+	tags["fan_id"] = fan.ID
+	// MemberID shall uniquely identify the member within the collection. For
+	// services supporting Redfish v1.6 or higher, this value shall be the
+	// zero-based array index.
+	tags["fan_member_id"] = fan.MemberID
+	// PhysicalContext shall be a description of the affected device or region
+	// within the chassis to which this fan is associated
+	tags["fan_physical_context"] = string(fan.PhysicalContext)
+	// Name
+	tags["fan_name"] = fan.Name
+	return tags
+}
+
+// powerControlTags returns the tags of a power control of the power information
+func powerControlTags(clientConfig *RedfishReceiverClientConfig, chassis *schemas.Chassis, pc *schemas.PowerControl) map[string]string {
+	tags := chassisTags(clientConfig, chassis)
+	// ID uniquely identifies the resource
+	tags["power_control_id"] = pc.ID
+	// MemberID shall uniquely identify the member within the collection. For
+	// services supporting Redfish v1.6 or higher, this value shall be the
+	// zero-based array index.
+	tags["power_control_member_id"] = pc.MemberID
+	// PhysicalContext shall be a description of the affected device(s) or region
+	// within the chassis to which this power control applies.
+	tags["power_control_physical_context"] = string(pc.PhysicalContext)
+	// Name
+	tags["power_control_name"] = pc.Name
+	return tags
+}
+
+// processorTags returns the tags of a processor
+func processorTags(clientConfig *RedfishReceiverClientConfig, processor *schemas.Processor) map[string]string {
+	return map[string]string{
+		"hostname": clientConfig.Hostname,
+		"type":     "socket",
+		// ProcessorType shall contain the string which identifies the type of processor contained in this Socket
+		"processor_typ": string(processor.ProcessorType),
+		// Processor name
+		"processor_name": processor.Name,
+		// ID uniquely identifies the resource
+		"processor_id": processor.ID,
+	}
+}
+
 // readSensors reads sensors from a redfish device
 // See: https://redfish.dmtf.org/schemas/v1/Sensor.json
 // Redfish URI: /redfish/v1/Chassis/{ChassisId}/Sensors/{SensorId}
@@ -116,20 +238,7 @@ func (r *RedfishReceiver) readSensors(
 		if sensor.Reading == nil {
 			return
 		}
-		tags := map[string]string{
-			"hostname": clientConfig.Hostname,
-			"type":     "node",
-			// ChassisType shall indicate the physical form factor for the type of chassis
-			"chassis_typ": string(chassis.ChassisType),
-			// Chassis name
-			"chassis_name": chassis.Name,
-			// ID uniquely identifies the resource
-			"sensor_id": sensor.ID,
-			// The area or device to which this sensor measurement applies
-			"temperature_physical_context": string(sensor.PhysicalContext),
-			// Name
-			"temperature_name": sensor.Name,
-		}
+		tags := sensorTags(clientConfig, chassis, sensor, "temperature")
 
 		// Set meta data tags
 		meta := map[string]string{
@@ -145,20 +254,7 @@ func (r *RedfishReceiver) readSensors(
 		if sensor.Reading == nil {
 			return
 		}
-		tags := map[string]string{
-			"hostname": clientConfig.Hostname,
-			"type":     "node",
-			// ChassisType shall indicate the physical form factor for the type of chassis
-			"chassis_typ": string(chassis.ChassisType),
-			// Chassis name
-			"chassis_name": chassis.Name,
-			// ID uniquely identifies the resource
-			"sensor_id": sensor.ID,
-			// The area or device to which this sensor measurement applies
-			"fan_physical_context": string(sensor.PhysicalContext),
-			// Name
-			"fan_name": sensor.Name,
-		}
+		tags := sensorTags(clientConfig, chassis, sensor, "fan")
 
 		// Set meta data tags
 		meta := map[string]string{
@@ -175,20 +271,7 @@ func (r *RedfishReceiver) readSensors(
 			return
 		}
 		// Set tags
-		tags := map[string]string{
-			"hostname": clientConfig.Hostname,
-			"type":     "node",
-			// ChassisType shall indicate the physical form factor for the type of chassis
-			"chassis_typ": string(chassis.ChassisType),
-			// Chassis name
-			"chassis_name": chassis.Name,
-			// ID uniquely identifies the resource
-			"sensor_id": sensor.ID,
-			// The area or device to which this sensor measurement applies
-			"power_physical_context": string(sensor.PhysicalContext),
-			// Name
-			"power_name": sensor.Name,
-		}
+		tags := sensorTags(clientConfig, chassis, sensor, "power")
 
 		// Set meta data tags
 		meta := map[string]string{
@@ -333,25 +416,7 @@ func (r *RedfishReceiver) readThermalMetrics(
 			continue
 		}
 
-		tags := map[string]string{
-			"hostname": clientConfig.Hostname,
-			"type":     "node",
-			// ChassisType shall indicate the physical form factor for the type of chassis
-			"chassis_typ": string(chassis.ChassisType),
-			// Chassis name
-			"chassis_name": chassis.Name,
-			// ID uniquely identifies the resource
-			"temperature_id": temperature.ID,
-			// MemberID shall uniquely identify the member within the collection. For
-			// services supporting Redfish v1.6 or higher, this value shall be the
-			// zero-based array index.
-			"temperature_member_id": temperature.MemberID,
-			// PhysicalContext shall be a description of the affected device or region
-			// within the chassis to which this temperature measurement applies
-			"temperature_physical_context": string(temperature.PhysicalContext),
-			// Name
-			"temperature_name": temperature.Name,
-		}
+		tags := temperatureTags(clientConfig, chassis, &temperature)
 
 		// Set meta data tags
 		meta := map[string]string{
@@ -380,25 +445,7 @@ func (r *RedfishReceiver) readThermalMetrics(
 			continue
 		}
 
-		tags := map[string]string{
-			"hostname": clientConfig.Hostname,
-			"type":     "node",
-			// ChassisType shall indicate the physical form factor for the type of chassis
-			"chassis_typ": string(chassis.ChassisType),
-			// Chassis name
-			"chassis_name": chassis.Name,
-			// ID uniquely identifies the resource
-			"fan_id": fan.ID,
-			// MemberID shall uniquely identify the member within the collection. For
-			// services supporting Redfish v1.6 or higher, this value shall be the
-			// zero-based array index.
-			"fan_member_id": fan.MemberID,
-			// PhysicalContext shall be a description of the affected device or region
-			// within the chassis to which this fan is associated
-			"fan_physical_context": string(fan.PhysicalContext),
-			// Name
-			"fan_name": fan.Name,
-		}
+		tags := fanTags(clientConfig, chassis, &fan)
 
 		// Set meta data tags
 		meta := map[string]string{
@@ -479,25 +526,7 @@ func (r *RedfishReceiver) readPowerMetrics(
 		}
 
 		// Set tags
-		tags := map[string]string{
-			"hostname": clientConfig.Hostname,
-			"type":     "node",
-			// ChassisType shall indicate the physical form factor for the type of chassis
-			"chassis_typ": string(chassis.ChassisType),
-			// Chassis name
-			"chassis_name": chassis.Name,
-			// ID uniquely identifies the resource
-			"power_control_id": pc.ID,
-			// MemberID shall uniquely identify the member within the collection. For
-			// services supporting Redfish v1.6 or higher, this value shall be the
-			// zero-based array index.
-			"power_control_member_id": pc.MemberID,
-			// PhysicalContext shall be a description of the affected device(s) or region
-			// within the chassis to which this power control applies.
-			"power_control_physical_context": string(pc.PhysicalContext),
-			// Name
-			"power_control_name": pc.Name,
-		}
+		tags := powerControlTags(clientConfig, chassis, &pc)
 
 		// Set meta data tags
 		meta := map[string]string{
@@ -567,16 +596,7 @@ func (r *RedfishReceiver) readProcessorMetrics(
 	}
 
 	// Set tags
-	tags := map[string]string{
-		"hostname": clientConfig.Hostname,
-		"type":     "socket",
-		// ProcessorType shall contain the string which identifies the type of processor contained in this Socket
-		"processor_typ": string(processor.ProcessorType),
-		// Processor name
-		"processor_name": processor.Name,
-		// ID uniquely identifies the resource
-		"processor_id": processor.ID,
-	}
+	tags := processorTags(clientConfig, processor)
 
 	// Set meta data tags
 	metaPower := map[string]string{
@@ -611,9 +631,18 @@ func (r *RedfishReceiver) readProcessorMetrics(
 // It establishes a session, retrieves chassis and system lists, then calls specialized
 // read functions (readSensors, readThermalMetrics, readPowerMetrics, readProcessorMetrics)
 // based on the client configuration. Handles session management and cleanup via defer.
+// With telemetry_mode, the MetricReports of the TelemetryService are used
+// instead, if the service provides them.
 func (r *RedfishReceiver) readMetrics(clientConfig *RedfishReceiverClientConfig) error {
-	// Connect to redfish service
-	c, err := gofish.Connect(clientConfig.gofish)
+	// Subscribed MetricReports replace the polling
+	if clientConfig.telemetry != nil && clientConfig.telemetry.active.Load() {
+		return nil
+	}
+
+	// Connect to redfish service with the current password
+	gofishConfig := clientConfig.gofish
+	gofishConfig.Password = clientConfig.password.Value()
+	c, err := gofish.Connect(gofishConfig)
 	if err != nil {
 		return fmt.Errorf(
 			"readMetrics: gofish.Connect({Username: %v, Endpoint: %v, BasicAuth: %v, HttpTimeout: %v, HttpInsecure: %v}) failed: %v",
@@ -634,6 +663,17 @@ func (r *RedfishReceiver) readMetrics(clientConfig *RedfishReceiverClientConfig)
 		}
 	}
 
+	// Read the MetricReports of the TelemetryService, if available
+	if clientConfig.telemetry != nil && r.config.TelemetryMode == "poll" {
+		found, err := r.readMetricReports(c, clientConfig)
+		if err != nil {
+			cclog.ComponentError(r.name, fmt.Sprintf("Failed to read MetricReports of %s, polling metrics: %v", clientConfig.Hostname, err))
+		}
+		if found {
+			return nil
+		}
+	}
+
 	// Get all chassis managed by this service
 	isChassisListRequired := clientConfig.doSensors ||
 		clientConfig.doThermalMetrics ||
@@ -754,6 +794,18 @@ func (r *RedfishReceiver) doReadMetric() {
 func (r *RedfishReceiver) Start() {
 	cclog.ComponentDebug(r.name, "START")
 
+	// Apply the queued control messages
+	r.control.start(r.applyControl)
+
+	// Start event streams or subscriptions
+	if len(r.config.EventMode) > 0 {
+		r.startEvents()
+	}
+	if r.config.DisableMetrics {
+		cclog.ComponentDebug(r.name, "STARTED")
+		return
+	}
+
 	// Start redfish receiver
 	r.wg.Go(func() {
 		// Create ticker
@@ -786,10 +838,24 @@ func (r *RedfishReceiver) Start() {
 func (r *RedfishReceiver) Close() {
 	cclog.ComponentDebug(r.name, "CLOSE")
 
+	// Finish the queued control messages
+	r.control.close()
+
 	// Send the signal and wait
 	close(r.done)
+	r.closeEvents()
 	r.wg.Wait()
 
+	// Remove the push subscriptions
+	if r.config.EventMode == "push" {
+		r.deleteSubscriptions()
+	}
+
+	// Stop watching the passwords
+	for i := range r.config.ClientConfigs {
+		r.config.ClientConfigs[i].password.Close()
+	}
+
 	cclog.ComponentDebug(r.name, "DONE")
 }
 
@@ -817,9 +883,33 @@ func NewRedfishReceiver(name string, config json.RawMessage) (Receiver, error) {
 
 		// Default client username, password and endpoint
 		Username *string `json:"username"` // User name to authenticate with
-		Password *string `json:"password"` // Password to use for authentication
+		Password *string `json:"password"` // Password to use for authentication, may be a secret reference (env:, file: or exec:)
 		Endpoint *string `json:"endpoint"` // URL of the redfish service
 
+		// Re-read file: secret references when the files change
+		ReloadSecrets bool `json:"reload_secrets,omitempty"`
+
+		// Disable polling of metrics, e.g. to only receive events
+		DisableMetrics bool `json:"disable_metrics,omitempty"`
+		// Receive events of the redfish EventService: sse or push (default: no events)
+		EventMode string `json:"event_mode,omitempty"`
+		// Address of the HTTP listener for pushed events
+		EventListenAddress string `json:"event_listen_address,omitempty"`
+		// URL of the HTTP listener for pushed events as reached by the redfish services
+		EventDestination string `json:"event_destination,omitempty"`
+		// TLS configuration of the HTTP listener for pushed events
+		EventTLS *util.TLSConfig `json:"event_tls,omitempty"`
+		// Interval to check push subscriptions and maximum delay to reconnect event streams (default: 1m)
+		EventCheckIntervalString string `json:"event_check_interval,omitempty"`
+		// Subscribe only to events of these message registries (default: all)
+		EventRegistryPrefixes []string `json:"event_registry_prefixes,omitempty"`
+
+		// Read MetricReports of the redfish TelemetryService instead of polling
+		// metrics: poll or subscribe (default: no MetricReports)
+		TelemetryMode string `json:"telemetry_mode,omitempty"`
+		// Use only the MetricReports with these IDs (default: all)
+		TelemetryReports []string `json:"telemetry_reports,omitempty"`
+
 		// Globally disable collection of power, processor or thermal metrics
 		DisablePowerMetrics     bool `json:"disable_power_metrics"`
 		DisableProcessorMetrics bool `json:"disable_processor_metrics"`
@@ -841,6 +931,12 @@ func NewRedfishReceiver(name string, config json.RawMessage) (Receiver, error) {
 			DisableSensors          bool `json:"disable_sensors"`
 			DisableThermalMetrics   bool `json:"disable_thermal_metrics"`
 
+			// Per client disable events
+			DisableEvents bool `json:"disable_events"`
+
+			// Do not use MetricReports of the TelemetryService
+			DisableTelemetry bool `json:"disable_telemetry"`
+
 			// Per client excluded metrics
 			ExcludeMetrics   []string        `json:"exclude_metrics,omitempty"`
 			MessageProcessor json.RawMessage `json:"process_messages,omitempty"`
@@ -852,6 +948,8 @@ func NewRedfishReceiver(name string, config json.RawMessage) (Receiver, error) {
 		IntervalString:    "30s",
 		HttpTimeoutString: "10s",
 		HttpInsecure:      true,
+
+		EventCheckIntervalString: "1m",
 	}
 
 	// Set name
@@ -915,6 +1013,10 @@ func NewRedfishReceiver(name string, config json.RawMessage) (Receiver, error) {
 		Timeout:   r.config.HttpTimeout,
 		Transport: customTransport,
 	}
+	// Event streams are open for a long time
+	r.eventHTTPClient = &http.Client{
+		Transport: customTransport,
+	}
 
 	// Initialize client configurations
 	r.config.ClientConfigs = make([]RedfishReceiverClientConfig, 0)
@@ -949,16 +1051,22 @@ func NewRedfishReceiver(name string, config json.RawMessage) (Receiver, error) {
 		}
 
 		// Redfish password
-		var password string
+		var passwordRef string
 		if clientConfigJSON.Password != nil {
-			password = *clientConfigJSON.Password
+			passwordRef = *clientConfigJSON.Password
 		} else if configJSON.Password != nil {
-			password = *configJSON.Password
+			passwordRef = *configJSON.Password
 		} else {
 			err := fmt.Errorf("client config number %v requires password", i)
 			cclog.ComponentError(r.name, err)
 			return nil, err
 		}
+		password, err := util.NewSecret(passwordRef, configJSON.ReloadSecrets)
+		if err != nil {
+			err := fmt.Errorf("client config number %v: password: %w", i, err)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
 
 		// Which metrics should be collected
 		doPowerMetric := !(configJSON.DisablePowerMetrics ||
@@ -1003,6 +1111,12 @@ func NewRedfishReceiver(name string, config json.RawMessage) (Receiver, error) {
 			// Endpoint of the redfish service
 			endpoint := strings.ReplaceAll(endpoint_pattern, "%h", host)
 
+			// MetricReports of the TelemetryService
+			var telemetry *redfishTelemetry
+			if len(configJSON.TelemetryMode) > 0 && !clientConfigJSON.DisableTelemetry {
+				telemetry = &redfishTelemetry{metrics: make(map[string]*redfishTelemetryMetric)}
+			}
+
 			r.config.ClientConfigs = append(
 				r.config.ClientConfigs,
 				RedfishReceiverClientConfig{
@@ -1012,15 +1126,20 @@ func NewRedfishReceiver(name string, config json.RawMessage) (Receiver, error) {
 					doProcessorMetrics:      doProcessorMetrics,
 					doSensors:               doSensors,
 					doThermalMetrics:        doThermalMetrics,
+					doEvents:                !clientConfigJSON.DisableEvents,
 					skipProcessorMetricsURL: make(map[string]bool),
 					readSensorURLs:          map[string][]string{},
 					gofish: gofish.ClientConfig{
 						Username:   username,
-						Password:   password,
 						Endpoint:   endpoint,
 						HTTPClient: httpClient,
+						// The shared transport is configured above, gofish would
+						// modify it on each concurrent connect
+						NoModifyTransport: true,
 					},
-					mp: p,
+					password:  password,
+					telemetry: telemetry,
+					mp:        p,
 				})
 		}
 
@@ -1038,15 +1157,102 @@ func NewRedfishReceiver(name string, config json.RawMessage) (Receiver, error) {
 	}
 
 	// Check for duplicate client configurations
-	isDuplicate := make(map[string]bool)
+	r.clients = make(map[string]*RedfishReceiverClientConfig)
 	for i := range r.config.ClientConfigs {
 		host := r.config.ClientConfigs[i].Hostname
-		if isDuplicate[host] {
+		if _, ok := r.clients[host]; ok {
 			err := fmt.Errorf("found duplicate client config for host %s", host)
 			cclog.ComponentError(r.name, err)
 			return nil, err
 		}
-		isDuplicate[host] = true
+		r.clients[host] = &r.config.ClientConfigs[i]
+	}
+
+	// Setup events
+	r.config.DisableMetrics = configJSON.DisableMetrics
+	r.config.EventMode = configJSON.EventMode
+	r.config.EventRegistryPrefixes = configJSON.EventRegistryPrefixes
+	r.config.TelemetryMode = configJSON.TelemetryMode
+	r.config.TelemetryReports = configJSON.TelemetryReports
+	if len(r.config.EventMode) > 0 {
+		r.config.EventCheckInterval, err = time.ParseDuration(configJSON.EventCheckIntervalString)
+		if err != nil || r.config.EventCheckInterval <= 0 {
+			err := fmt.Errorf("invalid event_check_interval='%s': %v", configJSON.EventCheckIntervalString, err)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
+		r.eventClients = make(map[string]*RedfishReceiverClientConfig)
+		for i := range r.config.ClientConfigs {
+			// Subscribed MetricReports are received like events
+			if r.config.ClientConfigs[i].doEvents ||
+				(r.config.TelemetryMode == "subscribe" && r.config.ClientConfigs[i].telemetry != nil) {
+				r.eventClients[r.config.ClientConfigs[i].Hostname] = &r.config.ClientConfigs[i]
+			}
+		}
+	}
+	switch r.config.EventMode {
+	case "", "sse":
+	case "push":
+		r.config.EventListenAddress = configJSON.EventListenAddress
+		r.config.EventDestination = configJSON.EventDestination
+		if len(r.config.EventListenAddress) == 0 || len(r.config.EventDestination) == 0 {
+			err := errors.New("event_mode push requires event_listen_address and event_destination")
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
+		destination, err := url.Parse(r.config.EventDestination)
+		if err != nil {
+			err := fmt.Errorf("failed to parse event_destination='%s': %w", r.config.EventDestination, err)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
+		r.eventContext, err = newEventContext(r.name)
+		if err != nil {
+			return nil, err
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("POST "+strings.TrimSuffix(destination.Path, "/")+"/{host}", r.handleEvent)
+		r.eventServer = &http.Server{
+			Handler:      mux,
+			ReadTimeout:  r.config.HttpTimeout,
+			WriteTimeout: r.config.HttpTimeout,
+		}
+		if configJSON.EventTLS != nil {
+			r.eventServer.TLSConfig, err = configJSON.EventTLS.ServerConfig()
+			if err != nil {
+				err := fmt.Errorf("event_tls: %w", err)
+				cclog.ComponentError(r.name, err)
+				return nil, err
+			}
+		}
+	default:
+		err := fmt.Errorf("unknown event_mode '%s'", r.config.EventMode)
+		cclog.ComponentError(r.name, err)
+		return nil, err
+	}
+	if r.config.DisableMetrics && len(r.config.EventMode) == 0 {
+		err := errors.New("disable_metrics requires event_mode")
+		cclog.ComponentError(r.name, err)
+		return nil, err
+	}
+	switch r.config.TelemetryMode {
+	case "":
+	case "poll":
+		if r.config.DisableMetrics {
+			err := errors.New("telemetry_mode poll conflicts with disable_metrics")
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
+	case "subscribe":
+		if len(r.config.EventMode) == 0 {
+			err := errors.New("telemetry_mode subscribe requires event_mode")
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
+	default:
+		err := fmt.Errorf("unknown telemetry_mode '%s'", r.config.TelemetryMode)
+		cclog.ComponentError(r.name, err)
+		return nil, err
 	}
 
 	// Give some basic info about redfish receiver status
@@ -1054,5 +1260,8 @@ func NewRedfishReceiver(name string, config json.RawMessage) (Receiver, error) {
 	cclog.ComponentInfo(r.name, "Monitoring interval:", r.config.Interval)
 	cclog.ComponentInfo(r.name, "Monitoring parallel fanout:", r.config.fanout)
 
+	// Apply control messages with the same parallel fanout
+	r.control = newControlQueue(r.config.fanout)
+
 	return r, nil
 }
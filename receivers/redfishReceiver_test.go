@@ -0,0 +1,634 @@
+//go:build linux
+
+package receivers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+)
+
+// mockRedfish is a minimal redfish service with sessions and an EventService
+type mockRedfish struct {
+	*httptest.Server
+	mux *http.ServeMux
+
+	lock          sync.Mutex
+	resources     map[string]any // GET responses by path
+	subscriptions map[string]map[string]any
+	nextID        int
+	subscribed    chan map[string]any // created subscriptions
+	sseEvents     chan string         // events sent on the SSE stream, closed streams on empty string
+	lastEventIDs  []string            // Last-Event-ID headers of the SSE requests
+}
+
+func newMockRedfish(t *testing.T) *mockRedfish {
+	t.Helper()
+	m := &mockRedfish{
+		mux:           http.NewServeMux(),
+		subscriptions: make(map[string]map[string]any),
+		subscribed:    make(chan map[string]any, 10),
+		sseEvents:     make(chan string, 10),
+		resources: map[string]any{
+			"/redfish/v1/": map[string]any{
+				"@odata.id":    "/redfish/v1/",
+				"Id":           "RootService",
+				"Links":        map[string]any{"Sessions": map[string]any{"@odata.id": "/redfish/v1/SessionService/Sessions"}},
+				"EventService": map[string]any{"@odata.id": "/redfish/v1/EventService"},
+			},
+			"/redfish/v1/EventService": map[string]any{
+				"@odata.id":          "/redfish/v1/EventService",
+				"Id":                 "EventService",
+				"ServerSentEventUri": "/redfish/v1/EventService/SSE",
+				"Subscriptions":      map[string]any{"@odata.id": "/redfish/v1/EventService/Subscriptions"},
+			},
+		},
+	}
+	m.mux.HandleFunc("POST /redfish/v1/SessionService/Sessions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Auth-Token", "token")
+		w.Header().Set("Location", "/redfish/v1/SessionService/Sessions/1")
+		w.WriteHeader(http.StatusCreated)
+	})
+	m.mux.HandleFunc("DELETE /redfish/v1/SessionService/Sessions/1", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	m.mux.HandleFunc("GET /redfish/v1/EventService/SSE", func(w http.ResponseWriter, r *http.Request) {
+		m.lock.Lock()
+		m.lastEventIDs = append(m.lastEventIDs, r.Header.Get("Last-Event-ID"))
+		m.lock.Unlock()
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case event := <-m.sseEvents:
+				if len(event) == 0 {
+					// Simulate a reset of the BMC
+					return
+				}
+				fmt.Fprint(w, event)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+	m.mux.HandleFunc("GET /redfish/v1/EventService/Subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		members := []any{}
+		for uri := range m.subscriptions {
+			members = append(members, map[string]any{"@odata.id": uri})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"Members": members, "Members@odata.count": len(members)})
+	})
+	m.mux.HandleFunc("POST /redfish/v1/EventService/Subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		var s map[string]any
+		json.NewDecoder(r.Body).Decode(&s)
+		m.lock.Lock()
+		m.nextID++
+		uri := fmt.Sprintf("/redfish/v1/EventService/Subscriptions/%d", m.nextID)
+		s["@odata.id"] = uri
+		s["Id"] = fmt.Sprint(m.nextID)
+		m.subscriptions[uri] = s
+		m.lock.Unlock()
+		m.subscribed <- s
+		w.Header().Set("Location", uri)
+		w.WriteHeader(http.StatusCreated)
+	})
+	m.mux.HandleFunc("DELETE /redfish/v1/EventService/Subscriptions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		m.lock.Lock()
+		delete(m.subscriptions, r.URL.Path)
+		m.lock.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	m.mux.HandleFunc("PATCH /", func(w http.ResponseWriter, r *http.Request) {
+		var patch map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		res, ok := m.resources[r.URL.Path].(map[string]any)
+		if !ok {
+			http.Error(w, `{"error": {"code": "Base.1.0.ResourceMissingAtURI", "message": "not found"}}`, http.StatusNotFound)
+			return
+		}
+		mergePatch(res, patch)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	m.mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		res, ok := m.resources[r.URL.Path]
+		if !ok {
+			res, ok = m.subscriptions[r.URL.Path]
+		}
+		if !ok {
+			http.Error(w, `{"error": {"code": "Base.1.0.ResourceMissingAtURI", "message": "not found"}}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+	m.Server = httptest.NewServer(m.mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// mergePatch applies a PATCH request to a resource. Arrays of the same length
+// are patched element by element.
+func mergePatch(dst, src map[string]any) {
+	for k, v := range src {
+		switch v := v.(type) {
+		case map[string]any:
+			if d, ok := dst[k].(map[string]any); ok {
+				mergePatch(d, v)
+				continue
+			}
+		case []any:
+			if d, ok := dst[k].([]any); ok && len(d) == len(v) {
+				for i := range v {
+					dm, ok1 := d[i].(map[string]any)
+					vm, ok2 := v[i].(map[string]any)
+					if ok1 && ok2 {
+						mergePatch(dm, vm)
+					} else {
+						d[i] = v[i]
+					}
+				}
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+// resource returns a copy of a resource
+func (m *mockRedfish) resource(path string) map[string]any {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var res map[string]any
+	data, _ := json.Marshal(m.resources[path])
+	json.Unmarshal(data, &res)
+	return res
+}
+
+// resetSubscriptions removes all subscriptions like a reset of the BMC
+func (m *mockRedfish) resetSubscriptions() {
+	m.lock.Lock()
+	clear(m.subscriptions)
+	m.lock.Unlock()
+}
+
+// receiveEvent waits for an event of the receiver
+func receiveEvent(t *testing.T, sink chan lp.CCMessage) lp.CCMessage {
+	t.Helper()
+	select {
+	case m := <-sink:
+		if !m.IsEvent() || m.Name() != REDFISH_EVENT_NAME {
+			t.Fatalf("unexpected message %s", m.ToLineProtocol(nil))
+		}
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+	return nil
+}
+
+func TestRedfishEventRecords(t *testing.T) {
+	records, context, err := redfishEventRecords([]byte(`{
+		"@odata.type": "#Event.v1_7_0.Event",
+		"Context": "ctx",
+		"Events": [
+			{"EventType": "Alert", "MessageId": "Power.1.0.PowerSupplyFailed", "MessageSeverity": "Critical",
+			 "Message": "Power supply 1 failed", "EventTimestamp": "2024-01-31T12:00:00Z",
+			 "OriginOfCondition": {"@odata.id": "/redfish/v1/Chassis/1/PowerSubsystem/PowerSupplies/1"}},
+			{"EventType": "Alert", "MessageId": "Thermal.1.0.Trip", "Severity": "Warning"}
+		]}`))
+	if err != nil || context != "ctx" || len(records) != 2 {
+		t.Fatalf("failed to parse event: %v %s %+v", err, context, records)
+	}
+	if records[0].MessageSeverity != "Critical" || records[0].OriginOfCondition.ODataID != "/redfish/v1/Chassis/1/PowerSubsystem/PowerSupplies/1" {
+		t.Errorf("invalid event record %+v", records[0])
+	}
+
+	records, _, err = redfishEventRecords([]byte(`{
+		"@odata.type": "#LogEntry.v1_15_0.LogEntry",
+		"Id": "42", "EntryType": "Event", "Severity": "Warning", "Message": "Fan 2 degraded",
+		"MessageId": "Fan.1.0.Degraded", "Created": "2024-01-31T12:00:00Z",
+		"Links": {"OriginOfCondition": {"@odata.id": "/redfish/v1/Chassis/1/ThermalSubsystem/Fans/2"}}}`))
+	if err != nil || len(records) != 1 {
+		t.Fatalf("failed to parse log entry: %v %+v", err, records)
+	}
+	if records[0].Severity != "Warning" || records[0].EventTimestamp != "2024-01-31T12:00:00Z" ||
+		records[0].OriginOfCondition.ODataID != "/redfish/v1/Chassis/1/ThermalSubsystem/Fans/2" {
+		t.Errorf("invalid log entry record %+v", records[0])
+	}
+}
+
+func TestRedfishReceiverSSE(t *testing.T) {
+	m := newMockRedfish(t)
+	r, err := NewRedfishReceiver("test", json.RawMessage(fmt.Sprintf(`{
+		"type": "redfish", "username": "admin", "password": "secret", "endpoint": "%s",
+		"disable_metrics": true, "event_mode": "sse", "event_check_interval": "100ms",
+		"client_config": [{"host_list": "node01"}]}`, m.URL)))
+	if err != nil {
+		t.Fatalf("failed to create redfish receiver: %v", err)
+	}
+	sink := make(chan lp.CCMessage, 10)
+	r.SetSink(sink)
+	r.Start()
+	defer r.Close()
+
+	m.sseEvents <- "id: 1\ndata: {\"@odata.type\": \"#Event.v1_7_0.Event\", \"Events\": [{\"MessageId\": \"Power.1.0.PowerSupplyFailed\",\n" +
+		"data: \"MessageSeverity\": \"Critical\", \"Message\": \"Power supply 1 failed\", \"OriginOfCondition\": {\"@odata.id\": \"/redfish/v1/Chassis/1\"}}]}\n\n"
+	e := receiveEvent(t, sink)
+	if v, _ := e.GetTag("severity"); v != "Critical" {
+		t.Errorf("invalid severity '%s'", v)
+	}
+	if v, _ := e.GetTag("origin"); v != "/redfish/v1/Chassis/1" {
+		t.Errorf("invalid origin '%s'", v)
+	}
+	if v, _ := e.GetTag("hostname"); v != "node01" {
+		t.Errorf("invalid hostname '%s'", v)
+	}
+	if v, _ := e.GetEventValue(); v != "Power supply 1 failed" {
+		t.Errorf("invalid event '%s'", v)
+	}
+
+	// The stream is re-opened after a reset and resumed after the last event
+	m.sseEvents <- ""
+	m.sseEvents <- ": keep-alive\n\nid: 2\ndata: {\"@odata.type\": \"#LogEntry.v1_15_0.LogEntry\", \"Severity\": \"Warning\", \"Message\": \"Fan 2 degraded\"}\n\n"
+	e = receiveEvent(t, sink)
+	if v, _ := e.GetTag("severity"); v != "Warning" {
+		t.Errorf("invalid severity '%s'", v)
+	}
+	m.lock.Lock()
+	if len(m.lastEventIDs) != 2 || m.lastEventIDs[1] != "1" {
+		t.Errorf("invalid Last-Event-ID headers %v", m.lastEventIDs)
+	}
+	m.lock.Unlock()
+}
+
+func TestRedfishReceiverPush(t *testing.T) {
+	m := newMockRedfish(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	r, err := NewRedfishReceiver("test", json.RawMessage(fmt.Sprintf(`{
+		"type": "redfish", "username": "admin", "password": "secret", "endpoint": "%s",
+		"disable_metrics": true, "event_mode": "push", "event_check_interval": "100ms",
+		"event_listen_address": "%s", "event_destination": "http://%s/events",
+		"client_config": [{"host_list": "node01"}]}`, m.URL, addr, addr)))
+	if err != nil {
+		t.Fatalf("failed to create redfish receiver: %v", err)
+	}
+	sink := make(chan lp.CCMessage, 10)
+	r.SetSink(sink)
+	r.Start()
+
+	var s map[string]any
+	select {
+	case s = <-m.subscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for subscription")
+	}
+	destination := s["Destination"].(string)
+	if destination != "http://"+addr+"/events/node01" {
+		t.Fatalf("invalid destination %s", destination)
+	}
+
+	post := func(context string) int {
+		body, _ := json.Marshal(map[string]any{
+			"@odata.type": "#Event.v1_7_0.Event",
+			"Context":     context,
+			"Events":      []any{map[string]any{"MessageId": "Thermal.1.0.Trip", "MessageSeverity": "Critical", "Message": "Thermal trip"}},
+		})
+		resp, err := http.Post(destination, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to post event: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post("invalid"); code != http.StatusForbidden {
+		t.Errorf("event with invalid context was answered with %d", code)
+	}
+	if code := post(s["Context"].(string)); code != http.StatusNoContent {
+		t.Errorf("event was answered with %d", code)
+	}
+	if e := receiveEvent(t, sink); e != nil {
+		if v, _ := e.GetEventValue(); v != "Thermal trip" {
+			t.Errorf("invalid event '%s'", v)
+		}
+	}
+
+	// The subscription is re-created after a reset
+	m.resetSubscriptions()
+	select {
+	case <-m.subscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription was not re-created")
+	}
+
+	// The subscription is removed on close
+	r.Close()
+	m.lock.Lock()
+	if len(m.subscriptions) != 0 {
+		t.Errorf("subscriptions not removed: %v", m.subscriptions)
+	}
+	m.lock.Unlock()
+}
+
+// addPlatform adds a chassis with thermal, power and sensor information and a
+// processor, optionally with a TelemetryService with a MetricReport of them
+func (m *mockRedfish) addPlatform(telemetry bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	root := m.resources["/redfish/v1/"].(map[string]any)
+	root["Chassis"] = map[string]any{"@odata.id": "/redfish/v1/Chassis"}
+	m.resources["/redfish/v1/Chassis"] = map[string]any{
+		"Members": []any{map[string]any{"@odata.id": "/redfish/v1/Chassis/1"}},
+	}
+	m.resources["/redfish/v1/Chassis/1"] = map[string]any{
+		"@odata.id":   "/redfish/v1/Chassis/1",
+		"Id":          "1",
+		"Name":        "Computer System Chassis",
+		"ChassisType": "RackMount",
+		"Thermal":     map[string]any{"@odata.id": "/redfish/v1/Chassis/1/Thermal"},
+		"Power":       map[string]any{"@odata.id": "/redfish/v1/Chassis/1/Power"},
+	}
+	m.resources["/redfish/v1/Chassis/1/Thermal"] = map[string]any{
+		"@odata.id": "/redfish/v1/Chassis/1/Thermal",
+		"Temperatures": []any{map[string]any{
+			"MemberId": "0", "Name": "CPU1 Temp", "PhysicalContext": "CPU", "ReadingCelsius": 45,
+		}},
+		"Fans": []any{map[string]any{
+			"MemberId": "0", "Name": "Fan1", "PhysicalContext": "SystemBoard", "Reading": 3000,
+			"ReadingUnits": "RPM", "Status": map[string]any{"State": "Enabled"},
+		}},
+	}
+	m.resources["/redfish/v1/Chassis/1/Power"] = map[string]any{
+		"@odata.id": "/redfish/v1/Chassis/1/Power",
+		"PowerControl": []any{map[string]any{
+			"MemberId": "0", "Name": "System Power Control", "PhysicalContext": "Chassis", "PowerConsumedWatts": 250,
+			"PowerMetrics": map[string]any{"IntervalInMin": 1, "AverageConsumedWatts": 240},
+		}},
+	}
+	m.resources["/redfish/v1/Chassis/1/Sensors/PS1Power"] = map[string]any{
+		"@odata.id": "/redfish/v1/Chassis/1/Sensors/PS1Power",
+		"Id":        "PS1Power", "Name": "PS1 Power", "PhysicalContext": "PowerSupply",
+		"ReadingType": "Power", "ReadingUnits": "Watts", "Reading": 120,
+	}
+	m.resources["/redfish/v1/Systems/1/Processors/CPU1"] = map[string]any{
+		"@odata.id": "/redfish/v1/Systems/1/Processors/CPU1",
+		"Id":        "CPU1", "Name": "Processor 1", "ProcessorType": "CPU",
+	}
+	if !telemetry {
+		return
+	}
+	root["TelemetryService"] = map[string]any{"@odata.id": "/redfish/v1/TelemetryService"}
+	m.resources["/redfish/v1/TelemetryService"] = map[string]any{
+		"@odata.id":     "/redfish/v1/TelemetryService",
+		"Id":            "TelemetryService",
+		"Status":        map[string]any{"State": "Enabled"},
+		"MetricReports": map[string]any{"@odata.id": "/redfish/v1/TelemetryService/MetricReports"},
+	}
+	m.resources["/redfish/v1/TelemetryService/MetricReports"] = map[string]any{
+		"Members": []any{map[string]any{"@odata.id": "/redfish/v1/TelemetryService/MetricReports/PlatformMetrics"}},
+	}
+	m.resources["/redfish/v1/TelemetryService/MetricReports/PlatformMetrics"] = mockMetricReport("")
+}
+
+// mockMetricReport returns a MetricReport of the metrics of addPlatform
+func mockMetricReport(context string) map[string]any {
+	value := func(property, value string) map[string]any {
+		return map[string]any{"MetricProperty": property, "MetricValue": value}
+	}
+	return map[string]any{
+		"@odata.id":   "/redfish/v1/TelemetryService/MetricReports/PlatformMetrics",
+		"@odata.type": "#MetricReport.v1_5_0.MetricReport",
+		"Id":          "PlatformMetrics",
+		"Context":     context,
+		"Timestamp":   "2024-01-31T12:00:00Z",
+		"MetricValues": []any{
+			value("/redfish/v1/Chassis/1/Thermal#/Temperatures/0/ReadingCelsius", "45"),
+			value("/redfish/v1/Chassis/1/Thermal#/Fans/0/Reading", "3000"),
+			value("/redfish/v1/Chassis/1/Power#/PowerControl/0/PowerConsumedWatts", "250"),
+			value("/redfish/v1/Chassis/1/Power#/PowerControl/0/PowerMetrics/AverageConsumedWatts", "240"),
+			value("/redfish/v1/Chassis/1/Sensors/PS1Power#/Reading", "120"),
+			value("/redfish/v1/Systems/1/Processors/CPU1/ProcessorMetrics#/ConsumedPowerWatt", "95"),
+			value("/redfish/v1/Systems/1/Processors/CPU1/ProcessorMetrics#/TemperatureCelsius", "null"),
+			value("/redfish/v1/Chassis/1/Oem#/Unknown", "1"),
+		},
+	}
+}
+
+// receiveMetrics waits for n metrics of the receiver and returns them by name
+func receiveMetrics(t *testing.T, sink chan lp.CCMessage, n int) map[string]lp.CCMessage {
+	t.Helper()
+	metrics := make(map[string]lp.CCMessage)
+	for range n {
+		select {
+		case m := <-sink:
+			if !m.IsMetric() {
+				t.Fatalf("unexpected message %s", m.ToLineProtocol(nil))
+			}
+			metrics[m.Name()] = m
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for metrics, received %v", slices.Collect(maps.Keys(metrics)))
+		}
+	}
+	return metrics
+}
+
+func TestRedfishReceiverTelemetryPoll(t *testing.T) {
+	m := newMockRedfish(t)
+	m.addPlatform(true)
+	r, err := NewRedfishReceiver("test", json.RawMessage(fmt.Sprintf(`{
+		"type": "redfish", "username": "admin", "password": "secret", "endpoint": "%s",
+		"interval": "1h", "telemetry_mode": "poll", "exclude_metrics": ["max_consumed_watts"],
+		"client_config": [{"host_list": "node01"}]}`, m.URL)))
+	if err != nil {
+		t.Fatalf("failed to create redfish receiver: %v", err)
+	}
+	sink := make(chan lp.CCMessage, 10)
+	r.SetSink(sink)
+	r.Start()
+	metrics := receiveMetrics(t, sink, 6)
+	r.Close()
+	if len(sink) > 0 {
+		t.Errorf("unexpected message %s", (<-sink).ToLineProtocol(nil))
+	}
+
+	for _, name := range []string{"temperature", "fan_speed", "consumed_watts", "average_consumed_watts", "power", "consumed_power"} {
+		if _, ok := metrics[name]; !ok {
+			t.Errorf("missing metric %s", name)
+		}
+	}
+	if v, _ := metrics["consumed_watts"].GetMeta("interval_in_minutes"); v != "1" {
+		t.Errorf("invalid interval_in_minutes '%s'", v)
+	}
+	if v, _ := metrics["power"].GetTag("power_name"); v != "PS1 Power" {
+		t.Errorf("invalid power_name '%s'", v)
+	}
+	if v, _ := metrics["consumed_power"].GetTag("type"); v != "socket" {
+		t.Errorf("invalid type '%s' of processor metric", v)
+	}
+	if ts := metrics["temperature"].Time(); !ts.Equal(time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("invalid timestamp %v", ts)
+	}
+
+	// Without TelemetryService, the metrics are polled with the same tags
+	m = newMockRedfish(t)
+	m.addPlatform(false)
+	r, err = NewRedfishReceiver("test", json.RawMessage(fmt.Sprintf(`{
+		"type": "redfish", "username": "admin", "password": "secret", "endpoint": "%s",
+		"interval": "1h", "telemetry_mode": "poll", "disable_power_metrics": true,
+		"disable_processor_metrics": true, "disable_sensors": true,
+		"client_config": [{"host_list": "node01"}]}`, m.URL)))
+	if err != nil {
+		t.Fatalf("failed to create redfish receiver: %v", err)
+	}
+	r.SetSink(sink)
+	r.Start()
+	polled := receiveMetrics(t, sink, 2)
+	r.Close()
+	for _, name := range []string{"temperature", "fan_speed"} {
+		if p, ok := polled[name]; !ok {
+			t.Errorf("missing polled metric %s", name)
+		} else if !maps.Equal(p.Tags(), metrics[name].Tags()) {
+			t.Errorf("tags of %s differ: polled %v, MetricReport %v", name, p.Tags(), metrics[name].Tags())
+		}
+	}
+}
+
+func TestRedfishReceiverTelemetrySubscribe(t *testing.T) {
+	m := newMockRedfish(t)
+	m.addPlatform(true)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	r, err := NewRedfishReceiver("test", json.RawMessage(fmt.Sprintf(`{
+		"type": "redfish", "username": "admin", "password": "secret", "endpoint": "%s",
+		"disable_metrics": true, "event_mode": "push", "event_check_interval": "100ms",
+		"event_listen_address": "%s", "event_destination": "http://%s/events",
+		"telemetry_mode": "subscribe", "client_config": [{"host_list": "node01", "disable_events": true}]}`, m.URL, addr, addr)))
+	if err != nil {
+		t.Fatalf("failed to create redfish receiver: %v", err)
+	}
+	sink := make(chan lp.CCMessage, 10)
+	r.SetSink(sink)
+	r.Start()
+	defer r.Close()
+
+	// Only the MetricReports are subscribed
+	var s map[string]any
+	select {
+	case s = <-m.subscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for subscription")
+	}
+	if s["EventFormatType"] != "MetricReport" {
+		t.Fatalf("invalid subscription %v", s)
+	}
+
+	body, _ := json.Marshal(mockMetricReport(s["Context"].(string)))
+	resp, err := http.Post(s["Destination"].(string), "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to post MetricReport: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("MetricReport was answered with %d", resp.StatusCode)
+	}
+	metrics := receiveMetrics(t, sink, 6)
+	if v, _ := metrics["fan_speed"].GetTag("fan_name"); v != "Fan1" {
+		t.Errorf("invalid fan_name '%s'", v)
+	}
+	if v, _ := metrics["fan_speed"].GetMeta("unit"); v != "RPM" {
+		t.Errorf("invalid unit '%s'", v)
+	}
+}
+
+func TestRedfishReceiverPowerCap(t *testing.T) {
+	m := newMockRedfish(t)
+	m.addPlatform(false)
+	m.lock.Lock()
+	m.resources["/redfish/v1/Chassis/1"].(map[string]any)["Controls"] = map[string]any{"@odata.id": "/redfish/v1/Chassis/1/Controls"}
+	m.resources["/redfish/v1/Chassis/1/Controls"] = map[string]any{
+		"Members": []any{map[string]any{"@odata.id": "/redfish/v1/Chassis/1/Controls/PowerLimit"}},
+	}
+	m.resources["/redfish/v1/Chassis/1/Controls/PowerLimit"] = map[string]any{
+		"@odata.id": "/redfish/v1/Chassis/1/Controls/PowerLimit",
+		"Id":        "PowerLimit", "ControlType": "Power", "ControlMode": "Automatic", "SetPoint": 400, "SetPointUnits": "W",
+	}
+	m.lock.Unlock()
+
+	recv, err := NewRedfishReceiver("test", json.RawMessage(fmt.Sprintf(`{
+		"type": "redfish", "username": "admin", "password": "secret", "endpoint": "%s",
+		"client_config": [{"host_list": "node01"}]}`, m.URL)))
+	if err != nil {
+		t.Fatalf("failed to create redfish receiver: %v", err)
+	}
+	sink := make(chan lp.CCMessage, 100)
+	recv.SetSink(sink)
+	recv.Start()
+	defer recv.Close()
+	r := recv.(ControlReceiver)
+
+	// Other controls and hosts are not handled
+	msg, _ := lp.NewPutControl("frequency", map[string]string{"hostname": "node01"}, nil, "2000", time.Now())
+	if r.Control(msg) {
+		t.Error("unsupported control was accepted")
+	}
+	msg, _ = lp.NewPutControl(CONTROL_POWERCAP, map[string]string{"hostname": "node02"}, nil, "300", time.Now())
+	if r.Control(msg) {
+		t.Error("control of unknown host was accepted")
+	}
+
+	// Control resource
+	testPowerCap(t, r, sink, "GET", "", "ok", "400")
+	testPowerCap(t, r, sink, "PUT", "300", "ok", "300")
+	if res := m.resource("/redfish/v1/Chassis/1/Controls/PowerLimit"); res["SetPoint"] != 300.0 || res["ControlMode"] != "Automatic" {
+		t.Errorf("power limit not set: %v", res)
+	}
+	testPowerCap(t, r, sink, "PUT", "off", "ok", "off")
+	if res := m.resource("/redfish/v1/Chassis/1/Controls/PowerLimit"); res["ControlMode"] != "Disabled" {
+		t.Errorf("power limit not disabled: %v", res)
+	}
+	testPowerCap(t, r, sink, "GET", "", "ok", "off")
+	testPowerCap(t, r, sink, "PUT", "lots", "failed", "")
+
+	// PowerLimit of the Power resource
+	m.lock.Lock()
+	delete(m.resources["/redfish/v1/Chassis/1"].(map[string]any), "Controls")
+	m.lock.Unlock()
+	testPowerCap(t, r, sink, "GET", "", "ok", "off")
+	testPowerCap(t, r, sink, "PUT", "250", "ok", "250")
+	power := m.resource("/redfish/v1/Chassis/1/Power")
+	limit := power["PowerControl"].([]any)[0].(map[string]any)["PowerLimit"].(map[string]any)["LimitInWatts"]
+	if limit != 250.0 {
+		t.Errorf("power limit not set: %v", power)
+	}
+	testPowerCap(t, r, sink, "GET", "", "ok", "250")
+}
@@ -0,0 +1,308 @@
+//go:build linux
+
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	"github.com/stmcginnis/gofish"
+	"github.com/stmcginnis/gofish/schemas"
+)
+
+// Metric properties of MetricReports which are mapped to metrics
+// See: https://redfish.dmtf.org/schemas/v1/MetricReport.json
+var (
+	// Redfish URI: /redfish/v1/Chassis/{ChassisId}/Thermal#/Temperatures/{i}/ReadingCelsius
+	redfishThermalProperty = regexp.MustCompile(`^(/redfish/v1/Chassis/[^/#]+)/Thermal#/(Temperatures|Fans)/(\d+)/(ReadingCelsius|Reading)$`)
+	// Redfish URI: /redfish/v1/Chassis/{ChassisId}/Power#/PowerControl/{i}/PowerConsumedWatts
+	redfishPowerProperty = regexp.MustCompile(`^(/redfish/v1/Chassis/[^/#]+)/Power#/PowerControl/(\d+)/(PowerConsumedWatts|PowerMetrics/(?:Average|Min|Max)ConsumedWatts)$`)
+	// Redfish URI: /redfish/v1/Chassis/{ChassisId}/Sensors/{SensorId}#/Reading
+	redfishSensorProperty = regexp.MustCompile(`^(/redfish/v1/Chassis/[^/#]+)/Sensors/[^/#]+(?:#/Reading)?$`)
+	// Redfish URI: /redfish/v1/Systems/{ComputerSystemId}/Processors/{ProcessorId}/ProcessorMetrics#/ConsumedPowerWatt
+	redfishProcessorProperty = regexp.MustCompile(`^(/redfish/v1/Systems/[^/#]+/Processors/[^/#]+)/ProcessorMetrics#/(ConsumedPowerWatt|TemperatureCelsius)$`)
+)
+
+// Metric names of the power control properties
+var redfishPowerControlMetrics = map[string]string{
+	"PowerConsumedWatts":                "consumed_watts",
+	"PowerMetrics/AverageConsumedWatts": "average_consumed_watts",
+	"PowerMetrics/MinConsumedWatts":     "min_consumed_watts",
+	"PowerMetrics/MaxConsumedWatts":     "max_consumed_watts",
+}
+
+// redfishTelemetry is the TelemetryService state of a client
+type redfishTelemetry struct {
+	active  atomic.Bool // subscribed MetricReports replace the polling of metrics
+	lock    sync.Mutex
+	metrics map[string]*redfishTelemetryMetric // metrics by MetricProperty, nil for unsupported properties
+}
+
+// redfishTelemetryMetric is the metric a MetricProperty is mapped to
+type redfishTelemetryMetric struct {
+	name string
+	tags map[string]string
+	meta map[string]string
+}
+
+// unresolved reports whether the report contains metric properties which are
+// not mapped yet
+func (t *redfishTelemetry) unresolved(report *schemas.MetricReport) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, v := range report.MetricValues {
+		if _, ok := t.metrics[v.MetricProperty]; !ok && len(v.MetricProperty) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// isMetricReport reports whether an event payload is a MetricReport
+func isMetricReport(data []byte) bool {
+	var p struct {
+		ODataType string `json:"@odata.type"`
+	}
+	return json.Unmarshal(data, &p) == nil && strings.HasPrefix(p.ODataType, "#MetricReport.")
+}
+
+// telemetryService returns the TelemetryService of a redfish service or nil, if
+// the service is absent or disabled
+func telemetryService(c *gofish.APIClient) (*schemas.TelemetryService, error) {
+	ts, err := c.Service.TelemetryService()
+	if err != nil || ts == nil {
+		return nil, err
+	}
+	if ts.Status.State == schemas.DisabledState {
+		return nil, nil
+	}
+	return ts, nil
+}
+
+// useMetricReport reports whether the metric values of a report are used
+func (r *RedfishReceiver) useMetricReport(report *schemas.MetricReport) bool {
+	return len(r.config.TelemetryReports) == 0 || slices.Contains(r.config.TelemetryReports, report.ID)
+}
+
+// resolveMetricProperty maps a metric property to a metric with the name and
+// tags of the polled metric. It returns nil for unsupported or excluded
+// properties.
+func (r *RedfishReceiver) resolveMetricProperty(
+	c schemas.Client,
+	clientConfig *RedfishReceiverClientConfig,
+	property string,
+) (*redfishTelemetryMetric, error) {
+	resource, _, _ := strings.Cut(property, "#")
+	metric := func(name string, tags map[string]string, group, unit string) *redfishTelemetryMetric {
+		if clientConfig.isExcluded[name] {
+			return nil
+		}
+		return &redfishTelemetryMetric{
+			name: name,
+			tags: tags,
+			meta: map[string]string{
+				"source": r.name,
+				"group":  group,
+				"unit":   unit,
+			},
+		}
+	}
+
+	// Thermal metrics
+	if m := redfishThermalProperty.FindStringSubmatch(property); m != nil && clientConfig.doThermalMetrics {
+		chassis, err := schemas.GetChassis(c, m[1])
+		if err != nil {
+			return nil, fmt.Errorf("schemas.GetChassis(%s) failed: %w", m[1], err)
+		}
+		thermal, err := schemas.GetThermal(c, resource)
+		if err != nil {
+			return nil, fmt.Errorf("schemas.GetThermal(%s) failed: %w", resource, err)
+		}
+		i, _ := strconv.Atoi(m[3])
+		switch {
+		case m[2] == "Temperatures" && m[4] == "ReadingCelsius" && i < len(thermal.Temperatures):
+			return metric("temperature", temperatureTags(clientConfig, chassis, &thermal.Temperatures[i]), "Temperature", "degC"), nil
+		case m[2] == "Fans" && m[4] == "Reading" && i < len(thermal.Fans):
+			fan := &thermal.Fans[i]
+			return metric("fan_speed", fanTags(clientConfig, chassis, fan), "FanSpeed", string(fan.ReadingUnits)), nil
+		}
+		return nil, nil
+	}
+
+	// Power metrics
+	if m := redfishPowerProperty.FindStringSubmatch(property); m != nil && clientConfig.doPowerMetric {
+		chassis, err := schemas.GetChassis(c, m[1])
+		if err != nil {
+			return nil, fmt.Errorf("schemas.GetChassis(%s) failed: %w", m[1], err)
+		}
+		power, err := schemas.GetPower(c, resource)
+		if err != nil {
+			return nil, fmt.Errorf("schemas.GetPower(%s) failed: %w", resource, err)
+		}
+		i, _ := strconv.Atoi(m[2])
+		if i >= len(power.PowerControl) {
+			return nil, nil
+		}
+		pc := &power.PowerControl[i]
+		pcMetric := metric(redfishPowerControlMetrics[m[3]], powerControlTags(clientConfig, chassis, pc), "Energy", "watts")
+		if pcMetric != nil && pc.PowerMetrics.IntervalInMin != nil {
+			pcMetric.meta["interval_in_minutes"] = strconv.FormatUint(uint64(*pc.PowerMetrics.IntervalInMin), 10)
+		}
+		return pcMetric, nil
+	}
+
+	// Sensors
+	if m := redfishSensorProperty.FindStringSubmatch(property); m != nil && clientConfig.doSensors {
+		chassis, err := schemas.GetChassis(c, m[1])
+		if err != nil {
+			return nil, fmt.Errorf("schemas.GetChassis(%s) failed: %w", m[1], err)
+		}
+		sensor, err := schemas.GetSensor(c, resource)
+		if err != nil {
+			return nil, fmt.Errorf("schemas.GetSensor(%s) failed: %w", resource, err)
+		}
+		switch {
+		case (sensor.ReadingType == schemas.PowerReadingType || sensor.ReadingType == schemas.CurrentReadingType) &&
+			sensor.ReadingUnits == "Watts":
+			return metric("power", sensorTags(clientConfig, chassis, sensor, "power"), "Energy", "watts"), nil
+		case sensor.ReadingType == schemas.AirFlowReadingType && (sensor.ReadingUnits == "RPM" || sensor.ReadingUnits == "Percent"):
+			return metric("fan_speed", sensorTags(clientConfig, chassis, sensor, "fan"), "FanSpeed", sensor.ReadingUnits), nil
+		case sensor.ReadingType == schemas.TemperatureReadingType && sensor.ReadingUnits == "C":
+			return metric("temperature", sensorTags(clientConfig, chassis, sensor, "temperature"), "Temperature", "degC"), nil
+		}
+		return nil, nil
+	}
+
+	// Processor metrics
+	if m := redfishProcessorProperty.FindStringSubmatch(property); m != nil && clientConfig.doProcessorMetrics {
+		processor, err := schemas.GetProcessor(c, m[1])
+		if err != nil {
+			return nil, fmt.Errorf("schemas.GetProcessor(%s) failed: %w", m[1], err)
+		}
+		if m[2] == "ConsumedPowerWatt" {
+			return metric("consumed_power", processorTags(clientConfig, processor), "Energy", "watts"), nil
+		}
+		return metric("temperature", processorTags(clientConfig, processor), "Temperature", "degC"), nil
+	}
+
+	return nil, nil
+}
+
+// sendMetricReport sends the metric values of a MetricReport through the sink
+// channel. Metric properties are mapped to metrics on first use, which requires
+// the redfish client c.
+func (r *RedfishReceiver) sendMetricReport(
+	c schemas.Client,
+	clientConfig *RedfishReceiverClientConfig,
+	report *schemas.MetricReport,
+) {
+	t := clientConfig.telemetry
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	reportTimestamp, err := time.Parse(time.RFC3339, report.Timestamp)
+	if err != nil {
+		reportTimestamp = time.Now()
+	}
+	for _, v := range report.MetricValues {
+		if len(v.MetricProperty) == 0 {
+			continue
+		}
+		metric, ok := t.metrics[v.MetricProperty]
+		if !ok {
+			metric, err = r.resolveMetricProperty(c, clientConfig, v.MetricProperty)
+			if err != nil {
+				cclog.ComponentError(r.name, fmt.Sprintf("Failed to map metric property %s of %s: %v", v.MetricProperty, clientConfig.Hostname, err))
+				continue
+			}
+			if metric == nil {
+				cclog.ComponentDebug(r.name, fmt.Sprintf("Skipping metric property %s of %s", v.MetricProperty, clientConfig.Hostname))
+			}
+			t.metrics[v.MetricProperty] = metric
+		}
+		if metric == nil {
+			continue
+		}
+
+		// Skip null or non-numeric values
+		value, err := strconv.ParseFloat(v.MetricValue, 64)
+		if err != nil {
+			continue
+		}
+		timestamp, err := time.Parse(time.RFC3339, v.Timestamp)
+		if err != nil {
+			timestamp = reportTimestamp
+		}
+		r.sendMetric(clientConfig.mp, metric.name, maps.Clone(metric.tags), maps.Clone(metric.meta), value, timestamp)
+	}
+}
+
+// readMetricReports reads the MetricReports of the TelemetryService. It reports
+// whether MetricReports are available, otherwise the metrics have to be polled.
+func (r *RedfishReceiver) readMetricReports(c *gofish.APIClient, clientConfig *RedfishReceiverClientConfig) (bool, error) {
+	ts, err := telemetryService(c)
+	if err != nil || ts == nil {
+		return false, err
+	}
+	reports, err := ts.MetricReports()
+	if err != nil {
+		return false, fmt.Errorf("readMetricReports: ts.MetricReports() failed: %w", err)
+	}
+	found := false
+	for _, report := range reports {
+		if r.useMetricReport(report) {
+			r.sendMetricReport(c, clientConfig, report)
+			found = true
+		}
+	}
+	return found, nil
+}
+
+// subscribeMetricReports creates the push subscription of MetricReports, if the
+// redfish service has a TelemetryService. It returns the URI of the subscription
+// or an empty string without TelemetryService.
+func (r *RedfishReceiver) subscribeMetricReports(
+	c *gofish.APIClient,
+	es *schemas.EventService,
+	clientConfig *RedfishReceiverClientConfig,
+) (string, error) {
+	ts, err := telemetryService(c)
+	if err != nil {
+		return "", err
+	}
+	if ts == nil {
+		return "", nil
+	}
+	// gofish does not support to set the EventFormatType of subscriptions
+	resp, err := c.Post(es.SubscriptionsLink, map[string]any{
+		"Destination":      r.eventDestination(clientConfig),
+		"Protocol":         schemas.RedfishEventDestinationProtocol,
+		"Context":          r.eventContext,
+		"EventFormatType":  schemas.MetricReportEventFormatType,
+		"SubscriptionType": schemas.RedfishEventSubscriptionType,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create MetricReport subscription: %w", err)
+	}
+	resp.Body.Close()
+	uri := resp.Header.Get("Location")
+	if u, err := url.ParseRequestURI(uri); err == nil {
+		uri = u.RequestURI()
+	}
+	return uri, nil
+}
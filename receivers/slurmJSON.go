@@ -0,0 +1,352 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ClusterCockpit/cc-lib/v2/hostlist"
+	"github.com/ClusterCockpit/cc-lib/v2/schema"
+)
+
+// Job states of ClusterCockpit by base job state of Slurm
+var slurmJobStates = map[string]schema.JobState{
+	"PENDING":       schema.JobStatePending,
+	"RUNNING":       schema.JobStateRunning,
+	"SUSPENDED":     schema.JobStateSuspended,
+	"COMPLETED":     schema.JobStateCompleted,
+	"CANCELLED":     schema.JobStateCancelled,
+	"FAILED":        schema.JobStateFailed,
+	"TIMEOUT":       schema.JobStateTimeout,
+	"NODE_FAIL":     schema.JobStateNodeFail,
+	"PREEMPTED":     schema.JobStatePreempted,
+	"BOOT_FAIL":     schema.JobStateBootFail,
+	"DEADLINE":      schema.JobStateDeadline,
+	"OUT_OF_MEMORY": schema.JobStateOutOfMemory,
+}
+
+// slurmNumber is an integer of the Slurm JSON output. Depending on the data
+// parser version, it is a plain number or an object with the fields set,
+// infinite and number.
+type slurmNumber struct {
+	Set      bool  `json:"set"`
+	Infinite bool  `json:"infinite"`
+	Number   int64 `json:"number"`
+}
+
+func (n *slurmNumber) UnmarshalJSON(data []byte) error {
+	*n = slurmNumber{}
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '{' {
+		type number slurmNumber
+		return json.Unmarshal(data, (*number)(n))
+	}
+	n.Set = true
+	return json.Unmarshal(data, &n.Number)
+}
+
+// value returns the number, 0 if it is not set or infinite
+func (n slurmNumber) value() int64 {
+	if !n.Set || n.Infinite {
+		return 0
+	}
+	return n.Number
+}
+
+// slurmFlags is a list of flags of the Slurm JSON output. Older data parser
+// versions use a single string.
+type slurmFlags []string
+
+func (f *slurmFlags) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*f = strings.Fields(s)
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(f))
+}
+
+// slurmError is an error reported in the Slurm JSON output
+type slurmError struct {
+	Description string `json:"description"`
+	Error       string `json:"error"`
+	ErrorNumber int    `json:"error_number"`
+	Source      string `json:"source"`
+}
+
+// slurmErrors joins the errors of the Slurm JSON output
+func slurmErrors(errs []slurmError) error {
+	var err error
+	for _, e := range errs {
+		err = errors.Join(err, fmt.Errorf("%s: %s (%d): %s", e.Source, e.Error, e.ErrorNumber, e.Description))
+	}
+	return err
+}
+
+// slurmCore is a core of a socket of a job allocation
+type slurmCore struct {
+	Index  int        `json:"index"`
+	Status slurmFlags `json:"status"`
+}
+
+// slurmSocket is a socket of a job allocation
+type slurmSocket struct {
+	Index int         `json:"index"`
+	Cores []slurmCore `json:"cores"`
+}
+
+// slurmAllocation is the allocation of a job on a node
+type slurmAllocation struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	CPUs  struct {
+		Count int `json:"count"`
+	} `json:"cpus"`
+	Memory struct {
+		Allocated int64 `json:"allocated"` // in MB
+	} `json:"memory"`
+	Sockets []slurmSocket `json:"sockets"`
+}
+
+// slurmJob is a job of the slurmrestd jobs endpoint or of squeue --json
+type slurmJob struct {
+	JobID        int64       `json:"job_id"`
+	ArrayJobID   slurmNumber `json:"array_job_id"`
+	Name         string      `json:"name"`
+	UserName     string      `json:"user_name"`
+	Account      string      `json:"account"`
+	Partition    string      `json:"partition"`
+	Cluster      string      `json:"cluster"`
+	JobState     slurmFlags  `json:"job_state"`
+	Nodes        string      `json:"nodes"`
+	Shared       slurmFlags  `json:"shared"`
+	Exclusive    slurmFlags  `json:"exclusive"`
+	SubmitTime   slurmNumber `json:"submit_time"`
+	StartTime    slurmNumber `json:"start_time"`
+	EndTime      slurmNumber `json:"end_time"`
+	TimeLimit    slurmNumber `json:"time_limit"` // in minutes
+	GresDetail   []string    `json:"gres_detail"`
+	JobResources struct {
+		Nodes struct {
+			Allocation []slurmAllocation `json:"allocation"`
+		} `json:"nodes"`
+	} `json:"job_resources"`
+}
+
+// state returns the base state of a job
+func (j *slurmJob) state() schema.JobState {
+	for _, s := range j.JobState {
+		if state, ok := slurmJobStates[s]; ok {
+			return state
+		}
+	}
+	return ""
+}
+
+// slurmJobs is the response of the slurmrestd jobs endpoint and of squeue --json
+type slurmJobs struct {
+	Jobs   []slurmJob   `json:"jobs"`
+	Errors []slurmError `json:"errors"`
+}
+
+// slurmNode is a node of the slurmrestd nodes endpoint. Sockets, cores per
+// socket and threads per core describe the topology to map allocated cores to
+// hardware threads.
+type slurmNode struct {
+	Name    string     `json:"name"`
+	State   slurmFlags `json:"state"`
+	Sockets int        `json:"sockets"`
+	Cores   int        `json:"cores"`
+	Threads int        `json:"threads"`
+}
+
+// slurmNodes is the response of the slurmrestd nodes endpoint
+type slurmNodes struct {
+	Nodes  []slurmNode  `json:"nodes"`
+	Errors []slurmError `json:"errors"`
+}
+
+// slurmRange is a range of values of sinfo --json
+type slurmRange struct {
+	Minimum int `json:"minimum"`
+	Maximum int `json:"maximum"`
+}
+
+// slurmSinfo is the output of sinfo --json. Each entry describes the nodes of
+// a partition with the same state and configuration.
+type slurmSinfo struct {
+	Sinfo []struct {
+		Node struct {
+			State slurmFlags `json:"state"`
+		} `json:"node"`
+		Nodes struct {
+			Nodes []string `json:"nodes"`
+		} `json:"nodes"`
+		Sockets slurmRange `json:"sockets"`
+		Cores   slurmRange `json:"cores"`
+		Threads slurmRange `json:"threads"`
+	} `json:"sinfo"`
+	Errors []slurmError `json:"errors"`
+}
+
+// nodes returns the nodes of the sinfo output. Nodes listed in several
+// partitions are returned once.
+func (s *slurmSinfo) nodes() ([]slurmNode, error) {
+	var nodes []slurmNode
+	index := make(map[string]int)
+	for _, entry := range s.Sinfo {
+		for _, names := range entry.Nodes.Nodes {
+			hosts, err := hostlist.Expand(names)
+			if err != nil {
+				return nil, fmt.Errorf("failed to expand nodes %s: %w", names, err)
+			}
+			for _, host := range hosts {
+				if i, ok := index[host]; ok {
+					for _, state := range entry.Node.State {
+						if !slices.Contains(nodes[i].State, state) {
+							nodes[i].State = append(nodes[i].State, state)
+						}
+					}
+					continue
+				}
+				index[host] = len(nodes)
+				nodes = append(nodes, slurmNode{
+					Name:    host,
+					State:   slices.Clone(entry.Node.State),
+					Sockets: entry.Sockets.Maximum,
+					Cores:   entry.Cores.Maximum,
+					Threads: entry.Threads.Maximum,
+				})
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// hwthreads returns the hardware thread IDs of a core of a socket. With the
+// order cores_first, the hardware threads of the first thread of all cores
+// are numbered first, as done by Linux on most x86 systems. With the order
+// threads_first, the hardware threads of a core are numbered consecutively.
+func (n *slurmNode) hwthreads(socket, core, threads int, order string) []int {
+	id := socket*n.Cores + core
+	hwthreads := make([]int, 0, threads)
+	for t := range threads {
+		if order == "threads_first" {
+			hwthreads = append(hwthreads, id*n.Threads+t)
+		} else {
+			hwthreads = append(hwthreads, id+t*n.Sockets*n.Cores)
+		}
+	}
+	return hwthreads
+}
+
+// slurmAccelerators returns the accelerator indices of a gres_detail entry of
+// a job, e.g. "gpu:a100:2(IDX:0-1)"
+func slurmAccelerators(gres string) ([]string, error) {
+	_, indices, ok := strings.Cut(gres, "IDX:")
+	if !ok {
+		return nil, nil
+	}
+	indices, _, _ = strings.Cut(indices, ")")
+	if indices == "N/A" {
+		return nil, nil
+	}
+	var accelerators []string
+	for r := range strings.SplitSeq(indices, ",") {
+		first, last, isRange := strings.Cut(r, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid accelerator index in %s", gres)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil || end < start {
+				return nil, fmt.Errorf("invalid accelerator index range in %s", gres)
+			}
+		}
+		for i := start; i <= end; i++ {
+			accelerators = append(accelerators, strconv.Itoa(i))
+		}
+	}
+	return accelerators, nil
+}
+
+// resources returns the resources allocated by a job. Allocated cores are
+// mapped to hardware threads using the topology of the nodes. Without
+// allocation details, the node list of the job is expanded.
+func (j *slurmJob) resources(nodes map[string]*slurmNode, order string) ([]*schema.Resource, error) {
+	var resources []*schema.Resource
+	allocations := j.JobResources.Nodes.Allocation
+	if len(allocations) == 0 {
+		hosts, err := hostlist.Expand(j.Nodes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand nodes %s: %w", j.Nodes, err)
+		}
+		for _, host := range hosts {
+			resources = append(resources, &schema.Resource{Hostname: host})
+		}
+	}
+	for _, allocation := range allocations {
+		resource := &schema.Resource{Hostname: allocation.Name}
+		if node, ok := nodes[allocation.Name]; ok && node.Cores > 0 && node.Threads > 0 {
+			numCores := 0
+			for _, socket := range allocation.Sockets {
+				for _, core := range socket.Cores {
+					if slices.Contains(core.Status, "ALLOCATED") || slices.Contains(core.Status, "IN_USE") {
+						numCores++
+					}
+				}
+			}
+			// Jobs with one thread per core use only the first hardware thread
+			threads := node.Threads
+			if allocation.CPUs.Count == numCores {
+				threads = 1
+			}
+			for _, socket := range allocation.Sockets {
+				for _, core := range socket.Cores {
+					if slices.Contains(core.Status, "ALLOCATED") || slices.Contains(core.Status, "IN_USE") {
+						resource.HWThreads = append(resource.HWThreads, node.hwthreads(socket.Index, core.Index, threads, order)...)
+					}
+				}
+			}
+			slices.Sort(resource.HWThreads)
+		}
+		resources = append(resources, resource)
+	}
+	for i, gres := range j.GresDetail {
+		if i >= len(resources) {
+			break
+		}
+		accelerators, err := slurmAccelerators(gres)
+		if err != nil {
+			return nil, err
+		}
+		resources[i].Accelerators = accelerators
+	}
+	return resources, nil
+}
+
+// shared returns how the nodes of a job are shared with other jobs
+func (j *slurmJob) shared() string {
+	switch {
+	case slices.Contains(j.Exclusive, "true"):
+		return "none"
+	case slices.Contains(j.Exclusive, "user") || slices.Contains(j.Shared, "user"):
+		return "single_user"
+	}
+	return "multi_user"
+}
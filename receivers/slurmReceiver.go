@@ -0,0 +1,461 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	mp "github.com/ClusterCockpit/cc-lib/v2/messageProcessor"
+	"github.com/ClusterCockpit/cc-lib/v2/schema"
+	"github.com/ClusterCockpit/cc-lib/v2/util"
+)
+
+const SLURM_NODE_STATE_EVENT_NAME = "node_state"
+
+// SlurmReceiverConfig configures the receiver for jobs and node states of Slurm
+type SlurmReceiverConfig struct {
+	defaultReceiverConfig
+	Interval      string          `json:"interval,omitempty"`       // How often Slurm is polled (default: 30s)
+	Cluster       string          `json:"cluster"`                  // Cluster name of the jobs and nodes
+	URL           string          `json:"url,omitempty"`            // URL of slurmrestd, squeue and sinfo are used if empty
+	APIVersion    string          `json:"api_version,omitempty"`    // Version of the slurmrestd API (default: v0.0.42)
+	User          string          `json:"user,omitempty"`           // User name of the slurmrestd token
+	Token         string          `json:"token,omitempty"`          // JWT of slurmrestd, may be a secret reference
+	ReloadSecrets bool            `json:"reload_secrets,omitempty"` // Re-read file: secret references when the files change
+	TLS           *util.TLSConfig `json:"tls,omitempty"`            // CA bundle and client certificate for HTTPS
+	Timeout       string          `json:"timeout,omitempty"`        // Maximum time of a request or command (default: 10s)
+	SqueueCommand string          `json:"squeue_command,omitempty"` // squeue command (default: squeue)
+	SinfoCommand  string          `json:"sinfo_command,omitempty"`  // sinfo command (default: sinfo)
+	HWThreadOrder string          `json:"hwthread_order,omitempty"` // Numbering of hardware threads: cores_first (default) or threads_first
+	NodeStates    *bool           `json:"node_states,omitempty"`    // Send node state events (default: true)
+}
+
+type SlurmReceiver struct {
+	receiver
+	config   SlurmReceiverConfig
+	interval time.Duration
+	timeout  time.Duration
+	token    *util.Secret
+	client   *http.Client
+
+	jobs        map[int64]*schema.Job          // running jobs with sent start event
+	finished    map[int64]bool                 // finished jobs of the last snapshot
+	nodes       map[string]*schema.NodePayload // last sent node states
+	initialized bool                           // first snapshot was processed
+
+	done chan bool
+	wg   sync.WaitGroup
+}
+
+// get reads an endpoint of slurmrestd
+func (r *SlurmReceiver) get(ctx context.Context, endpoint string, v any) error {
+	url := fmt.Sprintf("%s/slurm/%s/%s", strings.TrimSuffix(r.config.URL, "/"), r.config.APIVersion, endpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if r.config.User != "" {
+		req.Header.Set("X-SLURM-USER-NAME", r.config.User)
+	}
+	if token := r.token.Value(); token != "" {
+		req.Header.Set("X-SLURM-USER-TOKEN", token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response of %s: %w", url, err)
+	}
+	// slurmrestd reports errors in the JSON body
+	if err := json.Unmarshal(body, v); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("request %s failed: %s", url, resp.Status)
+		}
+		return fmt.Errorf("failed to decode response of %s: %w", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request %s failed: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// run runs a Slurm command with JSON output
+func (r *SlurmReceiver) run(ctx context.Context, command string, v any) error {
+	args := append(strings.Fields(command), "--json")
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("command \"%s\" failed: %w: %s",
+			cmd.String(), err, strings.TrimSpace(stderr.String()))
+	}
+	if err := json.Unmarshal(stdout.Bytes(), v); err != nil {
+		return fmt.Errorf("failed to decode output of command \"%s\": %w", cmd.String(), err)
+	}
+	return nil
+}
+
+// read reads the jobs and nodes of Slurm from slurmrestd or with squeue and sinfo
+func (r *SlurmReceiver) read() ([]slurmJob, []slurmNode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	var jobs slurmJobs
+	if r.config.URL != "" {
+		var nodes slurmNodes
+		if err := r.get(ctx, "jobs/", &jobs); err != nil {
+			return nil, nil, errors.Join(err, slurmErrors(jobs.Errors))
+		}
+		if err := r.get(ctx, "nodes/", &nodes); err != nil {
+			return nil, nil, errors.Join(err, slurmErrors(nodes.Errors))
+		}
+		if err := errors.Join(slurmErrors(jobs.Errors), slurmErrors(nodes.Errors)); err != nil {
+			return nil, nil, err
+		}
+		return jobs.Jobs, nodes.Nodes, nil
+	}
+
+	var sinfo slurmSinfo
+	if err := r.run(ctx, r.config.SqueueCommand, &jobs); err != nil {
+		return nil, nil, err
+	}
+	if err := r.run(ctx, r.config.SinfoCommand, &sinfo); err != nil {
+		return nil, nil, err
+	}
+	if err := errors.Join(slurmErrors(jobs.Errors), slurmErrors(sinfo.Errors)); err != nil {
+		return nil, nil, err
+	}
+	nodes, err := sinfo.nodes()
+	if err != nil {
+		return nil, nil, err
+	}
+	return jobs.Jobs, nodes, nil
+}
+
+// newJob converts a Slurm job to a ClusterCockpit job
+func (r *SlurmReceiver) newJob(j *slurmJob, nodes map[string]*slurmNode) (*schema.Job, error) {
+	resources, err := j.resources(nodes, r.config.HWThreadOrder)
+	if err != nil {
+		return nil, fmt.Errorf("job %d: %w", j.JobID, err)
+	}
+	job := &schema.Job{
+		Cluster:          r.config.Cluster,
+		Partition:        j.Partition,
+		Project:          j.Account,
+		User:             j.UserName,
+		Shared:           j.shared(),
+		State:            schema.JobStateRunning,
+		Resources:        resources,
+		MetaData:         map[string]string{"jobName": j.Name},
+		ArrayJobID:       j.ArrayJobID.value(),
+		Walltime:         j.TimeLimit.value() * 60,
+		JobID:            j.JobID,
+		MonitoringStatus: schema.MonitoringStatusRunningOrArchiving,
+		NumNodes:         int32(len(resources)),
+		SubmitTime:       j.SubmitTime.value(),
+		StartTime:        j.StartTime.value(),
+	}
+	for _, resource := range resources {
+		job.NumHWThreads += int32(len(resource.HWThreads))
+		job.NumAcc += int32(len(resource.Accelerators))
+	}
+	return job, nil
+}
+
+// sendEvent sends an event through the message processor to the sink
+func (r *SlurmReceiver) sendEvent(event lp.CCMessage, err error) {
+	if err != nil {
+		cclog.ComponentError(r.name, "Failed to create event:", err.Error())
+		return
+	}
+	event.AddTag("cluster", r.config.Cluster)
+	event.AddMeta("source", r.name)
+	m, err := r.mp.ProcessMessage(event)
+	if err == nil && m != nil {
+		r.sink <- m
+	}
+}
+
+// stopJob sends the stop event of a job
+func (r *SlurmReceiver) stopJob(job *schema.Job, state schema.JobState, endTime int64) {
+	job.State = state
+	job.Duration = int32(max(endTime-job.StartTime, 0))
+	r.sendEvent(lp.NewJobStopEvent(job))
+}
+
+// updateJobs compares the jobs with the previous snapshot and sends start and
+// stop events of the changed jobs
+func (r *SlurmReceiver) updateJobs(jobs []slurmJob, nodes map[string]*slurmNode, now time.Time) {
+	seen := make(map[int64]bool)
+	for i := range jobs {
+		j := &jobs[i]
+		seen[j.JobID] = true
+		state := j.state()
+		switch state {
+		case "", schema.JobStatePending:
+		case schema.JobStateRunning, schema.JobStateSuspended:
+			delete(r.finished, j.JobID)
+			if _, ok := r.jobs[j.JobID]; ok {
+				continue
+			}
+			job, err := r.newJob(j, nodes)
+			if err != nil {
+				cclog.ComponentError(r.name, err.Error())
+				continue
+			}
+			r.jobs[j.JobID] = job
+			r.sendEvent(lp.NewJobStartEvent(job))
+		default:
+			if r.finished[j.JobID] {
+				continue
+			}
+			r.finished[j.JobID] = true
+			endTime := j.EndTime.value()
+			if endTime == 0 {
+				endTime = now.Unix()
+			}
+			if job, ok := r.jobs[j.JobID]; ok {
+				delete(r.jobs, j.JobID)
+				r.stopJob(job, state, endTime)
+				continue
+			}
+			// Jobs which started and finished between two snapshots
+			if !r.initialized || j.StartTime.value() == 0 || j.Nodes == "" {
+				continue
+			}
+			job, err := r.newJob(j, nodes)
+			if err != nil {
+				cclog.ComponentError(r.name, err.Error())
+				continue
+			}
+			r.sendEvent(lp.NewJobStartEvent(job))
+			r.stopJob(job, state, endTime)
+		}
+	}
+
+	// Jobs which are no longer listed have finished
+	for id, job := range r.jobs {
+		if !seen[id] {
+			delete(r.jobs, id)
+			r.stopJob(job, schema.JobStateCompleted, now.Unix())
+		}
+	}
+	for id := range r.finished {
+		if !seen[id] {
+			delete(r.finished, id)
+		}
+	}
+}
+
+// updateNodes sends node state events for the nodes with changed states or
+// allocations. The allocations are summed up from the running jobs.
+func (r *SlurmReceiver) updateNodes(jobs []slurmJob, nodes []slurmNode, now time.Time) {
+	payloads := make(map[string]*schema.NodePayload)
+	for i := range nodes {
+		states := make([]string, 0, len(nodes[i].State))
+		for _, state := range nodes[i].State {
+			states = append(states, strings.ToLower(state))
+		}
+		payloads[nodes[i].Name] = &schema.NodePayload{
+			Hostname: nodes[i].Name,
+			States:   states,
+		}
+	}
+	for i := range jobs {
+		if s := jobs[i].state(); s != schema.JobStateRunning && s != schema.JobStateSuspended {
+			continue
+		}
+		job, ok := r.jobs[jobs[i].JobID]
+		if !ok {
+			continue
+		}
+		allocations := jobs[i].JobResources.Nodes.Allocation
+		for k, resource := range job.Resources {
+			payload, ok := payloads[resource.Hostname]
+			if !ok {
+				continue
+			}
+			payload.JobsRunning++
+			payload.GpusAllocated += len(resource.Accelerators)
+			if k < len(allocations) && allocations[k].Name == resource.Hostname {
+				payload.CpusAllocated += allocations[k].CPUs.Count
+				payload.MemoryAllocated += allocations[k].Memory.Allocated
+			} else {
+				payload.CpusAllocated += len(resource.HWThreads)
+			}
+		}
+	}
+
+	for hostname, payload := range payloads {
+		if last, ok := r.nodes[hostname]; ok &&
+			slices.Equal(last.States, payload.States) &&
+			last.CpusAllocated == payload.CpusAllocated &&
+			last.MemoryAllocated == payload.MemoryAllocated &&
+			last.GpusAllocated == payload.GpusAllocated &&
+			last.JobsRunning == payload.JobsRunning {
+			continue
+		}
+		r.nodes[hostname] = payload
+		value, err := json.Marshal(payload)
+		if err != nil {
+			cclog.ComponentError(r.name, "Failed to encode node state:", err.Error())
+			continue
+		}
+		r.sendEvent(lp.NewEvent(
+			SLURM_NODE_STATE_EVENT_NAME,
+			map[string]string{"hostname": hostname, "type": "node"},
+			nil,
+			string(value),
+			now,
+		))
+	}
+	for hostname := range r.nodes {
+		if _, ok := payloads[hostname]; !ok {
+			delete(r.nodes, hostname)
+		}
+	}
+}
+
+// poll reads a snapshot of the jobs and nodes and sends the changes
+func (r *SlurmReceiver) poll() {
+	jobs, nodes, err := r.read()
+	if err != nil {
+		cclog.ComponentError(r.name, "Failed to read Slurm state:", err.Error())
+		return
+	}
+	now := time.Now()
+	nodeMap := make(map[string]*slurmNode, len(nodes))
+	for i := range nodes {
+		nodeMap[nodes[i].Name] = &nodes[i]
+	}
+	r.updateJobs(jobs, nodeMap, now)
+	if r.config.NodeStates == nil || *r.config.NodeStates {
+		r.updateNodes(jobs, nodes, now)
+	}
+	r.initialized = true
+}
+
+func (r *SlurmReceiver) Start() {
+	cclog.ComponentDebug(r.name, "START")
+
+	r.wg.Go(func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			r.poll()
+
+			select {
+			case <-ticker.C:
+				continue
+			case <-r.done:
+				return
+			}
+		}
+	})
+
+	cclog.ComponentDebug(r.name, "STARTED")
+}
+
+// Close receiver: stop polling
+func (r *SlurmReceiver) Close() {
+	cclog.ComponentDebug(r.name, "CLOSE")
+
+	close(r.done)
+	r.wg.Wait()
+	r.token.Close()
+
+	cclog.ComponentDebug(r.name, "DONE")
+}
+
+// NewSlurmReceiver creates a new instance of the Slurm receiver
+// Initialize the receiver by giving it a name and reading in the config JSON
+func NewSlurmReceiver(name string, config json.RawMessage) (Receiver, error) {
+	r := new(SlurmReceiver)
+	r.name = fmt.Sprintf("SlurmReceiver(%s)", name)
+
+	// Set defaults
+	r.config.Interval = "30s"
+	r.config.APIVersion = "v0.0.42"
+	r.config.Timeout = "10s"
+	r.config.SqueueCommand = "squeue"
+	r.config.SinfoCommand = "sinfo"
+	r.config.HWThreadOrder = "cores_first"
+
+	if len(config) > 0 {
+		d := json.NewDecoder(bytes.NewReader(config))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&r.config); err != nil {
+			cclog.ComponentError(r.name, "Error reading config:", err.Error())
+			return nil, err
+		}
+	}
+	if r.config.Cluster == "" {
+		return nil, errors.New("not all configuration variables set required by SlurmReceiver (cluster)")
+	}
+	var err error
+	if r.interval, err = time.ParseDuration(r.config.Interval); err != nil || r.interval <= 0 {
+		return nil, fmt.Errorf("%s: invalid interval '%s'", r.name, r.config.Interval)
+	}
+	if r.timeout, err = time.ParseDuration(r.config.Timeout); err != nil || r.timeout <= 0 {
+		return nil, fmt.Errorf("%s: invalid timeout '%s'", r.name, r.config.Timeout)
+	}
+	switch r.config.HWThreadOrder {
+	case "cores_first", "threads_first":
+	default:
+		return nil, fmt.Errorf("%s: unknown hardware thread order '%s'", r.name, r.config.HWThreadOrder)
+	}
+	if r.config.URL == "" && (len(strings.Fields(r.config.SqueueCommand)) == 0 || len(strings.Fields(r.config.SinfoCommand)) == 0) {
+		return nil, fmt.Errorf("%s: squeue_command and sinfo_command are required without url", r.name)
+	}
+
+	if r.token, err = util.NewSecret(r.config.Token, r.config.ReloadSecrets); err != nil {
+		return nil, fmt.Errorf("%s: token: %w", r.name, err)
+	}
+	r.client = &http.Client{}
+	if r.config.TLS != nil {
+		tlsConfig, err := r.config.TLS.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("%s: TLS configuration: %w", r.name, err)
+		}
+		r.client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+
+	p, err := mp.NewMessageProcessor()
+	if err != nil {
+		return nil, fmt.Errorf("initialization of message processor failed: %w", err)
+	}
+	r.mp = p
+	if len(r.config.MessageProcessor) > 0 {
+		err = r.mp.FromConfigJSON(r.config.MessageProcessor)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing JSON for message processor: %w", err)
+		}
+	}
+
+	r.jobs = make(map[int64]*schema.Job)
+	r.finished = make(map[int64]bool)
+	r.nodes = make(map[string]*schema.NodePayload)
+	r.done = make(chan bool)
+	return r, nil
+}
@@ -0,0 +1,281 @@
+package receivers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	"github.com/ClusterCockpit/cc-lib/v2/schema"
+)
+
+// slurmRunningJob is job 1001 on node[01-02] of the mock slurmrestd. On
+// node01 it uses the first two cores of both sockets with both hardware
+// threads, on node02 one core with one hardware thread.
+const slurmRunningJob = `{
+	"job_id": 1001, "array_job_id": {"set": true, "infinite": false, "number": 0},
+	"name": "simulation", "user_name": "alice", "account": "proj01", "partition": "main",
+	"cluster": "testcluster", "job_state": ["RUNNING"], "nodes": "node[01-02]",
+	"shared": [], "exclusive": ["user"],
+	"submit_time": {"set": true, "number": 1700000000}, "start_time": {"set": true, "number": 1700000100},
+	"end_time": {"set": true, "number": 1700086500}, "time_limit": {"set": true, "number": 1440},
+	"gres_detail": ["gpu:a100:2(IDX:0-1)", "gpu:a100:1(IDX:3)"],
+	"job_resources": {"nodes": {"allocation": [
+		{"index": 0, "name": "node01", "cpus": {"count": 8}, "memory": {"allocated": 16000},
+		 "sockets": [
+			{"index": 0, "cores": [{"index": 0, "status": ["ALLOCATED"]}, {"index": 1, "status": ["ALLOCATED"]}]},
+			{"index": 1, "cores": [{"index": 0, "status": ["ALLOCATED"]}, {"index": 1, "status": ["ALLOCATED"]}]}]},
+		{"index": 1, "name": "node02", "cpus": {"count": 1}, "memory": {"allocated": 2000},
+		 "sockets": [{"index": 1, "cores": [{"index": 3, "status": ["ALLOCATED"]}]}]}
+	]}}
+}`
+
+// slurmNodesResponse describes node[01-03] with 2 sockets, 4 cores per socket
+// and 2 threads per core
+const slurmNodesResponse = `{"nodes": [
+	{"name": "node01", "state": ["ALLOCATED"], "sockets": 2, "cores": 4, "threads": 2},
+	{"name": "node02", "state": ["MIXED"], "sockets": 2, "cores": 4, "threads": 2},
+	{"name": "node03", "state": %s, "sockets": 2, "cores": 4, "threads": 2}
+], "errors": []}`
+
+// receiveSlurm returns the job events by job ID and event name and the node
+// state events by host name
+func receiveSlurm(t *testing.T, sink chan lp.CCMessage) (map[string]*schema.Job, map[string]*schema.NodePayload) {
+	t.Helper()
+	jobs := make(map[string]*schema.Job)
+	nodes := make(map[string]*schema.NodePayload)
+	for len(sink) > 0 {
+		m := <-sink
+		if cluster, _ := m.GetTag("cluster"); cluster != "testcluster" {
+			t.Errorf("event %s has cluster %s", m.Name(), cluster)
+		}
+		if name, ok := m.IsJobEvent(); ok {
+			job, err := m.GetJob()
+			if err != nil {
+				t.Fatalf("failed to decode job: %v", err)
+			}
+			jobs[fmt.Sprintf("%s/%d", name, job.JobID)] = job
+			continue
+		}
+		if m.Name() != SLURM_NODE_STATE_EVENT_NAME {
+			t.Fatalf("unexpected message %v", m)
+		}
+		value, _ := m.GetEventValue()
+		var node schema.NodePayload
+		if err := json.Unmarshal([]byte(value), &node); err != nil {
+			t.Fatalf("failed to decode node state: %v", err)
+		}
+		if hostname, _ := m.GetTag("hostname"); hostname != node.Hostname {
+			t.Errorf("node state of %s has hostname tag %s", node.Hostname, hostname)
+		}
+		nodes[node.Hostname] = &node
+	}
+	return jobs, nodes
+}
+
+func TestSlurmReceiver(t *testing.T) {
+	var lock sync.Mutex
+	jobs := `{"jobs": [` + slurmRunningJob + `,
+		{"job_id": 1002, "job_state": ["PENDING"], "nodes": ""},
+		{"job_id": 1003, "job_state": ["COMPLETED"], "nodes": "node03",
+		 "start_time": {"set": true, "number": 1690000000}, "end_time": {"set": true, "number": 1690000100}}
+	], "errors": []}`
+	nodes := `["IDLE"]`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-SLURM-USER-NAME") != "cc" || req.Header.Get("X-SLURM-USER-TOKEN") != "jwt" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		switch req.URL.Path {
+		case "/slurm/v0.0.42/jobs/":
+			w.Write([]byte(jobs))
+		case "/slurm/v0.0.42/nodes/":
+			w.Write([]byte(fmt.Sprintf(slurmNodesResponse, nodes)))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+
+	r, err := NewSlurmReceiver("test", json.RawMessage(`{
+		"type": "slurm", "cluster": "testcluster", "url": "`+server.URL+`", "user": "cc", "token": "jwt"}`))
+	if err != nil {
+		t.Fatalf("failed to create Slurm receiver: %v", err)
+	}
+	sink := make(chan lp.CCMessage, 100)
+	r.SetSink(sink)
+	s := r.(*SlurmReceiver)
+
+	// First snapshot: start of the running job, but not of the finished job
+	s.poll()
+	events, nodeStates := receiveSlurm(t, sink)
+	if len(events) != 1 {
+		t.Fatalf("expected 1 job event, got %v", events)
+	}
+	job := events["start_job/1001"]
+	if job == nil {
+		t.Fatalf("missing start event of job 1001: %v", events)
+	}
+	if job.JobID != 1001 || job.Cluster != "testcluster" || job.User != "alice" || job.Project != "proj01" ||
+		job.Partition != "main" || job.Shared != "single_user" || job.State != schema.JobStateRunning ||
+		job.StartTime != 1700000100 || job.SubmitTime != 1700000000 || job.Walltime != 86400 ||
+		job.NumNodes != 2 || job.NumHWThreads != 9 || job.NumAcc != 3 || job.MetaData["jobName"] != "simulation" {
+		t.Errorf("unexpected job %+v", job)
+	}
+	if len(job.Resources) != 2 ||
+		job.Resources[0].Hostname != "node01" ||
+		!slices.Equal(job.Resources[0].HWThreads, []int{0, 1, 4, 5, 8, 9, 12, 13}) ||
+		!slices.Equal(job.Resources[0].Accelerators, []string{"0", "1"}) ||
+		job.Resources[1].Hostname != "node02" ||
+		!slices.Equal(job.Resources[1].HWThreads, []int{7}) ||
+		!slices.Equal(job.Resources[1].Accelerators, []string{"3"}) {
+		t.Errorf("unexpected resources %+v %+v", job.Resources[0], job.Resources[1])
+	}
+	if len(nodeStates) != 3 {
+		t.Fatalf("expected 3 node states, got %v", nodeStates)
+	}
+	if n := nodeStates["node01"]; !slices.Equal(n.States, []string{"allocated"}) ||
+		n.CpusAllocated != 8 || n.MemoryAllocated != 16000 || n.GpusAllocated != 2 || n.JobsRunning != 1 {
+		t.Errorf("unexpected node state %+v", n)
+	}
+	if n := nodeStates["node03"]; !slices.Equal(n.States, []string{"idle"}) || n.JobsRunning != 0 {
+		t.Errorf("unexpected node state %+v", n)
+	}
+
+	// Second snapshot: short job started and finished between the snapshots,
+	// changed state of node03
+	lock.Lock()
+	jobs = `{"jobs": [` + slurmRunningJob + `,
+		{"job_id": 1003, "job_state": ["COMPLETED"], "nodes": "node03",
+		 "start_time": {"set": true, "number": 1690000000}, "end_time": {"set": true, "number": 1690000100}},
+		{"job_id": 1004, "job_state": ["FAILED"], "nodes": "node03", "user_name": "bob",
+		 "start_time": {"set": true, "number": 1700000200}, "end_time": {"set": true, "number": 1700000260}}
+	], "errors": []}`
+	nodes = `["IDLE", "DRAIN"]`
+	lock.Unlock()
+	s.poll()
+	events, nodeStates = receiveSlurm(t, sink)
+	if len(events) != 2 || events["start_job/1004"] == nil || events["stop_job/1004"] == nil {
+		t.Fatalf("expected start and stop of job 1004, got %v", events)
+	}
+	if job := events["stop_job/1004"]; job.State != schema.JobStateFailed || job.Duration != 60 ||
+		len(job.Resources) != 1 || job.Resources[0].Hostname != "node03" {
+		t.Errorf("unexpected stopped job %+v", job)
+	}
+	if len(nodeStates) != 1 || !slices.Equal(nodeStates["node03"].States, []string{"idle", "drain"}) {
+		t.Errorf("expected changed state of node03, got %v", nodeStates)
+	}
+
+	// Third snapshot: running job timed out
+	lock.Lock()
+	jobs = `{"jobs": [{"job_id": 1001, "job_state": ["TIMEOUT"], "nodes": "node[01-02]",
+		 "start_time": {"set": true, "number": 1700000100}, "end_time": {"set": true, "number": 1700086500}}
+	], "errors": []}`
+	lock.Unlock()
+	s.poll()
+	events, nodeStates = receiveSlurm(t, sink)
+	if len(events) != 1 || events["stop_job/1001"] == nil {
+		t.Fatalf("expected stop of job 1001, got %v", events)
+	}
+	if job := events["stop_job/1001"]; job.State != schema.JobStateTimeout || job.Duration != 86400 ||
+		!slices.Equal(job.Resources[0].HWThreads, []int{0, 1, 4, 5, 8, 9, 12, 13}) {
+		t.Errorf("unexpected stopped job %+v", job)
+	}
+	if len(nodeStates) != 2 || nodeStates["node01"].JobsRunning != 0 || nodeStates["node02"].CpusAllocated != 0 {
+		t.Errorf("expected released node01 and node02, got %v", nodeStates)
+	}
+
+	// Fourth snapshot: finished job is no longer listed
+	lock.Lock()
+	jobs = `{"jobs": [], "errors": []}`
+	lock.Unlock()
+	s.poll()
+	if events, nodeStates = receiveSlurm(t, sink); len(events) != 0 || len(nodeStates) != 0 {
+		t.Errorf("expected no events, got %v %v", events, nodeStates)
+	}
+
+	// Errors reported by slurmrestd
+	lock.Lock()
+	jobs = `{"jobs": [], "errors": [{"description": "permission denied", "error": "Access denied", "error_number": 1, "source": "slurmctld"}]}`
+	lock.Unlock()
+	if _, _, err := s.read(); err == nil {
+		t.Errorf("expected error of slurmrestd")
+	}
+}
+
+func TestSlurmReceiverCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a shell")
+	}
+	dir := t.TempDir()
+	squeue := `#!/bin/sh
+cat <<'EOT'
+{"jobs": [{"job_id": 7, "job_state": "RUNNING", "nodes": "n1", "user_name": "carol", "start_time": 1700000000,
+  "array_job_id": 5, "exclusive": ["true"],
+  "job_resources": {"nodes": {"allocation": [{"name": "n1", "cpus": {"count": 4},
+    "sockets": [{"index": 1, "cores": [{"index": 0, "status": ["ALLOCATED"]}, {"index": 1, "status": ["ALLOCATED"]}]}]}]}}}]}
+EOT
+`
+	sinfo := `#!/bin/sh
+cat <<'EOT'
+{"sinfo": [
+  {"node": {"state": ["MIXED"]}, "nodes": {"nodes": ["n1"]}, "sockets": {"minimum": 2, "maximum": 2}, "cores": {"minimum": 4, "maximum": 4}, "threads": {"minimum": 2, "maximum": 2}},
+  {"node": {"state": ["IDLE"]}, "nodes": {"nodes": ["n[2-3]"]}, "sockets": {"minimum": 2, "maximum": 2}, "cores": {"minimum": 4, "maximum": 4}, "threads": {"minimum": 2, "maximum": 2}},
+  {"node": {"state": ["IDLE"]}, "nodes": {"nodes": ["n2"]}, "sockets": {"minimum": 2, "maximum": 2}, "cores": {"minimum": 4, "maximum": 4}, "threads": {"minimum": 2, "maximum": 2}}
+]}
+EOT
+`
+	for name, script := range map[string]string{"squeue": squeue, "sinfo": sinfo} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewSlurmReceiver("test", json.RawMessage(`{
+		"type": "slurm", "cluster": "testcluster", "hwthread_order": "threads_first",
+		"squeue_command": "`+filepath.Join(dir, "squeue")+`", "sinfo_command": "`+filepath.Join(dir, "sinfo")+`"}`))
+	if err != nil {
+		t.Fatalf("failed to create Slurm receiver: %v", err)
+	}
+	sink := make(chan lp.CCMessage, 100)
+	r.SetSink(sink)
+	r.(*SlurmReceiver).poll()
+	events, nodeStates := receiveSlurm(t, sink)
+	job := events["start_job/7"]
+	if job == nil || job.User != "carol" || job.ArrayJobID != 5 || job.Shared != "none" || job.StartTime != 1700000000 ||
+		!slices.Equal(job.Resources[0].HWThreads, []int{8, 9, 10, 11}) {
+		t.Errorf("unexpected job %+v", job)
+	}
+	if len(nodeStates) != 3 || nodeStates["n1"].CpusAllocated != 4 || !slices.Equal(nodeStates["n2"].States, []string{"idle"}) {
+		t.Errorf("unexpected node states %v", nodeStates)
+	}
+}
+
+func TestSlurmAccelerators(t *testing.T) {
+	for gres, expected := range map[string][]string{
+		"gpu:a100:2(IDX:0-1)": {"0", "1"},
+		"gpu:4(IDX:0,2-3,7)":  {"0", "2", "3", "7"},
+		"gpu(CNT:1,IDX:5)":    {"5"},
+		"gpu:a100:2(IDX:N/A)": nil,
+		"":                    nil,
+	} {
+		accelerators, err := slurmAccelerators(gres)
+		if err != nil || !slices.Equal(accelerators, expected) {
+			t.Errorf("%s: expected %v, got %v (%v)", gres, expected, accelerators, err)
+		}
+	}
+	for _, gres := range []string{"gpu:2(IDX:3-1)", "gpu:2(IDX:a)"} {
+		if _, err := slurmAccelerators(gres); err == nil {
+			t.Errorf("invalid indices of %s were accepted", gres)
+		}
+	}
+}
@@ -0,0 +1,821 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	"github.com/ClusterCockpit/cc-lib/v2/hostlist"
+	mp "github.com/ClusterCockpit/cc-lib/v2/messageProcessor"
+	"github.com/ClusterCockpit/cc-lib/v2/util"
+	"github.com/gosnmp/gosnmp"
+)
+
+const SNMP_DEFAULT_PORT = 161
+
+// SNMP versions by config name
+var snmpVersions = map[string]gosnmp.SnmpVersion{
+	"2c": gosnmp.Version2c,
+	"3":  gosnmp.Version3,
+}
+
+// SNMPv3 security levels by config name
+var snmpSecurityLevels = map[string]gosnmp.SnmpV3MsgFlags{
+	"noauthnopriv": gosnmp.NoAuthNoPriv,
+	"authnopriv":   gosnmp.AuthNoPriv,
+	"authpriv":     gosnmp.AuthPriv,
+}
+
+// SNMPv3 authentication protocols by config name
+var snmpAuthProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"MD5":    gosnmp.MD5,
+	"SHA":    gosnmp.SHA,
+	"SHA224": gosnmp.SHA224,
+	"SHA256": gosnmp.SHA256,
+	"SHA384": gosnmp.SHA384,
+	"SHA512": gosnmp.SHA512,
+}
+
+// SNMPv3 privacy protocols by config name
+var snmpPrivProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"DES":     gosnmp.DES,
+	"AES":     gosnmp.AES,
+	"AES192":  gosnmp.AES192,
+	"AES256":  gosnmp.AES256,
+	"AES192C": gosnmp.AES192C,
+	"AES256C": gosnmp.AES256C,
+}
+
+// SNMPReceiverMetricConfig maps an OID to a metric. For tables, the OID is
+// the OID of a table column.
+type SNMPReceiverMetricConfig struct {
+	Name  string  `json:"name"`            // Metric name
+	OID   string  `json:"oid"`             // Numeric OID of the object or table column
+	Unit  string  `json:"unit,omitempty"`  // Unit of the metric
+	Scale float64 `json:"scale,omitempty"` // Factor the value is multiplied with (default: 1)
+}
+
+// SNMPReceiverTableConfig maps the columns of an SNMP table to metrics and
+// tags. Each table row is sent with its index as tag.
+type SNMPReceiverTableConfig struct {
+	IndexTag string                     `json:"index_tag,omitempty"` // Tag of the row index (default: index)
+	Tags     map[string]string          `json:"tags,omitempty"`      // Tags with the values of table columns by column OID
+	Metrics  []SNMPReceiverMetricConfig `json:"metrics"`             // Metrics of table columns
+}
+
+// SNMPReceiverV3Config configures the SNMPv3 user based security model
+type SNMPReceiverV3Config struct {
+	Username      string  `json:"username"`                 // Security name
+	SecurityLevel string  `json:"security_level,omitempty"` // noAuthNoPriv, authNoPriv or authPriv (default: authPriv)
+	AuthProtocol  string  `json:"auth_protocol,omitempty"`  // MD5, SHA, SHA224, SHA256, SHA384 or SHA512 (default: SHA)
+	AuthPassword  *string `json:"auth_password,omitempty"`  // Authentication passphrase, may be a secret reference
+	PrivProtocol  string  `json:"priv_protocol,omitempty"`  // DES, AES, AES192, AES256, AES192C or AES256C (default: AES)
+	PrivPassword  *string `json:"priv_password,omitempty"`  // Privacy passphrase, may be a secret reference
+	ContextName   string  `json:"context_name,omitempty"`   // Context name
+}
+
+// snmpV3Security are the resolved SNMPv3 security parameters
+type snmpV3Security struct {
+	username     string
+	msgFlags     gosnmp.SnmpV3MsgFlags
+	authProtocol gosnmp.SnmpV3AuthProtocol
+	authPassword *util.Secret
+	privProtocol gosnmp.SnmpV3PrivProtocol
+	privPassword *util.Secret
+	contextName  string
+}
+
+type SNMPReceiverClientConfig struct {
+	Version        gosnmp.SnmpVersion         // SNMP version
+	Community      *util.Secret               // SNMPv2c community
+	Fanout         int                        // Maximum number of simultaneously polled devices
+	Timeout        time.Duration              // Timeout of a single SNMP request
+	Retries        int                        // Number of retries of a SNMP request
+	MaxRepetitions uint32                     // GETBULK max-repetitions to walk tables
+	Metrics        []SNMPReceiverMetricConfig // Scalar objects
+	Tables         []SNMPReceiverTableConfig  // Tables
+	v3             *snmpV3Security            // SNMPv3 security parameters
+	hosts          []*snmpHost                // SNMP devices
+}
+
+// snmpHost is a SNMP device
+type snmpHost struct {
+	clientConfig *SNMPReceiverClientConfig
+	address      string
+	port         uint16
+	hostname     string
+}
+
+type SNMPReceiver struct {
+	receiver
+	config struct {
+		Interval time.Duration
+
+		// Client config for each SNMP device group
+		ClientConfigs []SNMPReceiverClientConfig
+	}
+
+	done chan bool      // channel to finish / stop SNMP receiver
+	wg   sync.WaitGroup // wait group for SNMP receiver
+}
+
+// snmpOID normalizes a numeric OID to the notation of gosnmp with leading dot
+func snmpOID(oid string) (string, error) {
+	oid = "." + strings.TrimPrefix(strings.TrimSpace(oid), ".")
+	for n := range strings.SplitSeq(oid[1:], ".") {
+		if _, err := strconv.ParseUint(n, 10, 32); err != nil {
+			return "", fmt.Errorf("invalid OID '%s'", oid)
+		}
+	}
+	return oid, nil
+}
+
+// snmpString returns the value of an object as tag value
+func snmpString(pdu gosnmp.SnmpPDU) (string, bool) {
+	switch pdu.Type {
+	case gosnmp.OctetString:
+		return strings.TrimSpace(string(pdu.Value.([]byte))), true
+	case gosnmp.ObjectIdentifier, gosnmp.IPAddress:
+		return pdu.Value.(string), true
+	case gosnmp.Integer, gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks,
+		gosnmp.Counter64, gosnmp.Uinteger32:
+		return gosnmp.ToBigInt(pdu.Value).String(), true
+	}
+	return "", false
+}
+
+// snmpValue returns the value of an object scaled with scale. Counters are
+// returned unscaled as uint64 unless a scale is set, so that their wrap-around
+// can be handled by rate computations. The meta information of counters is
+// added to meta.
+func snmpValue(pdu gosnmp.SnmpPDU, scale float64, meta map[string]string) (any, bool) {
+	var value float64
+	switch pdu.Type {
+	case gosnmp.Counter32, gosnmp.Counter64:
+		meta["metric_type"] = "counter"
+		meta["counter_bits"] = "32"
+		if pdu.Type == gosnmp.Counter64 {
+			meta["counter_bits"] = "64"
+		}
+		counter := gosnmp.ToBigInt(pdu.Value).Uint64()
+		if scale == 1 {
+			return counter, true
+		}
+		value = float64(counter)
+	case gosnmp.Integer, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Uinteger32:
+		value = float64(gosnmp.ToBigInt(pdu.Value).Int64())
+	case gosnmp.OpaqueFloat:
+		value = float64(pdu.Value.(float32))
+	case gosnmp.OpaqueDouble:
+		value = pdu.Value.(float64)
+	case gosnmp.OctetString:
+		// Some devices report readings as strings, e.g. "23.5"
+		v, err := strconv.ParseFloat(strings.TrimSpace(string(pdu.Value.([]byte))), 64)
+		if err != nil {
+			return nil, false
+		}
+		value = v
+	default:
+		return nil, false
+	}
+	return value * scale, true
+}
+
+// sendValue sends the value of an object as metric with the given tags
+func (r *SNMPReceiver) sendValue(metric *SNMPReceiverMetricConfig, pdu gosnmp.SnmpPDU, tags map[string]string, timestamp time.Time) {
+	meta := map[string]string{
+		"source": r.name,
+		"group":  "SNMP",
+	}
+	if metric.Unit != "" {
+		meta["unit"] = metric.Unit
+	}
+	value, ok := snmpValue(pdu, metric.Scale, meta)
+	if !ok {
+		cclog.ComponentDebug(r.name, fmt.Sprintf("Skipping %s of %s: unsupported value type %v", pdu.Name, tags["hostname"], pdu.Type))
+		return
+	}
+	y, err := lp.NewMessage(metric.Name, tags, meta, map[string]any{"value": value}, timestamp)
+	if err != nil {
+		return
+	}
+	m, err := r.mp.ProcessMessage(y)
+	if err == nil && m != nil {
+		r.sink <- m
+	}
+}
+
+// client returns a SNMP client for a device
+func (r *SNMPReceiver) client(host *snmpHost) *gosnmp.GoSNMP {
+	clientConfig := host.clientConfig
+	client := &gosnmp.GoSNMP{
+		Target:         host.address,
+		Port:           host.port,
+		Transport:      "udp",
+		Version:        clientConfig.Version,
+		Timeout:        clientConfig.Timeout,
+		Retries:        clientConfig.Retries,
+		MaxOids:        gosnmp.MaxOids,
+		MaxRepetitions: clientConfig.MaxRepetitions,
+	}
+	if v3 := clientConfig.v3; v3 != nil {
+		client.SecurityModel = gosnmp.UserSecurityModel
+		client.MsgFlags = v3.msgFlags
+		client.ContextName = v3.contextName
+		client.SecurityParameters = &gosnmp.UsmSecurityParameters{
+			UserName:                 v3.username,
+			AuthenticationProtocol:   v3.authProtocol,
+			AuthenticationPassphrase: v3.authPassword.Value(),
+			PrivacyProtocol:          v3.privProtocol,
+			PrivacyPassphrase:        v3.privPassword.Value(),
+		}
+	} else {
+		client.Community = clientConfig.Community.Value()
+	}
+	return client
+}
+
+// readHost polls the scalar objects and tables of a SNMP device
+func (r *SNMPReceiver) readHost(host *snmpHost) error {
+	clientConfig := host.clientConfig
+	client := r.client(host)
+	if err := client.Connect(); err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer client.Conn.Close()
+
+	timestamp := time.Now()
+	for metrics := range slices.Chunk(clientConfig.Metrics, client.MaxOids) {
+		oids := make([]string, 0, len(metrics))
+		for i := range metrics {
+			oids = append(oids, metrics[i].OID)
+		}
+		result, err := client.Get(oids)
+		if err != nil {
+			return fmt.Errorf("failed to get %s: %w", strings.Join(oids, ","), err)
+		}
+		if result.Error != gosnmp.NoError {
+			return fmt.Errorf("failed to get %s: %v", strings.Join(oids, ","), result.Error)
+		}
+		for i, pdu := range result.Variables {
+			if i >= len(metrics) || pdu.Name != metrics[i].OID {
+				continue
+			}
+			r.sendValue(&metrics[i], pdu, map[string]string{
+				"hostname": host.hostname,
+				"type":     "node",
+			}, timestamp)
+		}
+	}
+
+	for i := range clientConfig.Tables {
+		if err := r.readTable(client, host, &clientConfig.Tables[i], timestamp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readTable walks the columns of a table of a SNMP device and sends a metric
+// for each row of the metric columns
+func (r *SNMPReceiver) readTable(client *gosnmp.GoSNMP, host *snmpHost, table *SNMPReceiverTableConfig, timestamp time.Time) error {
+	// Tags of the table rows by row index
+	rowTags := make(map[string]map[string]string)
+	for tag, oid := range table.Tags {
+		pdus, err := client.BulkWalkAll(oid)
+		if err != nil {
+			return fmt.Errorf("failed to walk %s: %w", oid, err)
+		}
+		for _, pdu := range pdus {
+			index, ok := strings.CutPrefix(pdu.Name, oid+".")
+			if !ok {
+				continue
+			}
+			value, ok := snmpString(pdu)
+			if !ok || value == "" {
+				continue
+			}
+			if rowTags[index] == nil {
+				rowTags[index] = make(map[string]string)
+			}
+			rowTags[index][tag] = value
+		}
+	}
+
+	for i := range table.Metrics {
+		metric := &table.Metrics[i]
+		pdus, err := client.BulkWalkAll(metric.OID)
+		if err != nil {
+			return fmt.Errorf("failed to walk %s: %w", metric.OID, err)
+		}
+		for _, pdu := range pdus {
+			index, ok := strings.CutPrefix(pdu.Name, metric.OID+".")
+			if !ok {
+				continue
+			}
+			tags := map[string]string{
+				"hostname":     host.hostname,
+				"type":         "node",
+				table.IndexTag: index,
+			}
+			maps.Copy(tags, rowTags[index])
+			r.sendValue(metric, pdu, tags, timestamp)
+		}
+	}
+	return nil
+}
+
+// readHosts polls the SNMP devices of a client config in parallel, at most
+// fanout devices at a time
+func (r *SNMPReceiver) readHosts(clientConfig *SNMPReceiverClientConfig) {
+	forEachParallel(clientConfig.hosts, clientConfig.Fanout, func(host *snmpHost) {
+		if err := r.readHost(host); err != nil {
+			cclog.ComponentError(r.name, fmt.Sprintf("Failed to poll %s: %v", host.address, err))
+		}
+	})
+}
+
+// doReadMetric polls all configured SNMP devices
+func (r *SNMPReceiver) doReadMetric() {
+	for i := range r.config.ClientConfigs {
+		r.readHosts(&r.config.ClientConfigs[i])
+	}
+}
+
+func (r *SNMPReceiver) Start() {
+	cclog.ComponentDebug(r.name, "START")
+
+	// Start SNMP receiver
+	r.wg.Go(func() {
+		// Create ticker
+		ticker := time.NewTicker(r.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			r.doReadMetric()
+
+			select {
+			case tickerTime := <-ticker.C:
+				// Check if we missed the ticker event
+				if since := time.Since(tickerTime); since > 5*time.Second {
+					cclog.ComponentInfo(r.name, "Missed ticker event for more then", since)
+				}
+
+				// process ticker event -> continue
+				continue
+			case <-r.done:
+				// process done event
+				return
+			}
+		}
+	})
+
+	cclog.ComponentDebug(r.name, "STARTED")
+}
+
+// Close receiver: close network connection, close files, close libraries, ...
+func (r *SNMPReceiver) Close() {
+	cclog.ComponentDebug(r.name, "CLOSE")
+
+	// Send the signal and wait
+	close(r.done)
+	r.wg.Wait()
+
+	// Stop watching the secrets
+	for i := range r.config.ClientConfigs {
+		clientConfig := &r.config.ClientConfigs[i]
+		clientConfig.Community.Close()
+		if clientConfig.v3 != nil {
+			clientConfig.v3.authPassword.Close()
+			clientConfig.v3.privPassword.Close()
+		}
+	}
+
+	cclog.ComponentDebug(r.name, "DONE")
+}
+
+// newSNMPMetrics validates the metric definitions and removes excluded metrics
+func newSNMPMetrics(metricsJSON []SNMPReceiverMetricConfig, isExcluded map[string]bool) ([]SNMPReceiverMetricConfig, error) {
+	metrics := make([]SNMPReceiverMetricConfig, 0, len(metricsJSON))
+	for _, metric := range metricsJSON {
+		if metric.Name == "" {
+			return nil, fmt.Errorf("metric with OID '%s' requires name", metric.OID)
+		}
+		if isExcluded[metric.Name] {
+			continue
+		}
+		oid, err := snmpOID(metric.OID)
+		if err != nil {
+			return nil, fmt.Errorf("metric %s: %w", metric.Name, err)
+		}
+		metric.OID = oid
+		if metric.Scale == 0 {
+			metric.Scale = 1
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// newSNMPTables validates the table definitions and removes excluded metrics
+func newSNMPTables(tablesJSON []SNMPReceiverTableConfig, isExcluded map[string]bool) ([]SNMPReceiverTableConfig, error) {
+	tables := make([]SNMPReceiverTableConfig, 0, len(tablesJSON))
+	for i, tableJSON := range tablesJSON {
+		metrics, err := newSNMPMetrics(tableJSON.Metrics, isExcluded)
+		if err != nil {
+			return nil, fmt.Errorf("table number %d: %w", i, err)
+		}
+		if len(metrics) == 0 {
+			continue
+		}
+		table := SNMPReceiverTableConfig{
+			IndexTag: tableJSON.IndexTag,
+			Tags:     make(map[string]string, len(tableJSON.Tags)),
+			Metrics:  metrics,
+		}
+		if table.IndexTag == "" {
+			table.IndexTag = "index"
+		}
+		for tag, oid := range tableJSON.Tags {
+			if tag == "hostname" || tag == "type" || tag == table.IndexTag {
+				return nil, fmt.Errorf("table number %d: tag %s is set by the receiver", i, tag)
+			}
+			table.Tags[tag], err = snmpOID(oid)
+			if err != nil {
+				return nil, fmt.Errorf("table number %d: tag %s: %w", i, tag, err)
+			}
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// newSNMPv3Security resolves the SNMPv3 security parameters
+func newSNMPv3Security(v3 *SNMPReceiverV3Config, reloadSecrets bool) (*snmpV3Security, error) {
+	if v3 == nil || v3.Username == "" {
+		return nil, fmt.Errorf("version 3 requires v3 username")
+	}
+	s := &snmpV3Security{
+		username:    v3.Username,
+		contextName: v3.ContextName,
+	}
+
+	securityLevel := v3.SecurityLevel
+	if securityLevel == "" {
+		securityLevel = "authPriv"
+	}
+	var ok bool
+	s.msgFlags, ok = snmpSecurityLevels[strings.ToLower(securityLevel)]
+	if !ok {
+		return nil, fmt.Errorf("invalid security level %s", securityLevel)
+	}
+
+	s.authProtocol = gosnmp.NoAuth
+	if s.msgFlags&gosnmp.AuthNoPriv != 0 {
+		authProtocol := v3.AuthProtocol
+		if authProtocol == "" {
+			authProtocol = "SHA"
+		}
+		if s.authProtocol, ok = snmpAuthProtocols[strings.ToUpper(authProtocol)]; !ok {
+			return nil, fmt.Errorf("invalid authentication protocol %s", authProtocol)
+		}
+		if v3.AuthPassword == nil {
+			return nil, fmt.Errorf("security level %s requires auth_password", securityLevel)
+		}
+		var err error
+		if s.authPassword, err = util.NewSecret(*v3.AuthPassword, reloadSecrets); err != nil {
+			return nil, fmt.Errorf("auth_password: %w", err)
+		}
+	}
+
+	s.privProtocol = gosnmp.NoPriv
+	if s.msgFlags&gosnmp.AuthPriv == gosnmp.AuthPriv {
+		privProtocol := v3.PrivProtocol
+		if privProtocol == "" {
+			privProtocol = "AES"
+		}
+		if s.privProtocol, ok = snmpPrivProtocols[strings.ToUpper(privProtocol)]; !ok {
+			return nil, fmt.Errorf("invalid privacy protocol %s", privProtocol)
+		}
+		if v3.PrivPassword == nil {
+			return nil, fmt.Errorf("security level %s requires priv_password", securityLevel)
+		}
+		var err error
+		if s.privPassword, err = util.NewSecret(*v3.PrivPassword, reloadSecrets); err != nil {
+			return nil, fmt.Errorf("priv_password: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// NewSNMPReceiver creates a new instance of the SNMP receiver
+// Initialize the receiver by giving it a name and reading in the config JSON
+func NewSNMPReceiver(name string, config json.RawMessage) (Receiver, error) {
+	r := new(SNMPReceiver)
+
+	// Config options from config file
+	configJSON := struct {
+		defaultReceiverConfig
+
+		// How often the SNMP devices should be polled (default: 30 s)
+		IntervalString string `json:"interval,omitempty"`
+
+		// Maximum number of simultaneously polled devices (default: 64)
+		Fanout int `json:"fanout,omitempty"`
+
+		// Timeout of a single SNMP request (default: 5 s)
+		Timeout string `json:"timeout,omitempty"`
+
+		// Number of retries of a SNMP request (default: 1)
+		Retries *int `json:"retries,omitempty"`
+
+		// GETBULK max-repetitions to walk tables (default: 25)
+		MaxRepetitions uint32 `json:"max_repetitions,omitempty"`
+
+		// Default SNMP version, community, SNMPv3 security parameters and endpoint
+		Version   string                `json:"version,omitempty"`   // SNMP version: 2c or 3 (default: 2c)
+		Community *string               `json:"community,omitempty"` // SNMPv2c community, may be a secret reference (env:, file: or exec:)
+		V3        *SNMPReceiverV3Config `json:"v3,omitempty"`        // SNMPv3 security parameters
+		Endpoint  string                `json:"endpoint,omitempty"`  // Address of the SNMP agent (default: %h)
+
+		// Re-read file: secret references when the files change
+		ReloadSecrets bool `json:"reload_secrets,omitempty"`
+
+		// Globally excluded metrics
+		ExcludeMetrics []string `json:"exclude_metrics,omitempty"`
+
+		// Default scalar objects and tables
+		Metrics []SNMPReceiverMetricConfig `json:"metrics,omitempty"`
+		Tables  []SNMPReceiverTableConfig  `json:"tables,omitempty"`
+
+		ClientConfigs []struct {
+			HostList       string                     `json:"host_list"`                 // List of hosts with the same client configuration
+			Fanout         int                        `json:"fanout,omitempty"`          // Maximum number of simultaneously polled devices
+			Timeout        string                     `json:"timeout,omitempty"`         // Timeout of a single SNMP request
+			Retries        *int                       `json:"retries,omitempty"`         // Number of retries of a SNMP request
+			MaxRepetitions uint32                     `json:"max_repetitions,omitempty"` // GETBULK max-repetitions to walk tables
+			Version        string                     `json:"version,omitempty"`         // SNMP version: 2c or 3
+			Community      *string                    `json:"community,omitempty"`       // SNMPv2c community
+			V3             *SNMPReceiverV3Config      `json:"v3,omitempty"`              // SNMPv3 security parameters
+			Endpoint       string                     `json:"endpoint,omitempty"`        // Address of the SNMP agent
+			Metrics        []SNMPReceiverMetricConfig `json:"metrics,omitempty"`         // Scalar objects, replace the default ones
+			Tables         []SNMPReceiverTableConfig  `json:"tables,omitempty"`          // Tables, replace the default ones
+
+			// Per client excluded metrics
+			ExcludeMetrics []string `json:"exclude_metrics,omitempty"`
+		} `json:"client_config"`
+	}{
+		// Set defaults values
+		// Allow overwriting these defaults by reading config JSON
+		IntervalString: "30s",
+		Fanout:         64,
+		Timeout:        "5s",
+		MaxRepetitions: 25,
+		Version:        "2c",
+		Endpoint:       "%h",
+	}
+
+	// Set name of SNMPReceiver
+	r.name = fmt.Sprintf("SNMPReceiver(%s)", name)
+
+	// Create done channel
+	r.done = make(chan bool)
+
+	// Read the SNMP receiver specific JSON config
+	if len(config) > 0 {
+		d := json.NewDecoder(bytes.NewReader(config))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&configJSON); err != nil {
+			cclog.ComponentError(r.name, "Error reading config:", err.Error())
+			return nil, err
+		}
+	}
+
+	p, err := mp.NewMessageProcessor()
+	if err != nil {
+		return nil, fmt.Errorf("initialization of message processor failed: %w", err)
+	}
+	r.mp = p
+	if len(configJSON.MessageProcessor) > 0 {
+		err = r.mp.FromConfigJSON(configJSON.MessageProcessor)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing JSON for message processor: %w", err)
+		}
+	}
+
+	// Convert interval string representation to duration
+	r.config.Interval, err = time.ParseDuration(configJSON.IntervalString)
+	if err != nil {
+		err := fmt.Errorf(
+			"failed to parse duration string interval='%s': %w",
+			configJSON.IntervalString,
+			err,
+		)
+		cclog.ComponentError(r.name, err)
+		return nil, err
+	}
+
+	// Create client config from JSON config
+	// The SNMP devices refer to their client config, so it must not be reallocated
+	r.config.ClientConfigs = make([]SNMPReceiverClientConfig, 0, len(configJSON.ClientConfigs))
+	totalNumHosts := 0
+	addresses := make(map[string]bool)
+	for i := range configJSON.ClientConfigs {
+		clientConfigJSON := &configJSON.ClientConfigs[i]
+
+		fanout := configJSON.Fanout
+		if clientConfigJSON.Fanout != 0 {
+			fanout = clientConfigJSON.Fanout
+		}
+		if fanout < 1 {
+			err := fmt.Errorf("client config number %v has invalid fanout %d", i, fanout)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
+
+		timeoutString := configJSON.Timeout
+		if clientConfigJSON.Timeout != "" {
+			timeoutString = clientConfigJSON.Timeout
+		}
+		timeout, err := time.ParseDuration(timeoutString)
+		if err != nil || timeout <= 0 {
+			err := fmt.Errorf("client config number %v has invalid timeout %s", i, timeoutString)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
+
+		retries := 1
+		if clientConfigJSON.Retries != nil {
+			retries = *clientConfigJSON.Retries
+		} else if configJSON.Retries != nil {
+			retries = *configJSON.Retries
+		}
+		if retries < 0 {
+			err := fmt.Errorf("client config number %v has invalid number of retries %d", i, retries)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
+
+		maxRepetitions := configJSON.MaxRepetitions
+		if clientConfigJSON.MaxRepetitions != 0 {
+			maxRepetitions = clientConfigJSON.MaxRepetitions
+		}
+
+		versionString := configJSON.Version
+		if clientConfigJSON.Version != "" {
+			versionString = clientConfigJSON.Version
+		}
+		version, ok := snmpVersions[strings.TrimPrefix(versionString, "v")]
+		if !ok {
+			err := fmt.Errorf("client config number %v has unsupported SNMP version %s", i, versionString)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
+
+		var community *util.Secret
+		var v3 *snmpV3Security
+		switch version {
+		case gosnmp.Version2c:
+			var communityRef string
+			if clientConfigJSON.Community != nil {
+				communityRef = *clientConfigJSON.Community
+			} else if configJSON.Community != nil {
+				communityRef = *configJSON.Community
+			} else {
+				err := fmt.Errorf("client config number %v requires community", i)
+				cclog.ComponentError(r.name, err)
+				return nil, err
+			}
+			community, err = util.NewSecret(communityRef, configJSON.ReloadSecrets)
+			if err != nil {
+				err := fmt.Errorf("client config number %v: community: %w", i, err)
+				cclog.ComponentError(r.name, err)
+				return nil, err
+			}
+		case gosnmp.Version3:
+			v3JSON := configJSON.V3
+			if clientConfigJSON.V3 != nil {
+				v3JSON = clientConfigJSON.V3
+			}
+			v3, err = newSNMPv3Security(v3JSON, configJSON.ReloadSecrets)
+			if err != nil {
+				err := fmt.Errorf("client config number %v: %w", i, err)
+				cclog.ComponentError(r.name, err)
+				return nil, err
+			}
+		}
+
+		// Is metrics excluded globally or per client
+		isExcluded := make(map[string]bool)
+		for _, key := range clientConfigJSON.ExcludeMetrics {
+			isExcluded[key] = true
+		}
+		for _, key := range configJSON.ExcludeMetrics {
+			isExcluded[key] = true
+		}
+
+		metricsJSON := configJSON.Metrics
+		if clientConfigJSON.Metrics != nil {
+			metricsJSON = clientConfigJSON.Metrics
+		}
+		metrics, err := newSNMPMetrics(metricsJSON, isExcluded)
+		if err != nil {
+			err := fmt.Errorf("client config number %v: %w", i, err)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
+		tablesJSON := configJSON.Tables
+		if clientConfigJSON.Tables != nil {
+			tablesJSON = clientConfigJSON.Tables
+		}
+		tables, err := newSNMPTables(tablesJSON, isExcluded)
+		if err != nil {
+			err := fmt.Errorf("client config number %v: %w", i, err)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
+		if len(metrics) == 0 && len(tables) == 0 {
+			err := fmt.Errorf("client config number %v requires metrics or tables", i)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
+
+		endpoint := configJSON.Endpoint
+		if clientConfigJSON.Endpoint != "" {
+			endpoint = clientConfigJSON.Endpoint
+		}
+		hostList, err := hostlist.Expand(clientConfigJSON.HostList)
+		if err != nil {
+			err := fmt.Errorf("client config number %d failed to parse host list %s: %v",
+				i, clientConfigJSON.HostList, err)
+			cclog.ComponentError(r.name, err)
+			return nil, err
+		}
+
+		r.config.ClientConfigs = append(
+			r.config.ClientConfigs,
+			SNMPReceiverClientConfig{
+				Version:        version,
+				Community:      community,
+				Fanout:         fanout,
+				Timeout:        timeout,
+				Retries:        retries,
+				MaxRepetitions: maxRepetitions,
+				Metrics:        metrics,
+				Tables:         tables,
+				v3:             v3,
+			})
+		clientConfig := &r.config.ClientConfigs[len(r.config.ClientConfigs)-1]
+
+		// Map SNMP agent address to host name
+		// This also guaranties that all SNMP agents are polled only once
+		for _, hostname := range hostList {
+			address := strings.ReplaceAll(endpoint, "%h", hostname)
+			if addresses[address] {
+				continue
+			}
+			addresses[address] = true
+			host := &snmpHost{
+				clientConfig: clientConfig,
+				address:      address,
+				port:         SNMP_DEFAULT_PORT,
+				hostname:     hostname,
+			}
+			if h, port, err := net.SplitHostPort(address); err == nil {
+				p, err := strconv.ParseUint(port, 10, 16)
+				if err != nil {
+					err := fmt.Errorf("client config number %v has invalid port in endpoint %s", i, address)
+					cclog.ComponentError(r.name, err)
+					return nil, err
+				}
+				host.address = h
+				host.port = uint16(p)
+			}
+			clientConfig.hosts = append(clientConfig.hosts, host)
+		}
+		totalNumHosts += len(clientConfig.hosts)
+	}
+
+	if totalNumHosts == 0 {
+		err := fmt.Errorf("at least one SNMP host config is required")
+		cclog.ComponentError(r.name, err)
+		return nil, err
+	}
+
+	cclog.ComponentInfo(r.name, "monitoring", totalNumHosts, "SNMP hosts")
+	return r, nil
+}
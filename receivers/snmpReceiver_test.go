@@ -0,0 +1,309 @@
+package receivers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	"github.com/gosnmp/gosnmp"
+)
+
+// snmpAgent is a SNMPv2c agent serving a fixed set of objects
+type snmpAgent struct {
+	t         *testing.T
+	conn      net.PacketConn
+	community string
+	oids      []string
+	objects   map[string]gosnmp.SnmpPDU
+}
+
+// snmpCompareOID compares numeric OIDs in lexicographic order of their sub-identifiers
+func snmpCompareOID(a, b string) int {
+	parse := func(oid string) []int {
+		var n []int
+		for s := range strings.SplitSeq(strings.TrimPrefix(oid, "."), ".") {
+			v, _ := strconv.Atoi(s)
+			n = append(n, v)
+		}
+		return n
+	}
+	return slices.Compare(parse(a), parse(b))
+}
+
+func newSNMPAgent(t *testing.T, community string, objects []gosnmp.SnmpPDU) *snmpAgent {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &snmpAgent{t: t, conn: conn, community: community, objects: make(map[string]gosnmp.SnmpPDU)}
+	for _, object := range objects {
+		a.oids = append(a.oids, object.Name)
+		a.objects[object.Name] = object
+	}
+	slices.SortFunc(a.oids, snmpCompareOID)
+	t.Cleanup(func() { conn.Close() })
+	go a.serve()
+	return a
+}
+
+func (a *snmpAgent) port() int {
+	return a.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// next returns the object following oid
+func (a *snmpAgent) next(oid string) gosnmp.SnmpPDU {
+	i, _ := slices.BinarySearchFunc(a.oids, oid, func(o, oid string) int {
+		if snmpCompareOID(o, oid) <= 0 {
+			return -1
+		}
+		return 1
+	})
+	if i == len(a.oids) {
+		return gosnmp.SnmpPDU{Name: oid, Type: gosnmp.EndOfMibView}
+	}
+	return a.objects[a.oids[i]]
+}
+
+func (a *snmpAgent) serve() {
+	decoder := &gosnmp.GoSNMP{Version: gosnmp.Version2c}
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := a.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		request, err := decoder.SnmpDecodePacket(buf[:n])
+		if err != nil {
+			a.t.Errorf("failed to decode request: %v", err)
+			continue
+		}
+		if request.Community != a.community {
+			continue
+		}
+		var variables []gosnmp.SnmpPDU
+		switch request.PDUType {
+		case gosnmp.GetRequest:
+			for _, v := range request.Variables {
+				object, ok := a.objects[v.Name]
+				if !ok {
+					object = gosnmp.SnmpPDU{Name: v.Name, Type: gosnmp.NoSuchObject}
+				}
+				variables = append(variables, object)
+			}
+		case gosnmp.GetNextRequest:
+			for _, v := range request.Variables {
+				variables = append(variables, a.next(v.Name))
+			}
+		case gosnmp.GetBulkRequest:
+			oids := make([]string, 0, len(request.Variables))
+			for _, v := range request.Variables {
+				oids = append(oids, v.Name)
+			}
+			for range request.MaxRepetitions {
+				for i, oid := range oids {
+					object := a.next(oid)
+					variables = append(variables, object)
+					oids[i] = object.Name
+				}
+			}
+		}
+		response := &gosnmp.SnmpPacket{
+			Version:   gosnmp.Version2c,
+			Community: request.Community,
+			PDUType:   gosnmp.GetResponse,
+			RequestID: request.RequestID,
+			Variables: variables,
+		}
+		out, err := response.MarshalMsg()
+		if err != nil {
+			a.t.Errorf("failed to encode response: %v", err)
+			continue
+		}
+		a.conn.WriteTo(out, addr)
+	}
+}
+
+func TestSNMPReceiver(t *testing.T) {
+	agent := newSNMPAgent(t, "secret", []gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(4200)},
+		{Name: ".1.3.6.1.4.1.318.1.1.12.1.16.0", Type: gosnmp.Integer, Value: 1234},
+		{Name: ".1.3.6.1.4.1.9999.1.1.0", Type: gosnmp.OctetString, Value: []byte("23.5")},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.1.1", Type: gosnmp.OctetString, Value: []byte("eth1")},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.1.2", Type: gosnmp.OctetString, Value: []byte("eth2")},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.6.1", Type: gosnmp.Counter64, Value: uint64(1) << 40},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.6.2", Type: gosnmp.Counter64, Value: uint64(42)},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.7.1", Type: gosnmp.Counter64, Value: uint64(7)},
+		{Name: ".1.3.6.1.2.1.2.2.1.10.1", Type: gosnmp.Counter32, Value: uint(100)},
+		{Name: ".1.3.6.1.2.1.2.2.1.10.2", Type: gosnmp.Counter32, Value: uint(200)},
+	})
+
+	config := json.RawMessage(fmt.Sprintf(`{
+		"type": "snmp",
+		"community": "secret",
+		"timeout": "1s",
+		"endpoint": "127.0.0.1:%d",
+		"exclude_metrics": ["uptime"],
+		"client_config": [{
+			"host_list": "pdu01",
+			"metrics": [
+				{"name": "uptime", "oid": "1.3.6.1.2.1.1.3.0"},
+				{"name": "power", "oid": "1.3.6.1.4.1.318.1.1.12.1.16.0", "unit": "Watt"},
+				{"name": "temperature", "oid": ".1.3.6.1.4.1.9999.1.1.0", "unit": "degC", "scale": 2},
+				{"name": "missing", "oid": "1.3.6.1.4.1.9999.1.2.0"}
+			],
+			"tables": [{
+				"index_tag": "port",
+				"tags": {"port_name": "1.3.6.1.2.1.31.1.1.1.1"},
+				"metrics": [
+					{"name": "port_rx_bytes", "oid": "1.3.6.1.2.1.31.1.1.1.6", "unit": "bytes"},
+					{"name": "port_rx_bytes32", "oid": "1.3.6.1.2.1.2.2.1.10"}
+				]
+			}]
+		}]
+	}`, agent.port()))
+	r, err := NewSNMPReceiver("test", config)
+	if err != nil {
+		t.Fatalf("failed to create SNMP receiver: %v", err)
+	}
+	sink := make(chan lp.CCMessage, 100)
+	r.SetSink(sink)
+	s := r.(*SNMPReceiver)
+	// Force walking the tables with multiple GETBULK requests
+	s.config.ClientConfigs[0].MaxRepetitions = 1
+	s.doReadMetric()
+	close(sink)
+
+	metrics := make(map[string]lp.CCMessage)
+	for m := range sink {
+		if h, _ := m.GetTag("hostname"); h != "pdu01" {
+			t.Errorf("unexpected hostname %s", h)
+		}
+		key := m.Name()
+		if port, ok := m.GetTag("port"); ok {
+			name, _ := m.GetTag("port_name")
+			key += "/" + port + "/" + name
+		}
+		metrics[key] = m
+	}
+	if len(metrics) != 6 {
+		t.Errorf("expected 6 metrics, got %d: %v", len(metrics), metrics)
+	}
+	for key, want := range map[string]any{
+		"power":                  float64(1234),
+		"temperature":            float64(47),
+		"port_rx_bytes/1/eth1":   uint64(1) << 40,
+		"port_rx_bytes/2/eth2":   uint64(42),
+		"port_rx_bytes32/1/eth1": uint64(100),
+		"port_rx_bytes32/2/eth2": uint64(200),
+	} {
+		m, ok := metrics[key]
+		if !ok {
+			t.Errorf("missing metric %s", key)
+			continue
+		}
+		if v, _ := m.GetField("value"); v != want {
+			t.Errorf("metric %s: expected value %v (%T), got %v (%T)", key, want, want, v, v)
+		}
+	}
+	if unit, _ := metrics["power"].GetMeta("unit"); unit != "Watt" {
+		t.Errorf("expected unit Watt, got %s", unit)
+	}
+	if _, ok := metrics["power"].GetMeta("metric_type"); ok {
+		t.Errorf("gauge power has metric type meta")
+	}
+	if bits, _ := metrics["port_rx_bytes/1/eth1"].GetMeta("counter_bits"); bits != "64" {
+		t.Errorf("expected 64 counter bits, got %s", bits)
+	}
+	if bits, _ := metrics["port_rx_bytes32/1/eth1"].GetMeta("counter_bits"); bits != "32" {
+		t.Errorf("expected 32 counter bits, got %s", bits)
+	}
+	if kind, _ := metrics["port_rx_bytes32/1/eth1"].GetMeta("metric_type"); kind != "counter" {
+		t.Errorf("expected metric type counter, got %s", kind)
+	}
+}
+
+func TestSNMPReceiverWrongCommunity(t *testing.T) {
+	agent := newSNMPAgent(t, "secret", []gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.4.1.318.1.1.12.1.16.0", Type: gosnmp.Integer, Value: 1234},
+	})
+	config := json.RawMessage(fmt.Sprintf(`{
+		"type": "snmp",
+		"community": "public",
+		"timeout": "100ms",
+		"retries": 0,
+		"endpoint": "127.0.0.1:%d",
+		"metrics": [{"name": "power", "oid": "1.3.6.1.4.1.318.1.1.12.1.16.0"}],
+		"client_config": [{"host_list": "pdu01"}]
+	}`, agent.port()))
+	r, err := NewSNMPReceiver("test", config)
+	if err != nil {
+		t.Fatalf("failed to create SNMP receiver: %v", err)
+	}
+	sink := make(chan lp.CCMessage, 10)
+	r.SetSink(sink)
+	r.(*SNMPReceiver).doReadMetric()
+	if len(sink) != 0 {
+		t.Errorf("expected no metrics, got %d", len(sink))
+	}
+}
+
+func TestSNMPReceiverConfig(t *testing.T) {
+	metrics := `"metrics": [{"name": "power", "oid": "1.3.6.1.4.1.318.1.1.12.1.16.0"}]`
+	for _, config := range []string{
+		`{"type": "snmp", ` + metrics + `, "client_config": [{"host_list": "pdu01"}]}`,
+		`{"type": "snmp", "community": "public", "client_config": [{"host_list": "pdu01"}]}`,
+		`{"type": "snmp", "community": "public", "metrics": [{"name": "power", "oid": "1.3.x"}], "client_config": [{"host_list": "pdu01"}]}`,
+		`{"type": "snmp", "community": "public", "version": "1", ` + metrics + `, "client_config": [{"host_list": "pdu01"}]}`,
+		`{"type": "snmp", "community": "public", "endpoint": "%h:snmp", ` + metrics + `, "client_config": [{"host_list": "pdu01"}]}`,
+		`{"type": "snmp", "version": "3", ` + metrics + `, "client_config": [{"host_list": "pdu01"}]}`,
+		`{"type": "snmp", "version": "3", "v3": {"username": "monitor", "auth_password": "x"}, ` + metrics + `, "client_config": [{"host_list": "pdu01"}]}`,
+		`{"type": "snmp", "version": "3", "v3": {"username": "monitor", "security_level": "authNoPriv", "auth_protocol": "SHA1", "auth_password": "x"}, ` + metrics + `, "client_config": [{"host_list": "pdu01"}]}`,
+		`{"type": "snmp", "community": "public", "tables": [{"index_tag": "port", "tags": {"port": "1.3.6.1.2.1.31.1.1.1.1"}, ` + metrics + `}], "client_config": [{"host_list": "sw01"}]}`,
+		`{"type": "snmp", "community": "public", "fanout": 0, ` + metrics + `, "client_config": [{"host_list": "pdu01"}]}`,
+		`{"type": "snmp", "community": "public", ` + metrics + `, "client_config": [{"host_list": "pdu01", "fanout": -1}]}`,
+	} {
+		if _, err := NewSNMPReceiver("test", json.RawMessage(config)); err == nil {
+			t.Errorf("invalid config was accepted: %s", config)
+		}
+	}
+
+	config := json.RawMessage(`{
+		"type": "snmp",
+		"version": "3",
+		"v3": {"username": "monitor", "auth_protocol": "sha256", "auth_password": "authpass", "priv_password": "privpass"},
+		` + metrics + `,
+		"client_config": [
+			{"host_list": "pdu[01-02]", "endpoint": "%h-mgmt:1161"},
+			{"host_list": "sw01", "version": "2c", "community": "public"}
+		]
+	}`)
+	r, err := NewSNMPReceiver("test", config)
+	if err != nil {
+		t.Fatalf("failed to create SNMP receiver: %v", err)
+	}
+	s := r.(*SNMPReceiver)
+	if len(s.config.ClientConfigs) != 2 || len(s.config.ClientConfigs[0].hosts) != 2 {
+		t.Fatalf("unexpected client configs %+v", s.config.ClientConfigs)
+	}
+	host := s.config.ClientConfigs[0].hosts[0]
+	if host.address != "pdu01-mgmt" || host.port != 1161 || host.hostname != "pdu01" {
+		t.Errorf("unexpected host %+v", host)
+	}
+	client := s.client(host)
+	usm := client.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+	if client.Version != gosnmp.Version3 || client.MsgFlags != gosnmp.AuthPriv ||
+		usm.UserName != "monitor" || usm.AuthenticationProtocol != gosnmp.SHA256 ||
+		usm.AuthenticationPassphrase != "authpass" || usm.PrivacyProtocol != gosnmp.AES ||
+		usm.PrivacyPassphrase != "privpass" {
+		t.Errorf("unexpected SNMPv3 client %+v %+v", client, usm)
+	}
+	client = s.client(s.config.ClientConfigs[1].hosts[0])
+	if client.Version != gosnmp.Version2c || client.Community != "public" || client.Port != SNMP_DEFAULT_PORT {
+		t.Errorf("unexpected SNMPv2c client %+v", client)
+	}
+}
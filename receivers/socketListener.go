@@ -0,0 +1,101 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import (
+	"net"
+	"sync"
+
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+)
+
+// socketListener accepts the connections of a stream socket or reads the
+// datagrams of a packet socket in the background. It is embedded by the
+// receivers of line based protocols. The receiver opens listener or packet
+// and creates conns and done before calling serve.
+type socketListener struct {
+	listener net.Listener
+	packet   net.PacketConn
+	conns    map[net.Conn]struct{}
+	connLock sync.Mutex
+	done     chan struct{} // closed by shutdown
+	wg       sync.WaitGroup
+}
+
+// serve calls handleConn for each accepted connection and handlePacket for
+// each received datagram. The connection is closed when handleConn returns.
+func (s *socketListener) serve(name string, handleConn func(conn net.Conn), handlePacket func(data []byte)) {
+	if s.listener != nil {
+		s.wg.Go(func() {
+			for {
+				conn, err := s.listener.Accept()
+				if err != nil {
+					select {
+					case <-s.done:
+					default:
+						cclog.ComponentError(name, "Accept failed:", err.Error())
+					}
+					return
+				}
+				s.connLock.Lock()
+				select {
+				case <-s.done:
+					// shutdown already closed the known connections
+					s.connLock.Unlock()
+					conn.Close()
+					return
+				default:
+				}
+				s.conns[conn] = struct{}{}
+				s.connLock.Unlock()
+				s.wg.Go(func() {
+					defer func() {
+						s.connLock.Lock()
+						delete(s.conns, conn)
+						s.connLock.Unlock()
+						conn.Close()
+					}()
+					handleConn(conn)
+				})
+			}
+		})
+	}
+	if s.packet != nil {
+		s.wg.Go(func() {
+			buf := make([]byte, SOCKET_RECEIVER_MAX_DATAGRAM_SIZE)
+			for {
+				n, _, err := s.packet.ReadFrom(buf)
+				if err != nil {
+					select {
+					case <-s.done:
+					default:
+						cclog.ComponentError(name, "Read failed:", err.Error())
+					}
+					return
+				}
+				handlePacket(buf[:n])
+			}
+		})
+	}
+}
+
+// shutdown stops listening, closes all connections and waits until all
+// handlers and other goroutines of wg returned
+func (s *socketListener) shutdown() {
+	s.connLock.Lock()
+	close(s.done)
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	if s.packet != nil {
+		s.packet.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.connLock.Unlock()
+	s.wg.Wait()
+}
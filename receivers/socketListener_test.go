@@ -0,0 +1,53 @@
+package receivers
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSocketListenerShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &socketListener{listener: l, conns: make(map[net.Conn]struct{}), done: make(chan struct{})}
+	lines := make(chan string, 1)
+	s.serve("test", func(conn net.Conn) {
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}, nil)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello\n"))
+	select {
+	case line := <-lines:
+		if line != "hello" {
+			t.Errorf("unexpected line '%s'", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for line")
+	}
+
+	// The open connection does not block the shutdown
+	closed := make(chan struct{})
+	go func() {
+		s.shutdown()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown blocked on open connection")
+	}
+	if len(s.conns) != 0 {
+		t.Errorf("expected no tracked connections, got %d", len(s.conns))
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	mp "github.com/ClusterCockpit/cc-lib/v2/messageProcessor"
+	influx "github.com/ClusterCockpit/cc-line-protocol/v2/lineprotocol"
+)
+
+// Maximum size of a datagram received by the socket, graphite and statsd receivers
+const SOCKET_RECEIVER_MAX_DATAGRAM_SIZE = 65536
+
+// SocketReceiverConfig configures the receiver for line protocol over plain sockets.
+type SocketReceiverConfig struct {
+	defaultReceiverConfig
+	Protocol    string `json:"protocol"`               // Socket type: tcp, udp, unix (stream) or unixgram (datagram)
+	Addr        string `json:"address,omitempty"`      // Address to listen on for tcp and udp (default: all interfaces)
+	Port        string `json:"port,omitempty"`         // Port to listen on for tcp and udp
+	Path        string `json:"socket_path,omitempty"`  // Path of the unix domain socket
+	SocketMode  string `json:"socket_mode,omitempty"`  // File permissions of the unix domain socket in octal notation (default: 0660)
+	ReadTimeout string `json:"read_timeout,omitempty"` // Close stream connections without data for this duration (default: no timeout)
+}
+
+type SocketReceiver struct {
+	receiver
+	config      SocketReceiverConfig
+	readTimeout time.Duration
+	socketListener
+}
+
+// timeoutReader extends the read deadline of a connection before each read
+type timeoutReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (t *timeoutReader) Read(p []byte) (int, error) {
+	if err := t.conn.SetReadDeadline(time.Now().Add(t.timeout)); err != nil {
+		return 0, err
+	}
+	return t.conn.Read(p)
+}
+
+// decode decodes line protocol from in, which may be gzip compressed,
+// and forwards the messages to the sink
+func (r *SocketReceiver) decode(in io.Reader) error {
+	br := bufio.NewReader(in)
+	var reader io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("failed to read gzip data: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	d := influx.NewDecoder(reader)
+	for d.Next() {
+		y, err := DecodeInfluxMessage(d)
+		if err != nil {
+			cclog.ComponentError(r.name, "Failed to decode message:", err.Error())
+			continue
+		}
+		m, err := r.mp.ProcessMessage(y)
+		if err != nil || m == nil {
+			continue
+		}
+		select {
+		case <-r.done:
+			return nil
+		case r.sink <- m:
+		}
+	}
+	return d.Err()
+}
+
+// serveConn handles a single stream connection
+func (r *SocketReceiver) serveConn(conn net.Conn) {
+	var in io.Reader = conn
+	if r.readTimeout > 0 {
+		in = &timeoutReader{conn: conn, timeout: r.readTimeout}
+	}
+	if err := r.decode(in); err != nil {
+		select {
+		case <-r.done:
+		default:
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				cclog.ComponentDebug(r.name, "Closing idle connection from", conn.RemoteAddr())
+			} else {
+				cclog.ComponentError(r.name, "Read from", conn.RemoteAddr(), "failed:", err.Error())
+			}
+		}
+	}
+}
+
+// Start accepts connections or datagrams in the background
+func (r *SocketReceiver) Start() {
+	cclog.ComponentDebug(r.name, "START")
+	r.serve(r.name, r.serveConn, func(data []byte) {
+		// Each datagram is decoded on its own
+		if err := r.decode(bytes.NewReader(data)); err != nil {
+			cclog.ComponentError(r.name, "Failed to decode datagram:", err.Error())
+		}
+	})
+}
+
+// Close stops listening and closes all connections
+func (r *SocketReceiver) Close() {
+	cclog.ComponentDebug(r.name, "CLOSE")
+	r.shutdown()
+	if r.packet != nil && r.config.Protocol == "unixgram" {
+		os.Remove(r.config.Path)
+	}
+	cclog.ComponentDebug(r.name, "DONE")
+}
+
+// NewSocketReceiver creates a new Receiver for line protocol over TCP, UDP and unix domain sockets
+func NewSocketReceiver(name string, config json.RawMessage) (Receiver, error) {
+	r := new(SocketReceiver)
+	r.name = fmt.Sprintf("SocketReceiver(%s)", name)
+	r.config.SocketMode = "0660"
+
+	if len(config) > 0 {
+		err := json.Unmarshal(config, &r.config)
+		if err != nil {
+			cclog.ComponentError(r.name, "Error reading config:", err.Error())
+			return nil, err
+		}
+	}
+	if len(r.config.ReadTimeout) > 0 {
+		t, err := time.ParseDuration(r.config.ReadTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to parse read timeout '%s': %w", r.name, r.config.ReadTimeout, err)
+		}
+		r.readTimeout = t
+	}
+
+	p, err := mp.NewMessageProcessor()
+	if err != nil {
+		return nil, fmt.Errorf("initialization of message processor failed: %w", err)
+	}
+	r.mp = p
+	if len(r.config.MessageProcessor) > 0 {
+		err = r.mp.FromConfigJSON(r.config.MessageProcessor)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing JSON for message processor: %w", err)
+		}
+	}
+	r.mp.AddAddMetaByCondition("true", "source", r.name)
+
+	switch r.config.Protocol {
+	case "tcp", "udp":
+		if len(r.config.Port) == 0 {
+			return nil, errors.New("not all configuration variables set required by SocketReceiver (port)")
+		}
+		addr := net.JoinHostPort(r.config.Addr, r.config.Port)
+		if r.config.Protocol == "tcp" {
+			r.listener, err = net.Listen("tcp", addr)
+		} else {
+			r.packet, err = net.ListenPacket("udp", addr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to listen on %s: %w", r.name, addr, err)
+		}
+	case "unix", "unixgram":
+		if len(r.config.Path) == 0 {
+			return nil, errors.New("not all configuration variables set required by SocketReceiver (socket_path)")
+		}
+		mode, err := strconv.ParseUint(r.config.SocketMode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid socket mode '%s': %w", r.name, r.config.SocketMode, err)
+		}
+		// Remove stale socket of a previous run
+		if fi, err := os.Lstat(r.config.Path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(r.config.Path)
+		}
+		if r.config.Protocol == "unix" {
+			r.listener, err = net.Listen("unix", r.config.Path)
+		} else {
+			r.packet, err = net.ListenPacket("unixgram", r.config.Path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to listen on %s: %w", r.name, r.config.Path, err)
+		}
+		if err := os.Chmod(r.config.Path, os.FileMode(mode)); err != nil {
+			if r.listener != nil {
+				r.listener.Close()
+			} else {
+				r.packet.Close()
+				os.Remove(r.config.Path)
+			}
+			return nil, fmt.Errorf("%s: failed to set permissions of %s: %w", r.name, r.config.Path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown protocol '%s' for SocketReceiver", r.config.Protocol)
+	}
+	r.conns = make(map[net.Conn]struct{})
+	r.done = make(chan struct{})
+
+	return r, nil
+}
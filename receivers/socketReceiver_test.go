@@ -0,0 +1,113 @@
+package receivers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+)
+
+func TestSocketReceiver(t *testing.T) {
+	dir := t.TempDir()
+	m, _ := lp.NewMetric("testmetric", map[string]string{"type": "node"}, nil, 42.0, time.Unix(1700000000, 0))
+	line := m.ToLineProtocol(nil)
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(line))
+	gz.Close()
+
+	tests := []struct {
+		protocol string
+		config   string
+		payload  []byte
+	}{
+		{"tcp", `"address": "127.0.0.1", "port": "0"`, []byte(line + line)},
+		{"tcp", `"address": "127.0.0.1", "port": "0"`, append(compressed.Bytes(), compressed.Bytes()...)},
+		{"udp", `"address": "127.0.0.1", "port": "0"`, []byte(line + line)},
+		{"unix", fmt.Sprintf(`"socket_path": "%s/stream.sock", "socket_mode": "0600"`, dir), compressed.Bytes()},
+		{"unixgram", fmt.Sprintf(`"socket_path": "%s/dgram.sock"`, dir), compressed.Bytes()},
+	}
+	for _, tt := range tests {
+		config := json.RawMessage(fmt.Sprintf(`{"type": "socket", "protocol": "%s", %s}`, tt.protocol, tt.config))
+		r, err := NewSocketReceiver("testreceiver", config)
+		if err != nil {
+			t.Fatalf("%s: failed to create socket receiver: %v", tt.protocol, err)
+		}
+		s := r.(*SocketReceiver)
+		var addr string
+		if s.listener != nil {
+			addr = s.listener.Addr().String()
+		} else {
+			addr = s.packet.LocalAddr().String()
+		}
+		if tt.protocol == "unix" {
+			if fi, err := os.Stat(addr); err != nil || fi.Mode().Perm() != 0o600 {
+				t.Errorf("invalid permissions of socket %s", addr)
+			}
+		}
+
+		sink := make(chan lp.CCMessage, 10)
+		r.SetSink(sink)
+		r.Start()
+
+		conn, err := net.Dial(tt.protocol, addr)
+		if err != nil {
+			t.Fatalf("%s: failed to connect to %s: %v", tt.protocol, addr, err)
+		}
+		conn.Write(tt.payload)
+		conn.Close()
+
+		select {
+		case x := <-sink:
+			if x.Name() != m.Name() || !x.Time().Equal(m.Time()) {
+				t.Errorf("%s: invalid message %s", tt.protocol, x)
+			}
+			if source, _ := x.GetMeta("source"); source != r.Name() {
+				t.Errorf("%s: invalid source meta '%s'", tt.protocol, source)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: timeout waiting for message", tt.protocol)
+		}
+		r.Close()
+
+		if tt.protocol == "unixgram" {
+			if _, err := os.Stat(filepath.Join(dir, "dgram.sock")); err == nil {
+				t.Errorf("socket file was not removed")
+			}
+		}
+	}
+}
+
+func TestSocketReceiverReadTimeout(t *testing.T) {
+	config := json.RawMessage(`{"type": "socket", "protocol": "tcp", "address": "127.0.0.1", "port": "0", "read_timeout": "100ms"}`)
+	r, err := NewSocketReceiver("testreceiver", config)
+	if err != nil {
+		t.Fatalf("failed to create socket receiver: %v", err)
+	}
+	defer r.Close()
+	s := r.(*SocketReceiver)
+	r.SetSink(make(chan lp.CCMessage, 10))
+	r.Start()
+
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// The receiver closes the idle connection
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("idle connection was not closed")
+	} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Error("idle connection was not closed within the read timeout")
+	}
+}
@@ -0,0 +1,436 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package receivers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	mp "github.com/ClusterCockpit/cc-lib/v2/messageProcessor"
+)
+
+const (
+	STATSD_TYPE_COUNTER = "c"
+	STATSD_TYPE_GAUGE   = "g"
+	STATSD_TYPE_TIMER   = "ms"
+	STATSD_TYPE_SET     = "s"
+)
+
+// StatsdReceiverConfig configures the receiver for the StatsD protocol.
+type StatsdReceiverConfig struct {
+	defaultReceiverConfig
+	Addr          string            `json:"address,omitempty"`        // Address to listen on (default: all interfaces)
+	Port          string            `json:"port,omitempty"`           // Port to listen on (default: 8125)
+	Protocol      string            `json:"protocol,omitempty"`       // Transport protocol: udp (default) or tcp
+	FlushInterval string            `json:"flush_interval,omitempty"` // Interval to emit the aggregated metrics (default: 10s)
+	Percentiles   []float64         `json:"percentiles,omitempty"`    // Percentiles computed for timers (default: 50, 90, 95, 99)
+	DeleteGauges  bool              `json:"delete_gauges,omitempty"`  // Do not re-send gauges without updates
+	Tags          map[string]string `json:"tags,omitempty"`           // Tags added to all metrics
+	MetaTags      []string          `json:"meta_tags,omitempty"`      // Tag keys which are stored as meta information (default: unit)
+}
+
+// statsdBucket aggregates the samples of one metric with one set of tags
+type statsdBucket struct {
+	name    string
+	kind    string
+	tags    map[string]string
+	meta    map[string]string
+	updated bool
+
+	value  float64             // counter sum or gauge value
+	count  float64             // number of (sample rate corrected) timer samples
+	values []float64           // timer samples
+	set    map[string]struct{} // unique set members
+}
+
+type StatsdReceiver struct {
+	receiver
+	config        StatsdReceiverConfig
+	flushInterval time.Duration
+	metaTags      map[string]bool
+
+	buckets    map[string]*statsdBucket
+	bucketLock sync.Mutex
+
+	socketListener
+}
+
+// statsdSample is a single parsed StatsD sample
+type statsdSample struct {
+	name  string
+	kind  string
+	value string
+	rate  float64
+	tags  map[string]string
+}
+
+// parseStatsdLine parses a line in the format
+// '<name>:<value>|<type>[|@<sample rate>][|#<tag>:<value>,...]'
+// Multiple values can be given in one line separated by ':'.
+func parseStatsdLine(line string) ([]statsdSample, error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || len(name) == 0 {
+		return nil, fmt.Errorf("invalid line '%s'", line)
+	}
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("missing type in line '%s'", line)
+	}
+
+	kind := parts[1]
+	switch kind {
+	case STATSD_TYPE_COUNTER, STATSD_TYPE_GAUGE, STATSD_TYPE_TIMER, STATSD_TYPE_SET:
+	case "h", "d":
+		// histograms and distributions are aggregated like timers
+		kind = STATSD_TYPE_TIMER
+	default:
+		return nil, fmt.Errorf("unknown type '%s' in line '%s'", kind, line)
+	}
+
+	rate := 1.0
+	tags := make(map[string]string)
+	for _, p := range parts[2:] {
+		switch {
+		case strings.HasPrefix(p, "@"):
+			r, err := strconv.ParseFloat(p[1:], 64)
+			if err != nil || r <= 0 || r > 1 {
+				return nil, fmt.Errorf("invalid sample rate '%s' in line '%s'", p[1:], line)
+			}
+			rate = r
+		case strings.HasPrefix(p, "#"):
+			// DogStatsD tags
+			for t := range strings.SplitSeq(p[1:], ",") {
+				if len(t) == 0 {
+					continue
+				}
+				k, v, ok := strings.Cut(t, ":")
+				if !ok {
+					v = "true"
+				}
+				tags[k] = v
+			}
+		}
+		// other DogStatsD extensions like container IDs are ignored
+	}
+
+	out := make([]statsdSample, 0, 1)
+	for value := range strings.SplitSeq(parts[0], ":") {
+		if kind != STATSD_TYPE_SET {
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return nil, fmt.Errorf("invalid value '%s' in line '%s'", value, line)
+			}
+		}
+		out = append(out, statsdSample{name: name, kind: kind, value: value, rate: rate, tags: tags})
+	}
+	return out, nil
+}
+
+// statsdBucketKey returns the aggregation key of a sample
+func statsdBucketKey(s *statsdSample) string {
+	var b strings.Builder
+	b.WriteString(s.name)
+	b.WriteByte('|')
+	b.WriteString(s.kind)
+	for _, k := range slices.Sorted(maps.Keys(s.tags)) {
+		b.WriteByte('|')
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(s.tags[k])
+	}
+	return b.String()
+}
+
+// add aggregates a sample into its bucket
+func (r *StatsdReceiver) add(s *statsdSample) {
+	key := statsdBucketKey(s)
+
+	r.bucketLock.Lock()
+	defer r.bucketLock.Unlock()
+
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &statsdBucket{
+			name: s.name,
+			kind: s.kind,
+			tags: maps.Clone(r.config.Tags),
+			meta: make(map[string]string),
+		}
+		if b.tags == nil {
+			b.tags = make(map[string]string)
+		}
+		for k, v := range s.tags {
+			if r.metaTags[k] {
+				b.meta[k] = v
+			} else {
+				b.tags[k] = v
+			}
+		}
+		if s.kind == STATSD_TYPE_SET {
+			b.set = make(map[string]struct{})
+		}
+		r.buckets[key] = b
+	}
+	b.updated = true
+
+	switch s.kind {
+	case STATSD_TYPE_COUNTER:
+		v, _ := strconv.ParseFloat(s.value, 64)
+		b.value += v / s.rate
+	case STATSD_TYPE_GAUGE:
+		v, _ := strconv.ParseFloat(s.value, 64)
+		// Signed values modify the current gauge value
+		if strings.HasPrefix(s.value, "+") || strings.HasPrefix(s.value, "-") {
+			b.value += v
+		} else {
+			b.value = v
+		}
+	case STATSD_TYPE_TIMER:
+		v, _ := strconv.ParseFloat(s.value, 64)
+		b.values = append(b.values, v)
+		b.count += 1 / s.rate
+	case STATSD_TYPE_SET:
+		b.set[s.value] = struct{}{}
+	}
+}
+
+// statsdPercentileField returns the field name of a percentile, e.g. p99_9 for 99.9
+func statsdPercentileField(p float64) string {
+	return "p" + strings.ReplaceAll(strconv.FormatFloat(p, 'f', -1, 64), ".", "_")
+}
+
+// statsdPercentile returns the nearest-rank percentile p of the sorted values
+func statsdPercentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	rank = min(max(rank, 1), len(sorted))
+	return sorted[rank-1]
+}
+
+// fields returns the message fields of a bucket
+func (r *StatsdReceiver) fields(b *statsdBucket) map[string]any {
+	switch b.kind {
+	case STATSD_TYPE_COUNTER:
+		return map[string]any{
+			"value": b.value,
+			"rate":  b.value / r.flushInterval.Seconds(),
+		}
+	case STATSD_TYPE_GAUGE:
+		return map[string]any{"value": b.value}
+	case STATSD_TYPE_SET:
+		return map[string]any{"value": int64(len(b.set))}
+	case STATSD_TYPE_TIMER:
+		sorted := slices.Clone(b.values)
+		slices.Sort(sorted)
+		sum := 0.0
+		for _, v := range sorted {
+			sum += v
+		}
+		fields := map[string]any{
+			"value": sum / float64(len(sorted)),
+			"count": b.count,
+			"sum":   sum,
+			"min":   sorted[0],
+			"max":   sorted[len(sorted)-1],
+		}
+		for _, p := range r.config.Percentiles {
+			fields[statsdPercentileField(p)] = statsdPercentile(sorted, p)
+		}
+		return fields
+	}
+	return nil
+}
+
+// flush emits all updated buckets and resets them. Sending is aborted when
+// done is closed.
+func (r *StatsdReceiver) flush(t time.Time, done <-chan struct{}) {
+	r.bucketLock.Lock()
+	msgs := make([]lp.CCMessage, 0, len(r.buckets))
+	for key, b := range r.buckets {
+		if !b.updated {
+			if b.kind != STATSD_TYPE_GAUGE || r.config.DeleteGauges {
+				delete(r.buckets, key)
+				continue
+			}
+		}
+		msg, err := lp.NewMessage(b.name, b.tags, b.meta, r.fields(b), t)
+		if err != nil {
+			cclog.ComponentError(r.name, "Failed to create message for", b.name, ":", err.Error())
+		} else {
+			msgs = append(msgs, msg)
+		}
+
+		// Gauges keep their value, all other buckets restart with the next sample
+		if b.kind == STATSD_TYPE_GAUGE {
+			b.updated = false
+		} else {
+			delete(r.buckets, key)
+		}
+	}
+	r.bucketLock.Unlock()
+
+	for _, msg := range msgs {
+		m, err := r.mp.ProcessMessage(msg)
+		if err != nil || m == nil {
+			continue
+		}
+		select {
+		case r.sink <- m:
+		case <-done:
+			return
+		}
+	}
+}
+
+// process parses the lines of in and aggregates the samples
+func (r *StatsdReceiver) process(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		// DogStatsD events and service checks are not supported
+		if strings.HasPrefix(line, "_e{") || strings.HasPrefix(line, "_sc|") {
+			cclog.ComponentDebug(r.name, "Skipping unsupported line", line)
+			continue
+		}
+		samples, err := parseStatsdLine(line)
+		if err != nil {
+			cclog.ComponentError(r.name, err.Error())
+			continue
+		}
+		for i := range samples {
+			r.add(&samples[i])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		select {
+		case <-r.done:
+		default:
+			cclog.ComponentError(r.name, "Read failed:", err.Error())
+		}
+	}
+}
+
+// Start listens for StatsD samples and emits the aggregated metrics
+// every flush interval
+func (r *StatsdReceiver) Start() {
+	cclog.ComponentDebug(r.name, "START")
+	r.serve(r.name, func(conn net.Conn) { r.process(conn) }, func(data []byte) {
+		r.process(bytes.NewReader(data))
+	})
+
+	r.wg.Go(func() {
+		ticker := time.NewTicker(r.flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.done:
+				return
+			case t := <-ticker.C:
+				r.flush(t, r.done)
+			}
+		}
+	})
+}
+
+// Close stops listening and emits the remaining aggregated metrics
+func (r *StatsdReceiver) Close() {
+	cclog.ComponentDebug(r.name, "CLOSE")
+	r.shutdown()
+
+	if r.sink != nil {
+		// Give up on the remaining metrics if the sink is not read anymore
+		timeout := make(chan struct{})
+		timer := time.AfterFunc(time.Second, func() { close(timeout) })
+		r.flush(time.Now(), timeout)
+		timer.Stop()
+	}
+	cclog.ComponentDebug(r.name, "DONE")
+}
+
+// NewStatsdReceiver creates a new Receiver for the StatsD protocol
+func NewStatsdReceiver(name string, config json.RawMessage) (Receiver, error) {
+	r := new(StatsdReceiver)
+	r.name = fmt.Sprintf("StatsdReceiver(%s)", name)
+	r.config.Port = "8125"
+	r.config.Protocol = "udp"
+	r.config.FlushInterval = "10s"
+	r.config.Percentiles = []float64{50, 90, 95, 99}
+	r.config.MetaTags = []string{"unit"}
+
+	if len(config) > 0 {
+		err := json.Unmarshal(config, &r.config)
+		if err != nil {
+			cclog.ComponentError(r.name, "Error reading config:", err.Error())
+			return nil, err
+		}
+	}
+	if len(r.config.Port) == 0 {
+		return nil, errors.New("not all configuration variables set required by StatsdReceiver (port)")
+	}
+	t, err := time.ParseDuration(r.config.FlushInterval)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to parse flush interval '%s': %w", r.name, r.config.FlushInterval, err)
+	}
+	if t <= 0 {
+		return nil, fmt.Errorf("%s: flush interval must be positive", r.name)
+	}
+	r.flushInterval = t
+	for _, p := range r.config.Percentiles {
+		if p <= 0 || p > 100 {
+			return nil, fmt.Errorf("%s: invalid percentile %v", r.name, p)
+		}
+	}
+	r.metaTags = make(map[string]bool)
+	for _, k := range r.config.MetaTags {
+		r.metaTags[k] = true
+	}
+
+	p, err := mp.NewMessageProcessor()
+	if err != nil {
+		return nil, fmt.Errorf("initialization of message processor failed: %w", err)
+	}
+	r.mp = p
+	if len(r.config.MessageProcessor) > 0 {
+		err = r.mp.FromConfigJSON(r.config.MessageProcessor)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing JSON for message processor: %w", err)
+		}
+	}
+	r.mp.AddAddMetaByCondition("true", "source", r.name)
+
+	addr := net.JoinHostPort(r.config.Addr, r.config.Port)
+	switch r.config.Protocol {
+	case "tcp":
+		r.listener, err = net.Listen("tcp", addr)
+	case "udp":
+		r.packet, err = net.ListenPacket("udp", addr)
+	default:
+		return nil, fmt.Errorf("unknown protocol '%s' for StatsdReceiver", r.config.Protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to listen on %s: %w", r.name, addr, err)
+	}
+	r.buckets = make(map[string]*statsdBucket)
+	r.conns = make(map[net.Conn]struct{})
+	r.done = make(chan struct{})
+
+	return r, nil
+}
@@ -0,0 +1,185 @@
+package receivers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+)
+
+func TestParseStatsdLine(t *testing.T) {
+	samples, err := parseStatsdLine("app.requests:1:2|c|@0.5|#hostname:f0101,unit:req,debug")
+	if err != nil {
+		t.Fatalf("failed to parse line: %v", err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("expected 2 samples, got %d", len(samples))
+	}
+	s := samples[1]
+	if s.name != "app.requests" || s.kind != STATSD_TYPE_COUNTER || s.value != "2" || s.rate != 0.5 {
+		t.Errorf("invalid sample %+v", s)
+	}
+	if s.tags["hostname"] != "f0101" || s.tags["unit"] != "req" || s.tags["debug"] != "true" {
+		t.Errorf("invalid tags %v", s.tags)
+	}
+
+	for _, line := range []string{"name", "name:1", "name:1|x", "name:abc|c", "name:1|c|@2"} {
+		if _, err := parseStatsdLine(line); err == nil {
+			t.Errorf("invalid line '%s' was accepted", line)
+		}
+	}
+}
+
+func TestStatsdReceiverAggregation(t *testing.T) {
+	config := json.RawMessage(`{"type": "statsd", "address": "127.0.0.1", "port": "0", "flush_interval": "10s", "percentiles": [50, 99.9], "tags": {"type": "node"}}`)
+	r, err := NewStatsdReceiver("testreceiver", config)
+	if err != nil {
+		t.Fatalf("failed to create statsd receiver: %v", err)
+	}
+	defer r.Close()
+	s := r.(*StatsdReceiver)
+	sink := make(chan lp.CCMessage, 10)
+	r.SetSink(sink)
+
+	lines := []string{
+		"requests:1|c|#unit:req",
+		"requests:3|c|@0.5|#unit:req",
+		"temperature:40|g",
+		"temperature:+2|g",
+		"users:alice|s",
+		"users:bob|s",
+		"users:alice|s",
+	}
+	for i := 1; i <= 100; i++ {
+		lines = append(lines, fmt.Sprintf("latency:%d|ms|#hostname:f0101", i))
+	}
+	for _, line := range lines {
+		samples, err := parseStatsdLine(line)
+		if err != nil {
+			t.Fatalf("failed to parse line '%s': %v", line, err)
+		}
+		for i := range samples {
+			s.add(&samples[i])
+		}
+	}
+
+	s.flush(time.Now(), nil)
+	close(sink)
+	msgs := make(map[string]lp.CCMessage)
+	for m := range sink {
+		msgs[m.Name()] = m
+	}
+	if len(msgs) != 4 {
+		t.Fatalf("expected 4 messages, got %d", len(msgs))
+	}
+
+	check := func(name, field string, want any) {
+		v, ok := msgs[name].GetField(field)
+		if !ok || v != want {
+			t.Errorf("%s: invalid field %s: %v vs %v", name, field, v, want)
+		}
+	}
+	check("requests", "value", 7.0)
+	check("requests", "rate", 0.7)
+	check("temperature", "value", 42.0)
+	check("users", "value", int64(2))
+	check("latency", "value", 50.5)
+	check("latency", "count", 100.0)
+	check("latency", "min", 1.0)
+	check("latency", "max", 100.0)
+	check("latency", "p50", 50.0)
+	check("latency", "p99_9", 100.0)
+
+	if unit, _ := msgs["requests"].GetMeta("unit"); unit != "req" {
+		t.Errorf("tag unit was not moved to meta: %v", msgs["requests"].Meta())
+	}
+	if hostname, _ := msgs["latency"].GetTag("hostname"); hostname != "f0101" {
+		t.Errorf("invalid tags: %v", msgs["latency"].Tags())
+	}
+	if typ, _ := msgs["latency"].GetTag("type"); typ != "node" {
+		t.Errorf("default tags missing: %v", msgs["latency"].Tags())
+	}
+
+	// Only gauges are kept for the next flush
+	sink = make(chan lp.CCMessage, 10)
+	r.SetSink(sink)
+	s.flush(time.Now(), nil)
+	close(sink)
+	n := 0
+	for m := range sink {
+		if m.Name() != "temperature" {
+			t.Errorf("unexpected message %s after second flush", m.Name())
+		}
+		n++
+	}
+	if n != 1 {
+		t.Errorf("expected 1 message after second flush, got %d", n)
+	}
+	r.SetSink(nil)
+}
+
+func TestStatsdReceiver(t *testing.T) {
+	config := json.RawMessage(`{"type": "statsd", "address": "127.0.0.1", "port": "0", "flush_interval": "100ms"}`)
+	r, err := NewStatsdReceiver("testreceiver", config)
+	if err != nil {
+		t.Fatalf("failed to create statsd receiver: %v", err)
+	}
+	s := r.(*StatsdReceiver)
+	sink := make(chan lp.CCMessage, 10)
+	r.SetSink(sink)
+	r.Start()
+	defer r.Close()
+
+	conn, err := net.Dial("udp", s.packet.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	fmt.Fprintf(conn, "jobs:1|c\njobs:2|c")
+	conn.Close()
+
+	select {
+	case m := <-sink:
+		if v, _ := m.GetMetricValue(); m.Name() != "jobs" || v != 3.0 {
+			t.Errorf("invalid message %s", m)
+		}
+		if source, _ := m.GetMeta("source"); source != r.Name() {
+			t.Errorf("invalid source meta '%s'", source)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+}
+
+func TestStatsdReceiverCloseBlockedSink(t *testing.T) {
+	config := json.RawMessage(`{"type": "statsd", "address": "127.0.0.1", "port": "0", "flush_interval": "50ms"}`)
+	r, err := NewStatsdReceiver("testreceiver", config)
+	if err != nil {
+		t.Fatalf("failed to create statsd receiver: %v", err)
+	}
+	s := r.(*StatsdReceiver)
+	// Unbuffered sink which is never read
+	r.SetSink(make(chan lp.CCMessage))
+	r.Start()
+
+	conn, err := net.Dial("udp", s.packet.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	fmt.Fprintf(conn, "jobs:1|c\nusers:2|g")
+	conn.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		r.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on the sink")
+	}
+}
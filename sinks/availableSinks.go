@@ -11,9 +11,12 @@ import "encoding/json"
 // Map of all available sinks
 var AvailableSinks = map[string]func(name string, config json.RawMessage) (Sink, error){
 	"stdout":      NewStdoutSink,
+	"file":        NewFileSink,
+	"graphite":    NewGraphiteSink,
 	"nats":        NewNatsSink,
 	"influxdb":    NewInfluxSink,
 	"influxasync": NewInfluxAsyncSink,
 	"http":        NewHttpSink,
 	"prometheus":  NewPrometheusSink,
+	"parquet":     NewParquetSink,
 }
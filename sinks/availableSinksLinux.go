@@ -12,10 +12,13 @@ import "encoding/json"
 var AvailableSinks = map[string]func(name string, config json.RawMessage) (Sink, error){
 	"ganglia":     NewGangliaSink,
 	"stdout":      NewStdoutSink,
+	"file":        NewFileSink,
+	"graphite":    NewGraphiteSink,
 	"nats":        NewNatsSink,
 	"influxdb":    NewInfluxSink,
 	"influxasync": NewInfluxAsyncSink,
 	"http":        NewHttpSink,
 	"prometheus":  NewPrometheusSink,
+	"parquet":     NewParquetSink,
 	"questdb":     NewQuestDBSink,
 }
@@ -0,0 +1,137 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sinks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// BatchStats are the cumulative statistics of the batches sent by a sink
+type BatchStats struct {
+	Batches         uint64 // Number of batches
+	Lines           uint64 // Number of line protocol lines
+	Bytes           uint64 // Size of the batches before compression
+	CompressedBytes uint64 // Size of the batches after compression
+}
+
+// CompressionRatio returns the ratio of uncompressed to compressed size
+func (s BatchStats) CompressionRatio() float64 {
+	if s.CompressedBytes == 0 {
+		return 0
+	}
+	return float64(s.Bytes) / float64(s.CompressedBytes)
+}
+
+// batchStats counts the batches of a sink
+type batchStats struct {
+	batches         atomic.Uint64
+	lines           atomic.Uint64
+	bytes           atomic.Uint64
+	compressedBytes atomic.Uint64
+}
+
+func (s *batchStats) add(lines, size, compressedSize int) {
+	s.batches.Add(1)
+	s.lines.Add(uint64(lines))
+	s.bytes.Add(uint64(size))
+	s.compressedBytes.Add(uint64(compressedSize))
+}
+
+func (s *batchStats) get() BatchStats {
+	return BatchStats{
+		Batches:         s.batches.Load(),
+		Lines:           s.lines.Load(),
+		Bytes:           s.bytes.Load(),
+		CompressedBytes: s.compressedBytes.Load(),
+	}
+}
+
+// splitBatch splits line protocol in buf into batches of at most maxBytes
+// bytes and maxLines lines (0: unlimited). Lines are never split, so a single
+// line larger than maxBytes forms its own batch. It returns the batches and
+// the number of lines of each batch.
+func splitBatch(buf []byte, maxBytes, maxLines int) ([][]byte, []int) {
+	var (
+		batches [][]byte
+		lines   []int
+	)
+	start, n := 0, 0
+	for pos := 0; pos < len(buf); {
+		end := bytes.IndexByte(buf[pos:], '\n')
+		if end < 0 {
+			end = len(buf)
+		} else {
+			end += pos + 1
+		}
+		if n > 0 && ((maxBytes > 0 && end-start > maxBytes) || (maxLines > 0 && n >= maxLines)) {
+			batches = append(batches, buf[start:pos])
+			lines = append(lines, n)
+			start, n = pos, 0
+		}
+		n++
+		pos = end
+	}
+	if n > 0 {
+		batches = append(batches, buf[start:])
+		lines = append(lines, n)
+	}
+	return batches, lines
+}
+
+// batchCompressor compresses batches. It is safe for concurrent use.
+type batchCompressor struct {
+	encoding string // Content-Encoding of the compressed data, empty without compression
+	gzip     sync.Pool
+	zstd     *zstd.Encoder
+}
+
+// newBatchCompressor creates a compressor for the compression 'gzip', 'zstd' or 'none'
+func newBatchCompressor(compression string) (*batchCompressor, error) {
+	c := new(batchCompressor)
+	switch compression {
+	case "", "none":
+	case "gzip":
+		c.encoding = "gzip"
+		c.gzip.New = func() any { return gzip.NewWriter(nil) }
+	case "zstd":
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		c.encoding = "zstd"
+		c.zstd = enc
+	default:
+		return nil, fmt.Errorf("unknown compression '%s'", compression)
+	}
+	return c, nil
+}
+
+// compress returns the compressed batch or the batch itself without compression
+func (c *batchCompressor) compress(batch []byte) ([]byte, error) {
+	switch c.encoding {
+	case "gzip":
+		var out bytes.Buffer
+		w := c.gzip.Get().(*gzip.Writer)
+		defer c.gzip.Put(w)
+		w.Reset(&out)
+		if _, err := w.Write(batch); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	case "zstd":
+		return c.zstd.EncodeAll(batch, nil), nil
+	}
+	return batch, nil
+}
@@ -0,0 +1,93 @@
+package sinks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestSplitBatch(t *testing.T) {
+	buf := []byte("a 1\nbb 2\nccc 3\ndddddddddd 4\ne 5\n")
+	tests := []struct {
+		maxBytes int
+		maxLines int
+		want     []string
+		lines    []int
+	}{
+		{0, 0, []string{string(buf)}, []int{5}},
+		{0, 2, []string{"a 1\nbb 2\n", "ccc 3\ndddddddddd 4\n", "e 5\n"}, []int{2, 2, 1}},
+		{10, 0, []string{"a 1\nbb 2\n", "ccc 3\n", "dddddddddd 4\n", "e 5\n"}, []int{2, 1, 1, 1}},
+		{16, 1, []string{"a 1\n", "bb 2\n", "ccc 3\n", "dddddddddd 4\n", "e 5\n"}, []int{1, 1, 1, 1, 1}},
+	}
+	for _, tt := range tests {
+		batches, lines := splitBatch(buf, tt.maxBytes, tt.maxLines)
+		got := make([]string, len(batches))
+		for i, b := range batches {
+			got[i] = string(b)
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("max %d bytes, %d lines: expected %q, got %q", tt.maxBytes, tt.maxLines, tt.want, got)
+		}
+		for i := range lines {
+			if i >= len(tt.lines) || lines[i] != tt.lines[i] {
+				t.Errorf("max %d bytes, %d lines: expected lines %v, got %v", tt.maxBytes, tt.maxLines, tt.lines, lines)
+				break
+			}
+		}
+	}
+
+	if batches, _ := splitBatch([]byte("no newline"), 4, 0); len(batches) != 1 || string(batches[0]) != "no newline" {
+		t.Errorf("invalid split of single line: %q", batches)
+	}
+}
+
+func TestBatchCompressor(t *testing.T) {
+	batch := bytes.Repeat([]byte("testmetric,hostname=f0101,type=node value=42 1700000000\n"), 100)
+	decompress := map[string]func([]byte) ([]byte, error){
+		"": func(b []byte) ([]byte, error) { return b, nil },
+		"gzip": func(b []byte) ([]byte, error) {
+			r, err := gzip.NewReader(bytes.NewReader(b))
+			if err != nil {
+				return nil, err
+			}
+			return io.ReadAll(r)
+		},
+		"zstd": func(b []byte) ([]byte, error) {
+			d, err := zstd.NewReader(nil)
+			if err != nil {
+				return nil, err
+			}
+			defer d.Close()
+			return d.DecodeAll(b, nil)
+		},
+	}
+	for _, compression := range []string{"none", "gzip", "zstd"} {
+		c, err := newBatchCompressor(compression)
+		if err != nil {
+			t.Fatalf("%s: %v", compression, err)
+		}
+		out, err := c.compress(batch)
+		if err != nil {
+			t.Fatalf("%s: compression failed: %v", compression, err)
+		}
+		if compression != "none" && len(out) >= len(batch) {
+			t.Errorf("%s: batch was not compressed (%d bytes)", compression, len(out))
+		}
+		plain, err := decompress[c.encoding](out)
+		if err != nil || !bytes.Equal(plain, batch) {
+			t.Errorf("%s: round trip failed: %v", compression, err)
+		}
+	}
+	if _, err := newBatchCompressor("lz4"); err == nil {
+		t.Error("unknown compression was accepted")
+	}
+
+	stats := BatchStats{Bytes: 1000, CompressedBytes: 250}
+	if stats.CompressionRatio() != 4 {
+		t.Errorf("invalid compression ratio %f", stats.CompressionRatio())
+	}
+}
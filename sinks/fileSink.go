@@ -0,0 +1,444 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package sinks
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	mp "github.com/ClusterCockpit/cc-lib/v2/messageProcessor"
+	"github.com/ClusterCockpit/cc-lib/v2/util"
+)
+
+const (
+	FILE_SINK_FORMAT_LINEPROTOCOL = "lineprotocol"
+	FILE_SINK_FORMAT_JSON         = "json"
+	FILE_SINK_FORMAT_CSV          = "csv"
+)
+
+type FileSinkConfig struct {
+	defaultSinkConfig
+
+	// Path template of the output files. Tags are inserted with {tagname},
+	// the current time with strftime-like directives (%Y, %m, %d, %H, %M, %S, %j)
+	Path string `json:"path"`
+
+	// Output format: lineprotocol (default), json or csv
+	Format string `json:"format,omitempty"`
+
+	// Rotate a file when it exceeds this size in bytes (0 disables size based rotation)
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	// Rotate a file after it was open for this duration (empty disables time based rotation)
+	RotateInterval string `json:"rotate_interval,omitempty"`
+	rotateInterval time.Duration
+
+	// Compress closed files with gzip
+	Compress bool `json:"compress,omitempty"`
+
+	// Remove closed files older than this duration (empty keeps all files)
+	Retention string `json:"retention,omitempty"`
+	retention time.Duration
+
+	// File permissions of created files (default: 0640) and directories (default: 0750)
+	FileMode string `json:"file_mode,omitempty"`
+	fileMode os.FileMode
+	DirMode  string `json:"dir_mode,omitempty"`
+	dirMode  os.FileMode
+}
+
+// fileSinkOutput is an open output file of the file sink
+type fileSinkOutput struct {
+	path   string
+	file   *os.File
+	writer *bufio.Writer
+	size   int64
+	opened time.Time
+}
+
+type FileSink struct {
+	sink
+	config FileSinkConfig
+
+	// Open output files by their path template rendered without time directives.
+	// A change of the full path (e.g. a new day) rotates the file.
+	outputs map[string]*fileSinkOutput
+	lock    sync.Mutex
+
+	// Glob pattern matching all files written by this sink (used for retention)
+	glob string
+
+	// Background compression and cleanup
+	wg sync.WaitGroup
+}
+
+var fileSinkTagPattern = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// fileSinkTimePattern matches the supported strftime-like directives
+var fileSinkTimePattern = regexp.MustCompile(`%[YmdHMSj%]`)
+
+// renderTime replaces the strftime-like directives in s with the values of t
+func renderTime(s string, t time.Time) string {
+	return fileSinkTimePattern.ReplaceAllStringFunc(s, func(d string) string {
+		switch d {
+		case "%Y":
+			return fmt.Sprintf("%04d", t.Year())
+		case "%m":
+			return fmt.Sprintf("%02d", int(t.Month()))
+		case "%d":
+			return fmt.Sprintf("%02d", t.Day())
+		case "%H":
+			return fmt.Sprintf("%02d", t.Hour())
+		case "%M":
+			return fmt.Sprintf("%02d", t.Minute())
+		case "%S":
+			return fmt.Sprintf("%02d", t.Second())
+		case "%j":
+			return fmt.Sprintf("%03d", t.YearDay())
+		}
+		return "%"
+	})
+}
+
+// renderTags replaces the {tagname} placeholders in s with the tag (or meta) values of msg
+func renderTags(s string, msg lp.CCMessage) string {
+	return fileSinkTagPattern.ReplaceAllStringFunc(s, func(p string) string {
+		key := p[1 : len(p)-1]
+		value, ok := msg.GetTag(key)
+		if !ok {
+			value, ok = msg.GetMeta(key)
+		}
+		if !ok || len(value) == 0 {
+			return "unknown"
+		}
+		// Tag values must not create additional directory levels
+		return strings.ReplaceAll(value, "/", "_")
+	})
+}
+
+// fileExists checks whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+// rotatedName returns the first path.N for which neither the file itself
+// nor its compressed version exists
+func rotatedName(path string) string {
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s.%d", path, i)
+		if !fileExists(name) && !fileExists(name+".gz") {
+			return name
+		}
+	}
+}
+
+// open opens (or appends to) the output file at path
+func (s *FileSink) open(path string) (*fileSinkOutput, error) {
+	if err := os.MkdirAll(filepath.Dir(path), s.config.dirMode); err != nil {
+		return nil, fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, s.config.fileMode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	o := &fileSinkOutput{
+		path:   path,
+		file:   f,
+		writer: bufio.NewWriter(f),
+		size:   info.Size(),
+		opened: time.Now(),
+	}
+	if o.size == 0 && s.config.Format == FILE_SINK_FORMAT_CSV {
+		n, _ := o.writer.WriteString("timestamp,name,tags,field,value\n")
+		o.size += int64(n)
+	}
+	cclog.ComponentDebug(s.name, "Opened", path)
+	return o, nil
+}
+
+// close closes the output file. Files closed due to size or time based rotation
+// are moved aside to path.N, so that a new file can be created at the same path.
+// Compression and retention run in the background.
+func (s *FileSink) close(o *fileSinkOutput, rotated bool) {
+	if err := o.writer.Flush(); err != nil {
+		cclog.ComponentError(s.name, "Failed to flush", o.path, ":", err.Error())
+	}
+	if err := o.file.Close(); err != nil {
+		cclog.ComponentError(s.name, "Failed to close", o.path, ":", err.Error())
+	}
+	cclog.ComponentDebug(s.name, "Closed", o.path)
+
+	name := o.path
+	// An existing compressed file stems from an earlier run writing to the same path
+	if rotated || (s.config.Compress && fileExists(o.path+".gz")) {
+		name = rotatedName(o.path)
+		if err := os.Rename(o.path, name); err != nil {
+			cclog.ComponentError(s.name, "Failed to rotate", o.path, ":", err.Error())
+			return
+		}
+	}
+	if s.config.Compress {
+		s.wg.Go(func() {
+			if err := util.CompressFile(name, name+".gz"); err != nil {
+				cclog.ComponentError(s.name, "Failed to compress", name, ":", err.Error())
+			}
+		})
+	}
+	if s.config.retention > 0 {
+		s.wg.Go(s.cleanup)
+	}
+}
+
+// cleanup removes closed files older than the retention time
+func (s *FileSink) cleanup() {
+	s.lock.Lock()
+	active := make(map[string]struct{}, len(s.outputs))
+	for _, o := range s.outputs {
+		active[o.path] = struct{}{}
+	}
+	s.lock.Unlock()
+
+	// All files of this sink are below the static part of the path template
+	root := filepath.Dir(s.glob)
+	if i := strings.IndexAny(s.glob, "*?["); i >= 0 {
+		root = filepath.Dir(s.glob[:i+1])
+	}
+	deadline := time.Now().Add(-s.config.retention)
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if _, ok := active[path]; ok {
+			return nil
+		}
+		// Match the file itself as well as rotated (.N) and compressed (.gz) files
+		ok, _ := filepath.Match(s.glob, path)
+		if !ok {
+			ok, _ = filepath.Match(s.glob+".*", path)
+		}
+		if !ok {
+			return nil
+		}
+		info, err := d.Info()
+		if err == nil && info.ModTime().Before(deadline) {
+			cclog.ComponentDebug(s.name, "Removing", path, "(retention)")
+			if err := os.Remove(path); err != nil {
+				cclog.ComponentError(s.name, "Failed to remove", path, ":", err.Error())
+			}
+		}
+		return nil
+	})
+}
+
+// encode converts msg into the configured output format
+func (s *FileSink) encode(msg lp.CCMessage) ([]byte, error) {
+	switch s.config.Format {
+	case FILE_SINK_FORMAT_JSON:
+		j, err := msg.ToJSON(s.meta_as_tags)
+		if err != nil {
+			return nil, err
+		}
+		return append(j, '\n'), nil
+	case FILE_SINK_FORMAT_CSV:
+		// One row per field, tags are sorted key=value pairs separated by ';'
+		tags := make([]string, 0, len(msg.Tags()))
+		for _, k := range slices.Sorted(maps.Keys(msg.Tags())) {
+			tags = append(tags, k+"="+msg.Tags()[k])
+		}
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		for _, k := range slices.Sorted(maps.Keys(msg.Fields())) {
+			w.Write([]string{
+				strconv.FormatInt(msg.Time().UnixNano(), 10),
+				msg.Name(),
+				strings.Join(tags, ";"),
+				k,
+				fmt.Sprint(msg.Fields()[k]),
+			})
+		}
+		w.Flush()
+		return buf.Bytes(), w.Error()
+	default:
+		return []byte(msg.ToLineProtocol(s.meta_as_tags)), nil
+	}
+}
+
+func (s *FileSink) Write(m lp.CCMessage) error {
+	msg, err := s.mp.ProcessMessage(m)
+	if err != nil || msg == nil {
+		return nil
+	}
+
+	data, err := s.encode(msg)
+	if err != nil {
+		return fmt.Errorf("encoding failed: %w", err)
+	}
+
+	key := renderTags(s.config.Path, msg)
+	path := renderTime(key, time.Now())
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	o := s.outputs[key]
+	if o != nil {
+		switch {
+		case o.path != path:
+			// The time dependent part of the path changed
+			s.close(o, false)
+			o = nil
+		case s.config.MaxSize > 0 && o.size+int64(len(data)) > s.config.MaxSize && o.size > 0:
+			s.close(o, true)
+			o = nil
+		case s.config.rotateInterval > 0 && time.Since(o.opened) >= s.config.rotateInterval:
+			s.close(o, true)
+			o = nil
+		}
+		if o == nil {
+			delete(s.outputs, key)
+		}
+	}
+	if o == nil {
+		o, err = s.open(path)
+		if err != nil {
+			return err
+		}
+		s.outputs[key] = o
+	}
+
+	n, err := o.writer.Write(data)
+	o.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write to %s: %w", o.path, err)
+	}
+	return nil
+}
+
+func (s *FileSink) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var errs []error
+	for _, o := range s.outputs {
+		if err := o.writer.Flush(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to flush %s: %w", o.path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *FileSink) Close() {
+	s.lock.Lock()
+	for key, o := range s.outputs {
+		s.close(o, false)
+		delete(s.outputs, key)
+	}
+	s.lock.Unlock()
+
+	// Wait for pending compressions and cleanups
+	s.wg.Wait()
+}
+
+// NewFileSink creates a new file sink
+func NewFileSink(name string, config json.RawMessage) (Sink, error) {
+	s := new(FileSink)
+	s.name = fmt.Sprintf("FileSink(%s)", name)
+	s.config.Format = FILE_SINK_FORMAT_LINEPROTOCOL
+	s.config.FileMode = "0640"
+	s.config.DirMode = "0750"
+
+	if len(config) > 0 {
+		d := json.NewDecoder(bytes.NewReader(config))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&s.config); err != nil {
+			cclog.ComponentError(s.name, "Error reading config:", err.Error())
+			return nil, err
+		}
+	}
+	if len(s.config.Path) == 0 {
+		return nil, errors.New("`path` config option is required for file sink")
+	}
+	switch s.config.Format {
+	case FILE_SINK_FORMAT_LINEPROTOCOL, FILE_SINK_FORMAT_JSON, FILE_SINK_FORMAT_CSV:
+	default:
+		return nil, fmt.Errorf("unknown format '%s' for file sink", s.config.Format)
+	}
+	if s.config.MaxSize < 0 {
+		return nil, errors.New("`max_size` must not be negative")
+	}
+	if len(s.config.RotateInterval) > 0 {
+		t, err := time.ParseDuration(s.config.RotateInterval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse rotate_interval '%s': %w", s.config.RotateInterval, err)
+		}
+		s.config.rotateInterval = t
+	}
+	if len(s.config.Retention) > 0 {
+		t, err := time.ParseDuration(s.config.Retention)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse retention '%s': %w", s.config.Retention, err)
+		}
+		s.config.retention = t
+	}
+	for _, m := range []struct {
+		value  string
+		target *os.FileMode
+	}{
+		{s.config.FileMode, &s.config.fileMode},
+		{s.config.DirMode, &s.config.dirMode},
+	} {
+		mode, err := strconv.ParseUint(m.value, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse file mode '%s': %w", m.value, err)
+		}
+		*m.target = os.FileMode(mode)
+	}
+
+	p, err := mp.NewMessageProcessor()
+	if err != nil {
+		return nil, fmt.Errorf("initialization of message processor failed: %w", err)
+	}
+	s.mp = p
+	if len(s.config.MessageProcessor) > 0 {
+		err = s.mp.FromConfigJSON(s.config.MessageProcessor)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing JSON for message processor: %w", err)
+		}
+	}
+	for _, k := range s.config.MetaAsTags {
+		s.mp.AddMoveMetaToTags("true", k, k)
+	}
+
+	s.outputs = make(map[string]*fileSinkOutput)
+	s.glob = filepath.Clean(fileSinkTimePattern.ReplaceAllString(
+		fileSinkTagPattern.ReplaceAllString(s.config.Path, "*"), "*"))
+	if s.config.retention > 0 {
+		s.wg.Go(s.cleanup)
+	}
+
+	return s, nil
+}
@@ -0,0 +1,146 @@
+package sinks
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+)
+
+func readFileSinkOutput(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("failed to read gzip file %s: %v", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestFileSinkFormats(t *testing.T) {
+	msgs, _ := gen_messages(5)
+	for _, format := range []string{FILE_SINK_FORMAT_LINEPROTOCOL, FILE_SINK_FORMAT_JSON, FILE_SINK_FORMAT_CSV} {
+		dir := t.TempDir()
+		config := fmt.Sprintf(`{"type": "file", "path": "%s/{type}/%%Y%%m%%d.out", "format": "%s"}`, dir, format)
+		s, err := NewFileSink("testsink", json.RawMessage(config))
+		if err != nil {
+			t.Fatalf("failed to setup file sink: %v", err)
+		}
+		for _, m := range msgs {
+			if err := s.Write(m); err != nil {
+				t.Errorf("failed to write message: %v", err)
+			}
+		}
+		s.Close()
+
+		path := filepath.Join(dir, "node", time.Now().Format("20060102")+".out")
+		lines := readFileSinkOutput(t, path)
+		switch format {
+		case FILE_SINK_FORMAT_LINEPROTOCOL:
+			for i, m := range msgs {
+				if want := strings.TrimSpace(m.ToLineProtocol(nil)); lines[i] != want {
+					t.Errorf("line %d invalid: '%s' vs '%s'", i, lines[i], want)
+				}
+			}
+		case FILE_SINK_FORMAT_JSON:
+			for i, m := range msgs {
+				x, err := lp.FromJSON(json.RawMessage(lines[i]))
+				if err != nil {
+					t.Errorf("failed to parse JSON line %d: %v", i, err)
+					continue
+				}
+				if x.Name() != m.Name() {
+					t.Errorf("line %d invalid: name '%s' vs '%s'", i, x.Name(), m.Name())
+				}
+			}
+		case FILE_SINK_FORMAT_CSV:
+			if len(lines) != len(msgs)+1 || lines[0] != "timestamp,name,tags,field,value" {
+				t.Errorf("invalid CSV output: %v", lines)
+				continue
+			}
+			if !strings.HasSuffix(lines[1], ",testmetric0,type=node,value,42") {
+				t.Errorf("invalid CSV row '%s'", lines[1])
+			}
+		}
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	dir := t.TempDir()
+	msgs, _ := gen_messages(10)
+	lineLen := len(msgs[0].ToLineProtocol(nil))
+
+	// Each file holds about three messages
+	config := fmt.Sprintf(`{"type": "file", "path": "%s/metrics.lp", "max_size": %d, "compress": true}`, dir, 3*lineLen+1)
+	s, err := NewFileSink("testsink", json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to setup file sink: %v", err)
+	}
+	for _, m := range msgs {
+		if err := s.Write(m); err != nil {
+			t.Errorf("failed to write message: %v", err)
+		}
+	}
+	s.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) < 4 {
+		t.Fatalf("expected at least 4 files, got %v", files)
+	}
+	total := 0
+	for _, f := range files {
+		if !strings.HasSuffix(f, ".gz") {
+			t.Errorf("file %s was not compressed", f)
+			continue
+		}
+		total += len(readFileSinkOutput(t, f))
+	}
+	if total != len(msgs) {
+		t.Errorf("expected %d messages in rotated files, got %d", len(msgs), total)
+	}
+}
+
+func TestFileSinkRetention(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "node", "metrics.lp.1.gz")
+	other := filepath.Join(dir, "node", "other.txt")
+	os.MkdirAll(filepath.Dir(old), 0o750)
+	for _, f := range []string{old, other} {
+		os.WriteFile(f, []byte("x"), 0o640)
+		past := time.Now().Add(-48 * time.Hour)
+		os.Chtimes(f, past, past)
+	}
+
+	config := fmt.Sprintf(`{"type": "file", "path": "%s/{type}/metrics.lp", "retention": "24h"}`, dir)
+	s, err := NewFileSink("testsink", json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to setup file sink: %v", err)
+	}
+	s.Close()
+
+	if fileExists(old) {
+		t.Errorf("file %s outside of retention was not removed", old)
+	}
+	if !fileExists(other) {
+		t.Errorf("file %s not written by the sink was removed", other)
+	}
+}
@@ -0,0 +1,358 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package sinks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	mp "github.com/ClusterCockpit/cc-lib/v2/messageProcessor"
+)
+
+const GRAPHITE_DEFAULT_TEMPLATE = "{cluster}.{hostname}.{name}.{type}{type-id}"
+
+// Maximum payload of a single UDP datagram sent by the graphite sink
+const GRAPHITE_MAX_DATAGRAM_SIZE = 1432
+
+type GraphiteSinkConfig struct {
+	defaultSinkConfig
+
+	// Address of the Graphite/Carbon plaintext receiver
+	Host string `json:"host"`
+	Port string `json:"port,omitempty"`
+
+	// Transport protocol: tcp (default) or udp
+	Protocol string `json:"protocol,omitempty"`
+
+	// Template of the dotted metric path. {name} is replaced by the metric name,
+	// all other placeholders by the tag (or meta) value. Missing values are left out.
+	Template string `json:"template,omitempty"`
+
+	// Prefix prepended to all metric paths
+	Prefix string `json:"prefix,omitempty"`
+
+	// Timeout for connecting and writing
+	Timeout string `json:"timeout,omitempty"`
+	timeout time.Duration
+
+	// Batch all writes arriving in during this duration
+	// (default '5s', batching can be disabled by setting it to 0)
+	FlushDelay string `json:"flush_delay,omitempty"`
+	flushDelay time.Duration
+
+	// Maximum size in bytes of the lines kept for the next flush when sending
+	// fails (default 1 MiB). The oldest lines are dropped beyond it.
+	MaxBufferSize int `json:"max_buffer_size,omitempty"`
+}
+
+type GraphiteSink struct {
+	sink
+	config GraphiteSinkConfig
+
+	// buffered plaintext lines
+	buffer     bytes.Buffer
+	bufferLock sync.Mutex
+
+	// connection to the Graphite server, re-established on errors
+	conn     net.Conn
+	connLock sync.Mutex
+
+	// timer to run Flush()
+	flushTimer *time.Timer
+	// Lock to assure that only one timer is running at a time
+	timerLock sync.Mutex
+}
+
+var graphitePlaceholder = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// graphiteSanitizer replaces characters with special meaning in Graphite paths
+var graphiteSanitizer = strings.NewReplacer(".", "_", " ", "_", "/", "_", ";", "_", "=", "_")
+
+// graphitePath renders the metric path of msg
+func (s *GraphiteSink) graphitePath(msg lp.CCMessage) string {
+	path := graphitePlaceholder.ReplaceAllStringFunc(s.config.Template, func(p string) string {
+		key := p[1 : len(p)-1]
+		if key == "name" {
+			return graphiteSanitizer.Replace(msg.Name())
+		}
+		value, ok := msg.GetTag(key)
+		if !ok {
+			value, _ = msg.GetMeta(key)
+		}
+		return graphiteSanitizer.Replace(value)
+	})
+
+	// Remove empty path components of missing tags
+	parts := strings.Split(path, ".")
+	out := make([]string, 0, len(parts)+1)
+	if len(s.config.Prefix) > 0 {
+		out = append(out, s.config.Prefix)
+	}
+	for _, p := range parts {
+		if len(p) > 0 {
+			out = append(out, p)
+		}
+	}
+	return strings.Join(out, ".")
+}
+
+// graphiteValue formats the metric value for the plaintext protocol
+func graphiteValue(value any) (string, error) {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	}
+	return "", fmt.Errorf("unsupported value type %T", value)
+}
+
+// connect establishes the connection to the Graphite server.
+// connLock has to be held by the caller.
+func (s *GraphiteSink) connect() error {
+	addr := net.JoinHostPort(s.config.Host, s.config.Port)
+	cclog.ComponentDebug(s.name, "Connect to", s.config.Protocol, addr)
+	conn, err := net.DialTimeout(s.config.Protocol, addr, s.config.timeout)
+	if err != nil {
+		return fmt.Errorf("connect to %s failed: %w", addr, err)
+	}
+	s.conn = conn
+	return nil
+}
+
+// send writes buf to the Graphite server. On errors, the connection is
+// re-established once. connLock has to be held by the caller.
+func (s *GraphiteSink) send(buf []byte) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if err = s.connect(); err != nil {
+				continue
+			}
+		}
+		if s.config.timeout > 0 {
+			s.conn.SetWriteDeadline(time.Now().Add(s.config.timeout))
+		}
+		if _, err = s.conn.Write(buf); err == nil {
+			return nil
+		}
+		cclog.ComponentError(s.name, "Write failed, reconnecting:", err.Error())
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *GraphiteSink) Write(m lp.CCMessage) error {
+	msg, err := s.mp.ProcessMessage(m)
+	if err == nil && msg != nil && msg.IsMetric() {
+		v, _ := msg.GetMetricValue()
+		value, err := graphiteValue(v)
+		if err != nil {
+			return fmt.Errorf("metric %s: %w", msg.Name(), err)
+		}
+		line := fmt.Sprintf("%s %s %d\n", s.graphitePath(msg), value, msg.Time().Unix())
+
+		s.bufferLock.Lock()
+		s.buffer.WriteString(line)
+		s.bufferLock.Unlock()
+	}
+
+	if s.config.flushDelay == 0 {
+		// Directly flush if no flush delay is configured
+		return s.Flush()
+	} else if s.timerLock.TryLock() {
+		// Setup flush timer when flush delay is configured
+		// and no other timer is already running
+		if s.flushTimer != nil {
+
+			// Restarting existing flush timer
+			cclog.ComponentDebug(s.name, "Write(): Restarting flush timer")
+			s.flushTimer.Reset(s.config.flushDelay)
+		} else {
+
+			// Creating and starting flush timer
+			cclog.ComponentDebug(s.name, "Write(): Starting new flush timer")
+			s.flushTimer = time.AfterFunc(
+				s.config.flushDelay,
+				func() {
+					defer s.timerLock.Unlock()
+					cclog.ComponentDebug(s.name, "Starting flush triggered by flush timer")
+					if err := s.Flush(); err != nil {
+						cclog.ComponentError(s.name, "Flush triggered by flush timer: flush failed:", err)
+					}
+				})
+		}
+	}
+	return nil
+}
+
+// Flush sends all buffered lines to the Graphite server
+func (s *GraphiteSink) Flush() error {
+	s.bufferLock.Lock()
+	buf := bytes.Clone(s.buffer.Bytes())
+	s.buffer.Reset()
+	s.bufferLock.Unlock()
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+
+	if s.config.Protocol == "tcp" {
+		if err := s.send(buf); err != nil {
+			s.retain(buf)
+			return err
+		}
+		return nil
+	}
+
+	// Split the lines into datagrams
+	for len(buf) > 0 {
+		n := len(buf)
+		if n > GRAPHITE_MAX_DATAGRAM_SIZE {
+			n = bytes.LastIndexByte(buf[:GRAPHITE_MAX_DATAGRAM_SIZE], '\n') + 1
+			if n == 0 {
+				// single line exceeding the datagram size
+				n = bytes.IndexByte(buf, '\n') + 1
+				if n == 0 {
+					n = len(buf)
+				}
+			}
+		}
+		if err := s.send(buf[:n]); err != nil {
+			s.retain(buf)
+			return err
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
+
+// retain puts the unsent lines in front of the lines buffered in the meantime,
+// so that they are sent by the next flush. Beyond max_buffer_size, the oldest
+// lines are dropped.
+func (s *GraphiteSink) retain(unsent []byte) {
+	s.bufferLock.Lock()
+	defer s.bufferLock.Unlock()
+	buf := slices.Concat(unsent, s.buffer.Bytes())
+	if excess := len(buf) - s.config.MaxBufferSize; excess > 0 {
+		// Drop complete lines only
+		cut := len(buf)
+		if i := bytes.IndexByte(buf[excess-1:], '\n'); i >= 0 {
+			cut = excess + i
+		}
+		cclog.ComponentError(s.name, fmt.Sprintf("Dropped %d lines, the buffer is full", bytes.Count(buf[:cut], []byte{'\n'})))
+		buf = buf[cut:]
+	}
+	s.buffer.Reset()
+	s.buffer.Write(buf)
+}
+
+func (s *GraphiteSink) Close() {
+	cclog.ComponentDebug(s.name, "Closing Graphite connection")
+
+	// Stop existing timer and immediately flush
+	if s.flushTimer != nil {
+		if ok := s.flushTimer.Stop(); ok {
+			s.timerLock.Unlock()
+		}
+	}
+	if err := s.Flush(); err != nil {
+		cclog.ComponentError(s.name, "Close(): Flush failed:", err)
+	}
+
+	s.connLock.Lock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	s.connLock.Unlock()
+}
+
+// NewGraphiteSink creates a new sink for the Graphite plaintext protocol
+func NewGraphiteSink(name string, config json.RawMessage) (Sink, error) {
+	s := new(GraphiteSink)
+	s.name = fmt.Sprintf("GraphiteSink(%s)", name)
+	s.config.Port = "2003"
+	s.config.Protocol = "tcp"
+	s.config.Template = GRAPHITE_DEFAULT_TEMPLATE
+	s.config.Timeout = "5s"
+	s.config.FlushDelay = "5s"
+	s.config.MaxBufferSize = 1 << 20
+
+	if len(config) > 0 {
+		d := json.NewDecoder(bytes.NewReader(config))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&s.config); err != nil {
+			cclog.ComponentError(s.name, "Error reading config:", err.Error())
+			return nil, err
+		}
+	}
+	if len(s.config.Host) == 0 || len(s.config.Port) == 0 {
+		return nil, errors.New("not all configuration variables set required by GraphiteSink (host and port)")
+	}
+	if s.config.Protocol != "tcp" && s.config.Protocol != "udp" {
+		return nil, fmt.Errorf("unknown protocol '%s' for GraphiteSink", s.config.Protocol)
+	}
+	if !strings.Contains(s.config.Template, "{name}") {
+		return nil, errors.New("template of GraphiteSink must contain {name}")
+	}
+	if s.config.MaxBufferSize <= 0 {
+		return nil, errors.New("max_buffer_size of GraphiteSink must be positive")
+	}
+	if len(s.config.Timeout) > 0 {
+		t, err := time.ParseDuration(s.config.Timeout)
+		if err == nil {
+			s.config.timeout = t
+		}
+	}
+	if len(s.config.FlushDelay) > 0 {
+		t, err := time.ParseDuration(s.config.FlushDelay)
+		if err == nil {
+			s.config.flushDelay = t
+			cclog.ComponentDebug(s.name, "Init(): flushDelay", t)
+		}
+	}
+
+	p, err := mp.NewMessageProcessor()
+	if err != nil {
+		return nil, fmt.Errorf("initialization of message processor failed: %w", err)
+	}
+	s.mp = p
+	if len(s.config.MessageProcessor) > 0 {
+		err = s.mp.FromConfigJSON(s.config.MessageProcessor)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing JSON for message processor: %w", err)
+		}
+	}
+	for _, k := range s.config.MetaAsTags {
+		s.mp.AddMoveMetaToTags("true", k, k)
+	}
+
+	// The connection is established lazily on the first flush, so that the
+	// sink can start while the Graphite server is unavailable
+	return s, nil
+}
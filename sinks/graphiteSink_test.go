@@ -0,0 +1,153 @@
+package sinks
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+)
+
+func TestGraphiteSinkPath(t *testing.T) {
+	s, err := NewGraphiteSink("testsink", json.RawMessage(`{"type": "graphite", "host": "localhost", "prefix": "cc"}`))
+	if err != nil {
+		t.Fatalf("failed to setup graphite sink: %v", err)
+	}
+	g := s.(*GraphiteSink)
+
+	tests := []struct {
+		tags map[string]string
+		name string
+		want string
+	}{
+		{map[string]string{"cluster": "testcluster", "hostname": "node01", "type": "socket", "type-id": "1"}, "mem_bw", "cc.testcluster.node01.mem_bw.socket1"},
+		{map[string]string{"cluster": "testcluster", "hostname": "node01.example.com", "type": "node"}, "flops any", "cc.testcluster.node01_example_com.flops_any.node"},
+		{map[string]string{"hostname": "node01", "type": "node"}, "load", "cc.node01.load.node"},
+	}
+	for _, tt := range tests {
+		m, _ := lp.NewMetric(tt.name, tt.tags, nil, 1.0, time.Now())
+		if got := g.graphitePath(m); got != tt.want {
+			t.Errorf("invalid path for %s: '%s' vs '%s'", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGraphiteSinkTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	lines := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	config := fmt.Sprintf(`{"type": "graphite", "host": "127.0.0.1", "port": "%s", "template": "{type}.{name}", "flush_delay": "100ms"}`, port)
+	s, err := NewGraphiteSink("testsink", json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to setup graphite sink: %v", err)
+	}
+	msgs, _ := gen_messages(3)
+	for _, m := range msgs {
+		if err := s.Write(m); err != nil {
+			t.Errorf("failed to write message: %v", err)
+		}
+	}
+	s.Close()
+
+	for i, m := range msgs {
+		v, _ := m.GetMetricValue()
+		want := fmt.Sprintf("node.%s %v %d", m.Name(), v, m.Time().Unix())
+		select {
+		case line := <-lines:
+			if line != want {
+				t.Errorf("line %d invalid: '%s' vs '%s'", i, line, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for line %d", i)
+		}
+	}
+}
+
+func TestGraphiteSinkRetain(t *testing.T) {
+	// Reserve a port without a listening server
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	_, port, _ := net.SplitHostPort(addr)
+	config := fmt.Sprintf(`{"type": "graphite", "host": "127.0.0.1", "port": "%s", "template": "{type}.{name}", "flush_delay": "1h", "timeout": "1s"}`, port)
+	s, err := NewGraphiteSink("testsink", json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to setup graphite sink: %v", err)
+	}
+	msgs, _ := gen_messages(3)
+	for _, m := range msgs {
+		if err := s.Write(m); err != nil {
+			t.Errorf("failed to write message: %v", err)
+		}
+	}
+	if err := s.Flush(); err == nil {
+		t.Fatal("flush without server succeeded")
+	}
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("failed to listen on %s again: %v", addr, err)
+	}
+	defer ln.Close()
+	lines := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	if err := s.Flush(); err != nil {
+		t.Fatalf("failed to flush retained lines: %v", err)
+	}
+	s.Close()
+
+	for i, m := range msgs {
+		v, _ := m.GetMetricValue()
+		want := fmt.Sprintf("node.%s %v %d", m.Name(), v, m.Time().Unix())
+		select {
+		case line := <-lines:
+			if line != want {
+				t.Errorf("line %d invalid: '%s' vs '%s'", i, line, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for line %d", i)
+		}
+	}
+
+	// The oldest lines are dropped beyond max_buffer_size
+	g := s.(*GraphiteSink)
+	g.config.MaxBufferSize = 10
+	g.retain([]byte("aaaa\nbbbb\ncccc\n"))
+	if got := g.buffer.String(); got != "bbbb\ncccc\n" {
+		t.Errorf("invalid retained lines: '%s'", got)
+	}
+}
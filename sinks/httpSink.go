@@ -11,14 +11,20 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"net/http"
+	"os"
 	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
 	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
 	mp "github.com/ClusterCockpit/cc-lib/v2/messageProcessor"
+	"github.com/ClusterCockpit/cc-lib/v2/util"
 	influx "github.com/ClusterCockpit/cc-line-protocol/v2/lineprotocol"
 )
 
@@ -29,13 +35,18 @@ type HttpSinkConfig struct {
 	URL string `json:"url"`
 
 	// JSON web tokens for authentication (Using the *Bearer* scheme)
+	// May be a secret reference (env:, file: or exec:)
 	JWT string `json:"jwt,omitempty"`
 
 	// Basic authentication
+	// The password may be a secret reference (env:, file: or exec:)
 	Username     string `json:"username"`
 	Password     string `json:"password"`
 	useBasicAuth bool
 
+	// Re-read file: secret references when the files change
+	ReloadSecrets bool `json:"reload_secrets,omitempty"`
+
 	// time limit for requests made by the http client
 	Timeout string `json:"timeout,omitempty"`
 	timeout time.Duration
@@ -53,8 +64,35 @@ type HttpSinkConfig struct {
 	// Maximum number of retries to connect to the http server (default: 3)
 	MaxRetries int `json:"max_retries,omitempty"`
 
+	// Delay before the first retry, doubled for each further retry (default: 500ms)
+	RetryInitialDelay string `json:"retry_initial_delay,omitempty"`
+	retryInitialDelay time.Duration
+
+	// Maximum delay between retries, also limits delays requested by Retry-After (default: 30s)
+	RetryMaxDelay string `json:"retry_max_delay,omitempty"`
+	retryMaxDelay time.Duration
+
+	// Number of consecutive failed flushes after which requests are suspended (default: 5, 0 disables the circuit breaker)
+	CircuitBreakerThreshold int `json:"circuit_breaker_threshold,omitempty"`
+
+	// Duration requests are suspended before a trial request (default: 1m)
+	CircuitBreakerTimeout string `json:"circuit_breaker_timeout,omitempty"`
+
+	// File to append batches which could not be delivered (default: drop them)
+	DeadLetterFile string `json:"dead_letter_file,omitempty"`
+
 	// Timestamp precision
 	Precision string `json:"precision,omitempty"`
+
+	// Compression of the request body: none, gzip or zstd (default: none)
+	Compression string `json:"compression,omitempty"`
+
+	// Split flushes into requests of at most this many bytes (before compression) and lines (default: 0, unlimited)
+	MaxBatchBytes int `json:"max_batch_bytes,omitempty"`
+	MaxBatchLines int `json:"max_batch_lines,omitempty"`
+
+	// CA bundle and client certificate for HTTPS endpoints
+	TLS *util.TLSConfig `json:"tls,omitempty"`
 }
 
 type HttpSink struct {
@@ -73,6 +111,17 @@ type HttpSink struct {
 	timerLock sync.Mutex
 
 	config HttpSinkConfig
+
+	// resolved JWT and password
+	jwt      *util.Secret
+	password *util.Secret
+
+	compressor     *batchCompressor
+	stats          batchStats
+	breaker        httpCircuitBreaker
+	deadLetterLock sync.Mutex
+	// closed by Close() to abort waiting for retries
+	done chan struct{}
 }
 
 // Write sends metric m as http message
@@ -124,7 +173,190 @@ func (s *HttpSink) Write(msg lp.CCMessage) error {
 	return nil
 }
 
-// Flush sends all metrics stored in encoder to HTTP server
+// ErrHttpSinkCircuitOpen is returned by Flush while the circuit breaker
+// suspends requests to an unavailable endpoint
+var ErrHttpSinkCircuitOpen = errors.New("circuit breaker is open")
+
+// httpCircuitBreaker suspends requests after repeated failures. After the
+// open duration, a single trial request decides whether to close it again.
+type httpCircuitBreaker struct {
+	lock      sync.Mutex
+	threshold int           // consecutive failures to open the circuit (0: disabled)
+	timeout   time.Duration // duration the circuit stays open
+	failures  int
+	openUntil time.Time
+	trial     bool // a trial request is running
+}
+
+// allow reports whether a request may be sent and whether it is a trial request
+func (b *httpCircuitBreaker) allow() (ok bool, trial bool) {
+	if b.threshold <= 0 {
+		return true, false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.failures < b.threshold {
+		return true, false
+	}
+	if b.trial || time.Now().Before(b.openUntil) {
+		return false, false
+	}
+	b.trial = true
+	return true, true
+}
+
+// success resets the failure count and reports whether the circuit was open
+func (b *httpCircuitBreaker) success() bool {
+	if b.threshold <= 0 {
+		return false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	wasOpen := b.failures >= b.threshold
+	b.failures = 0
+	b.trial = false
+	return wasOpen
+}
+
+// failure counts a failed request and reports whether the circuit was opened
+func (b *httpCircuitBreaker) failure() bool {
+	if b.threshold <= 0 {
+		return false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failures++
+	b.trial = false
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.timeout)
+		return true
+	}
+	return false
+}
+
+// release ends a trial request which was not sent, so that the next
+// request becomes the trial request
+func (b *httpCircuitBreaker) release() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.trial = false
+}
+
+// httpRetryable reports whether a request answered with status may succeed when repeated
+func httpRetryable(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+		return false
+	}
+	return status >= 500
+}
+
+// parseRetryAfter parses the Retry-After header, which is either a number of seconds or a date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// backoff returns the delay before retry number attempt (starting at 0):
+// exponential growth up to retry_max_delay with half of the delay randomized
+func (s *HttpSink) backoff(attempt int) time.Duration {
+	d := s.config.retryMaxDelay
+	if attempt < 32 && s.config.retryInitialDelay<<attempt < d {
+		d = s.config.retryInitialDelay << attempt
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// send posts the (compressed) batch body once. It returns whether a failed
+// request may be repeated and the delay requested by the server.
+func (s *HttpSink) send(body []byte) (retry bool, retryAfter time.Duration, err error) {
+	// Create new request to send buffer
+	req, err := http.NewRequest(http.MethodPost, s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	if len(s.compressor.encoding) > 0 {
+		req.Header.Set("Content-Encoding", s.compressor.encoding)
+	}
+
+	// Set authorization header
+	if s.jwt != nil {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.jwt.Value()))
+	}
+
+	// Set basic authentication
+	if s.config.useBasicAuth {
+		req.SetBasicAuth(s.config.Username, s.password.Value())
+	}
+
+	// Do request
+	res, err := s.client.Do(req)
+	if err != nil {
+		return true, 0, fmt.Errorf("transport/tcp error: %w", err)
+	}
+	defer res.Body.Close()
+	// Read (part of) the body to reuse the connection and report errors
+	resBody, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, 0, nil
+	}
+
+	// Handle application errors
+	err = fmt.Errorf("application error: %s", res.Status)
+	if msg := strings.TrimSpace(string(resBody)); len(msg) > 0 {
+		err = fmt.Errorf("%w: %s", err, msg)
+	}
+	if !httpRetryable(res.StatusCode) {
+		return false, 0, err
+	}
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable {
+		if d, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+			return true, min(d, s.config.retryMaxDelay), err
+		}
+	}
+	return true, 0, err
+}
+
+// deadLetter appends an undeliverable batch to the dead-letter file
+func (s *HttpSink) deadLetter(buf []byte, reason error) {
+	if len(s.config.DeadLetterFile) == 0 {
+		cclog.ComponentError(s.name, fmt.Sprintf("Dropping batch of %d bytes: %v", len(buf), reason))
+		return
+	}
+	s.deadLetterLock.Lock()
+	defer s.deadLetterLock.Unlock()
+	f, err := os.OpenFile(s.config.DeadLetterFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err == nil {
+		_, err = f.Write(buf)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		cclog.ComponentError(s.name, fmt.Sprintf("Dropping batch of %d bytes, writing to dead-letter file failed: %v", len(buf), err))
+		return
+	}
+	cclog.ComponentError(s.name, fmt.Sprintf("Wrote batch of %d bytes to dead-letter file %s: %v", len(buf), s.config.DeadLetterFile, reason))
+}
+
+// BatchStats returns the statistics of the batches sent by the sink
+func (s *HttpSink) BatchStats() BatchStats {
+	return s.stats.get()
+}
+
+// Flush sends all metrics stored in encoder to HTTP server.
+// The metrics are split into batches of at most max_batch_bytes and max_batch_lines.
 func (s *HttpSink) Flush() error {
 	// Lock for encoder usage
 	// Own lock for as short as possible: the time it takes to clone the buffer.
@@ -142,49 +374,92 @@ func (s *HttpSink) Flush() error {
 
 	cclog.ComponentDebug(s.name, "Flush(): Flushing")
 
-	var res *http.Response
-	for i := 0; i < s.config.MaxRetries; i++ {
-		// Create new request to send buffer
-		req, err := http.NewRequest(http.MethodPost, s.config.URL, bytes.NewReader(buf))
-		if err != nil {
-			cclog.ComponentError(s.name, "Flush(): Failed to create HTTP request:", err)
-			return err
+	// Send all batches and report the first error
+	var firstErr error
+	batches, lines := splitBatch(buf, s.config.MaxBatchBytes, s.config.MaxBatchLines)
+	for i, batch := range batches {
+		if err := s.flushBatch(batch, lines[i]); err != nil && firstErr == nil {
+			firstErr = err
 		}
+	}
+	return firstErr
+}
 
-		// Set authorization header
-		if len(s.config.JWT) != 0 {
-			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.config.JWT))
-		}
+// flushBatch compresses and sends a single batch.
+// Failed requests are retried with exponential backoff. Batches which cannot
+// be delivered are written to the dead-letter file.
+func (s *HttpSink) flushBatch(buf []byte, lines int) error {
+	ok, trial := s.breaker.allow()
+	if !ok {
+		s.deadLetter(buf, ErrHttpSinkCircuitOpen)
+		return ErrHttpSinkCircuitOpen
+	}
 
-		// Set basic authentication
-		if s.config.useBasicAuth {
-			req.SetBasicAuth(s.config.Username, s.config.Password)
+	body, err := s.compressor.compress(buf)
+	if err != nil {
+		err = fmt.Errorf("compression failed: %w", err)
+		if trial {
+			s.breaker.release()
 		}
+		s.deadLetter(buf, err)
+		return err
+	}
+	s.stats.add(lines, len(buf), len(body))
+	cclog.ComponentDebug(s.name, fmt.Sprintf("Flush(): batch of %d lines, %d bytes, compressed to %d bytes (ratio %.2f)",
+		lines, len(buf), len(body), float64(len(buf))/float64(len(body))))
+
+	// A trial request of the circuit breaker is not repeated
+	attempts := max(s.config.MaxRetries, 1)
+	if trial {
+		attempts = 1
+	}
 
-		// Do request
-		res, err = s.client.Do(req)
-		if err != nil {
-			cclog.ComponentError(s.name, "Flush(): transport/tcp error:", err)
-			// Wait between retries
-			time.Sleep(time.Duration(i+1) * (time.Second / 2))
-			continue
+	var (
+		retry      bool
+		retryAfter time.Duration
+	)
+retries:
+	for i := range attempts {
+		if i > 0 {
+			// Wait between retries, unless the sink is closed
+			delay := retryAfter
+			if delay == 0 {
+				delay = s.backoff(i - 1)
+			}
+			cclog.ComponentDebug(s.name, "Flush(): Retrying in", delay)
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-s.done:
+				timer.Stop()
+				break retries
+			}
 		}
 
-		break
-	}
-
-	if res == nil {
-		return errors.New("flush failed due to repeated errors")
+		retry, retryAfter, err = s.send(body)
+		if err == nil {
+			if s.breaker.success() {
+				cclog.ComponentInfo(s.name, "Flush(): Endpoint available again, closing circuit breaker")
+			}
+			return nil
+		}
+		cclog.ComponentError(s.name, fmt.Sprintf("Flush(): attempt %d of %d failed: %v", i+1, attempts, err))
+		if !retry {
+			break
+		}
 	}
 
-	// Handle application errors
-	if res.StatusCode != http.StatusOK {
-		err := errors.New(res.Status)
-		cclog.ComponentError(s.name, "Flush(): Application error:", err)
-		return err
+	if retry {
+		// The endpoint is unavailable
+		if s.breaker.failure() {
+			cclog.ComponentError(s.name, fmt.Sprintf("Flush(): Opening circuit breaker for %v", s.breaker.timeout))
+		}
+	} else {
+		// The endpoint rejected the request, but is available
+		s.breaker.success()
 	}
-
-	return nil
+	s.deadLetter(buf, err)
+	return err
 }
 
 func (s *HttpSink) Close() {
@@ -197,12 +472,17 @@ func (s *HttpSink) Close() {
 		}
 	}
 
+	// Abort waiting for retries, remaining batches go to the dead-letter file
+	close(s.done)
+
 	// Flush
 	if err := s.Flush(); err != nil {
 		cclog.ComponentError(s.name, "Close(): Flush failed:", err)
 	}
 
 	s.client.CloseIdleConnections()
+	s.jwt.Close()
+	s.password.Close()
 }
 
 // NewHttpSink creates a new http sink
@@ -215,6 +495,10 @@ func NewHttpSink(name string, config json.RawMessage) (Sink, error) {
 	s.config.Timeout = "5s"
 	s.config.FlushDelay = "5s"
 	s.config.MaxRetries = 3
+	s.config.RetryInitialDelay = "500ms"
+	s.config.RetryMaxDelay = "30s"
+	s.config.CircuitBreakerThreshold = 5
+	s.config.CircuitBreakerTimeout = "1m"
 	s.config.Precision = "s"
 	cclog.ComponentDebug(s.name, "Init()")
 
@@ -241,6 +525,20 @@ func NewHttpSink(name string, config json.RawMessage) (Sink, error) {
 	if s.config.useBasicAuth && len(s.config.Password) == 0 {
 		return nil, errors.New("basic authentication requires password")
 	}
+	if s.config.useBasicAuth {
+		secret, err := util.NewSecret(s.config.Password, s.config.ReloadSecrets)
+		if err != nil {
+			return nil, fmt.Errorf("%s: password: %w", s.name, err)
+		}
+		s.password = secret
+	}
+	if len(s.config.JWT) > 0 {
+		secret, err := util.NewSecret(s.config.JWT, s.config.ReloadSecrets)
+		if err != nil {
+			return nil, fmt.Errorf("%s: jwt: %w", s.name, err)
+		}
+		s.jwt = secret
+	}
 	p, err := mp.NewMessageProcessor()
 	if err != nil {
 		return nil, fmt.Errorf("initialization of message processor failed: %w", err)
@@ -268,6 +566,30 @@ func NewHttpSink(name string, config json.RawMessage) (Sink, error) {
 			cclog.ComponentDebug(s.name, "Init(): flushDelay", t)
 		}
 	}
+	for _, d := range []struct {
+		option string
+		value  string
+		target *time.Duration
+	}{
+		{"retry_initial_delay", s.config.RetryInitialDelay, &s.config.retryInitialDelay},
+		{"retry_max_delay", s.config.RetryMaxDelay, &s.config.retryMaxDelay},
+		{"circuit_breaker_timeout", s.config.CircuitBreakerTimeout, &s.breaker.timeout},
+	} {
+		t, err := time.ParseDuration(d.value)
+		if err != nil || t <= 0 {
+			return nil, fmt.Errorf("%s: invalid %s '%s'", s.name, d.option, d.value)
+		}
+		*d.target = t
+	}
+	s.breaker.threshold = s.config.CircuitBreakerThreshold
+	if s.config.MaxBatchBytes < 0 || s.config.MaxBatchLines < 0 {
+		return nil, fmt.Errorf("%s: max_batch_bytes and max_batch_lines must not be negative", s.name)
+	}
+	s.compressor, err = newBatchCompressor(s.config.Compression)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.name, err)
+	}
+	s.done = make(chan struct{})
 	if len(s.config.MessageProcessor) > 0 {
 		err = p.FromConfigJSON(s.config.MessageProcessor)
 		if err != nil {
@@ -293,12 +615,20 @@ func NewHttpSink(name string, config json.RawMessage) (Sink, error) {
 	}
 
 	// Create http client
+	transport := &http.Transport{
+		MaxIdleConns:    1, // We will only ever talk to one host.
+		IdleConnTimeout: s.config.idleConnTimeout,
+	}
+	if s.config.TLS != nil {
+		tlsConfig, err := s.config.TLS.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("%s: TLS configuration: %w", s.name, err)
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
 	s.client = &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConns:    1, // We will only ever talk to one host.
-			IdleConnTimeout: s.config.idleConnTimeout,
-		},
-		Timeout: s.config.timeout,
+		Transport: transport,
+		Timeout:   s.config.timeout,
 	}
 
 	// Configure influx line protocol encoder
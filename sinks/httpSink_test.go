@@ -1,12 +1,17 @@
 package sinks
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -97,3 +102,204 @@ func TestHttpSink(t *testing.T) {
 		}
 	}
 }
+
+func TestHttpSinkSecrets(t *testing.T) {
+	t.Setenv("CC_LIB_TEST_JWT", "secret-token")
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	config := json.RawMessage(`{"type": "http", "url": "` + server.URL + `", "jwt": "env:CC_LIB_TEST_JWT", "flush_delay": "0s"}`)
+	s, err := NewHttpSink("testsink", config)
+	if err != nil {
+		t.Fatalf("failed to setup http sink: %v", err)
+	}
+	msgs, _ := gen_messages(1)
+	if err := s.Write(msgs[0]); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	s.Close()
+	if auth != "Bearer secret-token" {
+		t.Errorf("secret reference was not resolved: '%s'", auth)
+	}
+
+	config = json.RawMessage(`{"type": "http", "url": "` + server.URL + `", "username": "user", "password": "env:CC_LIB_TEST_UNSET"}`)
+	if _, err := NewHttpSink("testsink", config); err == nil {
+		t.Error("unresolvable secret reference was accepted")
+	}
+}
+
+func TestHttpSinkRetry(t *testing.T) {
+	var requests atomic.Int32
+	var status atomic.Int32
+	var failures atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		requests.Add(1)
+		if failures.Add(-1) >= 0 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(int(status.Load()))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	deadLetterFile := filepath.Join(t.TempDir(), "dead-letter.lp")
+	config := json.RawMessage(`{
+		"type": "http",
+		"url": "` + server.URL + `",
+		"flush_delay": "0s",
+		"max_retries": 3,
+		"retry_initial_delay": "1ms",
+		"retry_max_delay": "10ms",
+		"circuit_breaker_threshold": 2,
+		"circuit_breaker_timeout": "100ms",
+		"dead_letter_file": "` + deadLetterFile + `"
+	}`)
+	s, err := NewHttpSink("testsink", config)
+	if err != nil {
+		t.Fatalf("failed to setup http sink: %v", err)
+	}
+	defer s.Close()
+	msgs, _ := gen_messages(4)
+
+	check := func(msg string, wantErr bool, wantRequests int32) {
+		t.Helper()
+		err := s.Write(msgs[0])
+		if (err != nil) != wantErr {
+			t.Errorf("%s: unexpected error: %v", msg, err)
+		}
+		if n := requests.Swap(0); n != wantRequests {
+			t.Errorf("%s: expected %d requests, got %d", msg, wantRequests, n)
+		}
+	}
+
+	// Temporary errors are retried
+	status.Store(http.StatusServiceUnavailable)
+	failures.Store(2)
+	check("retry", false, 3)
+
+	// Client errors are not retried
+	status.Store(http.StatusBadRequest)
+	failures.Store(1)
+	check("client error", true, 1)
+	data, _ := os.ReadFile(deadLetterFile)
+	if !strings.HasPrefix(string(data), "testmetric0,type=node value=42 ") {
+		t.Errorf("batch not written to dead-letter file: '%s'", string(data))
+	}
+
+	// Open the circuit breaker after two failed flushes
+	status.Store(http.StatusInternalServerError)
+	failures.Store(100)
+	check("failure 1", true, 3)
+	check("failure 2", true, 3)
+	if err := s.Write(msgs[0]); err != ErrHttpSinkCircuitOpen {
+		t.Errorf("expected open circuit breaker, got %v", err)
+	}
+	if n := requests.Swap(0); n != 0 {
+		t.Errorf("expected no requests with open circuit, got %d", n)
+	}
+
+	// A single trial request closes it again
+	failures.Store(0)
+	time.Sleep(150 * time.Millisecond)
+	check("trial", false, 1)
+	check("closed", false, 1)
+
+	data, _ = os.ReadFile(deadLetterFile)
+	if n := strings.Count(string(data), "\n"); n != 4 {
+		t.Errorf("expected 4 batches in dead-letter file, got %d", n)
+	}
+}
+
+func TestHttpCircuitBreaker(t *testing.T) {
+	b := httpCircuitBreaker{threshold: 1, timeout: time.Millisecond}
+	if b.failure(); !b.failure() {
+		t.Fatal("expected open circuit breaker")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if ok, trial := b.allow(); !ok || !trial {
+		t.Fatalf("expected trial request, got %v %v", ok, trial)
+	}
+	if ok, _ := b.allow(); ok {
+		t.Error("expected a single trial request")
+	}
+	// A trial request which was not sent allows another one
+	b.release()
+	if ok, trial := b.allow(); !ok || !trial {
+		t.Errorf("expected trial request after release, got %v %v", ok, trial)
+	}
+	if !b.success() {
+		t.Error("expected closed circuit breaker")
+	}
+	if ok, trial := b.allow(); !ok || trial {
+		t.Errorf("expected regular request, got %v %v", ok, trial)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 6, 22, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{"Sat, 22 Jun 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"Sat, 22 Jun 2024 11:00:00 GMT", 0, true},
+		{"", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		d, ok := parseRetryAfter(tt.value, now)
+		if d != tt.want || ok != tt.ok {
+			t.Errorf("%s: expected %v %v, got %v %v", tt.value, tt.want, tt.ok, d, ok)
+		}
+	}
+}
+
+func TestHttpSinkCompression(t *testing.T) {
+	var lines []string
+	var encodings []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(gz)
+		lines = append(lines, strings.TrimSuffix(string(data), "\n"))
+	}))
+	defer server.Close()
+
+	config := json.RawMessage(`{"type": "http", "url": "` + server.URL + `", "compression": "gzip", "max_batch_lines": 3, "flush_delay": "1h"}`)
+	s, err := NewHttpSink("testsink", config)
+	if err != nil {
+		t.Fatalf("failed to setup http sink: %v", err)
+	}
+	msgs, _ := gen_messages(10)
+	for _, m := range msgs {
+		s.Write(m)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	s.Close()
+
+	if len(lines) != 4 || strings.Count(lines[0], "\n") != 2 || strings.Count(lines[3], "\n") != 0 {
+		t.Errorf("flush was not split into batches of 3 lines: %q", lines)
+	}
+	for _, e := range encodings {
+		if e != "gzip" {
+			t.Errorf("invalid content encoding '%s'", e)
+		}
+	}
+	stats := s.(*HttpSink).BatchStats()
+	if stats.Batches != 4 || stats.Lines != 10 || stats.CompressionRatio() <= 0 {
+		t.Errorf("invalid batch statistics %+v", stats)
+	}
+}
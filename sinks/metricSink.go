@@ -19,6 +19,9 @@ type defaultSinkConfig struct {
 	MetaAsTags       []string        `json:"meta_as_tags,omitempty"`
 	MessageProcessor json.RawMessage `json:"process_messages,omitempty"`
 	Type             string          `json:"type"`
+	Route            string          `json:"route,omitempty"`         // Condition evaluated by the sink manager whether to forward a message to the sink
+	RouteInclude     []string        `json:"route_include,omitempty"` // Message name patterns forwarded to the sink
+	RouteExclude     []string        `json:"route_exclude,omitempty"` // Message name patterns not forwarded to the sink
 }
 
 type sink struct {
@@ -8,41 +8,99 @@ package sinks
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"regexp"
 	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
 	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
 	mp "github.com/ClusterCockpit/cc-lib/v2/messageProcessor"
+	"github.com/ClusterCockpit/cc-lib/v2/util"
 	influx "github.com/ClusterCockpit/cc-line-protocol/v2/lineprotocol"
 	nats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
+// NatsSinkJetStreamConfig configures publishing to a JetStream stream
+type NatsSinkJetStreamConfig struct {
+	// Name of the stream. Publishing fails if the subject belongs to another stream.
+	Stream string `json:"stream,omitempty"`
+	// Create the stream or update its configuration at startup
+	CreateStream bool `json:"create_stream,omitempty"`
+	// Settings of created streams: storage file (default) or memory, number of
+	// replicas, maximum age and size of messages (default: unlimited) and window
+	// to detect duplicates by the Nats-Msg-Id header (default: 2m)
+	Storage         string `json:"storage,omitempty"`
+	Replicas        int    `json:"replicas,omitempty"`
+	MaxAge          string `json:"max_age,omitempty"`
+	MaxBytes        int64  `json:"max_bytes,omitempty"`
+	DuplicateWindow string `json:"duplicate_window,omitempty"`
+	// Maximum time to wait for the acknowledgements of a flush (default: 5s)
+	AckTimeout string `json:"ack_timeout,omitempty"`
+	ackTimeout time.Duration
+	// Maximum number of un-acked batches retained for retry (default: 1000)
+	MaxPending int `json:"max_pending,omitempty"`
+}
+
 type NatsSinkConfig struct {
 	defaultSinkConfig
-	Host       string `json:"host,omitempty"`
-	Port       string `json:"port,omitempty"`
+	Host string `json:"host,omitempty"`
+	Port string `json:"port,omitempty"`
+	// Subject of the messages. Placeholders {tagname} are replaced by the tag (or meta)
+	// value, {msgtype} by the message type and {name} by the metric name.
 	Subject    string `json:"subject,omitempty"`
 	User       string `json:"user,omitempty"`
-	Password   string `json:"password,omitempty"`
+	Password   string `json:"password,omitempty"` // May be a secret reference (env:, file: or exec:)
 	FlushDelay string `json:"flush_delay,omitempty"`
 	flushDelay time.Duration
 	NkeyFile   string `json:"nkey_file,omitempty"`
 	// Timestamp precision
 	Precision string `json:"precision,omitempty"`
+	// Re-read file: secret references when the files change, used on reconnect
+	ReloadSecrets bool `json:"reload_secrets,omitempty"`
+	// Compression of the message payload: none, gzip or zstd (default: none),
+	// announced in the Content-Encoding header of the message
+	Compression string `json:"compression,omitempty"`
+	// Split flushes into messages of at most this many bytes (before compression) and lines (default: 0, unlimited)
+	MaxBatchBytes int `json:"max_batch_bytes,omitempty"`
+	MaxBatchLines int `json:"max_batch_lines,omitempty"`
+	// Maximum number of rendered subjects buffered between two flushes (default: 1000)
+	MaxSubjects int `json:"max_subjects,omitempty"`
+	// Subject of messages exceeding max_subjects (default: the messages are dropped)
+	OverflowSubject string `json:"overflow_subject,omitempty"`
+	// Publish to a JetStream stream with acknowledgements instead of core NATS
+	JetStream *NatsSinkJetStreamConfig `json:"jetstream,omitempty"`
 }
 
 type NatsSink struct {
 	sink
 	client      *nats.Conn
-	encoder     influx.Encoder
+	encoders    map[string]*influx.Encoder // Encoder per rendered subject
 	encoderLock sync.Mutex
+	precision   influx.Precision
+	dropped     int // Messages dropped because of max_subjects since the last flush
 	config      NatsSinkConfig
+	password    *util.Secret
+	compressor  *batchCompressor
+	stats       batchStats
+
+	// JetStream publishing, nil for core NATS
+	js          jetstream.JetStream
+	pubOpts     []jetstream.PublishOpt
+	pending     []*nats.Msg // Un-acked batches retained for retry
+	pendingLock sync.Mutex
+	retryTimer  *time.Timer // flushes again while batches are retained, guarded by pendingLock
+	closed      bool        // no retries after Close, guarded by pendingLock
 
 	flushTimer *time.Timer
 	timerLock  sync.Mutex
@@ -53,7 +111,10 @@ func (s *NatsSink) connect() error {
 	var nc *nats.Conn
 	natsOpts := make([]nats.Option, 0)
 	if len(s.config.User) > 0 && len(s.config.Password) > 0 {
-		natsOpts = append(natsOpts, nats.UserInfo(s.config.User, s.config.Password))
+		// Query the password on each (re-)connect to use re-read secrets
+		natsOpts = append(natsOpts, nats.UserInfoHandler(func() (string, string) {
+			return s.config.User, s.password.Value()
+		}))
 	} else if len(s.config.NkeyFile) > 0 {
 		if _, err := os.Stat(s.config.NkeyFile); err == nil {
 			natsOpts = append(natsOpts, nats.UserCredentials(s.config.NkeyFile))
@@ -78,11 +139,27 @@ func (s *NatsSink) connect() error {
 func (s *NatsSink) Write(m lp.CCMessage) error {
 	msg, err := s.mp.ProcessMessage(m)
 	if err == nil && msg != nil {
+		subject := s.subject(msg)
+
 		// Lock for encoder usage
 		s.encoderLock.Lock()
 
-		// Add message to encoder
-		err = EncoderAdd(&s.encoder, m)
+		// Add message to the encoder of its subject
+		encoder, ok := s.encoders[subject]
+		if !ok && len(s.encoders) >= s.config.MaxSubjects {
+			subject = s.config.OverflowSubject
+			encoder, ok = s.encoders[subject]
+		}
+		if !ok && len(subject) > 0 {
+			encoder = new(influx.Encoder)
+			encoder.SetPrecision(s.precision)
+			s.encoders[subject] = encoder
+		}
+		if encoder != nil {
+			err = EncoderAdd(encoder, msg)
+		} else {
+			s.dropped++
+		}
 
 		// Unlock encoder usage
 		s.encoderLock.Unlock()
@@ -129,23 +206,298 @@ func (s *NatsSink) Flush() error {
 	// Own lock for as short as possible: the time it takes to clone the buffer.
 	s.encoderLock.Lock()
 
-	buf := slices.Clone(s.encoder.Bytes())
-	s.encoder.Reset()
+	bufs := make(map[string][]byte, len(s.encoders))
+	for subject, encoder := range s.encoders {
+		if buf := encoder.Bytes(); len(buf) > 0 {
+			bufs[subject] = slices.Clone(buf)
+		}
+	}
+	// Subjects of the last flush interval are not kept
+	clear(s.encoders)
+	dropped := s.dropped
+	s.dropped = 0
 
 	// Unlock encoder usage
 	s.encoderLock.Unlock()
 
-	if len(buf) == 0 {
-		return nil
+	if dropped > 0 {
+		cclog.ComponentError(s.name, fmt.Sprintf("Flush: dropped %d messages exceeding max_subjects %d", dropped, s.config.MaxSubjects))
+	}
+
+	// Split the buffer of each subject into batches
+	var batches []natsBatch
+	for _, subject := range slices.Sorted(maps.Keys(bufs)) {
+		data, lines := splitBatch(bufs[subject], s.config.MaxBatchBytes, s.config.MaxBatchLines)
+		for i := range data {
+			batches = append(batches, natsBatch{subject: subject, data: data[i], lines: lines[i]})
+		}
+	}
+	if s.js != nil {
+		return s.flushJetStream(batches)
+	}
+
+	// Publish all batches and report the first error
+	var firstErr error
+	for _, batch := range batches {
+		msg, err := s.message(batch)
+		if err == nil {
+			err = s.client.PublishMsg(msg)
+		}
+		if err != nil {
+			cclog.ComponentError(s.name, "Flush:", err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+var natsSubjectPlaceholder = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// natsSubjectSanitizer replaces characters with special meaning in NATS subjects
+var natsSubjectSanitizer = strings.NewReplacer(".", "_", " ", "_", "\t", "_", "*", "_", ">", "_")
+
+// subject renders the subject template for msg
+func (s *NatsSink) subject(msg lp.CCMessage) string {
+	if !strings.Contains(s.config.Subject, "{") {
+		return s.config.Subject
+	}
+	return natsSubjectPlaceholder.ReplaceAllStringFunc(s.config.Subject, func(p string) string {
+		key := p[1 : len(p)-1]
+		var value string
+		switch key {
+		case "msgtype":
+			value = msg.MessageType().String()
+		case "name":
+			value = msg.Name()
+		default:
+			var ok bool
+			value, ok = msg.GetTag(key)
+			if !ok {
+				value, _ = msg.GetMeta(key)
+			}
+		}
+		if len(value) == 0 {
+			return "unknown"
+		}
+		return natsSubjectSanitizer.Replace(value)
+	})
+}
+
+// subjectWildcard returns the subject template with all tokens containing
+// placeholders replaced by the wildcard *
+func subjectWildcard(template string) string {
+	tokens := strings.Split(template, ".")
+	for i, t := range tokens {
+		if natsSubjectPlaceholder.MatchString(t) {
+			tokens[i] = "*"
+		}
+	}
+	return strings.Join(tokens, ".")
+}
+
+// natsBatch is a batch of line protocol of one subject
+type natsBatch struct {
+	subject string
+	data    []byte
+	lines   int
+}
+
+// message compresses a single batch and creates its NATS message
+func (s *NatsSink) message(batch natsBatch) (*nats.Msg, error) {
+	data, err := s.compressor.compress(batch.data)
+	if err != nil {
+		return nil, fmt.Errorf("compression failed: %w", err)
+	}
+	s.stats.add(batch.lines, len(batch.data), len(data))
+	cclog.ComponentDebug(s.name, fmt.Sprintf("Flush: batch of %d lines for %s, %d bytes, compressed to %d bytes (ratio %.2f)",
+		batch.lines, batch.subject, len(batch.data), len(data), float64(len(batch.data))/float64(len(data))))
+
+	msg := nats.NewMsg(batch.subject)
+	msg.Data = data
+	if len(s.compressor.encoding) > 0 {
+		msg.Header.Set("Content-Encoding", s.compressor.encoding)
+	}
+	return msg, nil
+}
+
+// batchMsgID derives the message ID used by JetStream to detect duplicates
+// from the subject and the uncompressed batch
+func batchMsgID(subject string, batch []byte) string {
+	h := sha256.New()
+	h.Write([]byte(subject))
+	h.Write([]byte{0})
+	h.Write(batch)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// flushJetStream publishes the retained and the new batches asynchronously
+// and waits for their acknowledgements. Batches which are not acknowledged
+// are retained and published again with the same message ID on the next flush.
+func (s *NatsSink) flushJetStream(batches []natsBatch) error {
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+
+	// Retained batches are published first to keep the order
+	var firstErr error
+	msgs := s.pending
+	for _, batch := range batches {
+		msg, err := s.message(batch)
+		if err != nil {
+			cclog.ComponentError(s.name, "Flush:", err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		msg.Header.Set(jetstream.MsgIDHeader, batchMsgID(msg.Subject, batch.data))
+		msgs = append(msgs, msg)
+	}
+
+	futures := make([]jetstream.PubAckFuture, len(msgs))
+	for i, msg := range msgs {
+		f, err := s.js.PublishMsgAsync(msg, s.pubOpts...)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		futures[i] = f
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.JetStream.ackTimeout)
+	defer cancel()
+	s.pending = nil
+	for i, f := range futures {
+		if f == nil {
+			s.pending = append(s.pending, msgs[i])
+			continue
+		}
+		select {
+		case ack := <-f.Ok():
+			if ack.Duplicate {
+				cclog.ComponentDebug(s.name, fmt.Sprintf("Flush: batch %s was already stored in stream %s",
+					msgs[i].Header.Get(jetstream.MsgIDHeader), ack.Stream))
+			}
+		case err := <-f.Err():
+			s.pending = append(s.pending, msgs[i])
+			if firstErr == nil {
+				firstErr = err
+			}
+		case <-ctx.Done():
+			s.pending = append(s.pending, msgs[i])
+			if firstErr == nil {
+				firstErr = errors.New("timeout waiting for publish acknowledgement")
+			}
+		}
+	}
+
+	if len(s.pending) > 0 {
+		cclog.ComponentError(s.name, fmt.Sprintf("Flush: %d of %d batches not acknowledged, retained for retry: %v",
+			len(s.pending), len(msgs), firstErr))
+		if drop := len(s.pending) - s.config.JetStream.MaxPending; drop > 0 {
+			cclog.ComponentError(s.name, fmt.Sprintf("Flush: dropping %d oldest un-acked batches", drop))
+			s.pending = slices.Delete(s.pending, 0, drop)
+		}
+		s.scheduleRetry()
+	} else if firstErr != nil {
+		cclog.ComponentError(s.name, "Flush:", firstErr.Error())
+	}
+	return firstErr
+}
+
+// scheduleRetry flushes again after ack_timeout, so that retained batches are
+// retried even without new metrics. pendingLock has to be held by the caller.
+func (s *NatsSink) scheduleRetry() {
+	if s.closed {
+		return
+	}
+	if s.retryTimer != nil {
+		s.retryTimer.Reset(s.config.JetStream.ackTimeout)
+		return
+	}
+	s.retryTimer = time.AfterFunc(s.config.JetStream.ackTimeout, func() {
+		cclog.ComponentDebug(s.name, "Starting flush to retry retained batches")
+		if err := s.Flush(); err != nil {
+			cclog.ComponentError(s.name, "Flush to retry retained batches failed:", err)
+		}
+	})
+}
+
+// setupJetStream creates the JetStream context and optionally the stream
+func (s *NatsSink) setupJetStream() error {
+	c := s.config.JetStream
+	c.ackTimeout = 5 * time.Second
+	if c.MaxPending == 0 {
+		c.MaxPending = 1000
+	}
+	if c.MaxPending < 0 {
+		return errors.New("jetstream max_pending must not be negative")
+	}
+	stream := jetstream.StreamConfig{
+		Name:     c.Stream,
+		Subjects: []string{subjectWildcard(s.config.Subject)},
+		Replicas: c.Replicas,
+		MaxBytes: c.MaxBytes,
+	}
+	for _, d := range []struct {
+		option string
+		value  string
+		target *time.Duration
+	}{
+		{"ack_timeout", c.AckTimeout, &c.ackTimeout},
+		{"max_age", c.MaxAge, &stream.MaxAge},
+		{"duplicate_window", c.DuplicateWindow, &stream.Duplicates},
+	} {
+		if len(d.value) > 0 {
+			t, err := time.ParseDuration(d.value)
+			if err != nil {
+				return fmt.Errorf("failed to parse jetstream %s '%s': %w", d.option, d.value, err)
+			}
+			*d.target = t
+		}
+	}
+	if len(s.config.OverflowSubject) > 0 {
+		stream.Subjects = append(stream.Subjects, s.config.OverflowSubject)
+	}
+	switch c.Storage {
+	case "", "file":
+		stream.Storage = jetstream.FileStorage
+	case "memory":
+		stream.Storage = jetstream.MemoryStorage
+	default:
+		return fmt.Errorf("unknown jetstream storage '%s'", c.Storage)
 	}
 
-	if err := s.client.Publish(s.config.Subject, buf); err != nil {
-		cclog.ComponentError(s.name, "Flush:", err.Error())
+	js, err := jetstream.New(s.client, jetstream.WithPublishAsyncTimeout(c.ackTimeout))
+	if err != nil {
 		return err
 	}
+	if len(c.Stream) > 0 {
+		s.pubOpts = append(s.pubOpts, jetstream.WithExpectStream(c.Stream))
+	}
+	if c.CreateStream {
+		if len(c.Stream) == 0 {
+			return errors.New("jetstream create_stream requires stream")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), c.ackTimeout)
+		defer cancel()
+		if _, err := js.CreateOrUpdateStream(ctx, stream); err != nil {
+			return fmt.Errorf("failed to create stream %s: %w", c.Stream, err)
+		}
+		cclog.ComponentDebug(s.name, "Created or updated stream "+c.Stream)
+	}
+	s.js = js
 	return nil
 }
 
+// BatchStats returns the statistics of the batches published by the sink
+func (s *NatsSink) BatchStats() BatchStats {
+	return s.stats.get()
+}
+
 func (s *NatsSink) Close() {
 	// Stop existing timer and immediately flush
 	if s.flushTimer != nil {
@@ -153,8 +505,23 @@ func (s *NatsSink) Close() {
 			s.timerLock.Unlock()
 		}
 	}
+	s.pendingLock.Lock()
+	s.closed = true
+	if s.retryTimer != nil {
+		s.retryTimer.Stop()
+	}
+	s.pendingLock.Unlock()
+	if err := s.Flush(); err != nil {
+		cclog.ComponentError(s.name, "Close(): flush failed:", err)
+	}
+	s.pendingLock.Lock()
+	if n := len(s.pending); n > 0 {
+		cclog.ComponentError(s.name, fmt.Sprintf("Close(): dropping %d un-acked batches", n))
+	}
+	s.pendingLock.Unlock()
 	cclog.ComponentDebug(s.name, "Close NATS connection")
 	s.client.Close()
+	s.password.Close()
 }
 
 func NewNatsSink(name string, config json.RawMessage) (Sink, error) {
@@ -164,6 +531,7 @@ func NewNatsSink(name string, config json.RawMessage) (Sink, error) {
 	s.config.FlushDelay = "5s"
 	s.config.Port = "4222"
 	s.config.Precision = "s"
+	s.config.MaxSubjects = 1000
 	if len(config) > 0 {
 		d := json.NewDecoder(bytes.NewReader(config))
 		d.DisallowUnknownFields()
@@ -210,11 +578,35 @@ func NewNatsSink(name string, config json.RawMessage) (Sink, error) {
 		}
 	}
 
-	s.encoder.SetPrecision(precision)
+	s.precision = precision
+	s.encoders = make(map[string]*influx.Encoder)
+	if s.config.MaxSubjects < 1 {
+		return nil, fmt.Errorf("%s: max_subjects must be positive", s.name)
+	}
+	if s.config.MaxBatchBytes < 0 || s.config.MaxBatchLines < 0 {
+		return nil, fmt.Errorf("%s: max_batch_bytes and max_batch_lines must not be negative", s.name)
+	}
+	s.compressor, err = newBatchCompressor(s.config.Compression)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.name, err)
+	}
+	if len(s.config.User) > 0 && len(s.config.Password) > 0 {
+		secret, err := util.NewSecret(s.config.Password, s.config.ReloadSecrets)
+		if err != nil {
+			return nil, fmt.Errorf("%s: password: %w", s.name, err)
+		}
+		s.password = secret
+	}
 	// Setup infos for connection
 	if err := s.connect(); err != nil {
 		return nil, fmt.Errorf("unable to connect: %v", err)
 	}
+	if s.config.JetStream != nil {
+		if err := s.setupJetStream(); err != nil {
+			s.client.Close()
+			return nil, fmt.Errorf("%s: %w", s.name, err)
+		}
+	}
 
 	s.flushTimer = nil
 	if len(s.config.FlushDelay) > 0 {
@@ -1,14 +1,19 @@
 package sinks
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
 	"testing"
 	"time"
 
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	"github.com/klauspost/compress/zstd"
+
 	server "github.com/nats-io/nats-server/v2/server"
 	nats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
 var testNatsConfig = NatsSinkConfig{
@@ -105,3 +110,242 @@ func TestNatsSink(t *testing.T) {
 		}
 	}
 }
+
+func TestNatsSinkCompression(t *testing.T) {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1})
+	if err != nil {
+		t.Fatalf("nats server cannot be created: %v", err)
+	}
+	server.Run(ns)
+	defer ns.Shutdown()
+	if !ns.ReadyForConnections(4 * time.Second) {
+		t.Fatal("nats server not ready for connection")
+	}
+
+	c, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("failed to connect to nats server: %v", err)
+	}
+	defer c.Close()
+	received := make(chan *nats.Msg, 10)
+	if _, err := c.ChanSubscribe("compressed", received); err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	c.Flush()
+
+	addr := ns.Addr().String()
+	config := json.RawMessage(fmt.Sprintf(`{"type": "nats", "host": "127.0.0.1", "port": "%s", "subject": "compressed", "compression": "zstd", "max_batch_lines": 4, "flush_delay": "1h"}`,
+		addr[strings.LastIndex(addr, ":")+1:]))
+	s, err := NewNatsSink("testsink", config)
+	if err != nil {
+		t.Fatalf("failed to setup nats sink: %v", err)
+	}
+	defer s.Close()
+	msgs, _ := gen_messages(10)
+	for _, m := range msgs {
+		s.Write(m)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	d, _ := zstd.NewReader(nil)
+	defer d.Close()
+	lines := 0
+	for i := range 3 {
+		select {
+		case m := <-received:
+			if m.Header.Get("Content-Encoding") != "zstd" {
+				t.Errorf("message %d: invalid content encoding '%s'", i, m.Header.Get("Content-Encoding"))
+			}
+			data, err := d.DecodeAll(m.Data, nil)
+			if err != nil {
+				t.Fatalf("message %d: decompression failed: %v", i, err)
+			}
+			lines += strings.Count(string(data), "\n")
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout waiting for message %d", i)
+		}
+	}
+	if lines != 10 {
+		t.Errorf("expected 10 lines, got %d", lines)
+	}
+	if stats := s.(*NatsSink).BatchStats(); stats.Batches != 3 || stats.Lines != 10 {
+		t.Errorf("invalid batch statistics %+v", stats)
+	}
+}
+
+func TestNatsSinkJetStream(t *testing.T) {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("nats server cannot be created: %v", err)
+	}
+	server.Run(ns)
+	defer ns.Shutdown()
+	if !ns.ReadyForConnections(4 * time.Second) {
+		t.Fatal("nats server not ready for connection")
+	}
+
+	addr := ns.Addr().String()
+	config := json.RawMessage(fmt.Sprintf(`{"type": "nats", "host": "127.0.0.1", "port": "%s", "subject": "metrics", "flush_delay": "1h",
+		"jetstream": {"stream": "METRICS", "create_stream": true, "storage": "memory", "ack_timeout": "1s"}}`,
+		addr[strings.LastIndex(addr, ":")+1:]))
+	s, err := NewNatsSink("testsink", config)
+	if err != nil {
+		t.Fatalf("failed to setup nats sink: %v", err)
+	}
+	defer s.Close()
+
+	c, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("failed to connect to nats server: %v", err)
+	}
+	defer c.Close()
+	js, _ := jetstream.New(c)
+	ctx := context.Background()
+	streamMsgs := func() uint64 {
+		t.Helper()
+		stream, err := js.Stream(ctx, "METRICS")
+		if err != nil {
+			t.Fatalf("failed to get stream: %v", err)
+		}
+		info, err := stream.Info(ctx)
+		if err != nil {
+			t.Fatalf("failed to get stream info: %v", err)
+		}
+		return info.State.Msgs
+	}
+	pending := func() int {
+		ns := s.(*NatsSink)
+		ns.pendingLock.Lock()
+		defer ns.pendingLock.Unlock()
+		return len(ns.pending)
+	}
+
+	// The same batch is stored only once
+	msgs, _ := gen_messages(10)
+	for range 2 {
+		for _, m := range msgs {
+			s.Write(m)
+		}
+		if err := s.Flush(); err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+	}
+	if n := streamMsgs(); n != 1 {
+		t.Errorf("expected 1 message in stream, got %d", n)
+	}
+
+	// Batches are retained while the stream is missing
+	if err := js.DeleteStream(ctx, "METRICS"); err != nil {
+		t.Fatalf("failed to delete stream: %v", err)
+	}
+	for _, m := range msgs {
+		s.Write(m)
+	}
+	if err := s.Flush(); err == nil {
+		t.Fatal("flush without stream succeeded")
+	}
+	if n := pending(); n != 1 {
+		t.Fatalf("expected 1 retained batch, got %d", n)
+	}
+	if _, err := js.CreateStream(ctx, jetstream.StreamConfig{Name: "METRICS", Subjects: []string{"metrics"}, Storage: jetstream.MemoryStorage}); err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatalf("flush of retained batches failed: %v", err)
+	}
+	if n := streamMsgs(); n != 1 {
+		t.Errorf("expected 1 message in stream, got %d", n)
+	}
+	if n := pending(); n != 0 {
+		t.Errorf("expected no retained batches, got %d", n)
+	}
+
+	// Retained batches are retried without further writes
+	if err := js.DeleteStream(ctx, "METRICS"); err != nil {
+		t.Fatalf("failed to delete stream: %v", err)
+	}
+	for _, m := range msgs {
+		s.Write(m)
+	}
+	if err := s.Flush(); err == nil {
+		t.Fatal("flush without stream succeeded")
+	}
+	if _, err := js.CreateStream(ctx, jetstream.StreamConfig{Name: "METRICS", Subjects: []string{"metrics"}, Storage: jetstream.MemoryStorage}); err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for streamMsgs() != 1 || pending() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("retained batch not retried: %d messages in stream, %d retained", streamMsgs(), pending())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestNatsSinkSubjects(t *testing.T) {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1})
+	if err != nil {
+		t.Fatalf("nats server cannot be created: %v", err)
+	}
+	server.Run(ns)
+	defer ns.Shutdown()
+	if !ns.ReadyForConnections(4 * time.Second) {
+		t.Fatal("nats server not ready for connection")
+	}
+
+	c, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("failed to connect to nats server: %v", err)
+	}
+	defer c.Close()
+	received := make(chan *nats.Msg, 10)
+	if _, err := c.ChanSubscribe("cc.>", received); err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	c.Flush()
+
+	addr := ns.Addr().String()
+	config := json.RawMessage(fmt.Sprintf(`{"type": "nats", "host": "127.0.0.1", "port": "%s", "subject": "cc.{cluster}.{msgtype}.{hostname}",
+		"max_subjects": 3, "overflow_subject": "cc.overflow", "flush_delay": "1h"}`,
+		addr[strings.LastIndex(addr, ":")+1:]))
+	s, err := NewNatsSink("testsink", config)
+	if err != nil {
+		t.Fatalf("failed to setup nats sink: %v", err)
+	}
+	defer s.Close()
+
+	now := time.Now()
+	for _, host := range []string{"n1.example", "n1.example", "n2", "n3"} {
+		m, _ := lp.NewMetric("cpu_load", map[string]string{"cluster": "fritz", "hostname": host}, nil, 1.0, now)
+		s.Write(m)
+	}
+	e, _ := lp.NewEvent("job", map[string]string{"cluster": "fritz"}, nil, "start", now)
+	s.Write(e)
+	if err := s.Flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	expected := map[string]int{
+		"cc.fritz.metric.n1_example": 2,
+		"cc.fritz.metric.n2":         1,
+		"cc.fritz.metric.n3":         1,
+		"cc.overflow":                1,
+	}
+	for range expected {
+		select {
+		case m := <-received:
+			if n := strings.Count(string(m.Data), "\n"); n != expected[m.Subject] {
+				t.Errorf("subject %s: expected %d lines, got %d", m.Subject, expected[m.Subject], n)
+			}
+			delete(expected, m.Subject)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout waiting for messages of %v", expected)
+		}
+	}
+
+	if w := subjectWildcard("cc.{cluster}.{msgtype}.node-{hostname}"); w != "cc.*.*.*" {
+		t.Errorf("invalid subject wildcard %s", w)
+	}
+}
@@ -0,0 +1,421 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package sinks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	mp "github.com/ClusterCockpit/cc-lib/v2/messageProcessor"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+)
+
+// Fixed columns of the parquet files, all other columns are tags
+const (
+	PARQUET_COLUMN_TIME      = "time"
+	PARQUET_COLUMN_NAME      = "name"
+	PARQUET_COLUMN_VALUE     = "value"
+	PARQUET_COLUMN_VALUE_INT = "value_int"
+)
+
+type ParquetSinkConfig struct {
+	defaultSinkConfig
+
+	// Base directory of the partitioned parquet files
+	Path string `json:"path"`
+
+	// Tags used for partitioning in addition to the date (default: cluster)
+	PartitionTags []string `json:"partition_tags,omitempty"`
+
+	// Number of buffered rows written as one row group (default: 10000)
+	RowGroupSize int `json:"row_group_size,omitempty"`
+
+	// Maximum number of buffered rows per partition while writing fails,
+	// the oldest rows are dropped (default: 10 * row_group_size)
+	MaxBufferedRows int `json:"max_buffered_rows,omitempty"`
+
+	// Roll over to a new file when the file exceeds this size in bytes (0 disables size based roll over)
+	MaxFileSize int64 `json:"max_file_size,omitempty"`
+
+	// Roll over to a new file after it was open for this duration (default: 1h)
+	RotateInterval string `json:"rotate_interval,omitempty"`
+	rotateInterval time.Duration
+
+	// Compression codec: snappy (default), zstd, gzip, lz4 or none
+	Compression string `json:"compression,omitempty"`
+}
+
+// parquetPartition holds the buffered rows and the open file of one partition
+type parquetPartition struct {
+	dir     string
+	rows    []map[string]any
+	tags    map[string]struct{} // tag columns of the open file
+	writer  *parquet.Writer
+	file    *os.File
+	path    string // final path of the open file
+	tmpPath string // path of the open file while it is written
+	opened  time.Time
+	written bool // rows were added since the last flush
+}
+
+type ParquetSink struct {
+	sink
+	config     ParquetSinkConfig
+	codec      compress.Codec
+	partitions map[string]*parquetPartition
+	lock       sync.Mutex
+}
+
+// partitionDir returns the Hive-style partition directory of msg,
+// e.g. cluster=fritz/date=2024-01-31
+func (s *ParquetSink) partitionDir(msg lp.CCMessage) string {
+	parts := make([]string, 0, len(s.config.PartitionTags)+2)
+	parts = append(parts, s.config.Path)
+	for _, key := range s.config.PartitionTags {
+		value, ok := msg.GetTag(key)
+		if !ok || len(value) == 0 {
+			value = "unknown"
+		}
+		parts = append(parts, fmt.Sprintf("%s=%s", key, strings.ReplaceAll(value, "/", "_")))
+	}
+	parts = append(parts, "date="+msg.Time().UTC().Format(time.DateOnly))
+	return filepath.Join(parts...)
+}
+
+// toRow converts a metric to a row. Partition tags are not stored as columns.
+func (s *ParquetSink) toRow(msg lp.CCMessage) (map[string]any, error) {
+	row := map[string]any{
+		PARQUET_COLUMN_TIME: msg.Time(),
+		PARQUET_COLUMN_NAME: msg.Name(),
+	}
+	value, _ := msg.GetMetricValue()
+	switch v := value.(type) {
+	case float64:
+		row[PARQUET_COLUMN_VALUE] = v
+	case int64:
+		row[PARQUET_COLUMN_VALUE_INT] = v
+	case uint64:
+		if v <= math.MaxInt64 {
+			row[PARQUET_COLUMN_VALUE_INT] = int64(v)
+		} else {
+			row[PARQUET_COLUMN_VALUE] = float64(v)
+		}
+	case bool:
+		if v {
+			row[PARQUET_COLUMN_VALUE_INT] = int64(1)
+		} else {
+			row[PARQUET_COLUMN_VALUE_INT] = int64(0)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported value type %T of metric %s", value, msg.Name())
+	}
+	for key, value := range msg.Tags() {
+		if slices.Contains(s.config.PartitionTags, key) {
+			continue
+		}
+		switch key {
+		case PARQUET_COLUMN_TIME, PARQUET_COLUMN_NAME, PARQUET_COLUMN_VALUE, PARQUET_COLUMN_VALUE_INT:
+			continue
+		}
+		row[key] = value
+	}
+	return row, nil
+}
+
+// schema creates the parquet schema with the given tag columns.
+// Tags and names are dictionary encoded.
+func (s *ParquetSink) schema(tags map[string]struct{}) *parquet.Schema {
+	group := parquet.Group{
+		PARQUET_COLUMN_TIME:      parquet.Timestamp(parquet.Nanosecond),
+		PARQUET_COLUMN_NAME:      parquet.Encoded(parquet.String(), &parquet.RLEDictionary),
+		PARQUET_COLUMN_VALUE:     parquet.Optional(parquet.Leaf(parquet.DoubleType)),
+		PARQUET_COLUMN_VALUE_INT: parquet.Optional(parquet.Int(64)),
+	}
+	for key := range tags {
+		group[key] = parquet.Optional(parquet.Encoded(parquet.String(), &parquet.RLEDictionary))
+	}
+	return parquet.NewSchema("ccmessage", group)
+}
+
+// open creates a new file in the partition directory with the given tag columns.
+// The file is written with a hidden temporary name and renamed when it is closed,
+// so readers never see incomplete files.
+func (s *ParquetSink) open(p *parquetPartition, tags map[string]struct{}) error {
+	if err := os.MkdirAll(p.dir, 0o750); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", p.dir, err)
+	}
+	now := time.Now()
+	name := fmt.Sprintf("part-%s-%d.parquet", now.UTC().Format("20060102T150405"), now.UnixNano())
+	p.path = filepath.Join(p.dir, name)
+	p.tmpPath = filepath.Join(p.dir, "."+name+".tmp")
+	f, err := os.OpenFile(p.tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", p.tmpPath, err)
+	}
+	p.file = f
+	p.tags = tags
+	p.opened = now
+	p.writer = parquet.NewWriter(f, s.schema(tags), parquet.Compression(s.codec), parquet.CreatedBy("cc-lib", "", ""))
+	cclog.ComponentDebug(s.name, "Opened", p.tmpPath)
+	return nil
+}
+
+// closeFile writes the parquet footer and moves the file to its final name
+func (s *ParquetSink) closeFile(p *parquetPartition) error {
+	if p.writer == nil {
+		return nil
+	}
+	var errs []error
+	if err := p.writer.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to finish %s: %w", p.tmpPath, err))
+	}
+	if err := p.file.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close %s: %w", p.tmpPath, err))
+	}
+	if len(errs) == 0 {
+		if err := os.Rename(p.tmpPath, p.path); err != nil {
+			errs = append(errs, fmt.Errorf("failed to rename %s: %w", p.tmpPath, err))
+		} else {
+			cclog.ComponentDebug(s.name, "Closed", p.path)
+		}
+	}
+	p.writer = nil
+	p.file = nil
+	p.tags = nil
+	return errors.Join(errs...)
+}
+
+// rollOver checks whether the open file of the partition should be closed
+func (s *ParquetSink) rollOver(p *parquetPartition) bool {
+	if p.writer == nil {
+		return false
+	}
+	if s.config.MaxFileSize > 0 && p.writer.Size() >= s.config.MaxFileSize {
+		return true
+	}
+	return s.config.rotateInterval > 0 && time.Since(p.opened) >= s.config.rotateInterval
+}
+
+// flushPartition writes the buffered rows of the partition as row group
+func (s *ParquetSink) flushPartition(p *parquetPartition) error {
+	if s.rollOver(p) {
+		if err := s.closeFile(p); err != nil {
+			return err
+		}
+	}
+	if len(p.rows) == 0 {
+		return nil
+	}
+
+	// Collect the tag columns of the buffered rows
+	tags := make(map[string]struct{})
+	for _, row := range p.rows {
+		for key := range row {
+			switch key {
+			case PARQUET_COLUMN_TIME, PARQUET_COLUMN_NAME, PARQUET_COLUMN_VALUE, PARQUET_COLUMN_VALUE_INT:
+			default:
+				tags[key] = struct{}{}
+			}
+		}
+	}
+	if p.writer != nil {
+		// The schema of a parquet file is fixed, new tags require a new file
+		for key := range tags {
+			if _, ok := p.tags[key]; !ok {
+				maps.Copy(tags, p.tags)
+				if err := s.closeFile(p); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	if p.writer == nil {
+		if err := s.open(p, tags); err != nil {
+			return err
+		}
+	}
+
+	for i, row := range p.rows {
+		if err := p.writer.Write(row); err != nil {
+			// Keep only the rows which were not written
+			p.rows = slices.Delete(p.rows, 0, i)
+			return fmt.Errorf("failed to write row to %s: %w", p.tmpPath, err)
+		}
+	}
+	// The rows are held by the writer until they are flushed
+	p.rows = p.rows[:0]
+	if err := p.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write row group to %s: %w", p.tmpPath, err)
+	}
+	return nil
+}
+
+func (s *ParquetSink) Write(m lp.CCMessage) error {
+	msg, err := s.mp.ProcessMessage(m)
+	if err != nil || msg == nil {
+		return nil
+	}
+	// Only metrics are stored in the columnar format
+	if !msg.IsMetric() {
+		return nil
+	}
+	row, err := s.toRow(msg)
+	if err != nil {
+		return err
+	}
+	dir := s.partitionDir(msg)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	p, ok := s.partitions[dir]
+	if !ok {
+		p = &parquetPartition{
+			dir:  dir,
+			rows: make([]map[string]any, 0, s.config.RowGroupSize),
+		}
+		s.partitions[dir] = p
+	}
+	p.rows = append(p.rows, row)
+	p.written = true
+	if drop := len(p.rows) - s.config.MaxBufferedRows; drop > 0 {
+		p.rows = slices.Delete(p.rows, 0, drop)
+		cclog.ComponentError(s.name, fmt.Sprintf("Dropped %d rows of %s, the buffer is full", drop, dir))
+	}
+	if len(p.rows) >= s.config.RowGroupSize {
+		return s.flushPartition(p)
+	}
+	return nil
+}
+
+// Flush writes the buffered rows of all partitions as row groups
+// and rolls over files which are due. Partitions without new rows since
+// the previous flush, e.g. of past dates, are finished and dropped.
+func (s *ParquetSink) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var errs []error
+	for dir, p := range s.partitions {
+		if err := s.flushPartition(p); err != nil {
+			errs = append(errs, err)
+		}
+		if !p.written && len(p.rows) == 0 {
+			if err := s.closeFile(p); err != nil {
+				errs = append(errs, err)
+			}
+			delete(s.partitions, dir)
+			continue
+		}
+		p.written = false
+		if s.rollOver(p) {
+			if err := s.closeFile(p); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close writes all buffered rows and finishes all open files
+func (s *ParquetSink) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for dir, p := range s.partitions {
+		if err := s.flushPartition(p); err != nil {
+			cclog.ComponentError(s.name, "Close(): Flush failed:", err.Error())
+		}
+		if err := s.closeFile(p); err != nil {
+			cclog.ComponentError(s.name, "Close():", err.Error())
+		}
+		delete(s.partitions, dir)
+	}
+}
+
+// NewParquetSink creates a new parquet sink
+func NewParquetSink(name string, config json.RawMessage) (Sink, error) {
+	s := new(ParquetSink)
+	s.name = fmt.Sprintf("ParquetSink(%s)", name)
+	s.config.PartitionTags = []string{"cluster"}
+	s.config.RowGroupSize = 10000
+	s.config.RotateInterval = "1h"
+	s.config.Compression = "snappy"
+
+	if len(config) > 0 {
+		d := json.NewDecoder(bytes.NewReader(config))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&s.config); err != nil {
+			cclog.ComponentError(s.name, "Error reading config:", err.Error())
+			return nil, err
+		}
+	}
+	if len(s.config.Path) == 0 {
+		return nil, errors.New("`path` config option is required for parquet sink")
+	}
+	if s.config.RowGroupSize <= 0 {
+		return nil, errors.New("`row_group_size` must be positive")
+	}
+	if s.config.MaxBufferedRows == 0 {
+		s.config.MaxBufferedRows = 10 * s.config.RowGroupSize
+	}
+	if s.config.MaxBufferedRows < s.config.RowGroupSize {
+		return nil, errors.New("`max_buffered_rows` must not be smaller than `row_group_size`")
+	}
+	if len(s.config.RotateInterval) > 0 {
+		t, err := time.ParseDuration(s.config.RotateInterval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse rotate_interval '%s': %w", s.config.RotateInterval, err)
+		}
+		s.config.rotateInterval = t
+	}
+	switch s.config.Compression {
+	case "snappy":
+		s.codec = &parquet.Snappy
+	case "zstd":
+		s.codec = &parquet.Zstd
+	case "gzip":
+		s.codec = &parquet.Gzip
+	case "lz4":
+		s.codec = &parquet.Lz4Raw
+	case "none":
+		s.codec = &parquet.Uncompressed
+	default:
+		return nil, fmt.Errorf("unknown compression '%s' for parquet sink", s.config.Compression)
+	}
+
+	p, err := mp.NewMessageProcessor()
+	if err != nil {
+		return nil, fmt.Errorf("initialization of message processor failed: %w", err)
+	}
+	s.mp = p
+	if len(s.config.MessageProcessor) > 0 {
+		err = s.mp.FromConfigJSON(s.config.MessageProcessor)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing JSON for message processor: %w", err)
+		}
+	}
+	for _, k := range s.config.MetaAsTags {
+		s.mp.AddMoveMetaToTags("true", k, k)
+	}
+
+	s.partitions = make(map[string]*parquetPartition)
+
+	return s, nil
+}
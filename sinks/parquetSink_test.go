@@ -0,0 +1,176 @@
+package sinks
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	"github.com/parquet-go/parquet-go"
+)
+
+func readParquetRows(t *testing.T, path string) []map[string]any {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+	info, _ := f.Stat()
+	pf, err := parquet.OpenFile(f, info.Size())
+	if err != nil {
+		t.Fatalf("failed to read parquet file %s: %v", path, err)
+	}
+	r := parquet.NewReader(pf)
+	defer r.Close()
+	rows := make([]map[string]any, 0, pf.NumRows())
+	for range pf.NumRows() {
+		row := make(map[string]any)
+		if err := r.Read(&row); err != nil {
+			t.Fatalf("failed to read row from %s: %v", path, err)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestParquetSink(t *testing.T) {
+	dir := t.TempDir()
+	config := fmt.Sprintf(`{"type": "parquet", "path": "%s", "row_group_size": 3, "compression": "zstd"}`, dir)
+	s, err := NewParquetSink("testsink", json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to setup parquet sink: %v", err)
+	}
+
+	now := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	for i := range 10 {
+		tags := map[string]string{"cluster": "fritz", "hostname": fmt.Sprintf("f%04d", i%2), "type": "node"}
+		if i%5 == 0 {
+			tags["cluster"] = "alex"
+		}
+		var m lp.CCMessage
+		if i%2 == 0 {
+			m, _ = lp.NewMetric("flops_any", tags, nil, float64(i)+0.5, now)
+		} else {
+			m, _ = lp.NewMetric("mem_used", tags, nil, int64(i), now)
+		}
+		if err := s.Write(m); err != nil {
+			t.Errorf("failed to write message: %v", err)
+		}
+	}
+	// A new tag after the first row group rolls over to a new file
+	m, _ := lp.NewMetric("cpu_load", map[string]string{"cluster": "fritz", "hostname": "f0000", "type": "hwthread", "type-id": "1"}, nil, 1.0, now)
+	s.Write(m)
+	// Events are not stored
+	e, _ := lp.NewEvent("job_start", map[string]string{"cluster": "fritz"}, nil, "started", now)
+	s.Write(e)
+	s.Close()
+
+	tmp, _ := filepath.Glob(filepath.Join(dir, "*", "*", ".*.tmp"))
+	if len(tmp) > 0 {
+		t.Errorf("temporary files left after close: %v", tmp)
+	}
+
+	counts := map[string]int{}
+	for _, cluster := range []string{"fritz", "alex"} {
+		files, _ := filepath.Glob(filepath.Join(dir, "cluster="+cluster, "date=2024-01-31", "*.parquet"))
+		if len(files) == 0 {
+			t.Fatalf("no parquet files for cluster %s", cluster)
+		}
+		for _, f := range files {
+			for _, row := range readParquetRows(t, f) {
+				counts[cluster]++
+				if _, ok := row["cluster"]; ok {
+					t.Errorf("partition tag stored as column in %s", f)
+				}
+				switch row["name"] {
+				case "flops_any":
+					if _, ok := row["value"].(float64); !ok {
+						t.Errorf("invalid value column for flops_any: %v", row)
+					}
+				case "mem_used":
+					if _, ok := row["value_int"].(int64); !ok {
+						t.Errorf("invalid value_int column for mem_used: %v", row)
+					}
+				case "cpu_load":
+					if row["type-id"] != "1" {
+						t.Errorf("missing tag column type-id: %v", row)
+					}
+				}
+			}
+		}
+	}
+	if counts["fritz"] != 9 || counts["alex"] != 2 {
+		t.Errorf("unexpected number of rows per partition: %v", counts)
+	}
+}
+
+func TestParquetSinkIdlePartitions(t *testing.T) {
+	dir := t.TempDir()
+	config := fmt.Sprintf(`{"type": "parquet", "path": "%s"}`, dir)
+	s, err := NewParquetSink("testsink", json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to setup parquet sink: %v", err)
+	}
+	defer s.Close()
+	p := s.(*ParquetSink)
+
+	write := func(date time.Time) {
+		m, _ := lp.NewMetric("flops_any", map[string]string{"cluster": "fritz", "hostname": "f0101"}, nil, 1.0, date)
+		if err := s.Write(m); err != nil {
+			t.Errorf("failed to write message: %v", err)
+		}
+	}
+	day := time.Date(2024, 1, 31, 23, 59, 0, 0, time.UTC)
+	write(day)
+	s.Flush()
+	// The date of the metrics moves on
+	write(day.Add(time.Hour))
+	s.Flush()
+	write(day.Add(2 * time.Hour))
+	s.Flush()
+	if len(p.partitions) != 1 {
+		t.Errorf("expected 1 partition, got %d", len(p.partitions))
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "cluster=fritz", "date=2024-01-31", "*.parquet"))
+	if len(files) != 1 {
+		t.Fatalf("expected finished file of the idle partition, got %v", files)
+	}
+	if rows := readParquetRows(t, files[0]); len(rows) != 1 {
+		t.Errorf("expected 1 row, got %d", len(rows))
+	}
+}
+
+func TestParquetSinkBufferLimit(t *testing.T) {
+	// Partition directories cannot be created below a file
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config := fmt.Sprintf(`{"type": "parquet", "path": "%s", "row_group_size": 2, "max_buffered_rows": 3}`, path)
+	s, err := NewParquetSink("testsink", json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to setup parquet sink: %v", err)
+	}
+	defer s.Close()
+	p := s.(*ParquetSink)
+
+	now := time.Now()
+	for i := range 10 {
+		m, _ := lp.NewMetric("flops_any", map[string]string{"cluster": "fritz"}, nil, float64(i), now)
+		if err := s.Write(m); err == nil && i > 0 {
+			t.Errorf("write %d: expected error of the failing partition", i)
+		}
+	}
+	for _, part := range p.partitions {
+		if len(part.rows) != 3 || part.rows[0][PARQUET_COLUMN_VALUE] != 7.0 {
+			t.Errorf("expected the 3 newest rows, got %v", part.rows)
+		}
+	}
+
+	if _, err := NewParquetSink("testsink", json.RawMessage(`{"type": "parquet", "path": "/tmp", "row_group_size": 10, "max_buffered_rows": 5}`)); err == nil {
+		t.Error("expected error for max_buffered_rows below row_group_size")
+	}
+}
@@ -9,6 +9,7 @@ package sinks
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -19,6 +20,7 @@ import (
 	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
 	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
 	mp "github.com/ClusterCockpit/cc-lib/v2/messageProcessor"
+	"github.com/ClusterCockpit/cc-lib/v2/util"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -30,6 +32,8 @@ type PrometheusSinkConfig struct {
 	Port             string `json:"port"`
 	Path             string `json:"path,omitempty"`
 	GroupAsNameSpace bool   `json:"group_as_namespace,omitempty"`
+	// Serve HTTPS, optionally with client certificate authentication
+	TLS *util.TLSConfig `json:"tls,omitempty"`
 	// User       string `json:"user,omitempty"`
 	// Password   string `json:"password,omitempty"`
 	// FlushDelay string `json:"flush_delay,omitempty"`
@@ -207,6 +211,13 @@ func NewPrometheusSink(name string, config json.RawMessage) (Sink, error) {
 	for _, k := range s.config.MetaAsTags {
 		s.mp.AddMoveMetaToTags("true", k, k)
 	}
+	var tlsConfig *tls.Config
+	if s.config.TLS != nil {
+		tlsConfig, err = s.config.TLS.ServerConfig()
+		if err != nil {
+			return nil, fmt.Errorf("%s: TLS configuration: %w", s.name, err)
+		}
+	}
 	s.labelMetrics = make(map[string]*prometheus.GaugeVec)
 	s.nodeMetrics = make(map[string]prometheus.Gauge)
 	s.promWg.Go(func() {
@@ -216,8 +227,13 @@ func NewPrometheusSink(name string, config json.RawMessage) (Sink, error) {
 
 		url := fmt.Sprintf("%s:%s", s.config.Host, s.config.Port)
 		cclog.ComponentDebug(s.name, "Serving Prometheus metrics at", fmt.Sprintf("%s:%s/%s", s.config.Host, s.config.Port, s.config.Path))
-		s.promServer = &http.Server{Addr: url, Handler: router}
-		err := s.promServer.ListenAndServe()
+		s.promServer = &http.Server{Addr: url, Handler: router, TLSConfig: tlsConfig}
+		var err error
+		if tlsConfig != nil {
+			err = s.promServer.ListenAndServeTLS("", "")
+		} else {
+			err = s.promServer.ListenAndServe()
+		}
 		if err != nil && err.Error() != "http: Server closed" {
 			cclog.ComponentError(s.name, err.Error())
 		}
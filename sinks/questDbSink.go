@@ -17,6 +17,7 @@ import (
 	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
 	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
 	mp "github.com/ClusterCockpit/cc-lib/v2/messageProcessor"
+	"github.com/ClusterCockpit/cc-lib/v2/util"
 
 	// See https://pkg.go.dev/github.com/questdb/go-questdb-client/v4
 	qdb "github.com/questdb/go-questdb-client/v4"
@@ -33,9 +34,11 @@ type QuestDBSinkConfig struct {
 	Address string `json:"address,omitempty"`
 	// Authentication options for QuestDB:
 	// Basic authentication with username and password
+	// The password may be a secret reference (env:, file: or exec:)
 	Username string `json:"username,omitempty"`
 	Password string `json:"password,omitempty"`
 	// Authentication with bearer token in HTTP header
+	// May be a secret reference (env:, file: or exec:)
 	BearerToken string `json:"bearer_token,omitempty"`
 	// Auto flush configuration
 	// Interval at which the sender automatically flushes its buffer
@@ -183,12 +186,20 @@ func NewQuestDBSink(name string, config json.RawMessage) (Sink, error) {
 		return nil, fmt.Errorf("conflicting authentication methods: both basic auth and bearer token provided")
 	}
 	if s.config.Username != "" && s.config.Password != "" {
+		password, err := util.ResolveSecret(s.config.Password)
+		if err != nil {
+			return nil, fmt.Errorf("failed resolving password: %w", err)
+		}
 		options = append(options,
-			qdb.WithBasicAuth(s.config.Username, s.config.Password))
+			qdb.WithBasicAuth(s.config.Username, password))
 	}
 	if s.config.BearerToken != "" {
+		token, err := util.ResolveSecret(s.config.BearerToken)
+		if err != nil {
+			return nil, fmt.Errorf("failed resolving bearer token: %w", err)
+		}
 		options = append(options,
-			qdb.WithBearerToken(s.config.BearerToken))
+			qdb.WithBearerToken(token))
 	}
 
 	s.ctx = context.Background()
@@ -35,11 +35,12 @@ type SinkManager interface {
 
 // Metric collector manager data structure
 type sinkManager struct {
-	input      chan lp.CCMessage // input channel
-	done       chan bool         // channel to finish / stop metric sink manager
-	wg         *sync.WaitGroup   // wait group for all goroutines in cc-metric-collector
-	sinks      map[string]Sink   // Mapping sink name to sink
-	maxForward int               // number of metrics to write maximally in one iteration
+	input      chan lp.CCMessage     // input channel
+	done       chan bool             // channel to finish / stop metric sink manager
+	wg         *sync.WaitGroup       // wait group for all goroutines in cc-metric-collector
+	sinks      map[string]Sink       // Mapping sink name to sink
+	routes     map[string]*sinkRoute // Mapping sink name to message route (nil for all messages)
+	maxForward int                   // number of metrics to write maximally in one iteration
 }
 
 // Init initializes the sink manager by:
@@ -50,6 +51,7 @@ func (sm *sinkManager) Init(wg *sync.WaitGroup, sinkConfig json.RawMessage) erro
 	sm.done = make(chan bool)
 	sm.wg = wg
 	sm.sinks = make(map[string]Sink, 0)
+	sm.routes = make(map[string]*sinkRoute, 0)
 	sm.maxForward = SINK_MAX_FORWARD
 
 	// Parse config
@@ -93,9 +95,19 @@ func (sm *sinkManager) Start() {
 		}
 
 		toTheSinks := func(p lp.CCMessage) {
-			// Send received metric to all outputs
+			// Send received metric to all outputs with a matching route
 			cclog.ComponentDebug("SinkManager", "WRITE", p)
-			for _, s := range sm.sinks {
+			for name, s := range sm.sinks {
+				if r := sm.routes[name]; r != nil {
+					ok, err := r.Match(p)
+					if err != nil {
+						cclog.ComponentError("SinkManager", "ROUTE", s.Name(), "route evaluation failed:", err.Error())
+						continue
+					}
+					if !ok {
+						continue
+					}
+				}
 				if err := s.Write(p); err != nil {
 					cclog.ComponentError("SinkManager", "WRITE", s.Name(), "write failed:", err.Error())
 				}
@@ -140,12 +152,18 @@ func (sm *sinkManager) AddOutput(name string, rawConfig json.RawMessage) error {
 		cclog.ComponentError("SinkManager", "SKIP", name, "unknown sink:", sinkConfig.Type)
 		return err
 	}
+	r, err := newSinkRoute(sinkConfig)
+	if err != nil {
+		cclog.ComponentError("SinkManager", "SKIP", name, "route setup failed:", err.Error())
+		return err
+	}
 	s, err := AvailableSinks[sinkConfig.Type](name, rawConfig)
 	if err != nil {
 		cclog.ComponentError("SinkManager", "SKIP", name, "initialization failed:", err.Error())
 		return err
 	}
 	sm.sinks[name] = s
+	sm.routes[name] = r
 	cclog.ComponentDebug("SinkManager", "ADD SINK", s.Name(), "with name", fmt.Sprintf("'%s'", name))
 	return nil
 }
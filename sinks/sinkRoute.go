@@ -0,0 +1,73 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package sinks
+
+import (
+	"fmt"
+	"path"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+	mp "github.com/ClusterCockpit/cc-lib/v2/messageProcessor"
+)
+
+// sinkRoute decides in the sink manager whether a message is forwarded to a sink.
+// A message is forwarded if its name matches one of the include patterns (or no
+// include patterns are given), matches none of the exclude patterns and the
+// route condition evaluates to true (or no condition is given).
+type sinkRoute struct {
+	include   []string
+	exclude   []string
+	condition *mp.MessageCondition
+}
+
+// newSinkRoute creates the route of a sink from its configuration.
+// It returns nil if the sink should receive all messages.
+func newSinkRoute(config defaultSinkConfig) (*sinkRoute, error) {
+	if len(config.Route) == 0 && len(config.RouteInclude) == 0 && len(config.RouteExclude) == 0 {
+		return nil, nil
+	}
+	r := &sinkRoute{
+		include: config.RouteInclude,
+		exclude: config.RouteExclude,
+	}
+	// Check the patterns once, path.Match reports malformed patterns only on use
+	for _, p := range append(r.include, r.exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid route pattern '%s': %w", p, err)
+		}
+	}
+	if len(config.Route) > 0 {
+		c, err := mp.NewMessageCondition(config.Route)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route: %w", err)
+		}
+		r.condition = c
+	}
+	return r, nil
+}
+
+// matchName checks whether name matches any of the patterns
+func matchName(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns whether message m should be written to the sink
+func (r *sinkRoute) Match(m lp.CCMessage) (bool, error) {
+	if len(r.include) > 0 && !matchName(r.include, m.Name()) {
+		return false, nil
+	}
+	if matchName(r.exclude, m.Name()) {
+		return false, nil
+	}
+	if r.condition != nil {
+		return r.condition.Match(m)
+	}
+	return true, nil
+}
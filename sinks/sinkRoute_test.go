@@ -0,0 +1,137 @@
+package sinks
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	lp "github.com/ClusterCockpit/cc-lib/v2/ccMessage"
+)
+
+func TestSinkRoute(t *testing.T) {
+	metric, _ := lp.NewMetric("cpu_load", map[string]string{"type": "hwthread", "type-id": "0"}, nil, 1.0, time.Now())
+	nodeMetric, _ := lp.NewMetric("mem_used", map[string]string{"type": "node"}, nil, 1.0, time.Now())
+	event, _ := lp.NewEvent("job_start", map[string]string{"type": "node"}, nil, "started", time.Now())
+
+	tests := []struct {
+		name   string
+		config defaultSinkConfig
+		want   []bool // cpu_load, mem_used, job_start
+	}{
+		{
+			name:   "no route",
+			config: defaultSinkConfig{},
+			want:   []bool{true, true, true},
+		},
+		{
+			name:   "events only",
+			config: defaultSinkConfig{Route: "msgtype == 'event'"},
+			want:   []bool{false, false, true},
+		},
+		{
+			name:   "node metrics",
+			config: defaultSinkConfig{Route: "msgtype == 'metric' && tag.type == 'node'"},
+			want:   []bool{false, true, false},
+		},
+		{
+			name:   "include pattern",
+			config: defaultSinkConfig{RouteInclude: []string{"cpu_*", "job_*"}},
+			want:   []bool{true, false, true},
+		},
+		{
+			name:   "exclude pattern",
+			config: defaultSinkConfig{RouteExclude: []string{"cpu_*"}},
+			want:   []bool{false, true, true},
+		},
+		{
+			name: "pattern and condition",
+			config: defaultSinkConfig{
+				RouteInclude: []string{"*"},
+				RouteExclude: []string{"job_*"},
+				Route:        "tag.type == 'node'",
+			},
+			want: []bool{false, true, false},
+		},
+	}
+
+	for _, tc := range tests {
+		r, err := newSinkRoute(tc.config)
+		if err != nil {
+			t.Errorf("%s: failed to create route: %v", tc.name, err)
+			continue
+		}
+		for i, m := range []lp.CCMessage{metric, nodeMetric, event} {
+			got := true
+			if r != nil {
+				got, err = r.Match(m)
+				if err != nil {
+					t.Errorf("%s: failed to match %s: %v", tc.name, m.Name(), err)
+				}
+			}
+			if got != tc.want[i] {
+				t.Errorf("%s: route for %s returned %v, expected %v", tc.name, m.Name(), got, tc.want[i])
+			}
+		}
+	}
+
+	if _, err := newSinkRoute(defaultSinkConfig{Route: "name =="}); err == nil {
+		t.Error("invalid route condition accepted")
+	}
+	if _, err := newSinkRoute(defaultSinkConfig{RouteInclude: []string{"cpu_["}}); err == nil {
+		t.Error("invalid route pattern accepted")
+	}
+}
+
+func TestSinkManagerRoute(t *testing.T) {
+	var wg sync.WaitGroup
+	dir := t.TempDir()
+	allFile := filepath.Join(dir, "all.lp")
+	eventFile := filepath.Join(dir, "events.lp")
+
+	config := fmt.Sprintf(`{
+		"all": {"type": "stdout", "output_file": "%s"},
+		"events": {"type": "stdout", "output_file": "%s", "route": "msgtype == 'event'"}
+	}`, allFile, eventFile)
+
+	sm, err := New(&wg, json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to create sink manager: %v", err)
+	}
+	input := make(chan lp.CCMessage, 10)
+	sm.AddInput(input)
+	sm.Start()
+
+	msgs, _ := gen_messages(3)
+	for _, m := range msgs {
+		input <- m
+	}
+	event, _ := lp.NewEvent("job_start", map[string]string{"type": "node"}, nil, "started", time.Now())
+	input <- event
+
+	// wait until the sink manager has drained the input channel
+	for len(input) > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	sm.Close()
+	wg.Wait()
+
+	countLines := func(file string) int {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", file, err)
+		}
+		return len(strings.Split(strings.TrimSpace(string(data)), "\n"))
+	}
+	if n := countLines(allFile); n != 4 {
+		t.Errorf("sink without route received %d messages, expected 4", n)
+	}
+	if n := countLines(eventFile); n != 1 {
+		t.Errorf("sink with event route received %d messages, expected 1", n)
+	}
+}
@@ -8,6 +8,8 @@
 package util
 
 import (
+	"reflect"
+	"slices"
 	"sync"
 
 	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
@@ -16,46 +18,86 @@ import (
 
 // Listener is an interface for file system event callbacks.
 // Implementations should define what events to match and how to respond to them.
+// Listeners must be pointers, so that RemoveListener can identify them.
 type Listener interface {
 	EventCallback()
 	EventMatch(event string) bool
 }
 
+// listener is a registered Listener and the path it watches
+type listener struct {
+	path string
+	l    Listener
+}
+
 var (
-	initOnce  sync.Once
-	w         *fsnotify.Watcher
-	listeners []Listener
+	initOnce      sync.Once
+	w             *fsnotify.Watcher // guarded by listenersLock
+	listeners     []listener
+	listenersLock sync.Mutex
 )
 
 // AddListener registers a new file system watcher for the specified path.
 // The watcher is initialized on the first call to AddListener.
 // The listener will be notified of file system events matching its EventMatch criteria.
 func AddListener(path string, l Listener) {
-	var err error
-
 	initOnce.Do(func() {
-		var err error
-		w, err = fsnotify.NewWatcher()
+		watcher, err := fsnotify.NewWatcher()
 		if err != nil {
-			cclog.Error("creating a new watcher: %w", err)
+			cclog.Errorf("creating a new watcher: %s", err)
+			return
 		}
-		listeners = make([]Listener, 0)
-
-		go watchLoop(w)
+		listenersLock.Lock()
+		w = watcher
+		listenersLock.Unlock()
+		go watchLoop(watcher)
 	})
 
-	listeners = append(listeners, l)
-	err = w.Add(path)
-	if err != nil {
+	listenersLock.Lock()
+	defer listenersLock.Unlock()
+	listeners = append(listeners, listener{path: path, l: l})
+	if w == nil {
+		return
+	}
+	if err := w.Add(path); err != nil {
 		cclog.Warnf("%q: %s", path, err)
 	}
 }
 
+// RemoveListener unregisters all registrations of the listener l, which must
+// be a pointer. Paths without remaining listeners are no longer watched.
+func RemoveListener(l Listener) {
+	// Only pointers are compared, comparing other dynamic types may panic
+	if v := reflect.ValueOf(l); !v.IsValid() || v.Kind() != reflect.Pointer {
+		cclog.Warnf("RemoveListener: listener of type %T is not a pointer", l)
+		return
+	}
+	listenersLock.Lock()
+	defer listenersLock.Unlock()
+	var removed []string
+	listeners = slices.DeleteFunc(listeners, func(e listener) bool {
+		if e.l == l {
+			removed = append(removed, e.path)
+			return true
+		}
+		return false
+	})
+	for _, path := range removed {
+		if !slices.ContainsFunc(listeners, func(e listener) bool { return e.path == path }) && w != nil {
+			w.Remove(path)
+		}
+	}
+}
+
 // FsWatcherShutdown closes the file system watcher.
 // This should be called during application shutdown to clean up resources.
 func FsWatcherShutdown() {
-	if w != nil {
-		w.Close()
+	listenersLock.Lock()
+	watcher := w
+	listenersLock.Unlock()
+	// Closed without the lock, the watch loop may wait for it
+	if watcher != nil {
+		watcher.Close()
 	}
 }
 
@@ -75,9 +117,14 @@ func watchLoop(w *fsnotify.Watcher) {
 			}
 
 			cclog.Infof("Event %s", e)
-			for _, l := range listeners {
-				if l.EventMatch(e.String()) {
-					l.EventCallback()
+			// Call the listeners without the lock, so that callbacks may
+			// add or remove listeners
+			listenersLock.Lock()
+			current := slices.Clone(listeners)
+			listenersLock.Unlock()
+			for _, entry := range current {
+				if entry.l.EventMatch(e.String()) {
+					entry.l.EventCallback()
 				}
 			}
 		}
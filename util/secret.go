@@ -0,0 +1,138 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+)
+
+// Prefixes of secret references in configuration values
+const (
+	SecretEnvPrefix     = "env:"     // env:NAME reads the environment variable NAME
+	SecretFilePrefix    = "file:"    // file:/path reads the file /path
+	SecretExecPrefix    = "exec:"    // exec:/path/cmd args uses the output of the command
+	SecretLiteralPrefix = "literal:" // literal:value uses value, even if it starts with another prefix
+)
+
+// Maximum run time of commands of exec: secret references
+const SecretExecTimeout = 30 * time.Second
+
+// ResolveSecret resolves a configuration value which may be a secret reference.
+// Values without one of the prefixes env:, file:, exec: or literal: are returned
+// unchanged. Trailing newlines of files and command output are removed.
+func ResolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, SecretEnvPrefix):
+		name := strings.TrimPrefix(value, SecretEnvPrefix)
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable '%s' of secret is not set", name)
+		}
+		return v, nil
+	case strings.HasPrefix(value, SecretFilePrefix):
+		name := strings.TrimPrefix(value, SecretFilePrefix)
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(value, SecretExecPrefix):
+		args := strings.Fields(strings.TrimPrefix(value, SecretExecPrefix))
+		if len(args) == 0 {
+			return "", errors.New("empty command of exec: secret")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), SecretExecTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("secret command %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+	case strings.HasPrefix(value, SecretLiteralPrefix):
+		return strings.TrimPrefix(value, SecretLiteralPrefix), nil
+	}
+	return value, nil
+}
+
+// Secret holds a resolved secret reference. Secrets read from files can be
+// re-read automatically when the file changes.
+type Secret struct {
+	ref   string
+	path  string // absolute path of file: references, empty otherwise
+	lock  sync.RWMutex
+	value string
+}
+
+// NewSecret resolves the secret reference ref. If watch is set and ref is a
+// file: reference, the secret is re-read when the file changes.
+func NewSecret(ref string, watch bool) (*Secret, error) {
+	s := &Secret{ref: ref}
+	value, err := ResolveSecret(ref)
+	if err != nil {
+		return nil, err
+	}
+	s.value = value
+	if watch && strings.HasPrefix(ref, SecretFilePrefix) {
+		path, err := filepath.Abs(strings.TrimPrefix(ref, SecretFilePrefix))
+		if err != nil {
+			return nil, err
+		}
+		s.path = path
+		// Watch the directory, as secrets are commonly replaced by renaming
+		AddListener(filepath.Dir(path), s)
+	}
+	return s, nil
+}
+
+// Value returns the current value of the secret. It is safe to call on a nil Secret.
+func (s *Secret) Value() string {
+	if s == nil {
+		return ""
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.value
+}
+
+// Close stops watching the secret file. The last value stays available. It is
+// safe to call on a nil Secret.
+func (s *Secret) Close() {
+	if s != nil && len(s.path) > 0 {
+		RemoveListener(s)
+	}
+}
+
+// EventMatch matches file system events of the secret file
+func (s *Secret) EventMatch(event string) bool {
+	return len(s.path) > 0 && strings.Contains(event, `"`+s.path+`"`)
+}
+
+// EventCallback re-reads the secret file. On errors, the previous value stays in use.
+func (s *Secret) EventCallback() {
+	value, err := ResolveSecret(s.ref)
+	if err != nil {
+		cclog.Errorf("Secret: re-read failed, keeping previous value: %s", err)
+		return
+	}
+	s.lock.Lock()
+	s.value = value
+	s.lock.Unlock()
+	cclog.Infof("Secret: re-read %s", s.path)
+}
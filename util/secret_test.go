@@ -0,0 +1,102 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package util_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ClusterCockpit/cc-lib/v2/util"
+)
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "token")
+	if err := os.WriteFile(file, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CC_LIB_TEST_SECRET", "from-env")
+
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"plain", "plain"},
+		{"", ""},
+		{"env:CC_LIB_TEST_SECRET", "from-env"},
+		{"file:" + file, "from-file"},
+		{"exec:echo from exec", "from exec"},
+		{"literal:env:HOME", "env:HOME"},
+	}
+	for _, tt := range tests {
+		got, err := util.ResolveSecret(tt.ref)
+		if err != nil {
+			t.Errorf("%s: %v", tt.ref, err)
+		} else if got != tt.want {
+			t.Errorf("%s: expected '%s', got '%s'", tt.ref, tt.want, got)
+		}
+	}
+
+	for _, ref := range []string{
+		"env:CC_LIB_TEST_SECRET_UNSET",
+		"file:" + filepath.Join(dir, "missing"),
+		"exec:",
+		"exec:false",
+	} {
+		if _, err := util.ResolveSecret(ref); err == nil {
+			t.Errorf("invalid secret reference '%s' was resolved", ref)
+		}
+	}
+}
+
+func TestSecretReload(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "password")
+	if err := os.WriteFile(file, []byte("first"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := util.NewSecret("file:"+file, true)
+	if err != nil {
+		t.Fatalf("failed to create secret: %v", err)
+	}
+	if s.Value() != "first" {
+		t.Fatalf("expected 'first', got '%s'", s.Value())
+	}
+
+	// Replace the file by renaming
+	tmp := filepath.Join(dir, "password.tmp")
+	if err := os.WriteFile(tmp, []byte("second\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Value() != "second" {
+		if time.Now().After(deadline) {
+			t.Fatalf("secret was not re-read, value '%s'", s.Value())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// A closed secret keeps its value, but is no longer re-read
+	s.Close()
+	if err := os.WriteFile(file, []byte("third"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if s.Value() != "second" {
+		t.Errorf("closed secret was re-read, value '%s'", s.Value())
+	}
+
+	var unset *util.Secret
+	if unset.Value() != "" {
+		t.Error("nil secret has a value")
+	}
+	unset.Close()
+}
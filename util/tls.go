@@ -0,0 +1,265 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+)
+
+// TLSConfig is the TLS configuration block shared by all network components.
+// Certificates, keys and CA bundles are reloaded when they change on disk.
+type TLSConfig struct {
+	CertFile           string `json:"cert_file,omitempty"`            // PEM encoded certificate (chain)
+	KeyFile            string `json:"key_file,omitempty"`             // PEM encoded private key of the certificate
+	CAFile             string `json:"ca_file,omitempty"`              // PEM encoded CA bundle to verify the peer (default: system roots for clients)
+	ClientAuth         string `json:"client_auth,omitempty"`          // Client certificate policy of servers: none (default), request, require, verify_if_given or require_and_verify
+	MinVersion         string `json:"min_version,omitempty"`          // Minimum TLS version: 1.2 (default) or 1.3
+	ServerName         string `json:"server_name,omitempty"`          // Server name (SNI) used by clients to verify the server certificate
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"` // Clients do not verify the server certificate (testing only)
+}
+
+// tlsFiles holds the current certificate and CA pool and reloads them on changes
+type tlsFiles struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	lock sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+}
+
+// load (re-)reads the certificate and the CA bundle
+func (f *tlsFiles) load() error {
+	var (
+		cert *tls.Certificate
+		pool *x509.CertPool
+	)
+	if len(f.certFile) > 0 {
+		c, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate %s: %w", f.certFile, err)
+		}
+		cert = &c
+	}
+	if len(f.caFile) > 0 {
+		data, err := os.ReadFile(f.caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in CA bundle %s", f.caFile)
+		}
+	}
+
+	f.lock.Lock()
+	f.cert = cert
+	f.pool = pool
+	f.lock.Unlock()
+	return nil
+}
+
+// EventMatch matches file system events of the certificate, key and CA files
+func (f *tlsFiles) EventMatch(event string) bool {
+	for _, name := range []string{f.certFile, f.keyFile, f.caFile} {
+		if len(name) > 0 && strings.Contains(event, `"`+name+`"`) {
+			return true
+		}
+	}
+	return false
+}
+
+// EventCallback reloads the files. On errors, the previous files stay in use.
+func (f *tlsFiles) EventCallback() {
+	if err := f.load(); err != nil {
+		cclog.Errorf("TLS: reload failed, keeping previous certificates: %s", err)
+		return
+	}
+	cclog.Infof("TLS: reloaded certificates %s %s", f.certFile, f.caFile)
+}
+
+func (f *tlsFiles) certificate() *tls.Certificate {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.cert
+}
+
+func (f *tlsFiles) caPool() *x509.CertPool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.pool
+}
+
+var (
+	tlsFilesLock sync.Mutex
+	tlsFilesMap  = make(map[string]*tlsFiles)
+)
+
+// watchTLSFiles loads the files and registers them for reloading. Components
+// using the same files share one instance, so that each file is watched once.
+func watchTLSFiles(certFile, keyFile, caFile string) (*tlsFiles, error) {
+	for _, name := range []*string{&certFile, &keyFile, &caFile} {
+		if len(*name) > 0 {
+			abs, err := filepath.Abs(*name)
+			if err != nil {
+				return nil, err
+			}
+			*name = abs
+		}
+	}
+	key := certFile + "\x00" + keyFile + "\x00" + caFile
+
+	tlsFilesLock.Lock()
+	defer tlsFilesLock.Unlock()
+	if f, ok := tlsFilesMap[key]; ok {
+		return f, nil
+	}
+	f := &tlsFiles{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+
+	// Watch the directories, as certificates are commonly replaced by renaming.
+	// The files are shared by all configurations using them, so there is one
+	// listener per set of files for the lifetime of the process.
+	dirs := make(map[string]bool)
+	for _, name := range []string{certFile, keyFile, caFile} {
+		if len(name) > 0 {
+			dirs[filepath.Dir(name)] = true
+		}
+	}
+	for dir := range dirs {
+		AddListener(dir, f)
+	}
+	tlsFilesMap[key] = f
+	return f, nil
+}
+
+func (c *TLSConfig) minVersion() (uint16, error) {
+	switch c.MinVersion {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported minimum TLS version '%s'", c.MinVersion)
+}
+
+func (c *TLSConfig) clientAuth() (tls.ClientAuthType, error) {
+	switch c.ClientAuth {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "require":
+		return tls.RequireAnyClientCert, nil
+	case "verify_if_given":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require_and_verify":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("unsupported client authentication '%s'", c.ClientAuth)
+}
+
+// ServerConfig returns the TLS configuration for servers. The certificate and
+// key are required; the CA bundle is used to verify client certificates.
+func (c *TLSConfig) ServerConfig() (*tls.Config, error) {
+	if len(c.CertFile) == 0 || len(c.KeyFile) == 0 {
+		return nil, errors.New("TLS servers require cert_file and key_file")
+	}
+	minVersion, err := c.minVersion()
+	if err != nil {
+		return nil, err
+	}
+	clientAuth, err := c.clientAuth()
+	if err != nil {
+		return nil, err
+	}
+	if (clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert) && len(c.CAFile) == 0 {
+		return nil, fmt.Errorf("client authentication '%s' requires ca_file", c.ClientAuth)
+	}
+	files, err := watchTLSFiles(c.CertFile, c.KeyFile, c.CAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion: minVersion,
+		ClientAuth: clientAuth,
+	}
+	// Return a configuration with the current certificate and CA pool for each connection
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := config.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.Certificates = []tls.Certificate{*files.certificate()}
+		cfg.ClientCAs = files.caPool()
+		return cfg, nil
+	}
+	return config, nil
+}
+
+// ClientConfig returns the TLS configuration for clients. The certificate and
+// key are optional and used as client certificate; the CA bundle replaces the
+// system roots to verify the server.
+func (c *TLSConfig) ClientConfig() (*tls.Config, error) {
+	if (len(c.CertFile) == 0) != (len(c.KeyFile) == 0) {
+		return nil, errors.New("TLS client certificates require cert_file and key_file")
+	}
+	minVersion, err := c.minVersion()
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion:         minVersion,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if len(c.CertFile) == 0 && len(c.CAFile) == 0 {
+		return config, nil
+	}
+
+	files, err := watchTLSFiles(c.CertFile, c.KeyFile, c.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.CertFile) > 0 {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return files.certificate(), nil
+		}
+	}
+	if len(c.CAFile) > 0 && !c.InsecureSkipVerify {
+		// Verify the server with the current CA pool, which cannot be set in
+		// the static RootCAs field
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			serverName := c.ServerName
+			if len(serverName) == 0 {
+				serverName = cs.ServerName
+			}
+			opts := x509.VerifyOptions{
+				DNSName:       serverName,
+				Roots:         files.caPool(),
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		}
+	}
+	return config, nil
+}
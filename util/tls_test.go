@@ -0,0 +1,169 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-lib.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package util_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ClusterCockpit/cc-lib/v2/util"
+)
+
+// writeTestCert creates a certificate signed by parent (self-signed if nil)
+// and writes it and its key as PEM files
+func writeTestCert(t *testing.T, certFile, keyFile, cn string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if len(keyFile) > 0 {
+		if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cert, key
+}
+
+// handshake connects client and server and returns the certificate presented by the server
+func handshake(t *testing.T, server, client *tls.Config) (*x509.Certificate, error) {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.(*tls.Conn).Handshake()
+		conn.Close()
+	}()
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", ln.Addr().String(), client)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// Client certificate errors are reported by the server after the handshake
+	if _, err := conn.Read(make([]byte, 1)); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	serverCert, serverKey := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	clientCert, clientKey := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	ca, caKey := writeTestCert(t, caFile, "", "ca", true, nil, nil)
+	writeTestCert(t, serverCert, serverKey, "server", false, ca, caKey)
+	writeTestCert(t, clientCert, clientKey, "client", false, ca, caKey)
+
+	for _, c := range []util.TLSConfig{
+		{CertFile: serverCert},
+		{CertFile: serverCert, KeyFile: serverKey, MinVersion: "1.0"},
+		{CertFile: serverCert, KeyFile: serverKey, ClientAuth: "always"},
+		{CertFile: serverCert, KeyFile: serverKey, ClientAuth: "require_and_verify"},
+	} {
+		if _, err := c.ServerConfig(); err == nil {
+			t.Errorf("invalid server configuration %+v was accepted", c)
+		}
+	}
+
+	serverConfig, err := (&util.TLSConfig{
+		CertFile:   serverCert,
+		KeyFile:    serverKey,
+		CAFile:     caFile,
+		ClientAuth: "require_and_verify",
+	}).ServerConfig()
+	if err != nil {
+		t.Fatalf("failed to create server configuration: %v", err)
+	}
+	mtlsConfig, err := (&util.TLSConfig{
+		CertFile:   clientCert,
+		KeyFile:    clientKey,
+		CAFile:     caFile,
+		ServerName: "localhost",
+	}).ClientConfig()
+	if err != nil {
+		t.Fatalf("failed to create client configuration: %v", err)
+	}
+	noCertConfig, err := (&util.TLSConfig{CAFile: caFile, ServerName: "localhost"}).ClientConfig()
+	if err != nil {
+		t.Fatalf("failed to create client configuration: %v", err)
+	}
+
+	cert, err := handshake(t, serverConfig, mtlsConfig)
+	if err != nil {
+		t.Fatalf("mutual TLS handshake failed: %v", err)
+	}
+	if cert.Subject.CommonName != "server" {
+		t.Errorf("unexpected server certificate %s", cert.Subject.CommonName)
+	}
+	if _, err := handshake(t, serverConfig, noCertConfig); err == nil {
+		t.Error("handshake without client certificate succeeded")
+	}
+
+	// Replace the server certificate by renaming and wait for the reload
+	tmpCert, tmpKey := filepath.Join(dir, "new.pem"), filepath.Join(dir, "new.key")
+	writeTestCert(t, tmpCert, tmpKey, "renewed", false, ca, caKey)
+	if err := os.Rename(tmpKey, serverKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmpCert, serverCert); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cert, err := handshake(t, serverConfig, mtlsConfig)
+		if err == nil && cert.Subject.CommonName == "renewed" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("certificate was not reloaded: %v %v", cert, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -10,7 +10,10 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ClusterCockpit/cc-lib/v2/util"
 )
@@ -401,3 +404,73 @@ func TestMedian(t *testing.T) {
 		t.Error("expected NaN for empty slice")
 	}
 }
+
+type countingListener struct {
+	lock  sync.Mutex
+	calls int
+}
+
+func (l *countingListener) EventMatch(event string) bool { return strings.Contains(event, "watched") }
+
+func (l *countingListener) EventCallback() {
+	l.lock.Lock()
+	l.calls++
+	l.lock.Unlock()
+}
+
+func (l *countingListener) count() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.calls
+}
+
+func TestRemoveListener(t *testing.T) {
+	dir := t.TempDir()
+	l := &countingListener{}
+	util.AddListener(dir, l)
+
+	// Register and remove other listeners concurrently to the event loop
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			for range 50 {
+				other := &countingListener{}
+				util.AddListener(dir, other)
+				util.RemoveListener(other)
+			}
+		})
+	}
+	if err := os.WriteFile(filepath.Join(dir, "watched"), []byte("1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	deadline := time.Now().Add(5 * time.Second)
+	for l.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if l.count() == 0 {
+		t.Fatal("listener was not called")
+	}
+
+	util.RemoveListener(l)
+	calls := l.count()
+	if err := os.WriteFile(filepath.Join(dir, "watched"), []byte("2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if l.count() != calls {
+		t.Error("removed listener was called")
+	}
+	// Listeners which are not pointers are not removed, but do not panic
+	values := valueListener{events: []string{"other"}}
+	util.AddListener(dir, values)
+	util.RemoveListener(values)
+}
+
+// valueListener is a listener of a type which is not comparable
+type valueListener struct {
+	events []string
+}
+
+func (l valueListener) EventMatch(event string) bool { return false }
+func (l valueListener) EventCallback()               {}